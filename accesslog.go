@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logAccess wraps next to log every request with its status, response size and duration.
+//
+// Requests are logged at info level, except health and readiness probes, which are logged at
+// debug level so frequent probes don't drown out the traffic. The log line carries the request
+// ID, so next must be wrapped by tagRequestID.
+func (s *Server) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			level = slog.LevelDebug
+		}
+		s.requestLogger(r.Context()).LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", aw.statusCode()),
+			slog.Int64("size", aw.size),
+			slog.Float64("durationMs", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
+
+// accessLogWriter is an http.ResponseWriter that records the status and size of the response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	// status is the status code of the response, or 0 if the header wasn't written yet.
+	status int
+	// size is the number of body bytes written.
+	size int64
+}
+
+// WriteHeader records the status code and writes the header.
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body and writes it.
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the status code of the response, which is 200 if the handler wrote nothing.
+func (w *accessLogWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
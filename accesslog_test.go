@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestLogAccess tests the access log entry of each request.
+func TestLogAccess(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantLevel  string
+		wantStatus int
+	}{
+		{
+			name:       "generate",
+			method:     http.MethodPost,
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ"}`,
+			wantLevel:  "INFO",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "error",
+			method:     http.MethodPost,
+			path:       "/generate",
+			body:       `{"templateKey": "missing.typ"}`,
+			wantLevel:  "INFO",
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "no route", method: http.MethodGet, path: "/unknown", wantLevel: "INFO", wantStatus: http.StatusNotFound},
+		// The health check's status depends on typst being installed, so it isn't checked.
+		{name: "health", method: http.MethodGet, path: "/health", wantLevel: "DEBUG"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(logger, ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(requestIDHeader, "req-123")
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			entry := accessLogEntry(t, logs.String())
+			if entry["level"] != tt.wantLevel {
+				t.Errorf("expected level %q, got %v", tt.wantLevel, entry["level"])
+			}
+			if entry["method"] != tt.method || entry["path"] != tt.path {
+				t.Errorf("expected %s %s, got %v %v", tt.method, tt.path, entry["method"], entry["path"])
+			}
+			if tt.wantStatus != 0 && rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if entry["status"] != float64(rec.Code) {
+				t.Errorf("expected status %d logged, got %v", rec.Code, entry["status"])
+			}
+			if entry["size"] != float64(rec.Body.Len()) {
+				t.Errorf("expected size %d, got %v", rec.Body.Len(), entry["size"])
+			}
+			if entry["requestID"] != "req-123" {
+				t.Errorf("expected requestID %q, got %v", "req-123", entry["requestID"])
+			}
+			if _, ok := entry["durationMs"].(float64); !ok {
+				t.Errorf("expected durationMs, got %v", entry["durationMs"])
+			}
+		})
+	}
+}
+
+// accessLogEntry returns the access log entry of the logs, which is the last one.
+func accessLogEntry(t *testing.T, logs string) map[string]any {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSpace(logs), "\n")
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("failed to parse log entry %q: %v", lines[len(lines)-1], err)
+	}
+	if entry["msg"] != "request" {
+		t.Fatalf("expected the access log entry last, got %v", entry)
+	}
+	return entry
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+// defaultMaxBatchItems is the default maximum number of items in a batch request.
+const defaultMaxBatchItems = 500
+
+// errOutputsUnsupported is returned when outputs would be written to a read-only template filesystem.
+var errOutputsUnsupported = errors.New("batch outputs can't be written to an embedded template filesystem")
+
+// BatchRequest is the request body for batch generation.
+type BatchRequest struct {
+	// TemplateKey is the key of the template in the storage bucket, shared by all items.
+	TemplateKey string `json:"templateKey"`
+	// IncludeKeys are the keys of additional template files in the storage bucket.
+	IncludeKeys []string `json:"includeKeys,omitempty"`
+	// AssetKeys are the keys of binary files in the storage bucket.
+	AssetKeys []string `json:"assetKeys,omitempty"`
+	// Format is the output format ("pdf", "png", "svg" or "pdf-pages"). Defaults to "pdf".
+	Format string `json:"format,omitempty"`
+	// ACL is the ACL of the written outputs ("private" or "public-read"). Defaults to OUTPUT_ACL.
+	ACL string `json:"acl,omitempty"`
+	// Items are the documents to generate.
+	Items []BatchItem `json:"items"`
+}
+
+// BatchItem is a single document of a batch request.
+type BatchItem struct {
+	// Data is the data to inject into the template.
+	Data any `json:"data,omitempty"`
+	// OutputKey is the key the generated document is written to in the storage bucket.
+	OutputKey string `json:"outputKey"`
+}
+
+// BatchResponse is the response body for batch generation.
+type BatchResponse struct {
+	// Results are the results of the items, in request order.
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the result of a single batch item.
+type BatchResult struct {
+	// OutputKey is the key the document was written to.
+	OutputKey string `json:"outputKey"`
+	// Success reports whether the document was generated and written.
+	Success bool `json:"success"`
+	// Error is the error message of a failed item.
+	Error string `json:"error,omitempty"`
+}
+
+// handleGenerateBatch generates a document for each item of a batch and writes it to the bucket.
+//
+// The template is fetched once and shared by all items. Items are compiled by a bounded
+// number of workers under the compile limit, and a failed item doesn't stop the others.
+func (s *Server) handleGenerateBatch(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r.Context())
+
+	var batch BatchRequest
+	if err := decodeJSONBody(w, r, &batch, s.requestConfig(r.Context()).maxBatchRequestSize); err != nil {
+		writeError(w, err)
+		return
+	}
+	req := GenerateRequest{
+		TemplateKey: batch.TemplateKey,
+		IncludeKeys: batch.IncludeKeys,
+		AssetKeys:   batch.AssetKeys,
+		Format:      batch.Format,
+	}
+	if err := s.validateBatchRequest(r.Context(), &batch, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	acl, err := s.outputACL(r.Context(), batch.ACL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Check that the template isn't at its concurrency limit.
+	release, ok := s.limiter.Load().acquire(r.Context(), req.TemplateKey)
+	if !ok {
+		http.Error(w, errTemplateBusy.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	logger.Debug("generating batch",
+		"templateKey", req.TemplateKey,
+		"format", req.Format,
+		"acl", acl,
+		"items", len(batch.Items),
+	)
+
+	tmpl, err := s.resolveTemplate(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	results := s.generateBatch(r.Context(), tmpl, batchOutput{format: req.Format, acl: acl}, batch.Items)
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if encodeErr := json.NewEncoder(w).Encode(BatchResponse{Results: results}); encodeErr != nil {
+		logger.Error("failed to write batch response", "error", encodeErr)
+	}
+}
+
+// validateBatchRequest checks a batch request, validating its shared fields through req.
+func (s *Server) validateBatchRequest(ctx context.Context, batch *BatchRequest, req *GenerateRequest) error {
+	config := s.requestConfig(ctx)
+	if config.templateFS != nil {
+		return newStatusError(http.StatusNotImplemented, errOutputsUnsupported)
+	}
+	if req.TemplateKey == "" {
+		return newStatusError(http.StatusBadRequest, errors.New("templateKey is required"))
+	}
+	if err := s.validateGenerateRequest(ctx, req); err != nil {
+		return err
+	}
+	// The documents are written in the output format, so it must be allowed like a /generate response.
+	if err := s.checkFormatAllowed(ctx, outputFormats()[req.Format]); err != nil {
+		return err
+	}
+
+	if len(batch.Items) == 0 {
+		return newStatusError(http.StatusBadRequest, errors.New("items is required"))
+	}
+	if len(batch.Items) > config.maxBatchItems {
+		return newStatusError(http.StatusBadRequest,
+			fmt.Errorf("too many items (maximum %d)", config.maxBatchItems))
+	}
+
+	outputKeys := make(map[string]bool, len(batch.Items))
+	for i, item := range batch.Items {
+		if !fs.ValidPath(item.OutputKey) || item.OutputKey == "." {
+			return newStatusError(http.StatusBadRequest, fmt.Errorf("item %d: invalid outputKey %q", i, item.OutputKey))
+		}
+		if err := validateKey("outputKey", item.OutputKey); err != nil {
+			return newStatusError(http.StatusBadRequest, fmt.Errorf("item %d: %w", i, err))
+		}
+		if outputKeys[item.OutputKey] {
+			return newStatusError(http.StatusBadRequest, fmt.Errorf("item %d: duplicate outputKey %q", i, item.OutputKey))
+		}
+		outputKeys[item.OutputKey] = true
+		if err := validateInlineDataSize(item.Data, "", config.maxDataSize); err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// batchOutput describes how the documents of a batch are written.
+type batchOutput struct {
+	// format is the output format.
+	format string
+	// acl is the ACL of the written objects.
+	acl string
+}
+
+// generateBatch compiles every item and writes it to its output key, returning the results in item order.
+func (s *Server) generateBatch(
+	ctx context.Context,
+	tmpl resolvedTemplate,
+	output batchOutput,
+	items []BatchItem,
+) []BatchResult {
+	results := make([]BatchResult, len(items))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(s.requestConfig(ctx).maxConcurrentCompiles, len(items)) {
+		wg.Go(func() {
+			for i := range next {
+				results[i] = s.generateBatchItem(ctx, tmpl, output, items[i])
+			}
+		})
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// generateBatchItem compiles a single batch item and writes it to its output key.
+func (s *Server) generateBatchItem(
+	ctx context.Context,
+	tmpl resolvedTemplate,
+	output batchOutput,
+	item BatchItem,
+) BatchResult {
+	result := BatchResult{OutputKey: item.OutputKey}
+
+	doc, _, err := s.compile(ctx, tmpl, resolvedData{values: item.Data}, compileArgs{format: output.format})
+	if err == nil {
+		err = s.writeToBucket(ctx, item.OutputKey, doc, outputFormats()[output.format].contentType, output.acl)
+	}
+	if err != nil {
+		s.requestLogger(ctx).Warn("batch item failed", "outputKey", item.OutputKey, "error", err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Success = true
+	return result
+}
+
+// writeToBucket writes an object with the given ACL to the storage bucket, under the request's key prefix.
+func (s *Server) writeToBucket(ctx context.Context, key string, data []byte, contentType, acl string) error {
+	key = bucketKey(ctx, key)
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	bucket, err := s.openBucket(ctx)
+	if err != nil {
+		return err
+	}
+	opts := &blob.WriterOptions{ContentType: contentType, BeforeWrite: beforeWriteACL(acl)}
+	if writeErr := bucket.WriteAll(ctx, key, data, opts); writeErr != nil {
+		return fmt.Errorf("write key %s: %w", key, writeErr)
+	}
+	return nil
+}
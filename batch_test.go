@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dataFailingCompiler is a TypstCompiler that fails when the data file mentions "fail".
+type dataFailingCompiler struct{}
+
+// Compile writes a fake PDF, or fails if the data file contains "fail".
+func (c *dataFailingCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	data, err := os.ReadFile(filepath.Join(workDir, dataFileName))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("fail")) {
+		return errors.New("compile failed")
+	}
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), append([]byte("%PDF-"), data...), 0600)
+}
+
+// TestHandleGenerateBatch tests that each item is written to its output key and failures are reported per item.
+func TestHandleGenerateBatch(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"invoice.typ": []byte("= Invoice")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxConcurrentCompiles: 2})
+	srv.compiler = &dataFailingCompiler{}
+
+	reqBody := `{"templateKey": "invoice.typ", "items": [
+		{"data": {"id": 1}, "outputKey": "out/1.pdf"},
+		{"data": {"id": "fail"}, "outputKey": "out/2.pdf"},
+		{"data": {"id": 3}, "outputKey": "out/3.pdf"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerateBatch(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := []BatchResult{
+		{OutputKey: "out/1.pdf", Success: true},
+		{OutputKey: "out/2.pdf", Error: "compile failed"},
+		{OutputKey: "out/3.pdf", Success: true},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(resp.Results))
+	}
+	dir := strings.TrimPrefix(bucketURL, "file://")
+	for i, result := range resp.Results {
+		if result.OutputKey != want[i].OutputKey || result.Success != want[i].Success ||
+			!strings.Contains(result.Error, want[i].Error) {
+			t.Errorf("result %d: expected %+v, got %+v", i, want[i], result)
+		}
+
+		_, statErr := os.Stat(filepath.Join(dir, result.OutputKey))
+		if written := statErr == nil; written != want[i].Success {
+			t.Errorf("result %d: expected output written %v, got %v", i, want[i].Success, written)
+		}
+	}
+
+	output, err := os.ReadFile(filepath.Join(dir, "out", "3.pdf"))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !bytes.Contains(output, []byte(`"id": 3`)) {
+		t.Errorf("expected output of item 3 to be compiled with its data, got %q", output)
+	}
+}
+
+// TestHandleGenerateBatch_TooLarge tests that batch bodies over MAX_BATCH_REQUEST_SIZE are rejected.
+func TestHandleGenerateBatch_TooLarge(t *testing.T) {
+	t.Parallel()
+
+	// Every item's data is within MAX_DATA_SIZE, but the body as a whole isn't.
+	items := make([]string, 100)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"outputKey": "out/%d.pdf", "data": {"note": %q}}`, i, strings.Repeat("x", 900))
+	}
+	body := `{"templateKey": "invoice.typ", "items": [` + strings.Join(items, ",") + `]}`
+
+	tests := []struct {
+		name                string
+		maxBatchRequestSize int64
+		wantStatus          int
+	}{
+		{name: "default limit", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "configured limit", maxBatchRequestSize: 4096, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "raised limit", maxBatchRequestSize: 1 << 20, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"invoice.typ": []byte("= Invoice")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:           bucketURL,
+				maxDataSize:         1024,
+				maxBatchRequestSize: tt.maxBatchRequestSize,
+			})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerateBatch(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerateBatch_Invalid tests that invalid batch requests are rejected before compiling.
+func TestHandleGenerateBatch_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, 4)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf(`{"outputKey": "out/%d.pdf"}`, i)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "invalid JSON", body: `{`, wantErr: "invalid request"},
+		{name: "no template", body: `{"items": [{"outputKey": "a.pdf"}]}`, wantErr: "templateKey is required"},
+		{name: "no items", body: `{"templateKey": "invoice.typ"}`, wantErr: "items is required"},
+		{
+			name:    "too many items",
+			body:    `{"templateKey": "invoice.typ", "items": [` + strings.Join(tooMany, ",") + `]}`,
+			wantErr: "too many items",
+		},
+		{
+			name:    "missing output key",
+			body:    `{"templateKey": "invoice.typ", "items": [{"data": {}}]}`,
+			wantErr: "invalid outputKey",
+		},
+		{
+			name:    "escaping output key",
+			body:    `{"templateKey": "invoice.typ", "items": [{"outputKey": "../a.pdf"}]}`,
+			wantErr: "invalid outputKey",
+		},
+		{
+			name:    "output key with a null byte",
+			body:    `{"templateKey": "invoice.typ", "items": [{"outputKey": "out/a.pdf\u0000.txt"}]}`,
+			wantErr: "contains a null byte",
+		},
+		{
+			name:    "output key with a backslash segment",
+			body:    `{"templateKey": "invoice.typ", "items": [{"outputKey": "out\\..\\a.pdf"}]}`,
+			wantErr: "must not contain \"..\" segments",
+		},
+		{
+			name:    "duplicate output key",
+			body:    `{"templateKey": "invoice.typ", "items": [{"outputKey": "a.pdf"}, {"outputKey": "a.pdf"}]}`,
+			wantErr: "duplicate outputKey",
+		},
+		{
+			name:    "unsupported format",
+			body:    `{"templateKey": "invoice.typ", "format": "docx", "items": [{"outputKey": "a.pdf"}]}`,
+			wantErr: "unsupported format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"invoice.typ": []byte("= Invoice")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxBatchItems: 3})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerateBatch(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerateBatch_AllowedContentTypes tests that batches are only accepted for formats whose content
+// type is allowed.
+func TestHandleGenerateBatch_AllowedContentTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		format     string
+		wantStatus int
+	}{
+		{name: "allowed", format: formatPNG, wantStatus: http.StatusOK},
+		{name: "disallowed", format: formatPDF, wantStatus: http.StatusNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"invoice.typ": []byte("= Invoice")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:           bucketURL,
+				allowedContentTypes: []string{"image/png"},
+			})
+			srv.compiler = &stubCompiler{}
+
+			body := `{"templateKey": "invoice.typ", "format": "` + tt.format + `", "items": [{"outputKey": "out/1"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerateBatch(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerateBatch_Embedded tests that batches are rejected when objects come from an embedded filesystem.
+func TestHandleGenerateBatch_Embedded(t *testing.T) {
+	t.Parallel()
+
+	srv := newEmbedTestServer(t)
+
+	reqBody := `{"templateKey": "simple.typ", "items": [{"outputKey": "a.pdf"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerateBatch(rec, req)
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNotImplemented, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// bucketCachePrefix is the prefix of the objects the bucket cache stores templates under.
+const bucketCachePrefix = "templates/"
+
+// sourceCache caches template sources by key.
+//
+// Caches backed by a bucket can become unavailable, so lookups and stores report errors and
+// the server decides whether to compile without the cache or reject the request.
+type sourceCache interface {
+	// get returns the cached source for key, and whether it was found.
+	get(ctx context.Context, key string) (string, bool, error)
+	// put stores the source for key.
+	put(ctx context.Context, key, source string) error
+}
+
+// bucketCache is a template cache stored in a bucket, so replicas share the templates
+// any of them fetched.
+//
+// Entries expire ttl after they were stored, judged by the modification time of their object.
+// Expired objects are left for the bucket's lifecycle rules to delete. It is safe for concurrent use.
+type bucketCache struct {
+	// url is the URL of the cache bucket.
+	url string
+	// ttl is how long an entry stays valid after it was stored.
+	ttl time.Duration
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	// mu guards bucket.
+	mu sync.Mutex
+	// bucket is the cache bucket handle, opened on first use.
+	bucket *blob.Bucket
+}
+
+// newBucketCache creates a template cache stored in the bucket at url, whose entries stay valid for ttl.
+func newBucketCache(url string, ttl time.Duration) *bucketCache {
+	return &bucketCache{url: url, ttl: ttl, now: time.Now}
+}
+
+// get returns the cached source for key, if present and not expired.
+func (c *bucketCache) get(ctx context.Context, key string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	bucket, err := c.open(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	reader, err := bucket.NewReader(ctx, bucketCacheKey(key), nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("open cached template: %w", err)
+	}
+	defer reader.Close()
+
+	if !c.now().Before(reader.ModTime().Add(c.ttl)) {
+		return "", false, nil
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", false, fmt.Errorf("read cached template: %w", err)
+	}
+	return string(data), true, nil
+}
+
+// put stores the source for key.
+func (c *bucketCache) put(ctx context.Context, key, source string) error {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	bucket, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := &blob.WriterOptions{ContentType: "text/plain; charset=utf-8"}
+	if writeErr := bucket.WriteAll(ctx, bucketCacheKey(key), []byte(source), opts); writeErr != nil {
+		return fmt.Errorf("write cached template: %w", writeErr)
+	}
+	return nil
+}
+
+// open returns the cache bucket handle, opening it on first use.
+//
+// A failed open is not cached, so the next call tries again.
+func (c *bucketCache) open(ctx context.Context) (*blob.Bucket, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bucket != nil {
+		return c.bucket, nil
+	}
+
+	bucket, err := blob.OpenBucket(ctx, c.url)
+	if err != nil {
+		return nil, fmt.Errorf("open cache bucket: %w", err)
+	}
+	c.bucket = bucket
+
+	return bucket, nil
+}
+
+// Close closes the cache bucket handle, if it was opened.
+func (c *bucketCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bucket == nil {
+		return nil
+	}
+	err := c.bucket.Close()
+	c.bucket = nil
+	if err != nil {
+		return fmt.Errorf("close cache bucket: %w", err)
+	}
+	return nil
+}
+
+// bucketCacheKey returns the object key a template cache key is stored under.
+//
+// Cache keys can be URLs or hold the URL of an overridden bucket, so they are hashed into
+// a valid object key.
+func bucketCacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return bucketCachePrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBucketCache_GetPut tests storing and retrieving templates, shared by caches on the same bucket.
+func TestBucketCache_GetPut(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bucketURL := setupTestBucket(t, map[string][]byte{})
+	cache := newBucketCache(bucketURL, time.Minute)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	if _, ok, err := cache.get(ctx, "a.typ"); err != nil || ok {
+		t.Fatalf("get() on empty cache = %v, %v, want a miss", ok, err)
+	}
+
+	if err := cache.put(ctx, "a.typ", "= A"); err != nil {
+		t.Fatalf("put() returned error: %v", err)
+	}
+	source, ok, err := cache.get(ctx, "a.typ")
+	if err != nil || !ok {
+		t.Fatalf("get() after put() = %v, %v, want a hit", ok, err)
+	}
+	if source != "= A" {
+		t.Errorf("expected source %q, got %q", "= A", source)
+	}
+
+	// Another replica's cache on the same bucket sees the template.
+	replica := newBucketCache(bucketURL, time.Minute)
+	t.Cleanup(func() { _ = replica.Close() })
+	if source, _, _ = replica.get(ctx, "a.typ"); source != "= A" {
+		t.Errorf("expected shared source %q, got %q", "= A", source)
+	}
+
+	// Keys that aren't valid object keys are stored too.
+	urlKey := "https://example.com/a.typ\x00a.typ"
+	if err = cache.put(ctx, urlKey, "= URL"); err != nil {
+		t.Fatalf("put() returned error for %q: %v", urlKey, err)
+	}
+	if source, _, _ = cache.get(ctx, urlKey); source != "= URL" {
+		t.Errorf("expected source %q, got %q", "= URL", source)
+	}
+}
+
+// TestBucketCache_Expires tests that entries expire after the TTL.
+func TestBucketCache_Expires(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newBucketCache(setupTestBucket(t, map[string][]byte{}), time.Minute)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	if err := cache.put(ctx, "a.typ", "= A"); err != nil {
+		t.Fatalf("put() returned error: %v", err)
+	}
+
+	cache.now = func() time.Time { return time.Now().Add(59 * time.Second) }
+	if _, ok, _ := cache.get(ctx, "a.typ"); !ok {
+		t.Error("a.typ should still be cached before the TTL")
+	}
+
+	cache.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if _, ok, _ := cache.get(ctx, "a.typ"); ok {
+		t.Error("a.typ should have expired")
+	}
+}
+
+// TestBucketCache_Unavailable tests that lookups and stores fail when the bucket can't be opened.
+func TestBucketCache_Unavailable(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newBucketCache("unknown://template-cache", time.Minute)
+
+	if _, _, err := cache.get(ctx, "a.typ"); err == nil {
+		t.Error("get() should fail when the bucket can't be opened")
+	}
+	if err := cache.put(ctx, "a.typ", "= A"); err == nil {
+		t.Error("put() should fail when the bucket can't be opened")
+	}
+}
+
+// TestHandleGenerate_BucketCache tests generating with a template cache bucket, failing open and closed
+// when it is unavailable.
+func TestHandleGenerate_BucketCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		cacheURL   string
+		failClosed bool
+		wantStatus int
+	}{
+		{name: "available", wantStatus: http.StatusOK},
+		{name: "unavailable fail open", cacheURL: "unknown://template-cache", wantStatus: http.StatusOK},
+		{
+			name:       "unavailable fail closed",
+			cacheURL:   "unknown://template-cache",
+			failClosed: true,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheURL := tt.cacheURL
+			if cacheURL == "" {
+				cacheURL = setupTestBucket(t, map[string][]byte{})
+			}
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:        setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")}),
+				templateCacheURL: cacheURL,
+				cacheFailClosed:  tt.failClosed,
+			})
+			t.Cleanup(func() { _ = srv.Close() })
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"gocloud.dev/blob"
+)
+
+// bucketOverride is the bucket a request's fetches use instead of the configured one.
+type bucketOverride struct {
+	// url is the requested bucket URL.
+	url string
+	// bucket is the bucket opened for the request.
+	bucket *blob.Bucket
+}
+
+// bucketOverrideContextKey is the context key of a request's bucket override.
+type bucketOverrideContextKey struct{}
+
+// defaultBucketOverrideSchemes returns the bucket URL schemes a request may override the bucket with by default.
+func defaultBucketOverrideSchemes() []string {
+	return []string{"s3"}
+}
+
+// validateBucketOverride checks that a requested bucket URL override is enabled and safe to open.
+//
+// Only URLs with an allowed scheme, a bucket name and nothing else are accepted. Query
+// parameters are rejected because drivers read settings such as a custom endpoint from
+// them, which would let a caller point the server at an arbitrary host.
+func (s *Server) validateBucketOverride(ctx context.Context, bucketURL string) error {
+	config := s.requestConfig(ctx)
+	if !config.allowBucketOverride {
+		return errors.New("bucketURL override is disabled")
+	}
+	if config.templateFS != nil {
+		return errors.New("bucketURL override is not supported with embedded templates")
+	}
+
+	parsed, err := url.Parse(bucketURL)
+	if err != nil {
+		return fmt.Errorf("invalid bucketURL: %w", err)
+	}
+	if !slices.Contains(config.bucketOverrideSchemes, parsed.Scheme) {
+		return fmt.Errorf("bucketURL scheme %q is not allowed", parsed.Scheme)
+	}
+	if parsed.Host == "" && parsed.Path == "" || parsed.Opaque != "" {
+		return errors.New("bucketURL must name a bucket")
+	}
+	if parsed.User != nil || parsed.RawQuery != "" || parsed.ForceQuery || parsed.Fragment != "" {
+		return errors.New("bucketURL must not have credentials, query parameters or a fragment")
+	}
+	return nil
+}
+
+// withBucketOverride opens the bucket of a validated bucket URL override and returns a
+// context whose fetches use it, along with a function closing it.
+//
+// An empty bucket URL returns ctx unchanged.
+func (s *Server) withBucketOverride(ctx context.Context, bucketURL string) (context.Context, func(), error) {
+	if bucketURL == "" {
+		return ctx, func() {}, nil
+	}
+
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return nil, nil, newStatusError(http.StatusBadRequest, fmt.Errorf("open bucketURL: %w", err))
+	}
+	closeBucket := func() {
+		if closeErr := bucket.Close(); closeErr != nil {
+			s.logger.Warn("failed to close overridden bucket", "error", closeErr)
+		}
+	}
+	return context.WithValue(ctx, bucketOverrideContextKey{}, bucketOverride{url: bucketURL, bucket: bucket}),
+		closeBucket, nil
+}
+
+// bucketOverrideFromContext returns the bucket override stored by withBucketOverride, if any.
+func bucketOverrideFromContext(ctx context.Context) (bucketOverride, bool) {
+	override, ok := ctx.Value(bucketOverrideContextKey{}).(bucketOverride)
+	return override, ok
+}
+
+// templateCacheKey returns the template cache key of a template key.
+//
+// Templates fetched from an overridden bucket are cached under the bucket URL, and the key
+// includes the request's key prefix, so they never collide with same-named templates of the
+// configured bucket or of other tenants.
+func templateCacheKey(ctx context.Context, key string) string {
+	if !isURLSource(key) {
+		key = bucketKey(ctx, key)
+	}
+	if override, ok := bucketOverrideFromContext(ctx); ok {
+		return override.url + "\x00" + key
+	}
+	return key
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestValidateBucketOverride tests which bucket URL overrides are accepted.
+func TestValidateBucketOverride(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		bucketURL string
+		disabled  bool
+		wantErr   string
+	}{
+		{name: "valid", bucketURL: "s3://tenant-bucket"},
+		{name: "disabled", bucketURL: "s3://tenant-bucket", disabled: true, wantErr: "disabled"},
+		{name: "scheme not allowed", bucketURL: "file:///etc", wantErr: "not allowed"},
+		{name: "no bucket", bucketURL: "s3://", wantErr: "must name a bucket"},
+		{name: "opaque", bucketURL: "s3:tenant-bucket", wantErr: "must name a bucket"},
+		{name: "query", bucketURL: "s3://tenant-bucket?endpoint=http://169.254.169.254", wantErr: "query parameters"},
+		{name: "empty query", bucketURL: "s3://tenant-bucket?", wantErr: "query parameters"},
+		{name: "credentials", bucketURL: "s3://user:secret@tenant-bucket", wantErr: "credentials"},
+		{name: "fragment", bucketURL: "s3://tenant-bucket#x", wantErr: "fragment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp", allowBucketOverride: !tt.disabled})
+
+			err := srv.validateBucketOverride(context.Background(), tt.bucketURL)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBucketOverride(%q) returned error: %v", tt.bucketURL, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateBucketOverride(%q) expected error containing %q, got %v", tt.bucketURL, tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestValidateBucketOverride_Embedded tests that overrides are rejected with embedded templates.
+func TestValidateBucketOverride_Embedded(t *testing.T) {
+	t.Parallel()
+
+	srv := newEmbedTestServer(t)
+	config := *srv.config.Load()
+	config.allowBucketOverride = true
+	srv.config.Store(&config)
+
+	if err := srv.validateBucketOverride(context.Background(), "s3://tenant-bucket"); err == nil {
+		t.Error("expected an error with embedded templates")
+	}
+}
+
+// TestHandleGenerate_BucketOverride tests that templates are fetched from the requested bucket
+// and cached separately from the configured bucket's.
+func TestHandleGenerate_BucketOverride(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Default")})
+	overrideURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Override")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:             bucketURL,
+		templateCacheSize:     10,
+		allowBucketOverride:   true,
+		bucketOverrideSchemes: []string{"file"},
+	})
+	compiler := &recordingCompiler{}
+	srv.compiler = compiler
+
+	tests := []struct {
+		name       string
+		body       string
+		wantSource string
+	}{
+		{name: "default", body: `{"templateKey": "template.typ"}`, wantSource: "= Default"},
+		{
+			name:       "override",
+			body:       `{"templateKey": "template.typ", "bucketURL": "` + overrideURL + `"}`,
+			wantSource: "= Override",
+		},
+		{name: "default again", body: `{"templateKey": "template.typ"}`, wantSource: "= Default"},
+	}
+
+	// The cases share the server's template cache, so they run in order.
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+
+		srv.handleGenerate(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if compiler.files[sourceFileName] != tt.wantSource {
+			t.Errorf("%s: expected template %q, got %q", tt.name, tt.wantSource, compiler.files[sourceFileName])
+		}
+	}
+}
+
+// TestHandleGenerate_BucketOverrideRejected tests that invalid overrides are rejected before fetching.
+func TestHandleGenerate_BucketOverrideRejected(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Default")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+
+	body := `{"templateKey": "template.typ", "bucketURL": "s3://tenant-bucket"}`
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+}
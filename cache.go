@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCacheUnavailable is returned when the template cache fails and the server fails closed.
+var errCacheUnavailable = errors.New("template cache unavailable, try again later")
+
+// templateCache is a fixed-size LRU cache of template sources with a TTL, optionally also bounded
+// by the total size of the sources.
+//
+// It implements sourceCache and never returns an error. It is safe for concurrent use.
+type templateCache struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+	// size is the maximum number of entries.
+	size int
+	// maxBytes is the maximum total size of the sources. Zero means no limit.
+	maxBytes int64
+	// bytes is the total size of the sources.
+	bytes int64
+	// ttl is how long an entry stays valid after it was stored.
+	ttl time.Duration
+	// order holds the entries, most recently used first.
+	order *list.List
+	// entries maps template keys to their element in order.
+	entries map[string]*list.Element
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+}
+
+// templateCacheEntry is a single cached template.
+type templateCacheEntry struct {
+	// key is the template key.
+	key string
+	// source is the template source.
+	source string
+	// expiresAt is when the entry stops being valid.
+	expiresAt time.Time
+}
+
+// newTemplateCache creates a new template cache holding up to size entries, and up to maxBytes of
+// sources unless maxBytes is zero, for ttl.
+func newTemplateCache(size int, maxBytes int64, ttl time.Duration) *templateCache {
+	return &templateCache{
+		size:     size,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, size),
+		now:      time.Now,
+	}
+}
+
+// get returns the cached source for key, if present and not expired.
+func (c *templateCache) get(_ context.Context, key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	entry, _ := elem.Value.(*templateCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return "", false, nil
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.source, true, nil
+}
+
+// put stores the source for key, evicting the least recently used entries while the cache is
+// over its limits. A source larger than maxBytes on its own isn't cached.
+func (c *templateCache) put(_ context.Context, key, source string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if c.maxBytes > 0 && int64(len(source)) > c.maxBytes {
+		return nil
+	}
+
+	entry := &templateCacheEntry{key: key, source: source, expiresAt: c.now().Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.bytes += int64(len(source))
+
+	for c.order.Len() > c.size || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.order.Back())
+	}
+
+	return nil
+}
+
+// remove removes the entry of elem. The caller holds mu.
+func (c *templateCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	if entry, ok := elem.Value.(*templateCacheEntry); ok {
+		delete(c.entries, entry.key)
+		c.bytes -= int64(len(entry.source))
+	}
+}
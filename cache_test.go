@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestTemplateCache_GetPut tests storing and retrieving templates.
+func TestTemplateCache_GetPut(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newTemplateCache(2, 0, time.Minute)
+
+	if _, ok, _ := cache.get(ctx, "a.typ"); ok {
+		t.Fatal("get() on empty cache should miss")
+	}
+
+	_ = cache.put(ctx, "a.typ", "= A")
+	source, ok, _ := cache.get(ctx, "a.typ")
+	if !ok {
+		t.Fatal("get() should hit after put()")
+	}
+	if source != "= A" {
+		t.Errorf("expected source %q, got %q", "= A", source)
+	}
+
+	_ = cache.put(ctx, "a.typ", "= A2")
+	if source, _, _ = cache.get(ctx, "a.typ"); source != "= A2" {
+		t.Errorf("expected updated source %q, got %q", "= A2", source)
+	}
+}
+
+// TestTemplateCache_EvictsLeastRecentlyUsed tests LRU eviction.
+func TestTemplateCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newTemplateCache(2, 0, time.Minute)
+
+	_ = cache.put(ctx, "a.typ", "= A")
+	_ = cache.put(ctx, "b.typ", "= B")
+	// Touch a.typ so b.typ becomes the least recently used.
+	_, _, _ = cache.get(ctx, "a.typ")
+	_ = cache.put(ctx, "c.typ", "= C")
+
+	if _, ok, _ := cache.get(ctx, "b.typ"); ok {
+		t.Error("b.typ should have been evicted")
+	}
+	if _, ok, _ := cache.get(ctx, "a.typ"); !ok {
+		t.Error("a.typ should still be cached")
+	}
+	if _, ok, _ := cache.get(ctx, "c.typ"); !ok {
+		t.Error("c.typ should be cached")
+	}
+}
+
+// TestTemplateCache_MaxBytes tests that entries are evicted to stay within the total size limit.
+func TestTemplateCache_MaxBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newTemplateCache(10, 8, time.Minute)
+
+	_ = cache.put(ctx, "a.otf", "aaaa")
+	_ = cache.put(ctx, "b.otf", "bbbb")
+	_ = cache.put(ctx, "c.otf", "cc")
+
+	if _, ok, _ := cache.get(ctx, "a.otf"); ok {
+		t.Error("a.otf should have been evicted to stay within maxBytes")
+	}
+	for _, key := range []string{"b.otf", "c.otf"} {
+		if _, ok, _ := cache.get(ctx, key); !ok {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+
+	// A replaced entry's old size is released, and an entry over maxBytes isn't cached at all.
+	_ = cache.put(ctx, "b.otf", "b")
+	_ = cache.put(ctx, "big.otf", "123456789")
+	if _, ok, _ := cache.get(ctx, "big.otf"); ok {
+		t.Error("big.otf is larger than maxBytes and shouldn't be cached")
+	}
+	if cache.bytes != 3 {
+		t.Errorf("expected 3 cached bytes, got %d", cache.bytes)
+	}
+}
+
+// TestTemplateCache_Expires tests TTL expiry.
+func TestTemplateCache_Expires(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ctx := context.Background()
+	cache := newTemplateCache(2, 0, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	_ = cache.put(ctx, "a.typ", "= A")
+
+	now = now.Add(59 * time.Second)
+	if _, ok, _ := cache.get(ctx, "a.typ"); !ok {
+		t.Error("entry should be valid before the TTL")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok, _ := cache.get(ctx, "a.typ"); ok {
+		t.Error("entry should expire after the TTL")
+	}
+}
@@ -0,0 +1,139 @@
+// Package client is a Go client for the givetypst HTTP API.
+//
+// Its request and response types are the ones the server decodes, so the two can't drift apart.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxErrorSize is the maximum number of bytes of an error response that are read.
+	maxErrorSize = 64 << 10
+	// contentTypeJSON is the content type of JSON requests and compile error responses.
+	contentTypeJSON = "application/json"
+)
+
+// Client calls a givetypst server.
+type Client struct {
+	// BaseURL is the URL of the server, such as "http://localhost:8080".
+	BaseURL string
+	// Token is sent as a bearer token, for servers that set AUTH_TOKEN. Empty sends none.
+	Token string
+	// HTTPClient sends the requests. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// Error is the error of a request the server responded to with an error status.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the error code of a compile error, such as "compile_failed". Empty for other errors.
+	Code string
+	// Message is the server's error message, or the first diagnostic of a compile error.
+	Message string
+	// Diagnostics are the errors typst reported for a compile error.
+	Diagnostics []Diagnostic
+}
+
+// Error returns the status and the server's message.
+func (e *Error) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message == "" {
+		return "givetypst: " + status
+	}
+	return "givetypst: " + status + ": " + e.Message
+}
+
+// Generate generates a document and returns it.
+func (c *Client) Generate(ctx context.Context, req GenerateRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.GenerateToWriter(ctx, req, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateToWriter generates a document and copies it to w as it's received, without
+// holding it in memory.
+//
+// A response with an error status returns an *Error, and nothing is written to w.
+func (c *Client) GenerateToWriter(ctx context.Context, req GenerateRequest, w io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(c.BaseURL, "/")+"/generate", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", contentTypeJSON)
+	if c.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("post generate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if _, copyErr := io.Copy(w, resp.Body); copyErr != nil {
+		return fmt.Errorf("read document: %w", copyErr)
+	}
+	return nil
+}
+
+// responseError returns the *Error of a response with an error status.
+//
+// Compile errors are JSON with the typst diagnostics; other errors are plain text.
+func responseError(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if err != nil {
+		return errors.Join(&Error{StatusCode: resp.StatusCode}, fmt.Errorf("read error response: %w", err))
+	}
+
+	respErr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != contentTypeJSON {
+		return respErr
+	}
+
+	var compileErr CompileErrorResponse
+	if json.Unmarshal(body, &compileErr) != nil || compileErr.Error == "" {
+		return respErr
+	}
+	respErr.Code = compileErr.Error
+	respErr.Diagnostics = compileErr.Diagnostics
+	switch {
+	case len(compileErr.Diagnostics) > 0:
+		respErr.Message = compileErr.Diagnostics[0].Message
+	case compileErr.Output != "":
+		respErr.Message = strings.TrimSpace(compileErr.Output)
+	default:
+		respErr.Message = compileErr.Error
+	}
+	return respErr
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestClient_Generate tests generating documents and the errors of failed requests.
+func TestClient_Generate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		token       string
+		status      int
+		contentType string
+		body        string
+		want        string
+		wantErr     *Error
+	}{
+		{
+			name:        "document",
+			status:      http.StatusOK,
+			contentType: "application/pdf",
+			body:        "%PDF-1.7",
+			want:        "%PDF-1.7",
+		},
+		{
+			name:        "token",
+			token:       "secret",
+			status:      http.StatusOK,
+			contentType: "application/pdf",
+			body:        "%PDF-1.7",
+			want:        "%PDF-1.7",
+		},
+		{
+			name:        "plain text error",
+			status:      http.StatusNotFound,
+			contentType: "text/plain; charset=utf-8",
+			body:        "template not found: missing.typ\n",
+			wantErr:     &Error{StatusCode: http.StatusNotFound, Message: "template not found: missing.typ"},
+		},
+		{
+			name:        "compile error",
+			status:      http.StatusUnprocessableEntity,
+			contentType: "application/json",
+			body: `{"error": "compile_failed", "diagnostics": [` +
+				`{"file": "main.typ", "line": 2, "column": 1, "message": "unclosed delimiter"}]}`,
+			wantErr: &Error{
+				StatusCode:  http.StatusUnprocessableEntity,
+				Code:        "compile_failed",
+				Message:     "unclosed delimiter",
+				Diagnostics: []Diagnostic{{File: "main.typ", Line: 2, Column: 1, Message: "unclosed delimiter"}},
+			},
+		},
+		{
+			name:        "compile error without diagnostics",
+			status:      http.StatusUnprocessableEntity,
+			contentType: "application/json",
+			body:        `{"error": "compile_failed", "output": "error: something went wrong\n"}`,
+			wantErr: &Error{
+				StatusCode: http.StatusUnprocessableEntity,
+				Code:       "compile_failed",
+				Message:    "error: something went wrong",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got GenerateRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/generate" {
+					t.Errorf("expected POST /generate, got %s %s", r.Method, r.URL.Path)
+				}
+				if auth := r.Header.Get("Authorization"); tt.token != "" && auth != "Bearer "+tt.token {
+					t.Errorf("expected Authorization %q, got %q", "Bearer "+tt.token, auth)
+				} else if tt.token == "" && auth != "" {
+					t.Errorf("expected no Authorization, got %q", auth)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode request: %v", err)
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			c := New(srv.URL + "/")
+			c.Token = tt.token
+			doc, err := c.Generate(context.Background(), GenerateRequest{
+				TemplateKey: "report.typ",
+				Data:        map[string]any{"title": "Q3"},
+			})
+
+			if got.TemplateKey != "report.typ" {
+				t.Errorf("expected templateKey %q, got %q", "report.typ", got.TemplateKey)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Generate() error = %v", err)
+				}
+				if string(doc) != tt.want {
+					t.Errorf("expected document %q, got %q", tt.want, doc)
+				}
+				return
+			}
+
+			var respErr *Error
+			if !errors.As(err, &respErr) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			if respErr.StatusCode != tt.wantErr.StatusCode || respErr.Code != tt.wantErr.Code ||
+				respErr.Message != tt.wantErr.Message || len(respErr.Diagnostics) != len(tt.wantErr.Diagnostics) {
+				t.Errorf("expected error %+v, got %+v", tt.wantErr, respErr)
+			}
+			for i, want := range tt.wantErr.Diagnostics {
+				if got := respErr.Diagnostics[i]; got.Message != want.Message || got.Line != want.Line {
+					t.Errorf("expected diagnostic %+v, got %+v", want, got)
+				}
+			}
+			if doc != nil {
+				t.Errorf("expected no document, got %q", doc)
+			}
+		})
+	}
+}
+
+// TestClient_GenerateToWriter tests that documents are written to w, and error bodies aren't.
+func TestClient_GenerateToWriter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "document", status: http.StatusOK, body: "%PDF-1.7", want: "%PDF-1.7"},
+		{
+			name:    "error",
+			status:  http.StatusBadRequest,
+			body:    "templateKey or template is required",
+			wantErr: "givetypst: 400 Bad Request: templateKey or template is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			var buf bytes.Buffer
+			err := New(srv.URL).GenerateToWriter(context.Background(), GenerateRequest{TemplateKey: "report.typ"}, &buf)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("GenerateToWriter() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("GenerateToWriter() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("expected %q written, got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+// TestClient_Unreachable tests that a failed request isn't an *Error.
+func TestClient_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := New(srv.URL).Generate(context.Background(), GenerateRequest{TemplateKey: "report.typ"})
+	var respErr *Error
+	if err == nil || errors.As(err, &respErr) {
+		t.Errorf("expected a transport error, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "post generate") {
+		t.Errorf("expected error to mention the request, got %v", err)
+	}
+}
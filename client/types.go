@@ -0,0 +1,102 @@
+package client
+
+// GenerateRequest is the request body for the /generate endpoint.
+type GenerateRequest struct {
+	// TemplateKey is the key of the template in the storage bucket.
+	TemplateKey string `json:"templateKey,omitempty"`
+	// Template is the inline template source, used instead of TemplateKey.
+	Template string `json:"template,omitempty"`
+	// Data is the inline data to inject into the template. Any JSON value but null, e.g. an array.
+	Data any `json:"data,omitempty"`
+	// DataYAML is inline data to inject into the template, as a YAML document.
+	DataYAML string `json:"dataYaml,omitempty"`
+	// DataKey is the key of a JSON, YAML or CSV data file in the storage bucket.
+	DataKey string `json:"dataKey,omitempty"`
+	// MergeData deep-merges Data on top of the JSON or YAML data file of DataKey, instead of
+	// rejecting a request with both.
+	MergeData bool `json:"mergeData,omitempty"`
+	// DataFormat is the format of the DataKey file ("json", "yaml" or "csv").
+	// Detected from the key's extension when empty.
+	DataFormat string `json:"dataFormat,omitempty"`
+	// NoCache bypasses the template cache for this request.
+	NoCache bool `json:"noCache,omitempty"`
+	// IncludeKeys are the keys of additional template files in the storage bucket, such as
+	// files imported or included by the template. They are staged under their path relative
+	// to the template's directory.
+	IncludeKeys []string `json:"includeKeys,omitempty"`
+	// AssetKeys are the keys of binary files in the storage bucket, such as images read by the
+	// template. They are staged under their keys, relative to the project root.
+	AssetKeys []string `json:"assetKeys,omitempty"`
+	// FontKeys are the keys of font files in the storage bucket, which typst searches before
+	// TYPST_FONT_PATH and the system fonts.
+	FontKeys []string `json:"fontKeys,omitempty"`
+	// Format is the output format ("pdf", "png" or "svg"). Defaults to "pdf".
+	Format string `json:"format,omitempty"`
+	// Metadata is written to the document through a "#set document(...)" rule prepended to the template.
+	Metadata *DocumentMetadata `json:"metadata,omitempty"`
+	// Pages selects the pages to export, such as "1", "1-3" or "2,4". Defaults to all pages.
+	// Image formats must select a single page, and default to the first.
+	Pages string `json:"pages,omitempty"`
+	// BucketURL overrides the configured bucket for this request's fetches. Only honored
+	// when ALLOW_BUCKET_OVERRIDE is enabled.
+	BucketURL string `json:"bucketURL,omitempty"`
+	// Watermark is passed to typst as sys.inputs.watermark, for templates that render an
+	// overlay such as "DRAFT". Templates that don't read it ignore it.
+	Watermark string `json:"watermark,omitempty"`
+	// Filename is the suggested file name of the document. Directory components are stripped,
+	// and the format's extension is appended if missing. Defaults to "output" plus the extension,
+	// or to the template key's base name with FILENAME_FROM_TEMPLATE.
+	Filename string `json:"filename,omitempty"`
+	// Optimize compresses and linearizes the PDF with qpdf or Ghostscript, if either is installed.
+	// Only supported for PDF output.
+	Optimize bool `json:"optimize,omitempty"`
+	// TypstVersion pins the document to a typst version from TYPST_VERSIONS, such as "0.11".
+	// Empty compiles with the default typst binary.
+	TypstVersion string `json:"typstVersion,omitempty"`
+	// TypstFlags are additional "typst compile" flags, such as "--jobs=4". Each flag must be in
+	// TYPST_ALLOWED_FLAGS, and values are joined to their flag with "=".
+	TypstFlags []string `json:"typstFlags,omitempty"`
+	// CreationTime is the creation time embedded in the document, and the date of datetime.today(),
+	// as an RFC 3339 timestamp such as "2024-01-02T15:04:05Z". Defaults to the UNIX epoch, so that
+	// identical requests produce identical documents.
+	CreationTime string `json:"creationTime,omitempty"`
+	// MetaOnly compiles the document without returning it, as a cheap check that the request renders.
+	// A successful compile responds with 200 OK and an empty body, a failed one with the usual error.
+	MetaOnly bool `json:"metaOnly,omitempty"`
+}
+
+// DocumentMetadata is the document metadata of a generate request, written to the PDF.
+type DocumentMetadata struct {
+	// Title is the document title.
+	Title string `json:"title,omitempty"`
+	// Author is the document author.
+	Author string `json:"author,omitempty"`
+	// Keywords are the document keywords.
+	Keywords []string `json:"keywords,omitempty"`
+	// Date is the document date, as YYYY-MM-DD.
+	Date string `json:"date,omitempty"`
+}
+
+// Diagnostic is an error or warning reported by typst, located in a template file when typst reported a location.
+type Diagnostic struct {
+	// File is the path of the file relative to the project root, e.g. "main.typ".
+	File string `json:"file,omitempty"`
+	// Line is the 1-based line of the diagnostic in File.
+	Line int `json:"line,omitempty"`
+	// Column is the 1-based column of the diagnostic in Line.
+	Column int `json:"column,omitempty"`
+	// Message is the error or warning message.
+	Message string `json:"message"`
+	// Hints are suggestions typst gave for fixing the error or warning.
+	Hints []string `json:"hints,omitempty"`
+}
+
+// CompileErrorResponse is the JSON body of a failed compile or query.
+type CompileErrorResponse struct {
+	// Error is the error code, such as "compile_failed" or "package_resolution_failed".
+	Error string `json:"error"`
+	// Diagnostics are the errors reported by typst.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// Output is the raw typst output, set only when it couldn't be parsed into diagnostics.
+	Output string `json:"output,omitempty"`
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxConfigFileSize is the maximum size of the -config file.
+const maxConfigFileSize = 1 << 20
+
+// configFileKeyPattern matches the keys of a config file, which are environment variable names.
+var configFileKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// flagSettings maps flags to the environment variables of the same setting.
+//
+// -v isn't read from LOG_LEVEL, but a level takes precedence over it, so setting -v drops the
+// file's LOG_LEVEL like any other flag drops its variable.
+func flagSettings() map[string]string {
+	return map[string]string{"host": "HOST", "port": "PORT", "v": "LOG_LEVEL"}
+}
+
+// settings looks up configuration values, first in the environment and then in the -config file.
+//
+// The file's values are never written to the process environment, so re-reading the file replaces
+// them completely.
+type settings struct {
+	// file holds the values of the -config file, or is nil without one.
+	file map[string]string
+}
+
+// get returns the value of the environment variable name, or the file's value of it if the
+// variable is unset. Like everywhere else, empty variables count as unset.
+func (s settings) get(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return s.file[name]
+}
+
+// loadSettings loads the YAML or JSON config file at path below the environment. An empty path
+// loads only the environment.
+//
+// The file maps environment variable names to values, e.g. "MAX_DATA_SIZE: 1048576".
+// Variables set in the environment take precedence over the file, and so do the flags set in
+// flags over the variables of the same setting, such as -port over PORT.
+func loadSettings(path string, flags *flag.FlagSet) (settings, error) {
+	if path == "" {
+		return settings{}, nil
+	}
+	content, err := readConfigFile(path)
+	if err != nil {
+		return settings{}, err
+	}
+	file, err := parseConfigFile(content)
+	if err != nil {
+		return settings{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		delete(file, flagSettings()[f.Name])
+	})
+	return settings{file: file}, nil
+}
+
+// readConfigFile reads the config file at path, reading at most one byte past the size limit.
+func readConfigFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxConfigFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if len(content) > maxConfigFileSize {
+		return nil, fmt.Errorf("config file exceeds maximum size of %d bytes", maxConfigFileSize)
+	}
+	return content, nil
+}
+
+// parseConfigFile parses a config file into environment variable values.
+//
+// Values may be strings, numbers or booleans. Lists of them are joined with commas for the
+// variables that take comma-separated lists, such as TENANT_ALLOWLIST.
+func parseConfigFile(content []byte) (map[string]string, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+
+	settings := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if !configFileKeyPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid key %q: must be an environment variable name, e.g. MAX_DATA_SIZE", name)
+		}
+		formatted, err := formatConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		settings[name] = formatted
+	}
+	return settings, nil
+}
+
+// formatConfigValue formats a config file value as an environment variable value.
+func formatConfigValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, isList := item.([]any); isList {
+				return "", errors.New("lists can't be nested")
+			}
+			formatted, err := formatConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = formatted
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseConfigFile tests parsing YAML and JSON config files into environment variable values.
+func TestParseConfigFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			content: "BUCKET_URL: s3://templates\nMAX_DATA_SIZE: 1048576\nMETRICS_ENABLED: false\nDEBUG_SAMPLE_RATE: 0.5\n",
+			want: map[string]string{
+				"BUCKET_URL":        "s3://templates",
+				"MAX_DATA_SIZE":     "1048576",
+				"METRICS_ENABLED":   "false",
+				"DEBUG_SAMPLE_RATE": "0.5",
+			},
+		},
+		{
+			name:    "json",
+			content: `{"COMPILE_TIMEOUT": "30s", "TENANT_ALLOWLIST": ["acme", "globex"]}`,
+			want:    map[string]string{"COMPILE_TIMEOUT": "30s", "TENANT_ALLOWLIST": "acme,globex"},
+		},
+		{name: "empty", content: "", want: map[string]string{}},
+		{name: "lowercase key", content: "bucket_url: s3://templates", wantErr: `invalid key "bucket_url"`},
+		{name: "object value", content: "OUTPUT_ACL:\n  mode: private\n", wantErr: "invalid value of OUTPUT_ACL"},
+		{name: "nested list", content: "TENANT_ALLOWLIST: [[acme]]", wantErr: "can't be nested"},
+		{name: "not a mapping", content: "- BUCKET_URL", wantErr: "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseConfigFile([]byte(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigFile() returned error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseConfigFile() = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("expected %s=%q, got %q", name, value, got[name])
+				}
+			}
+		})
+	}
+}
+
+// TestLoadSettings tests that config file values fill in unset environment variables only,
+// without being written to the environment.
+func TestLoadSettings(t *testing.T) {
+	t.Setenv("MAX_DATA_SIZE", "")
+	t.Setenv("MAX_ASSET_SIZE", "")
+	t.Setenv("JOB_TTL", "2h")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "MAX_DATA_SIZE: 2048\nMAX_ASSET_SIZE: 4096\nJOB_TTL: 1h\n"
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	env, err := loadSettings(configPath, flag.NewFlagSet("givetypst", flag.ContinueOnError))
+	if err != nil {
+		t.Fatalf("loadSettings() returned error: %v", err)
+	}
+
+	config := serverConfigFromEnv("mem://", env)
+	if config.maxDataSize != 2048 || config.maxAssetSize != 4096 {
+		t.Errorf("expected sizes from the config file, got %d and %d", config.maxDataSize, config.maxAssetSize)
+	}
+	if config.jobTTL != 2*time.Hour {
+		t.Errorf("expected the environment to take precedence, got jobTTL %s", config.jobTTL)
+	}
+	if got := os.Getenv("MAX_DATA_SIZE"); got != "" {
+		t.Errorf("expected the environment to be left unchanged, got MAX_DATA_SIZE=%q", got)
+	}
+}
+
+// TestLoadSettings_Flags tests that flags set on the command line take precedence over the file.
+func TestLoadSettings_Flags(t *testing.T) {
+	t.Setenv("PORT", "")
+	t.Setenv("HOST", "")
+	t.Setenv("LOG_LEVEL", "")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "PORT: 9000\nHOST: 127.0.0.1\nLOG_LEVEL: warn\n"
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	flags := flag.NewFlagSet("givetypst", flag.ContinueOnError)
+	flags.String("host", "", "")
+	flags.String("port", "", "")
+	flags.Bool("v", false, "")
+	if err := flags.Parse([]string{"-port", "8081", "-v"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	env, err := loadSettings(configPath, flags)
+	if err != nil {
+		t.Fatalf("loadSettings() returned error: %v", err)
+	}
+
+	want := map[string]string{"PORT": "", "HOST": "127.0.0.1", "LOG_LEVEL": ""}
+	for name, value := range want {
+		if got := env.get(name); got != value {
+			t.Errorf("expected %s=%q, got %q", name, value, got)
+		}
+	}
+}
+
+// TestLoadSettings_Errors tests that unreadable and invalid config files are rejected.
+func TestLoadSettings_Errors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	invalidPath := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalidPath, []byte("port: 8080"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	oversizedPath := filepath.Join(dir, "oversized.yaml")
+	oversized := "# " + strings.Repeat("x", maxConfigFileSize)
+	if err := os.WriteFile(oversizedPath, []byte(oversized), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "missing", path: filepath.Join(dir, "missing.yaml"), wantErr: "failed to read config file"},
+		{name: "invalid", path: invalidPath, wantErr: "invalid config file"},
+		{name: "oversized", path: oversizedPath, wantErr: "exceeds maximum size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadSettings(tt.path, flag.NewFlagSet("givetypst", flag.ContinueOnError))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// notJSONMediaTypes are media types that a JSON data file is never stored as.
+func notJSONMediaTypes() []string {
+	return []string{"text/html", "application/xhtml+xml", "text/xml", "application/xml", "application/pdf"}
+}
+
+// notJSONReason returns why a data file with the given stored content type and content is
+// obviously not JSON, or "" if it may be JSON.
+//
+// Generic content types such as application/octet-stream are accepted, since objects are
+// often uploaded without one. The content is sniffed to catch mislabeled objects, such as
+// an HTML error page stored as a .json object.
+func notJSONReason(contentType string, data []byte) string {
+	if mediaType := notJSONMediaType(contentType); mediaType != "" {
+		return "stored as " + mediaType
+	}
+	if mediaType := notJSONMediaType(http.DetectContentType(data)); mediaType != "" {
+		return "content looks like " + mediaType
+	}
+	return ""
+}
+
+// notJSONMediaType returns the media type of contentType if it's never JSON, or "" otherwise.
+func notJSONMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	for _, notJSON := range notJSONMediaTypes() {
+		if mediaType == notJSON {
+			return mediaType
+		}
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return mediaType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// TestNotJSONReason tests detecting data files that are obviously not JSON.
+func TestNotJSONReason(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		want        string
+	}{
+		{name: "json", contentType: "application/json", data: `{"name": "World"}`},
+		{name: "octet stream", contentType: "application/octet-stream", data: `{"name": "World"}`},
+		{name: "no content type", data: `{"name": "World"}`},
+		{name: "invalid content type", contentType: "not a type;;", data: `{}`},
+		{
+			name:        "html content type",
+			contentType: "text/html; charset=utf-8",
+			data:        `{}`,
+			want:        "stored as text/html",
+		},
+		{name: "image content type", contentType: "image/png", data: `{}`, want: "stored as image/png"},
+		{
+			name:        "html content",
+			contentType: "application/json",
+			data:        "<!DOCTYPE html><html><body>Access denied</body></html>",
+			want:        "content looks like text/html",
+		},
+		{name: "pdf content", data: "%PDF-1.7", want: "content looks like application/pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := notJSONReason(tt.contentType, []byte(tt.data)); got != tt.want {
+				t.Errorf("notJSONReason(%q, %q) = %q, want %q", tt.contentType, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_CheckDataContentType tests rejecting mislabeled JSON data files.
+func TestHandleGenerate_CheckDataContentType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		check       bool
+		data        string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "json", check: true, data: `{"name": "World"}`, wantStatus: http.StatusOK},
+		{
+			name:        "json stored as octet stream",
+			check:       true,
+			data:        `{"name": "World"}`,
+			contentType: "application/octet-stream",
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "html stored as json",
+			check:       true,
+			data:        "<html><body>Not found</body></html>",
+			contentType: "application/json",
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "json stored as html",
+			check:       true,
+			data:        `{"name": "World"}`,
+			contentType: "text/html",
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "html without check",
+			data:       "<html><body>Not found</body></html>",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			bucket, err := blob.OpenBucket(context.Background(), bucketURL)
+			if err != nil {
+				t.Fatalf("failed to open bucket: %v", err)
+			}
+			opts := &blob.WriterOptions{ContentType: tt.contentType}
+			if writeErr := bucket.WriteAll(context.Background(), "data.json", []byte(tt.data), opts); writeErr != nil {
+				t.Fatalf("failed to write data: %v", writeErr)
+			}
+			_ = bucket.Close()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, checkDataContentType: tt.check})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := `{"templateKey": "template.typ", "dataKey": "data.json"}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity && !strings.Contains(rec.Body.String(), "not JSON") {
+				t.Errorf("expected a not JSON error, got %q", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// dataKeysOff disables checking data keys against the template's expected keys.
+	dataKeysOff = "off"
+	// dataKeysWarn reports data key mismatches in a response header and the logs.
+	dataKeysWarn = "warn"
+	// dataKeysStrict rejects requests whose data keys don't match with 422 Unprocessable Entity.
+	dataKeysStrict = "strict"
+	// dataKeysFileSuffix replaces a template's extension to form the key of its expected keys file.
+	dataKeysFileSuffix = ".keys.json"
+	// dataKeysMismatchHeader is the response header reporting data key mismatches in warn mode.
+	dataKeysMismatchHeader = "X-Data-Keys-Mismatch"
+)
+
+// dataKeysFile is the expected keys file stored next to a template.
+type dataKeysFile struct {
+	// Keys are the top-level data keys the template expects.
+	Keys []string `json:"keys"`
+}
+
+// dataKeysMismatch is the difference between the expected and provided top-level data keys.
+type dataKeysMismatch struct {
+	// missing are expected keys absent from the data.
+	missing []string
+	// unexpected are data keys the template doesn't expect.
+	unexpected []string
+}
+
+// String formats the mismatch as "missing=a,b; unexpected=c".
+func (m dataKeysMismatch) String() string {
+	var parts []string
+	if len(m.missing) > 0 {
+		parts = append(parts, "missing="+strings.Join(m.missing, ","))
+	}
+	if len(m.unexpected) > 0 {
+		parts = append(parts, "unexpected="+strings.Join(m.unexpected, ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// dataKeysPath returns the key of the expected keys file of a template, such as
+// "invoices/invoice.keys.json" for "invoices/invoice.typ".
+func dataKeysPath(templateKey string) string {
+	return strings.TrimSuffix(templateKey, path.Ext(templateKey)) + dataKeysFileSuffix
+}
+
+// checkDataKeys compares the top-level data keys with the keys the template expects.
+//
+// Only bucket templates with an expected keys file next to them are checked. In warn mode a
+// mismatch is logged and reported in the X-Data-Keys-Mismatch header; in strict mode it is
+// returned as a 422 error.
+func (s *Server) checkDataKeys(
+	ctx context.Context,
+	header http.Header,
+	logger *slog.Logger,
+	templateKey string,
+	data resolvedData,
+) error {
+	config := s.requestConfig(ctx)
+	if config.dataKeysMode == dataKeysOff || templateKey == "" || data.raw != nil {
+		return nil
+	}
+
+	// Most templates don't have an expected keys file, so check before fetching to keep
+	// the bucket fetch error metric meaningful.
+	keysPath := dataKeysPath(templateKey)
+	exists, err := s.objectExists(ctx, keysPath)
+	if err != nil {
+		return fmt.Errorf("failed to check expected data keys: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	keysData, err := s.fetchFromBucket(ctx, keysPath, config.maxTemplateSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch expected data keys: %w", err)
+	}
+
+	var keysFile dataKeysFile
+	if unmarshalErr := json.Unmarshal(keysData, &keysFile); unmarshalErr != nil {
+		return fmt.Errorf("invalid expected data keys file: %w", unmarshalErr)
+	}
+
+	mismatch, ok := compareDataKeys(keysFile.Keys, data.values)
+	if ok {
+		return nil
+	}
+
+	if config.dataKeysMode == dataKeysStrict {
+		return newStatusError(http.StatusUnprocessableEntity,
+			fmt.Errorf("data keys don't match the template: %s", mismatch))
+	}
+	logger.Warn("data keys don't match the template", "templateKey", templateKey, "mismatch", mismatch.String())
+	header.Set(dataKeysMismatchHeader, mismatch.String())
+	return nil
+}
+
+// compareDataKeys returns the sorted missing and unexpected top-level keys of data, and
+// whether the keys match.
+//
+// Data that isn't an object, such as an array, has no keys, so every expected key is missing.
+func compareDataKeys(expected []string, data any) (dataKeysMismatch, bool) {
+	var mismatch dataKeysMismatch
+	object, _ := data.(map[string]any)
+	for _, key := range expected {
+		if _, ok := object[key]; !ok {
+			mismatch.missing = append(mismatch.missing, key)
+		}
+	}
+	for key := range object {
+		if !slices.Contains(expected, key) {
+			mismatch.unexpected = append(mismatch.unexpected, key)
+		}
+	}
+	slices.Sort(mismatch.missing)
+	slices.Sort(mismatch.unexpected)
+	return mismatch, len(mismatch.missing) == 0 && len(mismatch.unexpected) == 0
+}
+
+// objectExists reports whether key exists in the template filesystem or storage bucket.
+func (s *Server) objectExists(ctx context.Context, key string) (bool, error) {
+	key = bucketKey(ctx, key)
+	config := s.requestConfig(ctx)
+	if config.templateFS != nil {
+		_, err := fs.Stat(config.templateFS, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("check key %s: %w", key, err)
+		}
+		return true, nil
+	}
+
+	bucket, err := s.openBucket(ctx)
+	if err != nil {
+		return false, err
+	}
+	exists, err := bucket.Exists(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("check key %s: %w", key, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleGenerate_DataKeys tests checking data keys against the template's expected keys file.
+func TestHandleGenerate_DataKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		mode         string
+		templateKey  string
+		data         string
+		wantStatus   int
+		wantMismatch string
+	}{
+		{
+			name:        "matching keys",
+			mode:        dataKeysStrict,
+			templateKey: "invoice.typ",
+			data:        `{"customer": "Acme", "total": 10}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "strict missing keys",
+			mode:        dataKeysStrict,
+			templateKey: "invoice.typ",
+			data:        `{"customer": "Acme"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "strict unexpected keys",
+			mode:        dataKeysStrict,
+			templateKey: "invoice.typ",
+			data:        `{"customer": "Acme", "total": 10, "totl": 10}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "warn",
+			mode:         dataKeysWarn,
+			templateKey:  "invoice.typ",
+			data:         `{"customer": "Acme", "totl": 10}`,
+			wantStatus:   http.StatusOK,
+			wantMismatch: "missing=total; unexpected=totl",
+		},
+		{
+			name:        "no keys file",
+			mode:        dataKeysStrict,
+			templateKey: "letter.typ",
+			data:        `{"anything": true}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "off",
+			mode:        "",
+			templateKey: "invoice.typ",
+			data:        `{"totl": 10}`,
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"invoice.typ":       []byte("= Invoice"),
+				"invoice.keys.json": []byte(`{"keys": ["customer", "total"]}`),
+				"letter.typ":        []byte("= Letter"),
+			})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, dataKeysMode: tt.mode})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := `{"templateKey": "` + tt.templateKey + `", "data": ` + tt.data + `}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get(dataKeysMismatchHeader); got != tt.wantMismatch {
+				t.Errorf("expected %s %q, got %q", dataKeysMismatchHeader, tt.wantMismatch, got)
+			}
+		})
+	}
+}
+
+// TestDataKeysPath tests deriving the expected keys file of a template.
+func TestDataKeysPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		templateKey string
+		want        string
+	}{
+		{templateKey: "invoice.typ", want: "invoice.keys.json"},
+		{templateKey: "invoices/invoice.typ", want: "invoices/invoice.keys.json"},
+		{templateKey: "report", want: "report.keys.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.templateKey, func(t *testing.T) {
+			t.Parallel()
+
+			if got := dataKeysPath(tt.templateKey); got != tt.want {
+				t.Errorf("dataKeysPath(%q) = %q, want %q", tt.templateKey, got, tt.want)
+			}
+		})
+	}
+}
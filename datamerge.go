@@ -0,0 +1,24 @@
+package main
+
+import "maps"
+
+// mergeData deep-merges override on top of base, for requests that set mergeData.
+//
+// Objects are merged key by key, recursively, with override winning on conflicts. Any other value
+// in override, including an array, replaces the value in base. Neither argument is modified.
+func mergeData(base, override any) any {
+	baseObject, baseIsObject := base.(map[string]any)
+	overrideObject, overrideIsObject := override.(map[string]any)
+	if !baseIsObject || !overrideIsObject {
+		return override
+	}
+
+	merged := maps.Clone(baseObject)
+	for key, value := range overrideObject {
+		if baseValue, ok := merged[key]; ok {
+			value = mergeData(baseValue, value)
+		}
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		want     string
+	}{
+		{
+			name:     "override wins",
+			base:     `{"title": "Base", "total": 10}`,
+			override: `{"title": "Q3"}`,
+			want:     `{"title": "Q3", "total": 10}`,
+		},
+		{
+			name:     "nested objects merge",
+			base:     `{"company": {"name": "Acme", "address": {"city": "Berlin", "zip": "10115"}}}`,
+			override: `{"company": {"address": {"city": "Hamburg"}}}`,
+			want:     `{"company": {"name": "Acme", "address": {"city": "Hamburg", "zip": "10115"}}}`,
+		},
+		{
+			name:     "arrays replace",
+			base:     `{"items": [1, 2, 3]}`,
+			override: `{"items": [4]}`,
+			want:     `{"items": [4]}`,
+		},
+		{
+			name:     "object replaces scalar",
+			base:     `{"footer": "none"}`,
+			override: `{"footer": {"text": "Confidential"}}`,
+			want:     `{"footer": {"text": "Confidential"}}`,
+		},
+		{
+			name:     "null overrides",
+			base:     `{"logo": "logo.png"}`,
+			override: `{"logo": null}`,
+			want:     `{"logo": null}`,
+		},
+		{
+			name:     "base isn't an object",
+			base:     `[1, 2]`,
+			override: `{"title": "Q3"}`,
+			want:     `{"title": "Q3"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var base, override, want any
+			for _, value := range []struct {
+				raw string
+				v   *any
+			}{{tt.base, &base}, {tt.override, &override}, {tt.want, &want}} {
+				if err := json.Unmarshal([]byte(value.raw), value.v); err != nil {
+					t.Fatalf("failed to decode %s: %v", value.raw, err)
+				}
+			}
+			baseCopy, _ := json.Marshal(base)
+
+			if got := mergeData(base, override); !reflect.DeepEqual(got, want) {
+				t.Errorf("mergeData() = %v, want %v", got, want)
+			}
+			if after, _ := json.Marshal(base); string(after) != string(baseCopy) {
+				t.Errorf("mergeData() modified base: %s, was %s", after, baseCopy)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_MergeData tests merging inline data on top of the data file of dataKey.
+func TestHandleGenerate_MergeData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantData    string
+		wantMessage string
+	}{
+		{
+			name: "merged",
+			body: `{"templateKey": "template.typ", "dataKey": "base.yaml", ` +
+				`"data": {"customer": {"name": "Bob"}}, "mergeData": true}`,
+			wantStatus: http.StatusOK,
+			wantData:   `{"customer":{"city":"Berlin","name":"Bob"},"lines":[1,2]}`,
+		},
+		{
+			name:        "both without mergeData",
+			body:        `{"templateKey": "template.typ", "dataKey": "base.yaml", "data": {"customer": {"name": "Bob"}}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "cannot specify both 'data' and 'dataKey'",
+		},
+		{
+			name:        "without dataKey",
+			body:        `{"templateKey": "template.typ", "data": {"title": "Q3"}, "mergeData": true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "mergeData requires both 'data' and 'dataKey'",
+		},
+		{
+			name:        "csv",
+			body:        `{"templateKey": "template.typ", "dataKey": "rows.csv", "data": {"title": "Q3"}, "mergeData": true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "mergeData is not supported for csv data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"template.typ": []byte("= Hello"),
+				"base.yaml":    []byte("customer:\n  name: Alice\n  city: Berlin\nlines: [1, 2]\n"),
+				"rows.csv":     []byte("a,b\n1,2\n"),
+			})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = compiler
+
+			rec := postGenerate(t, srv, tt.body, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMessage) {
+				t.Errorf("expected body to contain %q, got %q", tt.wantMessage, rec.Body.String())
+			}
+			if tt.wantData == "" {
+				return
+			}
+			var data any
+			if err := json.Unmarshal([]byte(compiler.files["data.json"]), &data); err != nil {
+				t.Fatalf("failed to decode data.json: %v", err)
+			}
+			if got, _ := json.Marshal(data); string(got) != tt.wantData {
+				t.Errorf("expected data %s, got %s", tt.wantData, got)
+			}
+		})
+	}
+}
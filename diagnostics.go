@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boringbin/givetypst/client"
+)
+
+const (
+	// errorCodePackageResolution is the error code of typst failures caused by an unresolved package import.
+	errorCodePackageResolution = "package_resolution_failed"
+	// diagnosticErrorPrefix starts the first line of a typst error diagnostic.
+	diagnosticErrorPrefix = "error: "
+	// diagnosticWarningPrefix starts the first line of a typst warning diagnostic.
+	diagnosticWarningPrefix = "warning: "
+	// diagnosticHintPrefix starts a hint line of a typst diagnostic, after its indentation.
+	diagnosticHintPrefix = "= hint: "
+	// diagnosticLocationPrefix starts the location line of a typst diagnostic, after its indentation.
+	diagnosticLocationPrefix = "┌─"
+)
+
+// typstError is the error of a typst command that failed with diagnostics, such as a
+// syntax error in the template.
+type typstError struct {
+	// command is the typst subcommand that failed, such as "compile".
+	command string
+	// output is the diagnostics written by typst, with paths relative to the project root.
+	output string
+	// cause is errPackageResolution for package resolution failures, or nil.
+	cause error
+}
+
+// Error returns the command and the diagnostics of the failure.
+func (e *typstError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s failed: %v: %s", e.command, e.cause, e.output)
+	}
+	return fmt.Sprintf("%s failed: %s", e.command, e.output)
+}
+
+// Unwrap returns the cause of the failure, if known.
+func (e *typstError) Unwrap() error {
+	return e.cause
+}
+
+// code returns the error code of the failure in a CompileErrorResponse.
+func (e *typstError) code() string {
+	if errors.Is(e.cause, errPackageResolution) {
+		return errorCodePackageResolution
+	}
+	return e.command + "_failed"
+}
+
+// typstFailure returns the error of a failed typst command with the given diagnostics.
+//
+// Paths under root are made relative to it, so responses don't expose work directories.
+// Failures to resolve an imported package wrap errPackageResolution, so they can be told
+// apart from errors in the template.
+func typstFailure(command, root, output string) error {
+	output = relativeToRoot(root, output)
+	var cause error
+	if isPackageFailure(output) {
+		cause = errPackageResolution
+	}
+	return &typstError{command: command, output: output, cause: cause}
+}
+
+// relativeToRoot makes the paths under root in typst's output relative to it. An empty root changes nothing.
+func relativeToRoot(root, output string) string {
+	if root == "" {
+		return output
+	}
+	return strings.ReplaceAll(output, strings.TrimSuffix(root, "/")+"/", "")
+}
+
+// Diagnostic is an error or warning reported by typst, shared with the Go client.
+type Diagnostic = client.Diagnostic
+
+// CompileErrorResponse is the JSON body of a failed compile or query.
+type CompileErrorResponse = client.CompileErrorResponse
+
+// writeTypstError writes a typst failure as a CompileErrorResponse.
+func writeTypstError(w http.ResponseWriter, status int, err *typstError) {
+	resp := CompileErrorResponse{Error: err.code(), Diagnostics: parseDiagnostics(err.output)}
+	if len(resp.Diagnostics) == 0 {
+		resp.Output = err.output
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// parseDiagnostics parses the errors of typst's human-readable or short diagnostic output.
+//
+// Warnings are skipped. Lines that belong to no error, such as source excerpts, are ignored,
+// so output that isn't in a known format yields no diagnostics.
+func parseDiagnostics(output string) []Diagnostic {
+	return parseSeverity(output, diagnosticErrorPrefix)
+}
+
+// parseWarnings parses the warnings of typst's diagnostic output, like parseDiagnostics does the errors.
+func parseWarnings(output string) []Diagnostic {
+	return parseSeverity(output, diagnosticWarningPrefix)
+}
+
+// parseSeverity parses the diagnostics of typst's output whose first line starts with prefix,
+// either diagnosticErrorPrefix or diagnosticWarningPrefix.
+func parseSeverity(output, prefix string) []Diagnostic {
+	var diagnostics []Diagnostic
+	inDiagnostic := false
+	for line := range strings.Lines(output) {
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, prefix):
+			diagnostics = append(diagnostics, Diagnostic{Message: strings.TrimPrefix(line, prefix)})
+			inDiagnostic = true
+		case strings.HasPrefix(line, diagnosticErrorPrefix), strings.HasPrefix(line, diagnosticWarningPrefix):
+			inDiagnostic = false
+		case inDiagnostic && strings.HasPrefix(trimmed, diagnosticLocationPrefix):
+			last := &diagnostics[len(diagnostics)-1]
+			if last.File == "" {
+				last.File, last.Line, last.Column, _ = parseLocation(
+					strings.TrimSpace(strings.TrimPrefix(trimmed, diagnosticLocationPrefix)))
+			}
+		case inDiagnostic && strings.HasPrefix(trimmed, diagnosticHintPrefix):
+			last := &diagnostics[len(diagnostics)-1]
+			last.Hints = append(last.Hints, strings.TrimPrefix(trimmed, diagnosticHintPrefix))
+		default:
+			diagnostic, severity, ok := parseShortDiagnostic(line)
+			if !ok {
+				continue
+			}
+			if severity == prefix {
+				diagnostics = append(diagnostics, diagnostic)
+			}
+			inDiagnostic = severity == prefix
+		}
+	}
+	return diagnostics
+}
+
+// parseShortDiagnostic parses a diagnostic of typst's short format, such as
+// "main.typ:1:5: error: unexpected end of file", along with its severity prefix.
+func parseShortDiagnostic(line string) (Diagnostic, string, bool) {
+	location, rest, found := strings.Cut(line, ": ")
+	if !found {
+		return Diagnostic{}, "", false
+	}
+	file, lineNumber, column, ok := parseLocation(location)
+	if !ok {
+		return Diagnostic{}, "", false
+	}
+	for _, severity := range []string{diagnosticErrorPrefix, diagnosticWarningPrefix} {
+		if message, isSeverity := strings.CutPrefix(rest, severity); isSeverity {
+			return Diagnostic{File: file, Line: lineNumber, Column: column, Message: message}, severity, true
+		}
+	}
+	return Diagnostic{}, "", false
+}
+
+// parseLocation parses a "file:line:column" location.
+func parseLocation(location string) (string, int, int, bool) {
+	rest, columnText, found := cutLast(location, ":")
+	if !found {
+		return "", 0, 0, false
+	}
+	file, lineText, found := cutLast(rest, ":")
+	if !found || file == "" {
+		return "", 0, 0, false
+	}
+	line, lineErr := strconv.Atoi(lineText)
+	column, columnErr := strconv.Atoi(columnText)
+	if lineErr != nil || columnErr != nil {
+		return "", 0, 0, false
+	}
+	return file, line, column, true
+}
+
+// cutLast slices s around the last instance of sep, like strings.Cut does around the first.
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
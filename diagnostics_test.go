@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseDiagnostics tests parsing the errors of typst's diagnostic output.
+func TestParseDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{
+			name: "human",
+			output: "error: unknown variable: totl\n" +
+				"  ┌─ main.typ:3:2\n" +
+				"  │\n" +
+				"3 │ #totl\n" +
+				"  │  ^^^^\n" +
+				"  │\n" +
+				"  = hint: did you mean `total`?\n",
+			want: []Diagnostic{
+				{File: "main.typ", Line: 3, Column: 2, Message: "unknown variable: totl", Hints: []string{"did you mean `total`?"}},
+			},
+		},
+		{
+			name: "warnings skipped",
+			output: "warning: unknown font family: inter\n" +
+				"  ┌─ main.typ:1:18\n" +
+				"\n" +
+				"error: unexpected end of file\n" +
+				"  ┌─ lib/table.typ:12:5\n",
+			want: []Diagnostic{{File: "lib/table.typ", Line: 12, Column: 5, Message: "unexpected end of file"}},
+		},
+		{
+			name:   "without location",
+			output: "error: file not found (searched at data.json)\n",
+			want:   []Diagnostic{{Message: "file not found (searched at data.json)"}},
+		},
+		{
+			name: "short",
+			output: "main.typ:1:18: warning: unknown font family: inter\n" +
+				"main.typ:4:1: error: expected expression\n",
+			want: []Diagnostic{{File: "main.typ", Line: 4, Column: 1, Message: "expected expression"}},
+		},
+		{name: "unknown format", output: "thread 'main' panicked at src/main.rs:10:5\n"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseDiagnostics(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiagnostics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseWarnings tests parsing the warnings of typst's diagnostic output.
+func TestParseWarnings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{
+			name: "human",
+			output: "warning: unknown font family: inter\n" +
+				"  ┌─ main.typ:1:18\n" +
+				"  │\n" +
+				"1 │ #set text(font: \"inter\")\n" +
+				"  │                   ^^^^^^^\n" +
+				"\n" +
+				"warning: `pattern` is deprecated\n" +
+				"  ┌─ lib/table.typ:7:3\n" +
+				"  = hint: use `tiling` instead\n",
+			want: []Diagnostic{
+				{File: "main.typ", Line: 1, Column: 18, Message: "unknown font family: inter"},
+				{
+					File:    "lib/table.typ",
+					Line:    7,
+					Column:  3,
+					Message: "`pattern` is deprecated",
+					Hints:   []string{"use `tiling` instead"},
+				},
+			},
+		},
+		{
+			name: "errors skipped",
+			output: "error: unexpected end of file\n" +
+				"  ┌─ main.typ:12:5\n" +
+				"warning: unknown font family: inter\n",
+			want: []Diagnostic{{Message: "unknown font family: inter"}},
+		},
+		{
+			name: "short",
+			output: "main.typ:1:18: warning: unknown font family: inter\n" +
+				"main.typ:4:1: error: expected expression\n",
+			want: []Diagnostic{{File: "main.typ", Line: 1, Column: 18, Message: "unknown font family: inter"}},
+		},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseWarnings(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWarnings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTypstFailure_Root tests that paths under the project root are made relative in diagnostics.
+func TestTypstFailure_Root(t *testing.T) {
+	t.Parallel()
+
+	err := typstFailure("compile", "/tmp/typst-123", "error: expected expression\n  ┌─ /tmp/typst-123/main.typ:4:1\n")
+	want := "compile failed: error: expected expression\n  ┌─ main.typ:4:1\n"
+	if err.Error() != want {
+		t.Errorf("expected error %q, got %q", want, err.Error())
+	}
+}
+
+// TestHandleGenerate_CompileDiagnostics tests the JSON error response of a template that fails to compile.
+func TestHandleGenerate_CompileDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		output          string
+		wantDiagnostics []Diagnostic
+		wantOutput      string
+	}{
+		{
+			name:            "parsed",
+			output:          "error: unclosed delimiter\n  ┌─ main.typ:2:1\n",
+			wantDiagnostics: []Diagnostic{{File: "main.typ", Line: 2, Column: 1, Message: "unclosed delimiter"}},
+		},
+		{name: "raw output", output: "something went wrong", wantOutput: "something went wrong"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{err: typstFailure("compile", "", tt.output)}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != contentTypeJSON {
+				t.Errorf("expected Content-Type %q, got %q", contentTypeJSON, contentType)
+			}
+			var resp CompileErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Error != "compile_failed" {
+				t.Errorf("expected error %q, got %q", "compile_failed", resp.Error)
+			}
+			if !reflect.DeepEqual(resp.Diagnostics, tt.wantDiagnostics) {
+				t.Errorf("expected diagnostics %+v, got %+v", tt.wantDiagnostics, resp.Diagnostics)
+			}
+			if resp.Output != tt.wantOutput {
+				t.Errorf("expected output %q, got %q", tt.wantOutput, resp.Output)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Warnings tests that the warnings of a successful compile are returned with the document.
+func TestHandleGenerate_Warnings(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst writes a document to its output path, the last argument, and warns about a font.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nfor arg; do out=$arg; done\nprintf '%%PDF-warned' > \"$out\"\n" +
+		"printf 'warning: unknown font family: inter\\n  ┌─ %s/main.typ:1:18\\n' \"$3\" >&2\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &LocalTypstCompiler{binary: binary}
+
+	rec := postGenerate(t, srv, `{"templateKey": "template.typ"}`, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Typst-Warnings"); got != "1" {
+		t.Errorf("expected X-Typst-Warnings %q, got %q", "1", got)
+	}
+
+	rec = postGenerate(t, srv, `{"templateKey": "template.typ"}`, contentTypeJSON, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp GenerateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := []Diagnostic{{File: "main.typ", Line: 1, Column: 18, Message: "unknown font family: inter"}}
+	if !reflect.DeepEqual(resp.Warnings, want) {
+		t.Errorf("expected warnings %+v, got %+v", want, resp.Warnings)
+	}
+	if resp.Data != "JVBERi13YXJuZWQ=" {
+		t.Errorf("expected the document in the envelope, got %q", resp.Data)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// documentKeyVersion is hashed into every document key, so that changing what is hashed
+// invalidates the cached documents and the entity tags clients hold.
+const documentKeyVersion = "3"
+
+// documentCache is an LRU cache of compiled documents keyed by their document key, bounded by
+// the total size of the documents.
+//
+// A nil *documentCache caches nothing, so callers don't need to check whether caching is enabled.
+// It is safe for concurrent use.
+type documentCache struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+	// maxBytes is the maximum total size of the cached documents.
+	maxBytes int64
+	// size is the total size of the cached documents.
+	size int64
+	// order holds the entries, most recently used first.
+	order *list.List
+	// entries maps document keys to their element in order.
+	entries map[string]*list.Element
+}
+
+// documentCacheEntry is a single cached document.
+type documentCacheEntry struct {
+	// key is the document key.
+	key string
+	// document is the compiled document. It is shared with callers and must not be modified.
+	document []byte
+}
+
+// newDocumentCache creates a new document cache holding up to maxBytes of documents, or nil if
+// maxBytes isn't positive.
+func newDocumentCache(maxBytes int64) *documentCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &documentCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached document for key, and whether it was found.
+func (c *documentCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	entry, _ := elem.Value.(*documentCacheEntry)
+	return entry.document, true
+}
+
+// fits reports whether a document of size bytes can be cached.
+func (c *documentCache) fits(size int64) bool {
+	return c != nil && size <= c.maxBytes
+}
+
+// put stores the document for key, evicting the least recently used documents until the cache
+// fits in its size. Documents larger than the whole cache aren't stored.
+func (c *documentCache) put(key string, document []byte) {
+	if c == nil || int64(len(document)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry, _ := elem.Value.(*documentCacheEntry)
+		c.size += int64(len(document)) - int64(len(entry.document))
+		entry.document = document
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&documentCacheEntry{key: key, document: document})
+		c.size += int64(len(document))
+	}
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		if entry, ok := oldest.Value.(*documentCacheEntry); ok {
+			delete(c.entries, entry.key)
+			c.size -= int64(len(entry.document))
+		}
+	}
+}
+
+// documentKey returns a hash of everything the compile of tmpl and data with args depends on:
+// the typst version, the staged files and data, the compile arguments and the PDF optimizer. Typst output is
+// deterministic, so documents with the same key are identical.
+//
+// It returns an empty key if the typst version is unknown, since a typst upgrade must change the key.
+func (s *Server) documentKey(
+	ctx context.Context,
+	tmpl resolvedTemplate,
+	data resolvedData,
+	args compileArgs,
+) (string, error) {
+	opts, values, err := s.stagingOptions(s.requestConfig(ctx), tmpl, data, args)
+	if err != nil {
+		return "", err
+	}
+	typstVersion, err := s.detectTypstVersion()
+	if err != nil {
+		return "", nil //nolint:nilerr // The document is compiled without a key.
+	}
+
+	h := sha256.New()
+	writeHashField(h, documentKeyVersion)
+	writeHashField(h, typstVersion)
+	writeHashField(h, tmpl.source)
+
+	// Data that isn't staged is distinct from null data.
+	if values != nil {
+		encoded, encodeErr := json.Marshal(values)
+		if encodeErr != nil {
+			return "", fmt.Errorf("failed to encode data: %w", encodeErr)
+		}
+		writeHashField(h, opts.dataPath)
+		writeHashField(h, string(encoded))
+	} else {
+		writeHashField(h, "")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(opts.files)) {
+		writeHashField(h, name)
+		writeHashField(h, string(opts.files[name]))
+	}
+	writeHashField(h, "")
+
+	// A pinned typst version is identified by its binary, since only the default one's version is detected.
+	writeHashField(h, opts.args.binary)
+	// The format tells apart formats that compile alike, such as a single-page svg and svg-pages.
+	writeHashField(h, opts.args.format)
+	writeHashField(h, opts.args.fontDir)
+	writeHashField(h, strconv.FormatInt(opts.args.creationTimestamp, 10))
+	for _, arg := range opts.args.args() {
+		writeHashField(h, arg)
+	}
+
+	// The output of an optimized PDF depends on the optimizer that is installed.
+	if opts.args.optimize {
+		optimizer, _, _ := s.findPDFOptimizer(s.requestConfig(ctx).optimizeLossy)
+		writeHashField(h, "optimize:"+optimizer.name)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeHashField writes a length-prefixed field to h, so that fields can't run into each other.
+func writeHashField(h hash.Hash, field string) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+	h.Write(size[:])
+	h.Write([]byte(field))
+}
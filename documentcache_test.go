@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDocumentCache_EvictsBySize tests that the cache evicts least recently used documents to fit its size.
+func TestDocumentCache_EvictsBySize(t *testing.T) {
+	t.Parallel()
+
+	cache := newDocumentCache(10)
+	cache.put("a", []byte("aaaa"))
+	cache.put("b", []byte("bbbb"))
+
+	// Using "a" makes "b" the least recently used document.
+	if doc, ok := cache.get("a"); !ok || string(doc) != "aaaa" {
+		t.Fatalf("get(a) = %q, %v, want %q, true", doc, ok, "aaaa")
+	}
+	cache.put("c", []byte("cccc"))
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("get(b) should miss after eviction")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("get(%s) should hit", key)
+		}
+	}
+	if cache.size != 8 {
+		t.Errorf("size = %d, want 8", cache.size)
+	}
+
+	// Replacing a document accounts for the change in size.
+	cache.put("a", []byte("aa"))
+	if cache.size != 6 {
+		t.Errorf("size = %d after replace, want 6", cache.size)
+	}
+}
+
+// TestDocumentCache_SkipsOversized tests that documents larger than the cache aren't stored.
+func TestDocumentCache_SkipsOversized(t *testing.T) {
+	t.Parallel()
+
+	cache := newDocumentCache(4)
+	cache.put("a", []byte("aaaa"))
+	cache.put("big", []byte("bbbbb"))
+
+	if _, ok := cache.get("big"); ok {
+		t.Error("get(big) should miss")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("get(a) should hit, an oversized document shouldn't evict others")
+	}
+}
+
+// TestDocumentCache_Disabled tests that a cache without a size caches nothing.
+func TestDocumentCache_Disabled(t *testing.T) {
+	t.Parallel()
+
+	cache := newDocumentCache(0)
+	if cache != nil {
+		t.Fatalf("newDocumentCache(0) = %v, want nil", cache)
+	}
+	cache.put("a", []byte("aaaa"))
+	if _, ok := cache.get("a"); ok {
+		t.Error("get(a) should miss on a disabled cache")
+	}
+}
+
+// TestHandleGenerate_DocumentCache tests that repeated requests are served from the document cache.
+func TestHandleGenerate_DocumentCache(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"templateKey": "template.typ", "data": {"title": "Report"}}`
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	compiler := &countingCompiler{output: "%PDF-stub"}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, pdfCacheMaxBytes: 1 << 20, metrics: true})
+	srv.compiler = compiler
+	srv.typstVersion = func(context.Context) (string, error) { return "typst 0.13.1", nil }
+
+	requests := []struct {
+		body         string
+		wantCompiles int32
+	}{
+		{body: body, wantCompiles: 1},
+		{body: body, wantCompiles: 1},
+		{body: `{"templateKey": "template.typ", "data": {"title": "Invoice"}}`, wantCompiles: 2},
+		{body: body, wantCompiles: 2},
+	}
+	for i, r := range requests {
+		rec := postGenerate(t, srv, r.body, "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d: %s", i, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if rec.Body.String() != "%PDF-stub" {
+			t.Errorf("request %d: body = %q, want %q", i, rec.Body.String(), "%PDF-stub")
+		}
+		if got := compiler.compiles.Load(); got != r.wantCompiles {
+			t.Errorf("request %d: compiles = %d, want %d", i, got, r.wantCompiles)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	metrics, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	for _, want := range []string{"givetypst_pdf_cache_hits_total 2", "givetypst_pdf_cache_misses_total 2"} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, metrics)
+		}
+	}
+}
+
+// TestDocumentKey_TypstVersion tests that the document key changes with the typst version, and
+// that there's none when the version is unknown.
+func TestDocumentKey_TypstVersion(t *testing.T) {
+	t.Parallel()
+
+	tmpl := resolvedTemplate{source: "= Hello"}
+	data := resolvedData{values: map[string]any{"title": "Report"}}
+	keys := make(map[string]string)
+	for _, version := range []string{"typst 0.13.1", "typst 0.14.0"} {
+		srv := NewServer(testLogger(), ServerConfig{})
+		srv.typstVersion = func(context.Context) (string, error) { return version, nil }
+		key, err := srv.documentKey(context.Background(), tmpl, data, compileArgs{})
+		if err != nil {
+			t.Fatalf("documentKey() error = %v", err)
+		}
+		keys[version] = key
+	}
+	if keys["typst 0.13.1"] == keys["typst 0.14.0"] {
+		t.Error("document key should change with the typst version")
+	}
+
+	srv := NewServer(testLogger(), ServerConfig{})
+	srv.typstVersion = func(context.Context) (string, error) { return "", errors.New("typst not found") }
+	if key, err := srv.documentKey(context.Background(), tmpl, data, compileArgs{}); err != nil || key != "" {
+		t.Errorf("documentKey() = %q, %v, want no key without a typst version", key, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errShuttingDown is the error of requests rejected while the server drains.
+var errShuttingDown = errors.New("server is shutting down")
+
+// Drain stops the server from accepting new work ahead of a shutdown.
+//
+// Requests that would start work, such as /generate, and readiness probes get 503 from then
+// on, so load balancers send traffic elsewhere. Requests already running are unaffected.
+func (s *Server) Drain() {
+	s.drain()
+}
+
+// isDraining reports whether Drain was called.
+func (s *Server) isDraining() bool {
+	return s.draining.Err() != nil
+}
+
+// rejectWhileDraining wraps next to respond 503 to requests that start work once the server drains.
+//
+// Every POST endpoint and GET /generate start a compile or a job, while other GET requests, such
+// as for the result of a finished job, are still served.
+func (s *Server) rejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startsWork := r.Method == http.MethodPost || r.URL.Path == "/generate"
+		if startsWork && s.isDraining() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
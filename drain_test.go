@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDrain tests that a draining server finishes running compiles but rejects new ones.
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	compiler := &gatedCompiler{marker: "Hello", started: make(chan struct{}), release: make(chan struct{})}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+	handler := srv.Handler()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	running := make(chan *httptest.ResponseRecorder)
+	go func() { running <- serve(http.MethodPost, "/generate", `{"templateKey": "template.typ"}`) }()
+	<-compiler.started
+
+	srv.Drain()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "generate",
+			method:     http.MethodPost,
+			target:     "/generate",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "jobs",
+			method:     http.MethodPost,
+			target:     "/jobs",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "generate from query",
+			method:     http.MethodGet,
+			target:     "/generate?templateKey=template.typ",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		// Reading jobs doesn't start work, so it's still served.
+		{name: "unknown job", method: http.MethodGet, target: "/jobs/unknown", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.method, tt.target, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.wantStatus, rec.Code, rec.Body.String())
+		}
+		if tt.wantStatus == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "shutting down") {
+			t.Errorf("%s: expected shutting down error, got %q", tt.name, rec.Body.String())
+		}
+	}
+
+	close(compiler.release)
+	if rec := <-running; rec.Code != http.StatusOK {
+		t.Errorf("expected the running compile to finish with status %d, got %d: %s",
+			http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// embedBucketURL is the BUCKET_URL pseudo-scheme that serves objects from the embedded templates.
+const embedBucketURL = "embed://"
+
+// embeddedFiles holds the templates directory, embedded at build time.
+//
+//go:embed templates
+var embeddedFiles embed.FS
+
+// embeddedTemplates returns the embedded templates directory as a filesystem rooted at the directory.
+func embeddedTemplates() fs.FS {
+	templates, err := fs.Sub(embeddedFiles, "templates")
+	if err != nil {
+		panic(err) // The directory is embedded above, so this can't fail.
+	}
+	return templates
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testTemplates holds the test templates, embedded like the production templates.
+//
+//go:embed testdata/simple.typ testdata/simple.json
+var testTemplates embed.FS
+
+// newEmbedTestServer creates a server reading objects from the embedded test templates.
+func newEmbedTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	templates, err := fs.Sub(testTemplates, "testdata")
+	if err != nil {
+		t.Fatalf("failed to open embedded testdata: %v", err)
+	}
+	return NewServer(testLogger(), ServerConfig{bucketURL: embedBucketURL, templateFS: templates})
+}
+
+// TestFetchTemplate_Embedded tests reading a template from an embedded filesystem.
+func TestFetchTemplate_Embedded(t *testing.T) {
+	t.Parallel()
+
+	srv := newEmbedTestServer(t)
+
+	want, err := os.ReadFile("testdata/simple.typ")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+
+	source, err := srv.fetchTemplate(context.Background(), "simple.typ", false)
+	if err != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", err)
+	}
+	if source != string(want) {
+		t.Errorf("expected embedded template %q, got %q", want, source)
+	}
+
+	if _, err = srv.fetchTemplate(context.Background(), "missing.typ", false); err == nil {
+		t.Error("fetchTemplate() should return error for a missing embedded template")
+	}
+}
+
+// TestHandleGenerate_Embedded tests generating a document from an embedded template and data file.
+func TestHandleGenerate_Embedded(t *testing.T) {
+	t.Parallel()
+
+	srv := newEmbedTestServer(t)
+	compiler := &recordingCompiler{}
+	srv.compiler = compiler
+
+	reqBody := `{"templateKey": "simple.typ", "dataKey": "simple.json"}`
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(compiler.files[dataFileName], `"title"`) {
+		t.Errorf("expected embedded data to be staged, got files: %v", compiler.files)
+	}
+}
+
+// TestEmbeddedTemplates tests that the production templates directory is embedded.
+func TestEmbeddedTemplates(t *testing.T) {
+	t.Parallel()
+
+	if _, err := fs.Stat(embeddedTemplates(), "README.md"); err != nil {
+		t.Errorf("expected embedded templates to contain README.md: %v", err)
+	}
+}
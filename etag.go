@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errNotModified is returned by generate when the client already has the document it would compile.
+var errNotModified = errors.New("not modified")
+
+// conditionalRender describes how a generate request can be answered without compiling the document.
+type conditionalRender struct {
+	// contentType is the negotiated content type of the response. Empty means no entity tag is computed.
+	contentType string
+	// filename is the download name of the document, which is part of the JSON envelope.
+	filename string
+	// ifNoneMatch is the If-None-Match header of the request.
+	ifNoneMatch string
+}
+
+// checkNotModified sets the entity tag of the document with documentKey on header, and returns
+// errNotModified if it matches the If-None-Match header of the request.
+//
+// The entity tag hashes the document key with the response content type and the download name,
+// which the JSON envelope includes. An empty document key sets no entity tag.
+func checkNotModified(header http.Header, documentKey string, cond conditionalRender) error {
+	if documentKey == "" || cond.contentType == "" {
+		return nil
+	}
+
+	h := sha256.New()
+	writeHashField(h, documentKey)
+	writeHashField(h, cond.contentType)
+	writeHashField(h, cond.filename)
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	header.Set("ETag", etag)
+
+	if etagMatches(cond.ifNoneMatch, etag) {
+		return errNotModified
+	}
+	return nil
+}
+
+// etagMatches reports whether the If-None-Match header matches the entity tag, using the weak
+// comparison that RFC 9110 specifies for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	t.Parallel()
+
+	const etag = `"abc"`
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{name: "empty", ifNoneMatch: "", want: false},
+		{name: "exact", ifNoneMatch: `"abc"`, want: true},
+		{name: "weak", ifNoneMatch: `W/"abc"`, want: true},
+		{name: "in list", ifNoneMatch: `"xyz", "abc"`, want: true},
+		{name: "wildcard", ifNoneMatch: " * ", want: true},
+		{name: "other", ifNoneMatch: `"xyz"`, want: false},
+		{name: "unquoted", ifNoneMatch: "abc", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+// postGenerate posts body to /generate with the Accept and If-None-Match headers, and returns the response.
+func postGenerate(t *testing.T, srv *Server, body, accept, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleGenerate_ETag(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"templateKey": "template.typ", "data": {"title": "Report"}}`
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	compiler := &countingCompiler{output: "%PDF-stub"}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+	srv.typstVersion = func(context.Context) (string, error) { return "typst 0.13.1", nil }
+
+	first := postGenerate(t, srv, body, "", "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", first.Code, http.StatusOK, first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag header not set")
+	}
+	if second := postGenerate(t, srv, body, "", ""); second.Header().Get("ETag") != etag {
+		t.Errorf("ETag = %q on repeated request, want %q", second.Header().Get("ETag"), etag)
+	}
+
+	// A matching If-None-Match skips the compile.
+	compiles := compiler.compiles.Load()
+	notModified := postGenerate(t, srv, body, "", etag)
+	if notModified.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", notModified.Code, http.StatusNotModified)
+	}
+	if notModified.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", notModified.Body.String())
+	}
+	if notModified.Header().Get("ETag") != etag {
+		t.Errorf("ETag = %q, want %q", notModified.Header().Get("ETag"), etag)
+	}
+	if got := compiler.compiles.Load(); got != compiles {
+		t.Errorf("compiles = %d, want %d", got, compiles)
+	}
+
+	// Anything that changes the response changes the entity tag.
+	changed := []struct {
+		name   string
+		body   string
+		accept string
+	}{
+		{name: "data", body: `{"templateKey": "template.typ", "data": {"title": "Invoice"}}`},
+		{name: "format", body: `{"templateKey": "template.typ", "data": {"title": "Report"}, "format": "svg"}`},
+		{name: "watermark", body: `{"templateKey": "template.typ", "data": {"title": "Report"}, "watermark": "DRAFT"}`},
+		{name: "envelope", body: body, accept: contentTypeJSON},
+	}
+	for _, c := range changed {
+		rec := postGenerate(t, srv, c.body, c.accept, etag)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", c.name, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if rec.Header().Get("ETag") == etag {
+			t.Errorf("%s: ETag unchanged", c.name)
+		}
+	}
+}
+
+func TestHandleGenerate_ETagMetaOnly(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &countingCompiler{output: "%PDF-stub"}
+	srv.typstVersion = func(context.Context) (string, error) { return "typst 0.13.1", nil }
+
+	rec := postGenerate(t, srv, `{"templateKey": "template.typ", "metaOnly": true}`, "", "*")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := rec.Header().Get("ETag"); got != "" {
+		t.Errorf("ETag = %q, want none", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// fontDirName is the directory of the work directory that per-request fonts are staged in.
+	fontDirName = ".fonts"
+	// maxFontKeys is the maximum number of font files of a single request.
+	maxFontKeys = 16
+	// defaultFontCacheSize is the default maximum number of cached font files.
+	defaultFontCacheSize = 32
+	// defaultFontCacheMaxBytes is the default maximum total size of the cached font files (64 MiB).
+	defaultFontCacheMaxBytes = 64 << 20
+)
+
+// validateFontKeys checks that every font key can be staged in the request's font directory.
+//
+// Like asset keys, font keys are staged as-is under the font directory, so keys that are
+// absolute or contain "." or ".." elements are rejected.
+func validateFontKeys(fontKeys []string) error {
+	if len(fontKeys) > maxFontKeys {
+		return fmt.Errorf("too many fontKeys (maximum %d)", maxFontKeys)
+	}
+	for _, key := range fontKeys {
+		if !fs.ValidPath(key) || key == "." {
+			return fmt.Errorf("invalid font key %q", key)
+		}
+	}
+	return nil
+}
+
+// fetchFont fetches a font file from the storage bucket, or from the font cache if it was fetched before.
+//
+// Fonts rarely change and can be large, so they are cached separately from templates
+// and always, regardless of TEMPLATE_CACHE_SIZE. Cache failures fall back to the bucket.
+func (s *Server) fetchFont(ctx context.Context, key string) ([]byte, error) {
+	cacheKey := templateCacheKey(ctx, key)
+	if content, ok, err := s.fonts.get(ctx, cacheKey); err == nil && ok {
+		return []byte(content), nil
+	}
+
+	content, err := s.fetchFromBucket(ctx, key, s.requestConfig(ctx).maxAssetSize)
+	if err != nil {
+		return nil, err
+	}
+	if putErr := s.fonts.put(ctx, cacheKey, string(content)); putErr != nil {
+		s.requestLogger(ctx).Warn("font cache unavailable, continuing without it", "key", key, "error", putErr)
+	}
+	return content, nil
+}
+
+// fontFilePath returns the path a font is staged at, relative to the project root.
+func fontFilePath(key string) string {
+	return path.Join(fontDirName, key)
+}
+
+// fontPathArgs returns the "--font-path" arguments for a compile's font directory, relative
+// to workDir, and the configured font path.
+//
+// The per-request directory comes first, so its fonts take precedence over the configured
+// ones when both provide the same family and style.
+func (c *LocalTypstCompiler) fontPathArgs(workDir, fontDir string) []string {
+	var paths []string
+	if fontDir != "" {
+		paths = append(paths, filepath.Join(workDir, fontDir))
+	}
+	if c.fontPath != "" {
+		paths = append(paths, c.fontPath)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return []string{"--font-path", strings.Join(paths, string(os.PathListSeparator))}
+}
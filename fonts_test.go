@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLocalTypstCompiler_FontPathArgs tests the "--font-path" arguments for each combination of font directories.
+func TestLocalTypstCompiler_FontPathArgs(t *testing.T) {
+	t.Parallel()
+
+	workDir := filepath.Join("work", "1")
+	sep := string(os.PathListSeparator)
+
+	tests := []struct {
+		name     string
+		fontPath string
+		fontDir  string
+		want     []string
+	}{
+		{name: "none"},
+		{name: "configured", fontPath: "/fonts", want: []string{"--font-path", "/fonts"}},
+		{
+			name:    "request",
+			fontDir: fontDirName,
+			want:    []string{"--font-path", filepath.Join(workDir, fontDirName)},
+		},
+		{
+			name:     "request before configured",
+			fontPath: "/fonts",
+			fontDir:  fontDirName,
+			want:     []string{"--font-path", filepath.Join(workDir, fontDirName) + sep + "/fonts"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &LocalTypstCompiler{fontPath: tt.fontPath}
+			if got := c.fontPathArgs(workDir, tt.fontDir); !slices.Equal(got, tt.want) {
+				t.Errorf("fontPathArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_FontKeys tests that fonts are staged in the font directory and served from the cache.
+func TestHandleGenerate_FontKeys(t *testing.T) {
+	t.Parallel()
+
+	font := []byte{0x00, 0x01, 0x00, 0x00, 0xff}
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"template.typ":     []byte(`#set text(font: "Inter")`),
+		"fonts/Inter.otf":  font,
+		"fonts/Unused.otf": []byte("unused"),
+	})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+
+	reqBody := `{"templateKey": "template.typ", "fontKeys": ["fonts/Inter.otf"]}`
+	for i := range 2 {
+		if i == 1 {
+			// The second request must be served from the font cache.
+			bucketDir := strings.TrimPrefix(bucketURL, "file://")
+			if err := os.Remove(filepath.Join(bucketDir, "fonts", "Inter.otf")); err != nil {
+				t.Fatalf("failed to remove font from bucket: %v", err)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+		rec := httptest.NewRecorder()
+
+		srv.handleGenerate(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status %d, got %d: %s", i, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if compiler.files[".fonts/fonts/Inter.otf"] != string(font) {
+			t.Errorf("request %d: expected font to be staged, got files: %v", i, compiler.files)
+		}
+		if compiler.args.fontDir != fontDirName {
+			t.Errorf("request %d: expected fontDir %q, got %q", i, fontDirName, compiler.args.fontDir)
+		}
+	}
+	if _, ok := compiler.files[".fonts/fonts/Unused.otf"]; ok {
+		t.Errorf("expected unrequested font not to be staged, got files: %v", compiler.files)
+	}
+}
+
+// TestHandleGenerate_FontKeysValidation tests that invalid font keys are rejected.
+func TestHandleGenerate_FontKeysValidation(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, maxFontKeys+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", fmt.Sprintf("fonts/%d.otf", i))
+	}
+
+	tests := []struct {
+		name     string
+		fontKeys string
+		wantErr  string
+	}{
+		{name: "parent directory", fontKeys: `["../secret.otf"]`, wantErr: "invalid font key"},
+		{name: "absolute path", fontKeys: `["/etc/passwd"]`, wantErr: "invalid font key"},
+		{name: "empty key", fontKeys: `[""]`, wantErr: "invalid font key"},
+		{name: "too many", fontKeys: "[" + strings.Join(tooMany, ",") + "]", wantErr: "too many fontKeys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test"})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "fontKeys": %s}`, tt.fontKeys)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
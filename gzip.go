@@ -0,0 +1,110 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponses wraps next to gzip compressible responses for clients that accept gzip.
+//
+// Whether a response is compressed is decided when its header is written, from its
+// Content-Type, so plain-text error responses and PDFs are sent as-is.
+func gzipResponses(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next(gw, r)
+	}
+}
+
+// gzipResponseWriter is an http.ResponseWriter that gzips the body of compressible responses.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	// wroteHeader is set once the header was written and compression was decided.
+	wroteHeader bool
+	// gz compresses the body. Nil if the response isn't compressed.
+	gz *gzip.Writer
+}
+
+// WriteHeader decides whether to compress the response and writes the header.
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if status != http.StatusNoContent && status != http.StatusNotModified &&
+		isCompressible(w.Header().Get("Content-Type")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body, compressed if the response is compressible.
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close flushes and closes the gzip stream, if the response was compressed.
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		_ = w.gz.Close() // The client is gone if the trailer can't be written.
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+//
+// An explicit "gzip" coding takes precedence over the "*" wildcard.
+func acceptsGzip(acceptEncoding string) bool {
+	accepted, wildcard := false, false
+	for coding := range strings.SplitSeq(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = parsed
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			return quality > 0
+		case "*":
+			accepted, wildcard = quality > 0, true
+		}
+	}
+	return wildcard && accepted
+}
+
+// isCompressible reports whether responses of the content type benefit from compression.
+//
+// PDFs and raster images are already compressed, so only text formats are compressed.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeJSON || mediaType == "image/svg+xml"
+}
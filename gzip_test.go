@@ -0,0 +1,135 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAcceptsGzip tests parsing the Accept-Encoding header.
+func TestAcceptsGzip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{acceptEncoding: "", want: false},
+		{acceptEncoding: "gzip", want: true},
+		{acceptEncoding: "deflate, GZIP;q=0.5", want: true},
+		{acceptEncoding: "br, deflate", want: false},
+		{acceptEncoding: "gzip;q=0", want: false},
+		{acceptEncoding: "*", want: true},
+		{acceptEncoding: "*;q=0", want: false},
+		{acceptEncoding: "*, gzip;q=0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			t.Parallel()
+
+			if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+				t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Gzip tests that only compressible responses are gzipped.
+func TestHandleGenerate_Gzip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		body           string
+		accept         string
+		acceptEncoding string
+		wantStatus     int
+		wantGzip       bool
+	}{
+		{
+			name:           "json envelope",
+			body:           `{"templateKey": "template.typ"}`,
+			accept:         contentTypeJSON,
+			acceptEncoding: "gzip",
+			wantStatus:     http.StatusOK,
+			wantGzip:       true,
+		},
+		{
+			name:           "svg",
+			body:           `{"templateKey": "template.typ", "format": "svg"}`,
+			acceptEncoding: "gzip",
+			wantStatus:     http.StatusOK,
+			wantGzip:       true,
+		},
+		{
+			name:           "pdf is not compressed",
+			body:           `{"templateKey": "template.typ"}`,
+			acceptEncoding: "gzip",
+			wantStatus:     http.StatusOK,
+			wantGzip:       false,
+		},
+		{
+			name:       "json envelope without accept-encoding",
+			body:       `{"templateKey": "template.typ"}`,
+			accept:     contentTypeJSON,
+			wantStatus: http.StatusOK,
+			wantGzip:   false,
+		},
+		{
+			name:           "error response is not compressed",
+			body:           `{"templateKey": "missing.typ"}`,
+			accept:         contentTypeJSON,
+			acceptEncoding: "gzip",
+			wantStatus:     http.StatusNotFound,
+			wantGzip:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if gotGzip := rec.Header().Get("Content-Encoding") == "gzip"; gotGzip != tt.wantGzip {
+				t.Fatalf("expected gzip %v, got Content-Encoding %q", tt.wantGzip, rec.Header().Get("Content-Encoding"))
+			}
+			if !tt.wantGzip {
+				return
+			}
+
+			// The stream must be complete, so the gzip writer was closed.
+			reader, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("failed to open gzip stream: %v", err)
+			}
+			body, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read gzip stream: %v", err)
+			}
+			if len(body) == 0 {
+				t.Error("expected a non-empty decompressed body")
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jobPending is the status of a job waiting in the queue.
+	jobPending = "pending"
+	// jobRunning is the status of a job being compiled.
+	jobRunning = "running"
+	// jobDone is the status of a job whose document is ready.
+	jobDone = "done"
+	// jobFailed is the status of a job that failed.
+	jobFailed = "failed"
+	// jobIDBytes is the number of random bytes in a job ID.
+	jobIDBytes = 16
+	// defaultJobQueueSize is the default maximum number of pending jobs.
+	defaultJobQueueSize = 100
+	// defaultJobTTL is the default time a finished job is kept.
+	defaultJobTTL = 15 * time.Minute
+	// defaultJobResultsMaxBytes is the default maximum total size of the documents of finished jobs.
+	defaultJobResultsMaxBytes = 256 << 20
+)
+
+var (
+	// errJobQueueFull is returned when a job is submitted to a full queue.
+	errJobQueueFull = errors.New("job queue full, try again later")
+	// errJobsClosed is returned when a job is submitted after the job queue was closed.
+	errJobsClosed = errors.New("job queue closed")
+	// errJobResultsFull is returned when the documents of finished jobs take up all the room for job results.
+	errJobResultsFull = errors.New("job results full, try again later")
+)
+
+// job is an asynchronous generate request.
+type job struct {
+	// id is the random ID of the job.
+	id string
+	// ctx carries the values of the submitting request, such as its tenant, without its cancellation.
+	ctx context.Context
+	// req is the validated generate request.
+	req GenerateRequest
+	// keyPrefix is the key prefix of the submitting request. Only requests with the same prefix can see the job.
+	keyPrefix string
+
+	// The fields below are guarded by the jobQueue's mu.
+
+	// status is the status of the job.
+	status string
+	// document is the generated document, once the job is done.
+	document []byte
+	// header holds the headers describing the document, such as its compile usage.
+	header http.Header
+	// err is the error of a failed job.
+	err error
+	// expiresAt is when a finished job is removed. Zero until the job finishes.
+	expiresAt time.Time
+}
+
+// jobQueue runs generate requests asynchronously and keeps their results in memory.
+//
+// Pending jobs wait in a bounded queue for a fixed number of workers, which are started
+// on the first submit. Finished jobs are kept for the TTL, and all jobs are lost when
+// the process exits. The documents of finished jobs are kept up to maxBytes in total:
+// once they reach it, submits are rejected, and jobs whose document doesn't fit fail.
+type jobQueue struct {
+	// workers is the number of workers.
+	workers int
+	// ttl is how long a finished job is kept.
+	ttl time.Duration
+	// maxBytes is the maximum total size of the documents of finished jobs. Zero means no limit.
+	maxBytes int64
+	// run generates the document of a job, setting the headers describing it on header.
+	run func(ctx context.Context, j *job, header http.Header) ([]byte, error)
+	// pending delivers submitted jobs to the workers.
+	pending chan *job
+	// done is closed when the queue is closed.
+	done chan struct{}
+	// startOnce starts the workers on the first submit.
+	startOnce sync.Once
+	// closeOnce closes done once.
+	closeOnce sync.Once
+	// running tracks the running workers.
+	running sync.WaitGroup
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	// mu guards jobs, bytes and the mutable fields of each job.
+	mu sync.Mutex
+	// jobs maps job IDs to their job.
+	jobs map[string]*job
+	// bytes is the total size of the documents of finished jobs.
+	bytes int64
+}
+
+// newJobQueue creates a job queue holding up to size pending jobs, run by workers workers.
+func newJobQueue(
+	size, workers int,
+	ttl time.Duration,
+	run func(ctx context.Context, j *job, header http.Header) ([]byte, error),
+) *jobQueue {
+	return &jobQueue{
+		workers: workers,
+		ttl:     ttl,
+		run:     run,
+		pending: make(chan *job, size),
+		done:    make(chan struct{}),
+		now:     time.Now,
+		jobs:    make(map[string]*job),
+	}
+}
+
+// submit queues req and returns the ID of its job.
+//
+// It returns errJobQueueFull if the queue has no room left, and errJobResultsFull if the
+// documents of finished jobs have no room left.
+func (q *jobQueue) submit(ctx context.Context, req GenerateRequest) (string, error) {
+	q.startOnce.Do(q.start)
+
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	j := &job{id: id, ctx: context.WithoutCancel(ctx), req: req, keyPrefix: keyPrefixFromContext(ctx), status: jobPending}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.removeExpired()
+	select {
+	case <-q.done:
+		return "", errJobsClosed
+	default:
+	}
+	if q.maxBytes > 0 && q.bytes >= q.maxBytes {
+		return "", errJobResultsFull
+	}
+	select {
+	case q.pending <- j:
+	default:
+		return "", errJobQueueFull
+	}
+	q.jobs[id] = j
+	return id, nil
+}
+
+// jobSnapshot is a copy of the state of a job.
+type jobSnapshot struct {
+	// req is the generate request of the job.
+	req GenerateRequest
+	// status is the status of the job.
+	status string
+	// document is the generated document, once the job is done.
+	document []byte
+	// header holds the headers describing the document.
+	header http.Header
+	// err is the error of a failed job.
+	err error
+}
+
+// get returns the state of the job with the given ID, and whether it exists.
+//
+// Jobs submitted with a different key prefix than the one of ctx, such as by another tenant,
+// don't exist for ctx.
+func (q *jobQueue) get(ctx context.Context, id string) (jobSnapshot, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.removeExpired()
+	j, ok := q.jobs[id]
+	if !ok || j.keyPrefix != keyPrefixFromContext(ctx) {
+		return jobSnapshot{}, false
+	}
+	return jobSnapshot{req: j.req, status: j.status, document: j.document, header: j.header, err: j.err}, true
+}
+
+// removeExpired removes the finished jobs whose TTL has passed. q.mu must be held.
+func (q *jobQueue) removeExpired() {
+	now := q.now()
+	maps.DeleteFunc(q.jobs, func(_ string, j *job) bool {
+		if j.expiresAt.IsZero() || now.Before(j.expiresAt) {
+			return false
+		}
+		q.bytes -= int64(len(j.document))
+		return true
+	})
+}
+
+// start starts the workers.
+func (q *jobQueue) start() {
+	for range q.workers {
+		q.running.Add(1)
+		go q.work()
+	}
+}
+
+// work runs jobs until the queue is closed.
+func (q *jobQueue) work() {
+	defer q.running.Done()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case j := <-q.pending:
+			q.runJob(j)
+		}
+	}
+}
+
+// runJob runs a single job and records its result. The job is canceled if the queue is closed.
+func (q *jobQueue) runJob(j *job) {
+	q.setStatus(j, jobRunning)
+
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	header := make(http.Header)
+	document, err := q.run(ctx, j, header)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.removeExpired()
+	if err == nil && q.maxBytes > 0 && q.bytes+int64(len(document)) > q.maxBytes {
+		err = newStatusError(http.StatusServiceUnavailable, errJobResultsFull)
+	}
+	j.status, j.document, j.header, j.err = jobDone, document, header, err
+	if err != nil {
+		j.status, j.document = jobFailed, nil
+	}
+	q.bytes += int64(len(j.document))
+	j.expiresAt = q.now().Add(q.ttl)
+}
+
+// setStatus sets the status of a job.
+func (q *jobQueue) setStatus(j *job, status string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.status = status
+}
+
+// close stops the workers, canceling their running jobs.
+//
+// Jobs submitted after close fail with errJobsClosed, and pending jobs are never run.
+func (q *jobQueue) close() {
+	q.closeOnce.Do(func() { close(q.done) })
+	q.running.Wait()
+}
+
+// newJobID returns a random job ID.
+func newJobID() (string, error) {
+	b := make([]byte, jobIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// JobResponse is the JSON body returned by /jobs.
+type JobResponse struct {
+	// JobID is the ID of the job.
+	JobID string `json:"jobId"`
+	// Status is the status of the job: "pending", "running", "done" or "failed".
+	Status string `json:"status"`
+	// Error is the error message of a failed job.
+	Error string `json:"error,omitempty"`
+	// ResultURL is the path of the generated document, once the job is done.
+	ResultURL string `json:"resultUrl,omitempty"`
+}
+
+// handleSubmitJob validates a generate request and queues it as a job.
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var req GenerateRequest
+	if err := s.decodeGenerateRequest(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.validateGenerateRequest(r.Context(), &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	// The result is served in the output format, so it must be allowed like a /generate response.
+	if err := s.checkFormatAllowed(r.Context(), outputFormats()[req.Format]); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	id, err := s.jobs.submit(r.Context(), req)
+	switch {
+	case errors.Is(err, errJobQueueFull), errors.Is(err, errJobResultsFull), errors.Is(err, errJobsClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+id)
+	s.writeJobResponse(w, http.StatusAccepted, JobResponse{JobID: id, Status: jobPending})
+}
+
+// handleGetJob returns the status of a job.
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	snapshot, ok := s.jobs.get(r.Context(), id)
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	resp := JobResponse{JobID: id, Status: snapshot.status}
+	switch snapshot.status {
+	case jobDone:
+		resp.ResultURL = "/jobs/" + id + "/result"
+	case jobFailed:
+		resp.Error = snapshot.err.Error()
+	}
+	s.writeJobResponse(w, http.StatusOK, resp)
+}
+
+// handleGetJobResult returns the document of a finished job.
+//
+// Jobs that aren't done yet, or that failed, get 409 Conflict.
+func (s *Server) handleGetJobResult(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := s.jobs.get(r.Context(), r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if snapshot.status != jobDone {
+		http.Error(w, "job is "+snapshot.status, http.StatusConflict)
+		return
+	}
+
+	format := outputFormats()[snapshot.req.Format]
+	maps.Copy(w.Header(), snapshot.header)
+	if snapshot.req.MetaOnly {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	filename := format.downloadName(s.requestConfig(r.Context()).requestFilename(&snapshot.req))
+	w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"\"")
+	if _, err := w.Write(snapshot.document); err != nil {
+		s.requestLogger(r.Context()).Error("failed to write job result", "error", err)
+	}
+}
+
+// runJob generates the document of a job.
+func (s *Server) runJob(ctx context.Context, j *job, header http.Header) ([]byte, error) {
+	logger := s.requestLogger(ctx).With("jobId", j.id)
+	logger.Debug("running job", "templateKey", j.req.TemplateKey, "format", j.req.Format)
+
+	// Jobs share the template concurrency limits of synchronous requests, and fail when the template is at its limit.
+	release, ok := s.limiter.Load().acquire(ctx, j.req.TemplateKey)
+	if !ok {
+		logger.Warn("job failed", "error", errTemplateBusy)
+		return nil, newStatusError(http.StatusServiceUnavailable, errTemplateBusy)
+	}
+	defer release()
+
+	// The request is copied, since generate normalizes it while the job can be read.
+	// Jobs have no entity tag, since their results are fetched by job ID.
+	req := j.req
+	output, err := s.generate(ctx, logger, header, &req, conditionalRender{})
+	if err != nil {
+		logger.Warn("job failed", "error", err)
+		return nil, err
+	}
+	defer output.Close()
+	if req.MetaOnly {
+		// Metadata-only jobs don't keep the document they have no use for.
+		return []byte{}, nil
+	}
+	return output.bytes()
+}
+
+// writeJobResponse writes resp as JSON with the given status code.
+func (s *Server) writeJobResponse(w http.ResponseWriter, status int, resp JobResponse) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("failed to write job response", "error", err)
+	}
+}
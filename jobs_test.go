@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForJobStatus polls the queue until the job has the given status.
+func waitForJobStatus(t *testing.T, q *jobQueue, id, status string) jobSnapshot {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, ok := q.get(context.Background(), id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if snapshot.status == status {
+			return snapshot
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job status %q, still %q", status, snapshot.status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestJobQueue tests that jobs move through their statuses and keep their result.
+func TestJobQueue(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	q := newJobQueue(10, 1, time.Minute, func(_ context.Context, j *job, header http.Header) ([]byte, error) {
+		<-release
+		if j.req.TemplateKey == "broken.typ" {
+			return nil, errors.New("compile failed")
+		}
+		header.Set("X-Test", "yes")
+		return []byte("%PDF-job"), nil
+	})
+	t.Cleanup(q.close)
+
+	doneID, err := q.submit(context.Background(), GenerateRequest{TemplateKey: "template.typ"})
+	if err != nil {
+		t.Fatalf("submit() returned error: %v", err)
+	}
+	failedID, err := q.submit(context.Background(), GenerateRequest{TemplateKey: "broken.typ"})
+	if err != nil {
+		t.Fatalf("submit() returned error: %v", err)
+	}
+
+	// The single worker runs the first job, so the second stays pending.
+	waitForJobStatus(t, q, doneID, jobRunning)
+	if snapshot, _ := q.get(context.Background(), failedID); snapshot.status != jobPending {
+		t.Errorf("expected second job to be %q, got %q", jobPending, snapshot.status)
+	}
+	close(release)
+
+	done := waitForJobStatus(t, q, doneID, jobDone)
+	if string(done.document) != "%PDF-job" {
+		t.Errorf("expected document %q, got %q", "%PDF-job", done.document)
+	}
+	if done.header.Get("X-Test") != "yes" {
+		t.Errorf("expected result header X-Test to be kept, got %v", done.header)
+	}
+
+	failed := waitForJobStatus(t, q, failedID, jobFailed)
+	if failed.err == nil || failed.document != nil {
+		t.Errorf("expected failed job to have an error and no document, got %v and %q", failed.err, failed.document)
+	}
+}
+
+// TestJobQueue_Workers tests that no more jobs run at once than there are workers.
+func TestJobQueue_Workers(t *testing.T) {
+	t.Parallel()
+
+	const workers, jobs = 2, 6
+
+	var running, maxRunning atomic.Int32
+	q := newJobQueue(jobs, workers, time.Minute, func(context.Context, *job, http.Header) ([]byte, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			highest := maxRunning.Load()
+			if current <= highest || maxRunning.CompareAndSwap(highest, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return []byte("%PDF-job"), nil
+	})
+	t.Cleanup(q.close)
+
+	ids := make([]string, jobs)
+	for i := range ids {
+		id, err := q.submit(context.Background(), GenerateRequest{})
+		if err != nil {
+			t.Fatalf("submit() returned error: %v", err)
+		}
+		ids[i] = id
+	}
+
+	for _, id := range ids {
+		waitForJobStatus(t, q, id, jobDone)
+	}
+	if got := maxRunning.Load(); got > workers {
+		t.Errorf("expected at most %d jobs running at once, got %d", workers, got)
+	}
+}
+
+// TestJobQueue_Full tests that submits beyond the queue size are rejected.
+func TestJobQueue_Full(t *testing.T) {
+	t.Parallel()
+
+	// Without workers, submitted jobs stay pending.
+	q := newJobQueue(2, 0, time.Minute, func(context.Context, *job, http.Header) ([]byte, error) {
+		return nil, nil
+	})
+	t.Cleanup(q.close)
+
+	for range 2 {
+		if _, err := q.submit(context.Background(), GenerateRequest{}); err != nil {
+			t.Fatalf("submit() returned error: %v", err)
+		}
+	}
+	if _, err := q.submit(context.Background(), GenerateRequest{}); !errors.Is(err, errJobQueueFull) {
+		t.Errorf("expected errJobQueueFull, got %v", err)
+	}
+
+	q.close()
+	if _, err := q.submit(context.Background(), GenerateRequest{}); !errors.Is(err, errJobsClosed) {
+		t.Errorf("expected errJobsClosed after close, got %v", err)
+	}
+}
+
+// TestJobQueue_Expiry tests that finished jobs are removed after the TTL.
+func TestJobQueue_Expiry(t *testing.T) {
+	t.Parallel()
+
+	q := newJobQueue(1, 1, time.Minute, func(context.Context, *job, http.Header) ([]byte, error) {
+		return []byte("%PDF-job"), nil
+	})
+	t.Cleanup(q.close)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	id, err := q.submit(context.Background(), GenerateRequest{})
+	if err != nil {
+		t.Fatalf("submit() returned error: %v", err)
+	}
+	waitForJobStatus(t, q, id, jobDone)
+
+	q.mu.Lock()
+	now = now.Add(time.Minute)
+	q.mu.Unlock()
+
+	if _, ok := q.get(context.Background(), id); ok {
+		t.Error("expected job to be removed after its TTL")
+	}
+}
+
+// TestJobQueue_ResultsFull tests that the documents of finished jobs are kept up to the byte limit.
+func TestJobQueue_ResultsFull(t *testing.T) {
+	t.Parallel()
+
+	// Each job's document is its template key.
+	q := newJobQueue(10, 1, time.Minute, func(_ context.Context, j *job, _ http.Header) ([]byte, error) {
+		return []byte(j.req.TemplateKey), nil
+	})
+	q.maxBytes = 10
+	t.Cleanup(q.close)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	steps := []struct {
+		templateKey string
+		wantStatus  string
+	}{
+		{templateKey: "abcdefgh", wantStatus: jobDone},
+		// The document doesn't fit next to the first.
+		{templateKey: "abcdefgh", wantStatus: jobFailed},
+		{templateKey: "ab", wantStatus: jobDone},
+	}
+	for _, step := range steps {
+		id, err := q.submit(context.Background(), GenerateRequest{TemplateKey: step.templateKey})
+		if err != nil {
+			t.Fatalf("submit() returned error: %v", err)
+		}
+		snapshot := waitForJobStatus(t, q, id, step.wantStatus)
+		if step.wantStatus == jobFailed && !errors.Is(snapshot.err, errJobResultsFull) {
+			t.Errorf("expected error %v, got %v", errJobResultsFull, snapshot.err)
+		}
+	}
+
+	if _, err := q.submit(context.Background(), GenerateRequest{}); !errors.Is(err, errJobResultsFull) {
+		t.Errorf("expected errJobResultsFull, got %v", err)
+	}
+
+	// Expired results make room again.
+	q.mu.Lock()
+	now = now.Add(time.Minute)
+	q.mu.Unlock()
+	if _, err := q.submit(context.Background(), GenerateRequest{}); err != nil {
+		t.Errorf("expected submit to succeed after the results expired, got %v", err)
+	}
+}
+
+// TestHandleJobs tests submitting a job, polling its status and fetching its result.
+func TestHandleJobs(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+	t.Cleanup(func() { _ = srv.Close() })
+	handler := srv.Handler()
+
+	reqBody := `{"templateKey": "template.typ", "filename": "report"}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(reqBody)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	var submitted JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &submitted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if submitted.JobID == "" || submitted.Status != jobPending {
+		t.Fatalf("expected a pending job with an ID, got %+v", submitted)
+	}
+	if location := rec.Header().Get("Location"); location != "/jobs/"+submitted.JobID {
+		t.Errorf("expected Location %q, got %q", "/jobs/"+submitted.JobID, location)
+	}
+
+	waitForJobStatus(t, srv.jobs, submitted.JobID, jobDone)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+submitted.JobID, nil))
+	var status JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	wantResultURL := "/jobs/" + submitted.JobID + "/result"
+	if status.Status != jobDone || status.ResultURL != wantResultURL {
+		t.Errorf("expected done job with result URL %q, got %+v", wantResultURL, status)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, status.ResultURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != "%PDF-stub" {
+		t.Errorf("expected stub PDF, got %q", rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `inline; filename="report.pdf"` {
+		t.Errorf("expected Content-Disposition for report.pdf, got %q", disposition)
+	}
+}
+
+// TestHandleJobs_MetaOnly tests that the result of a metadata-only job is empty.
+func TestHandleJobs_MetaOnly(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+	t.Cleanup(func() { _ = srv.Close() })
+	handler := srv.Handler()
+
+	reqBody := `{"templateKey": "template.typ", "metaOnly": true}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(reqBody)))
+	var submitted JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &submitted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	waitForJobStatus(t, srv.jobs, submitted.JobID, jobDone)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+submitted.JobID+"/result", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected empty result, got %q", rec.Body.String())
+	}
+}
+
+// TestHandleJobs_TemplateLimit tests that a job fails when its template is at its concurrency limit.
+func TestHandleJobs_TemplateLimit(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:           bucketURL,
+		templateConcurrency: map[string]int{"template.typ": 1},
+	})
+	srv.compiler = &stubCompiler{}
+	t.Cleanup(func() { _ = srv.Close() })
+	handler := srv.Handler()
+
+	// Hold the template's only slot, as a running synchronous request would.
+	release, ok := srv.limiter.Load().acquire(context.Background(), "template.typ")
+	if !ok {
+		t.Fatal("expected to acquire the template slot")
+	}
+	defer release()
+
+	reqBody := `{"templateKey": "template.typ"}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(reqBody)))
+	var submitted JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &submitted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	snapshot := waitForJobStatus(t, srv.jobs, submitted.JobID, jobFailed)
+	if !errors.Is(snapshot.err, errTemplateBusy) {
+		t.Errorf("expected error %v, got %v", errTemplateBusy, snapshot.err)
+	}
+}
+
+// TestHandleJobs_AllowedContentTypes tests that jobs are only accepted for formats whose content type is allowed.
+func TestHandleJobs_AllowedContentTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "allowed", body: `{"templateKey": "template.typ", "format": "png"}`, wantStatus: http.StatusAccepted},
+		{name: "disallowed", body: `{"templateKey": "template.typ"}`, wantStatus: http.StatusNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:           bucketURL,
+				allowedContentTypes: []string{"image/png"},
+			})
+			srv.compiler = &stubCompiler{}
+			t.Cleanup(func() { _ = srv.Close() })
+
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleJobs_KeyPrefix tests that a job can only be seen with the key prefix it was submitted with.
+func TestHandleJobs_KeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"tenants/acme/template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:    bucketURL,
+		tenantHeader: "X-Tenant-ID",
+		keyPrefix:    "tenants/{tenant}/",
+	})
+	srv.compiler = &stubCompiler{}
+	t.Cleanup(func() { _ = srv.Close() })
+	handler := srv.Handler()
+
+	serve := func(method, path, tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/jobs", "acme", `{"templateKey": "template.typ"}`)
+	var submitted JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &submitted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status JobResponse
+		rec = serve(http.MethodGet, "/jobs/"+submitted.JobID, "acme", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if status.Status == jobDone {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job status %q, still %q", jobDone, status.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		tenant     string
+		wantStatus int
+	}{
+		{name: "status of own job", path: "/jobs/" + submitted.JobID, tenant: "acme", wantStatus: http.StatusOK},
+		{name: "result of own job", path: "/jobs/" + submitted.JobID + "/result", tenant: "acme", wantStatus: http.StatusOK},
+		{name: "status of other tenant", path: "/jobs/" + submitted.JobID, tenant: "globex", wantStatus: http.StatusNotFound},
+		{
+			name:       "result of other tenant",
+			path:       "/jobs/" + submitted.JobID + "/result",
+			tenant:     "globex",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if rec := serve(http.MethodGet, tt.path, tt.tenant, ""); rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleJobs_Errors tests the error responses of the job endpoints.
+func TestHandleJobs_Errors(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{err: errors.New("compile failed")}
+	t.Cleanup(func() { _ = srv.Close() })
+	handler := srv.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid request, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown job, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	id, err := srv.jobs.submit(context.Background(), GenerateRequest{TemplateKey: "template.typ", Format: formatPDF})
+	if err != nil {
+		t.Fatalf("submit() returned error: %v", err)
+	}
+	waitForJobStatus(t, srv.jobs, id, jobFailed)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+	var status JobResponse
+	if unmarshalErr := json.Unmarshal(rec.Body.Bytes(), &status); unmarshalErr != nil {
+		t.Fatalf("failed to decode response: %v", unmarshalErr)
+	}
+	if status.Status != jobFailed || !strings.Contains(status.Error, "compile failed") {
+		t.Errorf("expected failed job with the compile error, got %+v", status)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id+"/result", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d for the result of a failed job, got %d", http.StatusConflict, rec.Code)
+	}
+}
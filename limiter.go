@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errTemplateBusy is returned when a template is at its concurrency limit.
+var errTemplateBusy = errors.New("too many concurrent requests for template")
+
+// templateLimiter limits the number of concurrent requests per template key.
+//
+// Limits apply per bucket key, so with a KEY_PREFIX every tenant gets the limit of a template
+// for its own. Templates without a configured limit are unlimited. It is safe for concurrent use.
+type templateLimiter struct {
+	// limits maps template keys to their limit. The map is never modified after construction.
+	limits map[string]int
+
+	// mu guards slots.
+	mu sync.Mutex
+	// slots maps bucket keys to a semaphore holding one token per running request. A semaphore
+	// is removed once its last request releases it.
+	slots map[string]chan struct{}
+}
+
+// newTemplateLimiter creates a new template limiter from per-template-key limits.
+//
+// Limits that aren't positive are ignored.
+func newTemplateLimiter(limits map[string]int) *templateLimiter {
+	positive := make(map[string]int, len(limits))
+	for key, limit := range limits {
+		if limit > 0 {
+			positive[key] = limit
+		}
+	}
+	return &templateLimiter{limits: positive, slots: make(map[string]chan struct{})}
+}
+
+// acquire takes a slot for a request to the template key without blocking. The slot is
+// taken for the key with the request's key prefix, if any.
+//
+// It returns a function releasing the slot, and false if the template is at its limit.
+func (l *templateLimiter) acquire(ctx context.Context, key string) (func(), bool) {
+	limit, ok := l.limits[key]
+	if !ok {
+		return func() {}, true
+	}
+	slotKey := bucketKey(ctx, key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot, ok := l.slots[slotKey]
+	if !ok {
+		slot = make(chan struct{}, limit)
+		l.slots[slotKey] = slot
+	}
+	select {
+	case slot <- struct{}{}:
+		return func() { l.release(slotKey, slot) }, true
+	default:
+		return nil, false
+	}
+}
+
+// release frees a slot taken by acquire, removing the semaphore once it's unused.
+func (l *templateLimiter) release(slotKey string, slot chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	<-slot
+	if len(slot) == 0 {
+		delete(l.slots, slotKey)
+	}
+}
+
+// compileLimiter bounds the number of concurrent compilations.
+//
+// It is safe for concurrent use.
+type compileLimiter struct {
+	// slots is a semaphore holding one token per running compilation.
+	slots chan struct{}
+	// waiting is the number of compilations waiting for a slot.
+	waiting atomic.Int64
+}
+
+// newCompileLimiter creates a new compile limiter allowing up to limit concurrent compilations.
+func newCompileLimiter(limit int) *compileLimiter {
+	return &compileLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire waits for a compile slot until the context is done, or for at most maxWait if positive.
+//
+// It returns a function releasing the slot, or an error wrapping errCompilerBusy and the
+// context's error if no slot became available in time.
+func (l *compileLimiter) acquire(ctx context.Context, maxWait time.Duration) (func(), error) {
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", errCompilerBusy, ctx.Err())
+	}
+}
+
+// queueDepth returns the number of compilations waiting for a slot.
+func (l *compileLimiter) queueDepth() int64 {
+	return l.waiting.Load()
+}
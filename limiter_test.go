@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTemplateLimiter tests that each template key is limited independently.
+func TestTemplateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := newTemplateLimiter(map[string]int{"hot.typ": 2, "ignored.typ": 0})
+
+	releaseFirst, ok := limiter.acquire(context.Background(), "hot.typ")
+	if !ok {
+		t.Fatal("expected first acquire to succeed")
+	}
+	if _, ok = limiter.acquire(context.Background(), "hot.typ"); !ok {
+		t.Fatal("expected second acquire to succeed")
+	}
+	if _, ok = limiter.acquire(context.Background(), "hot.typ"); ok {
+		t.Fatal("expected third acquire to fail at the limit")
+	}
+
+	for _, key := range []string{"other.typ", "ignored.typ"} {
+		if _, ok = limiter.acquire(context.Background(), key); !ok {
+			t.Errorf("expected unlimited template %s to be acquired", key)
+		}
+	}
+
+	releaseFirst()
+	if _, ok = limiter.acquire(context.Background(), "hot.typ"); !ok {
+		t.Error("expected acquire to succeed after a release")
+	}
+}
+
+// TestTemplateLimiter_KeyPrefix tests that each key prefix gets the limit of a template for its own.
+func TestTemplateLimiter_KeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	limiter := newTemplateLimiter(map[string]int{"hot.typ": 1})
+	acme := context.WithValue(context.Background(), keyPrefixContextKey{}, "tenants/acme/")
+	globex := context.WithValue(context.Background(), keyPrefixContextKey{}, "tenants/globex/")
+
+	release, ok := limiter.acquire(acme, "hot.typ")
+	if !ok {
+		t.Fatal("expected the first acme acquire to succeed")
+	}
+	if _, ok = limiter.acquire(acme, "hot.typ"); ok {
+		t.Error("expected the second acme acquire to fail at the limit")
+	}
+	if _, ok = limiter.acquire(globex, "hot.typ"); !ok {
+		t.Error("expected globex to have a slot of its own")
+	}
+
+	release()
+	limiter.mu.Lock()
+	_, kept := limiter.slots["tenants/acme/hot.typ"]
+	limiter.mu.Unlock()
+	if kept {
+		t.Error("expected the unused acme semaphore to be removed")
+	}
+}
+
+// TestCompileLimiter tests that compilations wait for a slot until their context is done.
+func TestCompileLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := newCompileLimiter(1)
+
+	release, err := limiter.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("expected first acquire to succeed, got %v", err)
+	}
+
+	// A second compilation waits in the queue until it gets the released slot.
+	acquired := make(chan error, 1)
+	go func() {
+		secondRelease, acquireErr := limiter.acquire(context.Background(), 0)
+		if acquireErr == nil {
+			secondRelease()
+		}
+		acquired <- acquireErr
+	}()
+	for limiter.queueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if acquireErr := <-acquired; acquireErr != nil {
+		t.Fatalf("expected queued acquire to succeed, got %v", acquireErr)
+	}
+
+	// A compilation whose context ends while waiting gives up.
+	release, err = limiter.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("expected acquire to succeed, got %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = limiter.acquire(ctx, 0); !errors.Is(err, errCompilerBusy) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected errCompilerBusy wrapping the deadline, got %v", err)
+	}
+	if depth := limiter.queueDepth(); depth != 0 {
+		t.Errorf("expected queue depth 0 after giving up, got %d", depth)
+	}
+}
+
+// TestCompileLimiter_MaxWait tests that the maximum wait bounds how long a compilation waits for a slot.
+func TestCompileLimiter_MaxWait(t *testing.T) {
+	t.Parallel()
+
+	limiter := newCompileLimiter(1)
+	release, err := limiter.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("expected first acquire to succeed, got %v", err)
+	}
+
+	// A short wait gives up while the slot is taken.
+	if _, err = limiter.acquire(context.Background(), 10*time.Millisecond); !errors.Is(err, errCompilerBusy) {
+		t.Errorf("expected errCompilerBusy after the maximum wait, got %v", err)
+	}
+
+	// A longer wait gets the slot once it's released.
+	acquired := make(chan error, 1)
+	go func() {
+		secondRelease, acquireErr := limiter.acquire(context.Background(), 10*time.Second)
+		if acquireErr == nil {
+			secondRelease()
+		}
+		acquired <- acquireErr
+	}()
+	for limiter.queueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if acquireErr := <-acquired; acquireErr != nil {
+		t.Errorf("expected acquire within the maximum wait to succeed, got %v", acquireErr)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// debugHandler wraps a slog.Handler and enables every level, including debug.
+//
+// It is used to elevate the logging of a single sampled request without
+// changing the level of the server-wide logger.
+type debugHandler struct {
+	slog.Handler
+}
+
+// Enabled reports that every level is enabled.
+func (h debugHandler) Enabled(_ context.Context, _ slog.Level) bool {
+	return true
+}
+
+// WithAttrs returns a new debugHandler whose wrapped handler has the given attributes.
+func (h debugHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return debugHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a new debugHandler whose wrapped handler has the given group.
+func (h debugHandler) WithGroup(name string) slog.Handler {
+	return debugHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -22,14 +27,14 @@ var version = "dev"
 const (
 	// defaultPort is the default HTTP port.
 	defaultPort = 8080
-	// readHeaderTimeout is the timeout for reading request headers.
-	readHeaderTimeout = 10 * time.Second
-	// readTimeout is the timeout for reading the entire request.
-	readTimeout = 30 * time.Second
-	// writeTimeout is the timeout for writing the response.
-	writeTimeout = 60 * time.Second
-	// shutdownTimeout is the timeout for graceful shutdown.
-	shutdownTimeout = 10 * time.Second
+	// defaultReadHeaderTimeout is the default timeout for reading request headers.
+	defaultReadHeaderTimeout = 10 * time.Second
+	// defaultReadTimeout is the default timeout for reading the entire request.
+	defaultReadTimeout = 30 * time.Second
+	// defaultWriteTimeout is the default timeout for writing the response.
+	defaultWriteTimeout = 60 * time.Second
+	// defaultShutdownTimeout is the default timeout for graceful shutdown.
+	defaultShutdownTimeout = 10 * time.Second
 	// exitSuccess is the exit code for success.
 	exitSuccess = 0
 	// exitError is the exit code for error.
@@ -42,6 +47,8 @@ func main() {
 
 func run() int {
 	var (
+		configPath  = flag.String("config", "", "YAML or JSON file of environment variable values")
+		host        = flag.String("host", "", "HTTP host to listen on (default all interfaces)")
 		port        = flag.Int("port", defaultPort, "HTTP port to listen on")
 		verbose     = flag.Bool("v", false, "Verbose output (debug mode)")
 		showVersion = flag.Bool("version", false, "Show version and exit")
@@ -61,112 +68,553 @@ func run() int {
 		return exitSuccess
 	}
 
+	// Load the config file below the environment variables and flags
+	env, err := loadSettings(*configPath, flag.CommandLine)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "givetypst: %v\n", err)
+		return exitError
+	}
+
 	// Setup logger
-	logger := setupLogger(*verbose)
+	logger, err := setupLogger(env, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "givetypst: %v\n", err)
+		return exitError
+	}
 
 	// Get bucket URL from environment variable (required)
-	bucketURL := os.Getenv("BUCKET_URL")
+	bucketURL := env.get("BUCKET_URL")
 	if bucketURL == "" {
 		logger.Error("BUCKET_URL environment variable is required")
 		return exitError
 	}
 
-	// Get port from flag or environment variable
-	portNum := *port
-	if portEnv := os.Getenv("PORT"); portEnv != "" {
-		if portFromEnv, err := strconv.Atoi(portEnv); err == nil {
-			portNum = portFromEnv
-		}
+	// Get the listen address from flags or environment variables
+	addr, err := listenAddr(env, *host, *port)
+	if err != nil {
+		logger.Error("invalid listen address", "error", err)
+		return exitError
 	}
 
-	// Get max template size from environment variable (optional)
-	var maxTemplateSize int64
-	if maxTemplateSizeEnv := os.Getenv("MAX_TEMPLATE_SIZE"); maxTemplateSizeEnv != "" {
-		if parsed, err := strconv.ParseInt(maxTemplateSizeEnv, 10, 64); err == nil && parsed > 0 {
-			maxTemplateSize = parsed
-		}
+	// Create server
+	srv := NewServer(logger, serverConfigFromEnv(bucketURL, env))
+	if prefixErr := srv.config.Load().checkKeyPrefix(); prefixErr != nil {
+		logger.Error("invalid KEY_PREFIX", "error", prefixErr)
+		return exitError
 	}
 
-	// Get max data size from environment variable (optional)
-	var maxDataSize int64
-	if maxDataSizeEnv := os.Getenv("MAX_DATA_SIZE"); maxDataSizeEnv != "" {
-		if parsed, err := strconv.ParseInt(maxDataSizeEnv, 10, 64); err == nil && parsed > 0 {
-			maxDataSize = parsed
-		}
+	// A missing default typst only fails /health, but a configured binary must exist
+	if typstPath, lookErr := srv.resolveTypstBinary(); lookErr == nil {
+		logger.Info("using typst binary", "path", typstPath)
+	} else if env.get("TYPST_BIN") != "" {
+		logger.Error("TYPST_BIN not found", "error", lookErr)
+		return exitError
 	}
+	srv.logTypstVersion()
+	srv.seedPackages(context.Background())
+	srv.sweepWorkDir()
 
-	// Create server
-	srv := NewServer(logger, ServerConfig{
-		bucketURL:       bucketURL,
-		maxTemplateSize: maxTemplateSize,
-		maxDataSize:     maxDataSize,
+	// Reload the configuration from the environment and config file on SIGHUP
+	stopReload := watchReload(logger, srv, bucketURL, func() (settings, error) {
+		return loadSettings(*configPath, flag.CommandLine)
 	})
+	defer stopReload()
 
 	// Create HTTP server
 	httpServer := &http.Server{
-		Addr:              fmt.Sprintf(":%d", portNum),
-		Handler:           srv.Handler(),
-		ReadHeaderTimeout: readHeaderTimeout,
-		ReadTimeout:       readTimeout,
-		WriteTimeout:      writeTimeout,
+		Addr:    addr,
+		Handler: srv.Handler(),
+	}
+
+	if timeoutErr := configureTimeouts(env, httpServer, srv.config.Load().compileTimeout); timeoutErr != nil {
+		logger.Error("invalid HTTP timeouts", "error", timeoutErr)
+		_ = srv.Close()
+		return exitError
+	}
+
+	if tlsErr := configureTLS(env, httpServer); tlsErr != nil {
+		logger.Error("invalid TLS configuration", "error", tlsErr)
+		_ = srv.Close()
+		return exitError
 	}
 
 	// Start server in a goroutine
 	serverErrors := make(chan error, 1)
 	go func() {
-		logger.Info("starting HTTP server", "port", portNum)
-		serverErrors <- httpServer.ListenAndServe()
+		logger.Info("starting HTTP server", "addr", addr, "tls", httpServer.TLSConfig != nil)
+		serverErrors <- listenAndServe(httpServer)
 	}()
 
 	// Wait for interrupt signal or server error
+	shutdownTimeout := cmp.Or(env.positiveDuration("SHUTDOWN_TIMEOUT"), defaultShutdownTimeout)
+	shutdownDelay := env.positiveDuration("SHUTDOWN_DELAY")
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case serverErr := <-serverErrors:
 		logger.Error("server error", "error", serverErr)
+		_ = srv.Close()
 		return exitError
 	case sig := <-shutdown:
 		logger.Info("received shutdown signal", "signal", sig.String())
+		return gracefulShutdown(logger, httpServer, srv, shutdownDelay, shutdownTimeout)
+	}
+}
 
-		// Graceful shutdown
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
+// gracefulShutdown stops the HTTP server, waiting up to timeout for in-flight requests,
+// then closes srv, and returns the exit code.
+//
+// srv is drained first, so requests that would start new work get 503 while the running
+// ones finish. The server keeps accepting connections for delay after that, so load balancers
+// polling /ready see it draining before it stops. Requests still running after timeout are canceled.
+func gracefulShutdown(logger *slog.Logger, httpServer *http.Server, srv *Server, delay, timeout time.Duration) int {
+	srv.Drain()
+	if delay > 0 {
+		logger.Info("draining before shutdown", "delay", delay)
+		time.Sleep(delay)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-		if shutdownErr := httpServer.Shutdown(shutdownCtx); shutdownErr != nil {
-			logger.Error("graceful shutdown failed", "error", shutdownErr)
-			if closeErr := httpServer.Close(); closeErr != nil {
-				logger.Error("forced shutdown failed", "error", closeErr)
+	if shutdownErr := httpServer.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Error("graceful shutdown failed", "error", shutdownErr)
+		if closeErr := httpServer.Close(); closeErr != nil {
+			logger.Error("forced shutdown failed", "error", closeErr)
+		}
+		if closeErr := srv.Close(); closeErr != nil {
+			logger.Error("failed to close server", "error", closeErr)
+		}
+		return exitError
+	}
+
+	if closeErr := srv.Close(); closeErr != nil {
+		logger.Error("failed to close server", "error", closeErr)
+		return exitError
+	}
+
+	logger.Info("server stopped gracefully")
+	return exitSuccess
+}
+
+// configureTimeouts sets the read and write timeouts of the HTTP server from the
+// READ_HEADER_TIMEOUT, READ_TIMEOUT and WRITE_TIMEOUT environment variables.
+//
+// The write timeout must exceed compileTimeout, so a slow compile isn't cut off before its
+// response is written.
+func configureTimeouts(env settings, httpServer *http.Server, compileTimeout time.Duration) error {
+	httpServer.ReadHeaderTimeout = cmp.Or(env.positiveDuration("READ_HEADER_TIMEOUT"), defaultReadHeaderTimeout)
+	httpServer.ReadTimeout = cmp.Or(env.positiveDuration("READ_TIMEOUT"), defaultReadTimeout)
+	httpServer.WriteTimeout = cmp.Or(env.positiveDuration("WRITE_TIMEOUT"), defaultWriteTimeout)
+	if httpServer.WriteTimeout <= compileTimeout {
+		return fmt.Errorf("WRITE_TIMEOUT %s must exceed COMPILE_TIMEOUT %s", httpServer.WriteTimeout, compileTimeout)
+	}
+	return nil
+}
+
+// configureTLS sets the TLS config of the HTTP server from the TLS_CERT_FILE and TLS_KEY_FILE
+// environment variables, leaving it nil for plain HTTP when neither is set.
+//
+// The certificate and key are loaded up front, so an invalid pair fails at startup rather
+// than on the first connection.
+func configureTLS(env settings, httpServer *http.Server) error {
+	certFile, keyFile := env.get("TLS_CERT_FILE"), env.get("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	httpServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return nil
+}
+
+// listenAndServe serves HTTPS when the HTTP server has a TLS config, and plain HTTP otherwise.
+func listenAndServe(httpServer *http.Server) error {
+	if httpServer.TLSConfig != nil {
+		// The certificate is already in the TLS config.
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	return httpServer.ListenAndServe()
+}
+
+// listenAddr returns the HTTP listen address of the host and port flags, which the HOST and
+// PORT environment variables override. An empty host listens on all interfaces.
+//
+// The address is resolved up front, so an invalid host or port fails at startup rather than
+// when the server starts listening.
+func listenAddr(env settings, host string, port int) (string, error) {
+	host = strings.Trim(cmp.Or(env.get("HOST"), host), "[]")
+	if portEnv := env.get("PORT"); portEnv != "" {
+		if portFromEnv, err := strconv.Atoi(portEnv); err == nil {
+			port = portFromEnv
+		}
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return addr, nil
+}
+
+// watchReload reloads the server configuration from the settings returned by load whenever the
+// process receives SIGHUP, logging what changed. It returns a function that stops watching.
+//
+// load re-reads the config file, so edits to it take effect without a restart. If it fails, the
+// current configuration is kept.
+func watchReload(logger *slog.Logger, srv *Server, bucketURL string, load func() (settings, error)) func() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-reload:
+				env, err := load()
+				if err != nil {
+					logger.Error("failed to reload configuration", "error", err)
+					continue
+				}
+				changed, ignored := srv.Reload(serverConfigFromEnv(bucketURL, env))
+				logger.Info("reloaded configuration", "changed", changed)
+				if len(ignored) > 0 {
+					logger.Warn("configuration changes ignored until restart", "fields", ignored)
+				}
 			}
-			return exitError
 		}
+	}()
 
-		logger.Info("server stopped gracefully")
-		return exitSuccess
+	return func() {
+		signal.Stop(reload)
+		close(done)
 	}
 }
 
+// serverConfigFromEnv builds the server configuration from the optional environment variables,
+// or their values in the config file of env.
+//
+// Unset or invalid values are left at their zero value, so NewServer applies the default.
+func serverConfigFromEnv(bucketURL string, env settings) ServerConfig {
+	config := ServerConfig{bucketURL: bucketURL}
+
+	// Serve objects from the embedded templates instead of a bucket if requested
+	if bucketURL == embedBucketURL {
+		config.templateFS = embeddedTemplates()
+	}
+
+	// Get size limits from environment variables (optional)
+	config.maxTemplateSize = env.positiveInt64("MAX_TEMPLATE_SIZE")
+	config.maxDataSize = env.positiveInt64("MAX_DATA_SIZE")
+	config.maxAssetSize = env.positiveInt64("MAX_ASSET_SIZE")
+	config.maxIncludeFiles = env.positiveInt("MAX_INCLUDE_FILES")
+	config.maxAssetFiles = env.positiveInt("MAX_ASSET_FILES")
+	config.maxOutputSize = env.positiveInt64("MAX_OUTPUT_SIZE")
+	config.maxOutputSizes = env.outputSizes()
+
+	// Get compile settings from environment variables (optional)
+	config.compileMemoryLimit = env.positiveInt64("COMPILE_MEMORY_LIMIT")
+	config.optimizeLossy, _ = strconv.ParseBool(env.get("OPTIMIZE_LOSSY"))
+	config.compileTimeout = env.positiveDuration("COMPILE_TIMEOUT")
+	config.maxConcurrentCompiles = env.positiveInt("MAX_CONCURRENT_COMPILES")
+	config.compileQueueTimeout = env.positiveDuration("COMPILE_QUEUE_TIMEOUT")
+	config.compileWorkers = env.positiveInt("COMPILE_WORKERS")
+	config.compileToStdout, _ = strconv.ParseBool(env.get("COMPILE_TO_STDOUT"))
+	config.dataAsInputs, _ = strconv.ParseBool(env.get("DATA_AS_INPUTS"))
+	config.maxInputs = env.positiveInt("MAX_INPUTS")
+	config.maxSplitPages = env.positiveInt("MAX_SPLIT_PAGES")
+	config.maxBatchItems = env.positiveInt("MAX_BATCH_ITEMS")
+	config.maxBatchRequestSize = env.positiveInt64("MAX_BATCH_REQUEST_SIZE")
+	config.jobQueueSize = env.positiveInt("JOB_QUEUE_SIZE")
+	config.jobWorkers = env.positiveInt("JOB_WORKERS")
+	config.jobTTL = env.positiveDuration("JOB_TTL")
+	config.jobResultsMaxBytes = env.positiveInt64("JOB_RESULTS_MAX_BYTES")
+	config.skipEmptyData, _ = strconv.ParseBool(env.get("SKIP_EMPTY_DATA"))
+	config.checkDataContentType, _ = strconv.ParseBool(env.get("CHECK_DATA_CONTENT_TYPE"))
+	config.keepDataBOM, _ = strconv.ParseBool(env.get("KEEP_DATA_BOM"))
+	config.filenameFromTemplate, _ = strconv.ParseBool(env.get("FILENAME_FROM_TEMPLATE"))
+	config.dataKeysMode = strings.ToLower(env.get("DATA_KEYS_VALIDATION"))
+	config.outputACL = strings.ToLower(env.get("OUTPUT_ACL"))
+
+	// Get allowed output content types from environment variable (optional)
+	config.allowedContentTypes = env.list("ALLOWED_CONTENT_TYPES", strings.ToLower)
+
+	// Get template cache settings from environment variables (optional)
+	config.templateCacheSize = env.positiveInt("TEMPLATE_CACHE_SIZE")
+	config.templateCacheTTL = env.positiveDuration("TEMPLATE_CACHE_TTL")
+	config.templateCacheURL = env.get("TEMPLATE_CACHE_URL")
+	config.fontCacheSize = env.positiveInt("FONT_CACHE_SIZE")
+	config.fontCacheMaxBytes = env.positiveInt64("FONT_CACHE_MAX_BYTES")
+	config.pdfCacheMaxBytes = env.positiveInt64("PDF_CACHE_MAX_BYTES")
+	config.fontPath = env.get("TYPST_FONT_PATH")
+	config.packageCachePath = env.get("TYPST_PACKAGE_CACHE_PATH")
+	config.packagePath = env.get("TYPST_PACKAGE_PATH")
+	config.packageSeedPrefix = env.get("PACKAGE_SEED_PREFIX")
+	config.typstBinary = env.get("TYPST_BIN")
+	config.typstVersions = parseTypstVersions(env.get("TYPST_VERSIONS"))
+	config.allowedTypstFlags = env.list("TYPST_ALLOWED_FLAGS", normalizeTypstFlag)
+	config.workDir = env.get("WORK_DIR")
+	config.cacheFailClosed, _ = strconv.ParseBool(env.get("CACHE_FAIL_CLOSED"))
+
+	// Get per-template concurrency limits from environment variable (optional)
+	config.templateConcurrency = parseTemplateConcurrency(env.get("TEMPLATE_CONCURRENCY"))
+
+	// Get auth token from environment variable (optional)
+	config.authToken = env.get("AUTH_TOKEN")
+
+	// Get metrics setting from environment variable (optional, enabled by default)
+	config.metrics = true
+	if metricsEnv := env.get("METRICS_ENABLED"); metricsEnv != "" {
+		if parsed, err := strconv.ParseBool(metricsEnv); err == nil {
+			config.metrics = parsed
+		}
+	}
+
+	// Get profiling setting from environment variable (optional)
+	config.pprof, _ = strconv.ParseBool(env.get("ENABLE_PPROF"))
+
+	// Get tenant label settings from environment variables (optional)
+	config.tenantHeader = env.get("TENANT_HEADER")
+	config.tenantAllowlist = env.list("TENANT_ALLOWLIST", nil)
+	config.keyPrefix = env.get("KEY_PREFIX")
+
+	// Get bucket override settings from environment variables (optional)
+	config.allowBucketOverride, _ = strconv.ParseBool(env.get("ALLOW_BUCKET_OVERRIDE"))
+	config.bucketOverrideSchemes = env.list("BUCKET_OVERRIDE_SCHEMES", strings.ToLower)
+
+	// Get URL source settings from environment variables (optional)
+	config.allowURLSources, _ = strconv.ParseBool(env.get("ALLOW_URL_SOURCES"))
+	config.urlSourceHosts = env.list("URL_SOURCE_HOSTS", strings.ToLower)
+
+	// Get templates listing page size from environment variable (optional)
+	config.templatesPageSize = env.positiveInt("TEMPLATES_PAGE_SIZE")
+
+	// Get data file path from environment variable (optional)
+	config.dataFilePath = env.get("DATA_FILE_PATH")
+
+	// Get debug sample rate from environment variable (optional)
+	if debugSampleRateEnv := env.get("DEBUG_SAMPLE_RATE"); debugSampleRateEnv != "" {
+		if parsed, err := strconv.ParseFloat(debugSampleRateEnv, 64); err == nil && parsed > 0 && parsed <= 1 {
+			config.debugSampleRate = parsed
+		}
+	}
+
+	return config
+}
+
+// parseTemplateConcurrency parses comma-separated "key=limit" pairs into per-template-key limits.
+//
+// Malformed pairs and limits that aren't positive integers are skipped.
+func parseTemplateConcurrency(value string) map[string]int {
+	limits := make(map[string]int)
+	for pair := range strings.SplitSeq(value, ",") {
+		key, limitStr, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			continue
+		}
+		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
+			limits[key] = limit
+		}
+	}
+	return limits
+}
+
+// parseTypstVersions parses comma-separated "version=binary" pairs into the typst binary of each version.
+//
+// Pairs without a version or binary are skipped.
+func parseTypstVersions(value string) map[string]string {
+	binaries := make(map[string]string)
+	for pair := range strings.SplitSeq(value, ",") {
+		version, binary, found := strings.Cut(strings.TrimSpace(pair), "=")
+		version, binary = strings.TrimSpace(version), strings.TrimSpace(binary)
+		if !found || version == "" || binary == "" {
+			continue
+		}
+		binaries[version] = binary
+	}
+	return binaries
+}
+
+// positiveInt64 returns the environment variable as a positive integer, or 0 if unset or invalid.
+func (s settings) positiveInt64(name string) int64 {
+	parsed, err := strconv.ParseInt(s.get(name), 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0
+	}
+	return parsed
+}
+
+// list returns the non-empty, trimmed values of a comma-separated environment variable,
+// passed through normalize if it isn't nil.
+func (s settings) list(name string, normalize func(string) string) []string {
+	var values []string
+	for value := range strings.SplitSeq(s.get(name), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if normalize != nil {
+			value = normalize(value)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// outputSizes returns the per-format output size limits set by MAX_OUTPUT_SIZE_<FORMAT>
+// environment variables, such as MAX_OUTPUT_SIZE_PNG or MAX_OUTPUT_SIZE_PDF_PAGES.
+func (s settings) outputSizes() map[string]int64 {
+	var sizes map[string]int64
+	for format := range outputFormats() {
+		name := "MAX_OUTPUT_SIZE_" + strings.ToUpper(strings.ReplaceAll(format, "-", "_"))
+		if size := s.positiveInt64(name); size > 0 {
+			if sizes == nil {
+				sizes = make(map[string]int64)
+			}
+			sizes[format] = size
+		}
+	}
+	return sizes
+}
+
+// positiveInt returns the environment variable as a positive int, or 0 if unset or invalid.
+func (s settings) positiveInt(name string) int {
+	parsed, err := strconv.Atoi(s.get(name))
+	if err != nil || parsed <= 0 {
+		return 0
+	}
+	return parsed
+}
+
+// positiveDuration returns the environment variable as a positive duration, or 0 if unset or invalid.
+func (s settings) positiveDuration(name string) time.Duration {
+	parsed, err := time.ParseDuration(s.get(name))
+	if err != nil || parsed <= 0 {
+		return 0
+	}
+	return parsed
+}
+
 // printUsage prints the usage message to the provided writer.
 func printUsage(w io.Writer, progName string) {
 	fmt.Fprintf(w, "Usage: %s [OPTIONS]\n\n", progName)
 	fmt.Fprintf(w, "Generate PDFs from Typst templates stored in cloud storage.\n\n")
 	fmt.Fprintf(w, "Environment Variables:\n")
-	fmt.Fprintf(w, "  BUCKET_URL          URL of the cloud storage bucket containing templates (required)\n")
-	fmt.Fprintf(w, "  PORT                HTTP port to listen on (overrides -port flag)\n")
-	fmt.Fprintf(w, "  MAX_TEMPLATE_SIZE   Maximum template file size in bytes (default: 1048576)\n")
-	fmt.Fprintf(w, "  MAX_DATA_SIZE       Maximum data file size in bytes (default: 10485760)\n\n")
+	fmt.Fprintf(w, "  BUCKET_URL                URL of the storage bucket containing templates, or embed:// (required)\n")
+	fmt.Fprintf(w, "  HOST                      HTTP host to listen on (overrides -host flag, default: all interfaces)\n")
+	fmt.Fprintf(w, "  PORT                      HTTP port to listen on (overrides -port flag)\n")
+	fmt.Fprintf(w, "  TLS_CERT_FILE             PEM certificate file, serves HTTPS together with TLS_KEY_FILE\n")
+	fmt.Fprintf(w, "  TLS_KEY_FILE              PEM private key file of TLS_CERT_FILE\n")
+	fmt.Fprintf(w, "  READ_HEADER_TIMEOUT       Maximum duration of reading request headers (default: 10s)\n")
+	fmt.Fprintf(w, "  READ_TIMEOUT              Maximum duration of reading a request, including uploads (default: 30s)\n")
+	fmt.Fprintf(w, "  WRITE_TIMEOUT             Maximum duration of a response, above COMPILE_TIMEOUT (default: 60s)\n")
+	fmt.Fprintf(w, "  SHUTDOWN_TIMEOUT          Maximum wait for in-flight requests on shutdown (default: 10s)\n")
+	fmt.Fprintf(w, "  SHUTDOWN_DELAY            Time to report not ready before shutting down (default: 0s)\n")
+	fmt.Fprintf(w, "  MAX_TEMPLATE_SIZE         Maximum template file size in bytes (default: 1048576)\n")
+	fmt.Fprintf(w, "  MAX_DATA_SIZE             Maximum data file size in bytes (default: 10485760)\n")
+	fmt.Fprintf(w, "  MAX_ASSET_SIZE            Maximum asset file size in bytes (default: 10485760)\n")
+	fmt.Fprintf(w, "  MAX_INCLUDE_FILES         Maximum number of includeKeys per request (default: 32)\n")
+	fmt.Fprintf(w, "  MAX_ASSET_FILES           Maximum number of assetKeys per request (default: 64)\n")
+	fmt.Fprintf(w, "  MAX_OUTPUT_SIZE           Maximum compiled document size in bytes (default: 0, unlimited)\n")
+	fmt.Fprintf(w, "  MAX_OUTPUT_SIZE_<FORMAT>  Per-format limit, e.g. MAX_OUTPUT_SIZE_PNG (default: MAX_OUTPUT_SIZE)\n")
+	fmt.Fprintf(w, "  COMPILE_MEMORY_LIMIT      Maximum compile memory in bytes, Linux only (default: unlimited)\n")
+	fmt.Fprintf(w, "  OPTIMIZE_LOSSY            Fall back to lossy Ghostscript PDF optimization (default: false)\n")
+	fmt.Fprintf(w, "  COMPILE_TIMEOUT           Maximum duration of a single compilation (default: 30s)\n")
+	fmt.Fprintf(w, "  MAX_CONCURRENT_COMPILES   Maximum number of concurrent compilations (default: number of CPUs)\n")
+	fmt.Fprintf(w, "  COMPILE_QUEUE_TIMEOUT     Maximum wait for a compile slot before 503 (default: COMPILE_TIMEOUT)\n")
+	fmt.Fprintf(w, "  COMPILE_WORKERS           Number of compiler pool workers (default: MAX_CONCURRENT_COMPILES)\n")
+	fmt.Fprintf(w, "  COMPILE_TO_STDOUT         Capture the PDF from typst's stdout instead of a file (default: false)\n")
+	fmt.Fprintf(w, "  DATA_AS_INPUTS            Also pass scalar data values as typst --input flags (default: false)\n")
+	fmt.Fprintf(w, "  MAX_INPUTS                Maximum number of typst --input flags per compilation (default: 128)\n")
+	fmt.Fprintf(w, "  MAX_SPLIT_PAGES           Maximum pages in pdf-pages and svg-pages zips (default: 100)\n")
+	fmt.Fprintf(w, "  MAX_BATCH_ITEMS           Maximum number of items in a batch request (default: 500)\n")
+	fmt.Fprintf(w, "  MAX_BATCH_REQUEST_SIZE    Maximum batch request body in bytes (default: 4 x MAX_DATA_SIZE + 64KB)\n")
+	fmt.Fprintf(w, "  JOB_QUEUE_SIZE            Maximum number of pending asynchronous jobs (default: 100)\n")
+	fmt.Fprintf(w, "  JOB_WORKERS               Number of async jobs run at once (default: MAX_CONCURRENT_COMPILES)\n")
+	fmt.Fprintf(w, "  JOB_TTL                   How long a finished asynchronous job is kept (default: 15m)\n")
+	fmt.Fprintf(w, "  JOB_RESULTS_MAX_BYTES     Maximum total bytes of finished job documents (default: 268435456)\n")
+	fmt.Fprintf(w, "  SKIP_EMPTY_DATA           Skip the data file for empty data such as {} or [] (default: false)\n")
+	fmt.Fprintf(w, "  CHECK_DATA_CONTENT_TYPE   Reject JSON data files that are obviously not JSON (default: false)\n")
+	fmt.Fprintf(w, "  KEEP_DATA_BOM             Keep a leading UTF-8 BOM in JSON data files (default: false, stripped)\n")
+	fmt.Fprintf(w, "  FILENAME_FROM_TEMPLATE    Name downloads after the template key (default: false, output.pdf)\n")
+	fmt.Fprintf(w, "  DATA_KEYS_VALIDATION      Check data keys against the template's .keys.json: off, warn, strict\n")
+	fmt.Fprintf(w, "  OUTPUT_ACL                ACL of batch outputs: private, public-read (default: private)\n")
+	fmt.Fprintf(w, "  ALLOWED_CONTENT_TYPES     Comma-separated output content types clients may request (default: all)\n")
+	fmt.Fprintf(w, "  TEMPLATE_CACHE_SIZE       Maximum number of cached templates (default: 0, disabled)\n")
+	fmt.Fprintf(w, "  TEMPLATE_CACHE_TTL        How long a cached template stays valid (default: 5m)\n")
+	fmt.Fprintf(w, "  TEMPLATE_CACHE_URL        URL of a bucket to cache templates in instead of memory (default: none)\n")
+	fmt.Fprintf(w, "  CACHE_FAIL_CLOSED         Respond 503 when the template cache fails (default: false)\n")
+	fmt.Fprintf(w, "  FONT_CACHE_SIZE           Maximum number of cached fontKeys font files (default: 32)\n")
+	fmt.Fprintf(w, "  FONT_CACHE_MAX_BYTES      Maximum total bytes of cached fontKeys font files (default: 67108864)\n")
+	fmt.Fprintf(w, "  PDF_CACHE_MAX_BYTES       Maximum total bytes of cached compiled documents (default: 0, disabled)\n")
+	fmt.Fprintf(w, "  TYPST_FONT_PATH           Directories with additional fonts, passed to typst as --font-path\n")
+	fmt.Fprintf(w, "  TYPST_PACKAGE_CACHE_PATH  Shared package cache (default: packages in WORK_DIR or user cache dir)\n")
+	fmt.Fprintf(w, "  TYPST_BIN                 Typst binary compiles run with (default: typst on PATH)\n")
+	fmt.Fprintf(w, "  TYPST_VERSIONS            Typst binaries by version for typstVersion, e.g. \"0.11=typst-0.11\"\n")
+	fmt.Fprintf(w, "  TYPST_ALLOWED_FLAGS       Typst compile flags requests may pass in typstFlags (default: none)\n")
+	fmt.Fprintf(w, "  TYPST_PACKAGE_PATH        Directory of @local packages (default: typst's data directory)\n")
+	fmt.Fprintf(w, "  PACKAGE_SEED_PREFIX       Bucket prefix copied into the package cache at startup\n")
+	fmt.Fprintf(w, "  TEMPLATE_CONCURRENCY      Per-template concurrency limits, e.g. \"invoice.typ=2,report.typ=1\"\n")
+	fmt.Fprintf(w, "  TEMPLATES_PAGE_SIZE       Maximum number of objects listed per /templates page (default: 100)\n")
+	fmt.Fprintf(w, "  DATA_FILE_PATH            Path of the data file relative to the project root (default: data.json)\n")
+	fmt.Fprintf(w, "  WORK_DIR                  Parent of compile work dirs, swept at startup (default: OS temp dir)\n")
+	fmt.Fprintf(w, "  AUTH_TOKEN                Bearer token required by /generate and /templates (default: none)\n")
+	fmt.Fprintf(w, "  METRICS_ENABLED           Expose Prometheus metrics on /metrics (default: true)\n")
+	fmt.Fprintf(w, "  ENABLE_PPROF              Expose pprof profiling on /debug/pprof/, for debugging (default: false)\n")
+	fmt.Fprintf(w, "  ALLOW_BUCKET_OVERRIDE     Honor the bucketURL field of generate requests (default: false)\n")
+	fmt.Fprintf(w, "  BUCKET_OVERRIDE_SCHEMES   Comma-separated bucket URL schemes requests may use (default: s3)\n")
+	fmt.Fprintf(w, "  ALLOW_URL_SOURCES         Allow templateKey and dataKey to be http(s) URLs (default: false)\n")
+	fmt.Fprintf(w, "  URL_SOURCE_HOSTS          Comma-separated hosts URL sources may be fetched from (default: none)\n")
+	fmt.Fprintf(w, "  TENANT_HEADER             Request header labeling /generate metrics and logs, e.g. X-Tenant-ID\n")
+	fmt.Fprintf(w, "  TENANT_ALLOWLIST          Comma-separated tenants used as labels, others are \"unknown\"\n")
+	fmt.Fprintf(w, "  KEY_PREFIX                Prefix of request bucket keys, e.g. tenants/{tenant}/ (default: none)\n")
+	fmt.Fprintf(w, "  DEBUG_SAMPLE_RATE         Fraction of requests (0-1) logged at debug level (default: 0)\n")
+	fmt.Fprintf(w, "  LOG_FORMAT                Log output format: json or text (default: json)\n")
+	fmt.Fprintf(w, "  LOG_LEVEL                 Log level: debug, info, warn or error (overrides -v flag)\n")
+	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "Options:\n")
 	flag.CommandLine.SetOutput(w)
 	flag.PrintDefaults()
 }
 
-// setupLogger sets up the logger based on the verbose flag.
-func setupLogger(verbose bool) *slog.Logger {
+// setupLogger sets up the logger based on the verbose flag and the LOG_FORMAT and LOG_LEVEL
+// environment variables.
+func setupLogger(env settings, verbose bool) (*slog.Logger, error) {
+	return newLogger(os.Stdout, verbose, env.get("LOG_FORMAT"), env.get("LOG_LEVEL"))
+}
+
+// newLogger returns a logger writing to w in the given format, "json" (the default) or "text".
+//
+// A level, such as "debug" or "warn", takes precedence over the verbose flag, which only
+// switches between info and debug.
+func newLogger(w io.Writer, verbose bool, format, level string) (*slog.Logger, error) {
 	logLevel := slog.LevelInfo
 	if verbose {
 		logLevel = slog.LevelDebug
 	}
-	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	}))
+	if level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch strings.ToLower(format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be json or text", format)
+	}
 }
@@ -2,9 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"flag"
 	"io"
+	"maps"
+	"math/big"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"testing"
@@ -27,7 +38,10 @@ func TestSetupLogger(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			logger := setupLogger(tt.verbose)
+			logger, err := setupLogger(settings{}, tt.verbose)
+			if err != nil {
+				t.Fatalf("setupLogger() returned error: %v", err)
+			}
 			if logger == nil {
 				t.Fatal("setupLogger() returned nil")
 			}
@@ -48,7 +62,9 @@ func TestPrintUsage(t *testing.T) {
 		"Usage: givetypst [OPTIONS]",
 		"Generate PDFs from Typst templates",
 		"BUCKET_URL",
+		"HOST",
 		"PORT",
+		"TLS_CERT_FILE",
 		"Options:",
 	}
 
@@ -59,6 +75,61 @@ func TestPrintUsage(t *testing.T) {
 	}
 }
 
+// TestNewLogger tests the output format and level of the logger.
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		verbose   bool
+		format    string
+		level     string
+		wantLines []string
+		wantErr   string
+	}{
+		{name: "default", wantLines: []string{`"level":"INFO","msg":"info"`, `"level":"WARN","msg":"warn"`}},
+		{name: "verbose", verbose: true, wantLines: []string{`"msg":"debug"`, `"msg":"info"`, `"msg":"warn"`}},
+		{name: "text", format: "text", wantLines: []string{"level=INFO msg=info", "level=WARN msg=warn"}},
+		{name: "json", format: "JSON", wantLines: []string{`"msg":"info"`, `"msg":"warn"`}},
+		{name: "level overrides verbose", verbose: true, level: "warn", wantLines: []string{`"msg":"warn"`}},
+		{name: "debug level", level: "DEBUG", wantLines: []string{`"msg":"debug"`, `"msg":"info"`, `"msg":"warn"`}},
+		{name: "invalid format", format: "xml", wantErr: "invalid LOG_FORMAT"},
+		{name: "invalid level", level: "loud", wantErr: "invalid LOG_LEVEL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, tt.verbose, tt.format, tt.level)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newLogger() returned error: %v", err)
+			}
+
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Warn("warn")
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != len(tt.wantLines) {
+				t.Fatalf("expected %d lines, got %q", len(tt.wantLines), lines)
+			}
+			for i, want := range tt.wantLines {
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("expected line %d to contain %q, got %q", i, want, lines[i])
+				}
+			}
+		})
+	}
+}
+
 // runTestConfig holds configuration for a run() test case.
 type runTestConfig struct {
 	name               string
@@ -150,6 +221,17 @@ func TestRun_MissingBucketURL(t *testing.T) {
 	})
 }
 
+// TestRun_MissingTypstBin tests that a configured typst binary that doesn't exist fails at startup.
+func TestRun_MissingTypstBin(t *testing.T) {
+	runTest(t, runTestConfig{
+		name:               "missing TYPST_BIN",
+		args:               []string{"givetypst"},
+		env:                map[string]string{"BUCKET_URL": "mem://", "TYPST_BIN": "/nonexistent/typst"},
+		wantExitCode:       1,
+		wantOutputContains: []string{"TYPST_BIN not found"},
+	})
+}
+
 // TestRun_PortEnvOverride tests the PORT env overrides flag.
 func TestRun_PortEnvOverride(t *testing.T) {
 	runTest(t, runTestConfig{
@@ -174,6 +256,222 @@ func TestRun_InvalidPortEnv(t *testing.T) {
 	})
 }
 
+// TestRun_HostEnv tests that the HOST env sets the host of the listen address.
+func TestRun_HostEnv(t *testing.T) {
+	runTest(t, runTestConfig{
+		name:               "HOST env overrides flag",
+		args:               []string{"givetypst", "-host", "0.0.0.0"},
+		env:                map[string]string{"BUCKET_URL": "mem://", "HOST": "127.0.0.1", "PORT": "19007"},
+		signal:             syscall.SIGTERM,
+		wantExitCode:       0,
+		wantOutputContains: []string{"127.0.0.1:19007"},
+	})
+}
+
+// TestRun_InvalidHost tests that an invalid listen address fails at startup.
+func TestRun_InvalidHost(t *testing.T) {
+	runTest(t, runTestConfig{
+		name:               "invalid host",
+		args:               []string{"givetypst", "-host", "not a host", "-port", "19008"},
+		env:                map[string]string{"BUCKET_URL": "mem://", "HOST": ""},
+		wantExitCode:       1,
+		wantOutputContains: []string{"invalid listen address"},
+	})
+}
+
+// TestRun_TLS tests that the server starts with TLS and shuts down gracefully.
+func TestRun_TLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	runTest(t, runTestConfig{
+		name: "TLS",
+		args: []string{"givetypst"},
+		env: map[string]string{
+			"BUCKET_URL":    "mem://",
+			"PORT":          "19009",
+			"TLS_CERT_FILE": certFile,
+			"TLS_KEY_FILE":  keyFile,
+		},
+		signal:             syscall.SIGTERM,
+		wantExitCode:       0,
+		wantOutputContains: []string{`"tls":true`, "server stopped gracefully"},
+	})
+}
+
+// TestConfigureTLS tests loading the TLS config of the HTTP server from the environment.
+func TestConfigureTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantTLS  bool
+		wantErr  string
+	}{
+		{name: "plain HTTP"},
+		{name: "valid pair", certFile: certFile, keyFile: keyFile, wantTLS: true},
+		{name: "only certificate", certFile: certFile, wantErr: "must be set together"},
+		{name: "only key", keyFile: keyFile, wantErr: "must be set together"},
+		{name: "mismatched pair", certFile: keyFile, keyFile: certFile, wantErr: "failed to load TLS certificate"},
+		{
+			name:     "missing file",
+			certFile: filepath.Join(t.TempDir(), "missing.pem"),
+			keyFile:  keyFile,
+			wantErr:  "failed to load TLS certificate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TLS_CERT_FILE", tt.certFile)
+			t.Setenv("TLS_KEY_FILE", tt.keyFile)
+
+			httpServer := &http.Server{ReadHeaderTimeout: defaultReadHeaderTimeout}
+			err := configureTLS(settings{}, httpServer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configureTLS() returned error: %v", err)
+			}
+			if got := httpServer.TLSConfig != nil; got != tt.wantTLS {
+				t.Errorf("expected TLS config set %v, got %v", tt.wantTLS, got)
+			}
+		})
+	}
+}
+
+// TestConfigureTimeouts tests the HTTP server timeouts set from the environment.
+func TestConfigureTimeouts(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		compileTimeout time.Duration
+		wantReadHeader time.Duration
+		wantRead       time.Duration
+		wantWrite      time.Duration
+		wantErr        string
+	}{
+		{
+			name:           "defaults",
+			compileTimeout: defaultCompileTimeout,
+			wantReadHeader: defaultReadHeaderTimeout,
+			wantRead:       defaultReadTimeout,
+			wantWrite:      defaultWriteTimeout,
+		},
+		{
+			name:           "configured",
+			env:            map[string]string{"READ_HEADER_TIMEOUT": "5s", "READ_TIMEOUT": "5m", "WRITE_TIMEOUT": "2m"},
+			compileTimeout: defaultCompileTimeout,
+			wantReadHeader: 5 * time.Second,
+			wantRead:       5 * time.Minute,
+			wantWrite:      2 * time.Minute,
+		},
+		{
+			name:           "invalid values",
+			env:            map[string]string{"READ_TIMEOUT": "soon", "WRITE_TIMEOUT": "-1s"},
+			compileTimeout: defaultCompileTimeout,
+			wantReadHeader: defaultReadHeaderTimeout,
+			wantRead:       defaultReadTimeout,
+			wantWrite:      defaultWriteTimeout,
+		},
+		{
+			name:           "write timeout within compile timeout",
+			env:            map[string]string{"WRITE_TIMEOUT": "30s"},
+			compileTimeout: defaultCompileTimeout,
+			wantErr:        "WRITE_TIMEOUT 30s must exceed COMPILE_TIMEOUT 30s",
+		},
+		{
+			name:           "default write timeout within compile timeout",
+			compileTimeout: 2 * time.Minute,
+			wantErr:        "must exceed COMPILE_TIMEOUT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"READ_HEADER_TIMEOUT", "READ_TIMEOUT", "WRITE_TIMEOUT"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			httpServer := &http.Server{}
+			err := configureTimeouts(settings{}, httpServer, tt.compileTimeout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configureTimeouts() returned error: %v", err)
+			}
+			if httpServer.ReadHeaderTimeout != tt.wantReadHeader || httpServer.ReadTimeout != tt.wantRead ||
+				httpServer.WriteTimeout != tt.wantWrite {
+				t.Errorf("expected timeouts %s, %s and %s, got %s, %s and %s",
+					tt.wantReadHeader, tt.wantRead, tt.wantWrite,
+					httpServer.ReadHeaderTimeout, httpServer.ReadTimeout, httpServer.WriteTimeout)
+			}
+		})
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key for localhost, returning their paths.
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if writeErr := os.WriteFile(certFile, certPEM, 0o600); writeErr != nil {
+		t.Fatalf("failed to write certificate: %v", writeErr)
+	}
+	if writeErr := os.WriteFile(keyFile, keyPEM, 0o600); writeErr != nil {
+		t.Fatalf("failed to write key: %v", writeErr)
+	}
+	return certFile, keyFile
+}
+
+// TestRun_ConfigFile tests that settings are read from the config file, below flags.
+func TestRun_ConfigFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("BUCKET_URL: mem://\nPORT: 19010\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	runTest(t, runTestConfig{
+		name:               "config file",
+		args:               []string{"givetypst", "-config", configPath, "-port", "19011"},
+		env:                map[string]string{"BUCKET_URL": "", "PORT": ""},
+		signal:             syscall.SIGTERM,
+		wantExitCode:       0,
+		wantOutputContains: []string{":19011"},
+	})
+}
+
 // TestRun_DefaultPort tests the default port 8080.
 func TestRun_DefaultPort(t *testing.T) {
 	runTest(t, runTestConfig{
@@ -222,6 +520,18 @@ func TestRun_GracefulShutdownSIGTERM(t *testing.T) {
 	})
 }
 
+// TestRun_GracefulShutdownDelay tests that the server drains for SHUTDOWN_DELAY before shutting down.
+func TestRun_GracefulShutdownDelay(t *testing.T) {
+	runTest(t, runTestConfig{
+		name:               "graceful shutdown with delay",
+		args:               []string{"givetypst"},
+		env:                map[string]string{"BUCKET_URL": "mem://", "PORT": "19008", "SHUTDOWN_DELAY": "50ms"},
+		signal:             syscall.SIGTERM,
+		wantExitCode:       0,
+		wantOutputContains: []string{"draining before shutdown", "server stopped gracefully"},
+	})
+}
+
 // TestRun_BucketURLEnv tests the BUCKET_URL from env.
 func TestRun_BucketURLEnv(t *testing.T) {
 	runTest(t, runTestConfig{
@@ -233,3 +543,268 @@ func TestRun_BucketURLEnv(t *testing.T) {
 		wantOutputContains: []string{"starting HTTP server"},
 	})
 }
+
+// TestServerConfigFromEnv tests that optional environment variables are parsed into the server config.
+func TestServerConfigFromEnv(t *testing.T) {
+	t.Setenv("MAX_TEMPLATE_SIZE", "2048")
+	t.Setenv("MAX_DATA_SIZE", "invalid")
+	t.Setenv("MAX_ASSET_SIZE", "4096")
+	t.Setenv("MAX_INCLUDE_FILES", "8")
+	t.Setenv("MAX_ASSET_FILES", "16")
+	t.Setenv("MAX_OUTPUT_SIZE", "1000")
+	t.Setenv("MAX_OUTPUT_SIZE_PNG", "500")
+	t.Setenv("MAX_OUTPUT_SIZE_PDF_PAGES", "invalid")
+	t.Setenv("COMPILE_TIMEOUT", "-5s")
+	t.Setenv("COMPILE_QUEUE_TIMEOUT", "250ms")
+	t.Setenv("TEMPLATE_CACHE_SIZE", "8")
+	t.Setenv("TEMPLATE_CACHE_TTL", "1m")
+	t.Setenv("TEMPLATE_CACHE_URL", "s3://template-cache")
+	t.Setenv("FONT_CACHE_SIZE", "4")
+	t.Setenv("FONT_CACHE_MAX_BYTES", "1048576")
+	t.Setenv("PDF_CACHE_MAX_BYTES", "1048576")
+	t.Setenv("TYPST_FONT_PATH", "/usr/share/fonts/custom")
+	t.Setenv("TYPST_PACKAGE_CACHE_PATH", "/var/cache/typst")
+	t.Setenv("TYPST_PACKAGE_PATH", "/opt/typst/packages")
+	t.Setenv("PACKAGE_SEED_PREFIX", "packages")
+	t.Setenv("TYPST_BIN", "/usr/local/bin/typst")
+	t.Setenv("TYPST_VERSIONS", "0.11=/opt/typst-0.11/typst, 0.14=typst-0.14")
+	t.Setenv("TYPST_ALLOWED_FLAGS", "--jobs, creation-timestamp")
+	t.Setenv("WORK_DIR", "/var/lib/givetypst/work")
+	t.Setenv("ALLOWED_CONTENT_TYPES", " Application/PDF ,,")
+	t.Setenv("COMPILE_TO_STDOUT", "true")
+	t.Setenv("CACHE_FAIL_CLOSED", "true")
+	t.Setenv("CHECK_DATA_CONTENT_TYPE", "true")
+	t.Setenv("KEEP_DATA_BOM", "true")
+	t.Setenv("FILENAME_FROM_TEMPLATE", "true")
+	t.Setenv("ALLOW_BUCKET_OVERRIDE", "true")
+	t.Setenv("BUCKET_OVERRIDE_SCHEMES", "s3, GS")
+	t.Setenv("ALLOW_URL_SOURCES", "true")
+	t.Setenv("ENABLE_PPROF", "true")
+	t.Setenv("OPTIMIZE_LOSSY", "true")
+	t.Setenv("URL_SOURCE_HOSTS", "API.internal, data.internal:8443")
+	t.Setenv("COMPILE_WORKERS", "3")
+	t.Setenv("MAX_INPUTS", "16")
+	t.Setenv("MAX_SPLIT_PAGES", "20")
+	t.Setenv("MAX_BATCH_ITEMS", "50")
+	t.Setenv("MAX_BATCH_REQUEST_SIZE", "65536")
+	t.Setenv("JOB_QUEUE_SIZE", "10")
+	t.Setenv("JOB_WORKERS", "3")
+	t.Setenv("JOB_TTL", "1h")
+	t.Setenv("JOB_RESULTS_MAX_BYTES", "1048576")
+	t.Setenv("DATA_KEYS_VALIDATION", "Strict")
+	t.Setenv("OUTPUT_ACL", "Public-Read")
+	t.Setenv("TENANT_HEADER", "X-Tenant-ID")
+	t.Setenv("TENANT_ALLOWLIST", " acme, ,globex")
+	t.Setenv("KEY_PREFIX", "tenants/{tenant}/")
+
+	config := serverConfigFromEnv("mem://", settings{})
+
+	if config.bucketURL != "mem://" {
+		t.Errorf("expected bucketURL %q, got %q", "mem://", config.bucketURL)
+	}
+	if config.maxTemplateSize != 2048 {
+		t.Errorf("expected maxTemplateSize 2048, got %d", config.maxTemplateSize)
+	}
+	if config.maxDataSize != 0 {
+		t.Errorf("expected invalid maxDataSize to be ignored, got %d", config.maxDataSize)
+	}
+	if config.maxAssetSize != 4096 {
+		t.Errorf("expected maxAssetSize 4096, got %d", config.maxAssetSize)
+	}
+	if config.maxIncludeFiles != 8 {
+		t.Errorf("expected maxIncludeFiles 8, got %d", config.maxIncludeFiles)
+	}
+	if config.maxAssetFiles != 16 {
+		t.Errorf("expected maxAssetFiles 16, got %d", config.maxAssetFiles)
+	}
+	if config.maxOutputSize != 1000 {
+		t.Errorf("expected maxOutputSize 1000, got %d", config.maxOutputSize)
+	}
+	if !maps.Equal(config.maxOutputSizes, map[string]int64{formatPNG: 500}) {
+		t.Errorf("expected maxOutputSizes only for png, got %v", config.maxOutputSizes)
+	}
+	if config.compileTimeout != 0 {
+		t.Errorf("expected negative compileTimeout to be ignored, got %v", config.compileTimeout)
+	}
+	if config.compileQueueTimeout != 250*time.Millisecond {
+		t.Errorf("expected compileQueueTimeout 250ms, got %v", config.compileQueueTimeout)
+	}
+	if config.templateCacheSize != 8 {
+		t.Errorf("expected templateCacheSize 8, got %d", config.templateCacheSize)
+	}
+	if config.templateCacheTTL != time.Minute {
+		t.Errorf("expected templateCacheTTL 1m, got %v", config.templateCacheTTL)
+	}
+	if config.templateCacheURL != "s3://template-cache" {
+		t.Errorf("expected templateCacheURL %q, got %q", "s3://template-cache", config.templateCacheURL)
+	}
+	if config.fontCacheSize != 4 {
+		t.Errorf("expected fontCacheSize 4, got %d", config.fontCacheSize)
+	}
+	if config.fontCacheMaxBytes != 1048576 {
+		t.Errorf("expected fontCacheMaxBytes 1048576, got %d", config.fontCacheMaxBytes)
+	}
+	if config.pdfCacheMaxBytes != 1048576 {
+		t.Errorf("expected pdfCacheMaxBytes 1048576, got %d", config.pdfCacheMaxBytes)
+	}
+	if config.fontPath != "/usr/share/fonts/custom" {
+		t.Errorf("expected fontPath %q, got %q", "/usr/share/fonts/custom", config.fontPath)
+	}
+	if config.packageCachePath != "/var/cache/typst" || config.packagePath != "/opt/typst/packages" {
+		t.Errorf("expected package paths to be set, got %q and %q", config.packageCachePath, config.packagePath)
+	}
+	if config.packageSeedPrefix != "packages" {
+		t.Errorf("expected packageSeedPrefix %q, got %q", "packages", config.packageSeedPrefix)
+	}
+	if config.typstBinary != "/usr/local/bin/typst" {
+		t.Errorf("expected typstBinary %q, got %q", "/usr/local/bin/typst", config.typstBinary)
+	}
+	wantVersions := map[string]string{"0.11": "/opt/typst-0.11/typst", "0.14": "typst-0.14"}
+	if !maps.Equal(config.typstVersions, wantVersions) {
+		t.Errorf("expected typstVersions %v, got %v", wantVersions, config.typstVersions)
+	}
+	if want := []string{"--jobs", "--creation-timestamp"}; !slices.Equal(config.allowedTypstFlags, want) {
+		t.Errorf("expected allowedTypstFlags %v, got %v", want, config.allowedTypstFlags)
+	}
+	if config.workDir != "/var/lib/givetypst/work" {
+		t.Errorf("expected workDir %q, got %q", "/var/lib/givetypst/work", config.workDir)
+	}
+	if len(config.allowedContentTypes) != 1 || config.allowedContentTypes[0] != "application/pdf" {
+		t.Errorf("expected allowedContentTypes [application/pdf], got %v", config.allowedContentTypes)
+	}
+	if !config.compileToStdout {
+		t.Error("expected compileToStdout to be true")
+	}
+	if !config.cacheFailClosed {
+		t.Error("expected cacheFailClosed to be true")
+	}
+	if !config.checkDataContentType {
+		t.Error("expected checkDataContentType to be true")
+	}
+	if !config.keepDataBOM {
+		t.Error("expected keepDataBOM to be true")
+	}
+	if !config.filenameFromTemplate {
+		t.Error("expected filenameFromTemplate to be true")
+	}
+	if !config.allowBucketOverride {
+		t.Error("expected allowBucketOverride to be true")
+	}
+	if !slices.Equal(config.bucketOverrideSchemes, []string{"s3", "gs"}) {
+		t.Errorf("expected bucketOverrideSchemes [s3 gs], got %v", config.bucketOverrideSchemes)
+	}
+	if !config.allowURLSources {
+		t.Error("expected allowURLSources to be true")
+	}
+	if !config.optimizeLossy {
+		t.Error("expected optimizeLossy to be true")
+	}
+	if !config.pprof {
+		t.Error("expected pprof to be true")
+	}
+	if !slices.Equal(config.urlSourceHosts, []string{"api.internal", "data.internal:8443"}) {
+		t.Errorf("expected urlSourceHosts [api.internal data.internal:8443], got %v", config.urlSourceHosts)
+	}
+	if config.dataKeysMode != dataKeysStrict {
+		t.Errorf("expected dataKeysMode %q, got %q", dataKeysStrict, config.dataKeysMode)
+	}
+	if config.outputACL != outputACLPublicRead {
+		t.Errorf("expected outputACL %q, got %q", outputACLPublicRead, config.outputACL)
+	}
+	if config.maxInputs != 16 {
+		t.Errorf("expected maxInputs 16, got %d", config.maxInputs)
+	}
+	if config.maxSplitPages != 20 {
+		t.Errorf("expected maxSplitPages 20, got %d", config.maxSplitPages)
+	}
+	if config.maxBatchItems != 50 {
+		t.Errorf("expected maxBatchItems 50, got %d", config.maxBatchItems)
+	}
+	if config.maxBatchRequestSize != 65536 {
+		t.Errorf("expected maxBatchRequestSize 65536, got %d", config.maxBatchRequestSize)
+	}
+	if config.jobQueueSize != 10 {
+		t.Errorf("expected jobQueueSize 10, got %d", config.jobQueueSize)
+	}
+	if config.jobWorkers != 3 {
+		t.Errorf("expected jobWorkers 3, got %d", config.jobWorkers)
+	}
+	if config.jobTTL != time.Hour {
+		t.Errorf("expected jobTTL 1h, got %v", config.jobTTL)
+	}
+	if config.jobResultsMaxBytes != 1048576 {
+		t.Errorf("expected jobResultsMaxBytes 1048576, got %d", config.jobResultsMaxBytes)
+	}
+	if config.compileWorkers != 3 {
+		t.Errorf("expected compileWorkers 3, got %d", config.compileWorkers)
+	}
+	if config.tenantHeader != "X-Tenant-ID" {
+		t.Errorf("expected tenantHeader %q, got %q", "X-Tenant-ID", config.tenantHeader)
+	}
+	if !slices.Equal(config.tenantAllowlist, []string{"acme", "globex"}) {
+		t.Errorf("expected tenantAllowlist [acme globex], got %v", config.tenantAllowlist)
+	}
+	if config.keyPrefix != "tenants/{tenant}/" {
+		t.Errorf("expected keyPrefix %q, got %q", "tenants/{tenant}/", config.keyPrefix)
+	}
+}
+
+// TestParseTemplateConcurrency tests parsing per-template concurrency limits.
+func TestParseTemplateConcurrency(t *testing.T) {
+	t.Parallel()
+
+	got := parseTemplateConcurrency(" invoice.typ=2, report.typ=1,bad,zero.typ=0,neg.typ=-1,=3,nan.typ=x")
+
+	want := map[string]int{"invoice.typ": 2, "report.typ": 1}
+	if !maps.Equal(got, want) {
+		t.Errorf("expected limits %v, got %v", want, got)
+	}
+}
+
+// TestParseTypstVersions tests parsing the typst binaries of pinnable versions.
+func TestParseTypstVersions(t *testing.T) {
+	t.Parallel()
+
+	got := parseTypstVersions(" 0.11 = /opt/typst-0.11/typst,0.14=typst-0.14,bad,=typst,0.12=")
+
+	want := map[string]string{"0.11": "/opt/typst-0.11/typst", "0.14": "typst-0.14"}
+	if !maps.Equal(got, want) {
+		t.Errorf("expected versions %v, got %v", want, got)
+	}
+}
+
+// TestWatchReload tests that SIGHUP reloads the configuration from a rewritten config file.
+func TestWatchReload(t *testing.T) {
+	t.Setenv("MAX_TEMPLATE_SIZE", "")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("MAX_TEMPLATE_SIZE: 2048\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	load := func() (settings, error) {
+		return loadSettings(configPath, flag.NewFlagSet("givetypst", flag.ContinueOnError))
+	}
+	env, err := load()
+	if err != nil {
+		t.Fatalf("loadSettings() returned error: %v", err)
+	}
+
+	srv := NewServer(testLogger(), serverConfigFromEnv("mem://", env))
+	stop := watchReload(testLogger(), srv, "mem://", load)
+	defer stop()
+
+	if err = os.WriteFile(configPath, []byte("MAX_TEMPLATE_SIZE: 4096\n"), 0o600); err != nil {
+		t.Fatalf("failed to rewrite config file: %v", err)
+	}
+	if err = syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("failed to send SIGHUP: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.config.Load().maxTemplateSize != 4096 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected maxTemplateSize 4096 after SIGHUP, got %d", srv.config.Load().maxTemplateSize)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
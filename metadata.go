@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boringbin/givetypst/client"
+)
+
+// metadataDateLayout is the layout of the metadata date, an ISO 8601 calendar date.
+const metadataDateLayout = "2006-01-02"
+
+// DocumentMetadata is the document metadata of a generate request, shared with the Go client.
+type DocumentMetadata = client.DocumentMetadata
+
+// validateMetadata checks that the metadata date is a valid calendar date.
+func validateMetadata(m *DocumentMetadata) error {
+	if m == nil || m.Date == "" {
+		return nil
+	}
+	if _, err := time.Parse(metadataDateLayout, m.Date); err != nil {
+		return fmt.Errorf("invalid metadata date %q, expected YYYY-MM-DD", m.Date)
+	}
+	return nil
+}
+
+// metadataPreamble returns a "#set document(...)" line setting the metadata, to prepend to the
+// template source. It returns "" if no metadata is set.
+//
+// Values are written as escaped Typst string literals, so they can't break out of the
+// set rule. The metadata must have been validated.
+func metadataPreamble(m *DocumentMetadata) string {
+	if m == nil {
+		return ""
+	}
+
+	var args []string
+	if m.Title != "" {
+		args = append(args, "title: "+typstString(m.Title))
+	}
+	if m.Author != "" {
+		args = append(args, "author: "+typstString(m.Author))
+	}
+	if len(m.Keywords) > 0 {
+		keywords := make([]string, len(m.Keywords))
+		for i, keyword := range m.Keywords {
+			keywords[i] = typstString(keyword) + ","
+		}
+		args = append(args, "keywords: ("+strings.Join(keywords, " ")+")")
+	}
+	if date, err := time.Parse(metadataDateLayout, m.Date); err == nil {
+		args = append(args, fmt.Sprintf("date: datetime(year: %d, month: %d, day: %d)",
+			date.Year(), date.Month(), date.Day()))
+	}
+
+	if len(args) == 0 {
+		return ""
+	}
+	return "#set document(" + strings.Join(args, ", ") + ")\n"
+}
+
+// typstString returns s as a quoted Typst string literal.
+//
+// Backslashes and quotes are escaped, and control characters are written as escape
+// sequences so the literal stays on one line.
+func typstString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
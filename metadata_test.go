@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestTypstString tests escaping strings as Typst string literals.
+func TestTypstString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "Invoice", want: `"Invoice"`},
+		{name: "quote", in: `Say "hi"`, want: `"Say \"hi\""`},
+		{name: "backslash", in: `C:\docs`, want: `"C:\\docs"`},
+		{name: "injection", in: `"), #include "/etc/passwd`, want: `"\"), #include \"/etc/passwd"`},
+		{name: "newline", in: "a\nb\r\tc", want: `"a\nb\r\tc"`},
+		{name: "control", in: "a\x00b", want: `"a\u{0}b"`},
+		{name: "unicode", in: "Grüße", want: `"Grüße"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := typstString(tt.in); got != tt.want {
+				t.Errorf("typstString(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDocumentMetadata_Preamble tests the generated "#set document" preamble.
+func TestDocumentMetadata_Preamble(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		metadata *DocumentMetadata
+		want     string
+	}{
+		{name: "nil", metadata: nil, want: ""},
+		{name: "empty", metadata: &DocumentMetadata{}, want: ""},
+		{name: "title", metadata: &DocumentMetadata{Title: "Report"}, want: "#set document(title: \"Report\")\n"},
+		{
+			name: "all fields",
+			metadata: &DocumentMetadata{
+				Title:    "Q1 \"Final\"",
+				Author:   "Jane Doe",
+				Keywords: []string{"finance"},
+				Date:     "2024-03-05",
+			},
+			want: `#set document(title: "Q1 \"Final\"", author: "Jane Doe", keywords: ("finance",), ` +
+				"date: datetime(year: 2024, month: 3, day: 5))\n",
+		},
+		{
+			name:     "keywords",
+			metadata: &DocumentMetadata{Keywords: []string{"a", "b"}},
+			want:     "#set document(keywords: (\"a\", \"b\",))\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := metadataPreamble(tt.metadata); got != tt.want {
+				t.Errorf("expected preamble %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Metadata tests that the metadata preamble is prepended to the template.
+func TestHandleGenerate_Metadata(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		metadata   string
+		wantStatus int
+		wantSource string
+	}{
+		{
+			name:       "title",
+			metadata:   `{"title": "Report"}`,
+			wantStatus: http.StatusOK,
+			wantSource: "#set document(title: \"Report\")\n= Hello",
+		},
+		{name: "invalid date", metadata: `{"date": "05/03/2024"}`, wantStatus: http.StatusBadRequest},
+		{name: "impossible date", metadata: `{"date": "2023-02-29"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ", "metadata": ` + tt.metadata + `}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := compiler.files[sourceFileName]; got != tt.wantSource {
+				t.Errorf("expected source %q, got %q", tt.wantSource, got)
+			}
+		})
+	}
+}
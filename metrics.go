@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsNamespace is the namespace of the Prometheus metrics.
+	metricsNamespace = "givetypst"
+	// tenantLabelName is the name of the tenant label of /generate metrics.
+	tenantLabelName = "tenant"
+)
+
+// serverMetrics holds the Prometheus metrics of the server.
+//
+// A nil *serverMetrics records nothing, so callers don't need to check whether metrics are enabled.
+type serverMetrics struct {
+	// registry is the registry the metrics are registered with and served from.
+	registry *prometheus.Registry
+	// generateRequests counts /generate requests by status code.
+	generateRequests *prometheus.CounterVec
+	// generateDuration observes the end-to-end duration of /generate requests.
+	generateDuration *prometheus.HistogramVec
+	// compileDuration observes the duration of the typst compiler alone.
+	compileDuration prometheus.Histogram
+	// fetchErrors counts failed fetches from the storage bucket.
+	fetchErrors prometheus.Counter
+	// pdfCacheHits counts compiled documents served from the document cache.
+	pdfCacheHits prometheus.Counter
+	// pdfCacheMisses counts document cache lookups that found no document.
+	pdfCacheMisses prometheus.Counter
+	// compileQueueDepth reports the number of compilations waiting for a compile slot.
+	compileQueueDepth prometheus.GaugeFunc
+	// tenantLabel labels /generate metrics with the request's tenant.
+	tenantLabel bool
+}
+
+// newServerMetrics creates the server metrics and registers them with a new registry.
+//
+// queueDepth reports the number of compilations waiting for a compile slot. If tenantLabel
+// is set, /generate metrics get a "tenant" label with the tenant stored by tagTenant.
+func newServerMetrics(queueDepth func() int64, tenantLabel bool) *serverMetrics {
+	var labels []string
+	if tenantLabel {
+		labels = []string{tenantLabelName}
+	}
+
+	m := &serverMetrics{
+		tenantLabel: tenantLabel,
+		registry:    prometheus.NewRegistry(),
+		generateRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "generate_requests_total",
+			Help:      "Number of /generate requests by status code.",
+		}, append([]string{"code"}, labels...)),
+		generateDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "generate_duration_seconds",
+			Help:      "End-to-end duration of /generate requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		compileDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "compile_duration_seconds",
+			Help:      "Duration of typst compilations.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		fetchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bucket_fetch_errors_total",
+			Help:      "Number of failed fetches from the storage bucket.",
+		}),
+		pdfCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "pdf_cache_hits_total",
+			Help:      "Number of compiled documents served from the document cache.",
+		}),
+		pdfCacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "pdf_cache_misses_total",
+			Help:      "Number of document cache lookups that found no document.",
+		}),
+		compileQueueDepth: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "compile_queue_depth",
+			Help:      "Number of compilations waiting for a compile slot.",
+		}, func() float64 { return float64(queueDepth()) }),
+	}
+
+	m.registry.MustRegister(
+		m.generateRequests,
+		m.generateDuration,
+		m.compileDuration,
+		m.fetchErrors,
+		m.pdfCacheHits,
+		m.pdfCacheMisses,
+		m.compileQueueDepth,
+	)
+
+	return m
+}
+
+// handler returns the HTTP handler serving the metrics.
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrumentGenerate wraps the /generate handler to count requests and observe their duration.
+func (m *serverMetrics) instrumentGenerate(next http.HandlerFunc) http.Handler {
+	if m == nil {
+		return next
+	}
+	var opts []promhttp.Option
+	if m.tenantLabel {
+		opts = append(opts, promhttp.WithLabelFromCtx(tenantLabelName, tenantFromContext))
+	}
+	return promhttp.InstrumentHandlerCounter(m.generateRequests,
+		promhttp.InstrumentHandlerDuration(m.generateDuration, next, opts...), opts...)
+}
+
+// observeCompile records the duration of a typst compilation.
+func (m *serverMetrics) observeCompile(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.compileDuration.Observe(duration.Seconds())
+}
+
+// fetchFailed records a failed fetch from the storage bucket.
+func (m *serverMetrics) fetchFailed() {
+	if m == nil {
+		return
+	}
+	m.fetchErrors.Inc()
+}
+
+// documentCacheLookup records a hit or miss of the document cache.
+func (m *serverMetrics) documentCacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.pdfCacheHits.Inc()
+	} else {
+		m.pdfCacheMisses.Inc()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMetrics tests that /generate requests, compilations and fetch errors are recorded.
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, metrics: true})
+	srv.compiler = &stubCompiler{}
+	handler := srv.Handler()
+
+	for _, templateKey := range []string{"template.typ", "missing.typ"} {
+		reqBody := `{"templateKey": "` + templateKey + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+
+	wantLines := []string{
+		`givetypst_generate_requests_total{code="200"} 1`,
+		`givetypst_generate_requests_total{code="404"} 1`,
+		`givetypst_generate_duration_seconds_count 2`,
+		`givetypst_compile_duration_seconds_count 1`,
+		`givetypst_bucket_fetch_errors_total 1`,
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+// TestMetrics_Disabled tests that the /metrics endpoint is absent when metrics are disabled.
+func TestMetrics_Disabled(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test"})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// TestMetrics_Tenant tests that /generate metrics are labeled with allowlisted tenants.
+func TestMetrics_Tenant(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:       bucketURL,
+		metrics:         true,
+		tenantHeader:    "X-Tenant-ID",
+		tenantAllowlist: []string{"acme"},
+	})
+	srv.compiler = &stubCompiler{}
+	handler := srv.Handler()
+
+	for _, tenant := range []string{"acme", "acme", "globex", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+		if tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	wantLines := []string{
+		`givetypst_generate_requests_total{code="200",tenant="acme"} 2`,
+		`givetypst_generate_requests_total{code="200",tenant="unknown"} 2`,
+		`givetypst_generate_duration_seconds_count{tenant="acme"} 2`,
+		`givetypst_generate_duration_seconds_count{tenant="unknown"} 2`,
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, rec.Body.String())
+		}
+	}
+	if strings.Contains(rec.Body.String(), "globex") {
+		t.Error("expected tenants outside the allowlist not to be used as labels")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// contentTypeMultipart is the content type of /generate requests that upload the template and data.
+	contentTypeMultipart = "multipart/form-data"
+	// requestOverhead is the allowance for the other fields of a request body on top of its template
+	// and data, such as JSON fields or multipart part headers and boundaries.
+	requestOverhead = 64 * 1024
+	// maxFormatPartSize is the maximum size of the "format" part of a multipart request.
+	maxFormatPartSize = 16
+)
+
+// decodeGenerateRequest decodes the /generate request body into req.
+//
+// The body is decoded according to the request's Content-Type: multipart/form-data
+// uploads are read by decodeMultipartRequest, everything else is decoded as JSON.
+// Bodies larger than maxRequestSize are rejected with 413 Payload Too Large. GET requests
+// have no body and are decoded from their query parameters by decodeGenerateQuery.
+func (s *Server) decodeGenerateRequest(w http.ResponseWriter, r *http.Request, req *GenerateRequest) error {
+	if r.Method == http.MethodGet {
+		decodeGenerateQuery(r, req)
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == contentTypeMultipart {
+		return s.decodeMultipartRequest(w, r, req)
+	}
+	return decodeJSONBody(w, r, req, s.requestConfig(r.Context()).maxRequestSize())
+}
+
+// decodeGenerateQuery decodes a GET /generate request from its query parameters.
+//
+// Only the scalar fields of a request can be expressed in a URL, so the template and data
+// must be keys rather than inline.
+func decodeGenerateQuery(r *http.Request, req *GenerateRequest) {
+	query := r.URL.Query()
+	*req = GenerateRequest{
+		TemplateKey:  query.Get("templateKey"),
+		DataKey:      query.Get("dataKey"),
+		DataFormat:   query.Get("dataFormat"),
+		Format:       query.Get("format"),
+		Pages:        query.Get("pages"),
+		Filename:     query.Get("filename"),
+		TypstVersion: query.Get("typstVersion"),
+		TypstFlags:   query["typstFlags"],
+		CreationTime: query.Get("creationTime"),
+	}
+}
+
+// decodeJSONBody decodes a JSON request body of at most maxSize bytes into v.
+//
+// The body is capped by http.MaxBytesReader, so a huge body can't exhaust memory
+// before the size limits of its fields apply.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any, maxSize int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return newStatusError(http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+		}
+		return newStatusError(http.StatusBadRequest, errors.New("invalid request"))
+	}
+	return nil
+}
+
+// maxRequestSize returns the maximum size of a /generate request body: an inline template
+// and data at their maximum sizes, plus the other fields.
+func (c *ServerConfig) maxRequestSize() int64 {
+	return c.maxTemplateSize + c.maxDataSize + requestOverhead
+}
+
+// decodeMultipartRequest decodes a multipart/form-data /generate request into req.
+//
+// The "template" part holds the template source, the optional "data" part a JSON or YAML
+// data file, detected from its file name, and the optional "format" part the output format.
+// Parts are streamed and limited to MAX_TEMPLATE_SIZE and MAX_DATA_SIZE, and the whole body
+// is capped by http.MaxBytesReader, so a huge upload can't exhaust memory.
+func (s *Server) decodeMultipartRequest(w http.ResponseWriter, r *http.Request, req *GenerateRequest) error {
+	config := s.requestConfig(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, config.maxRequestSize())
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, fmt.Errorf("invalid multipart request: %w", err))
+	}
+
+	for {
+		part, partErr := reader.NextPart()
+		if errors.Is(partErr, io.EOF) {
+			return nil
+		}
+		if partErr != nil {
+			return multipartError(partErr)
+		}
+
+		switch part.FormName() {
+		case "template":
+			source, readErr := readPart(part, config.maxTemplateSize, "template")
+			if readErr != nil {
+				return readErr
+			}
+			req.Template = string(source)
+		case "data":
+			if dataErr := s.decodeDataPart(r.Context(), part.FileName(), part, req); dataErr != nil {
+				return dataErr
+			}
+		case "format":
+			format, readErr := readPart(part, maxFormatPartSize, "format")
+			if readErr != nil {
+				return readErr
+			}
+			req.Format = strings.TrimSpace(string(format))
+		default:
+			return newStatusError(http.StatusBadRequest, fmt.Errorf("unexpected multipart part %q", part.FormName()))
+		}
+	}
+}
+
+// decodeDataPart decodes the uploaded data file into req, by the format of its file name.
+func (s *Server) decodeDataPart(ctx context.Context, fileName string, part io.Reader, req *GenerateRequest) error {
+	rawData, err := readPart(part, s.requestConfig(ctx).maxDataSize, "data")
+	if err != nil {
+		return err
+	}
+
+	dataFormat, err := resolveDataFormat(fileName, "")
+	if err != nil || dataFormat == dataFormatCSV {
+		return newStatusError(http.StatusBadRequest, errors.New("data part must be a JSON or YAML file"))
+	}
+
+	data, err := parseData(rawData, dataFormat)
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	req.Data = data
+	return nil
+}
+
+// readPart reads a multipart part of at most maxSize bytes.
+func readPart(part io.Reader, maxSize int64, name string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+	if err != nil {
+		return nil, multipartError(err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, newStatusError(http.StatusRequestEntityTooLarge, fmt.Errorf("%s exceeds maximum size", name))
+	}
+	return data, nil
+}
+
+// multipartError maps an error reading a multipart body to a status error.
+func multipartError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return newStatusError(http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+	}
+	return newStatusError(http.StatusBadRequest, fmt.Errorf("invalid multipart request: %w", err))
+}
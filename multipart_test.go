@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// multipartPart is a part of a multipart/form-data test request.
+type multipartPart struct {
+	// name is the form name of the part.
+	name string
+	// fileName is the file name of the part. Empty for plain fields.
+	fileName string
+	// content is the content of the part.
+	content string
+}
+
+// newMultipartRequest builds a multipart/form-data /generate request from parts.
+func newMultipartRequest(t *testing.T, parts []multipartPart) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var (
+			partWriter io.Writer
+			err        error
+		)
+		if part.fileName != "" {
+			partWriter, err = writer.CreateFormFile(part.name, part.fileName)
+		} else {
+			partWriter, err = writer.CreateFormField(part.name)
+		}
+		if err != nil {
+			t.Fatalf("failed to create part %q: %v", part.name, err)
+		}
+		if _, err = partWriter.Write([]byte(part.content)); err != nil {
+			t.Fatalf("failed to write part %q: %v", part.name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// TestHandleGenerate_Multipart tests generating from uploaded template and data files.
+func TestHandleGenerate_Multipart(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		parts           []multipartPart
+		wantStatus      int
+		wantData        string
+		wantContentType string
+	}{
+		{
+			name:            "template only",
+			parts:           []multipartPart{{name: "template", fileName: "main.typ", content: "= Hello"}},
+			wantStatus:      http.StatusOK,
+			wantContentType: contentTypePDF,
+		},
+		{
+			name: "json data",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "data", fileName: "data.json", content: `{"name": "World"}`},
+			},
+			wantStatus:      http.StatusOK,
+			wantData:        "{\n  \"name\": \"World\"\n}",
+			wantContentType: contentTypePDF,
+		},
+		{
+			name: "yaml data",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "data", fileName: "data.yaml", content: "name: World\n"},
+			},
+			wantStatus:      http.StatusOK,
+			wantData:        "{\n  \"name\": \"World\"\n}",
+			wantContentType: contentTypePDF,
+		},
+		{
+			name: "format",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "format", content: "svg"},
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "image/svg+xml",
+		},
+		{
+			name:       "missing template",
+			parts:      []multipartPart{{name: "data", fileName: "data.json", content: `{}`}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "template too large",
+			parts:      []multipartPart{{name: "template", fileName: "main.typ", content: strings.Repeat("a", 65)}},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "data too large",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "data", fileName: "data.json", content: `{"a": "` + strings.Repeat("a", 64) + `"}`},
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "csv data",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "data", fileName: "data.csv", content: "a,b\n1,2\n"},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid data",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "data", fileName: "data.json", content: `{`},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unexpected part",
+			parts: []multipartPart{
+				{name: "template", fileName: "main.typ", content: "= Hello"},
+				{name: "templateKey", content: "other.typ"},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:       "mem://",
+				maxTemplateSize: 64,
+				maxDataSize:     64,
+			})
+			compiler := &recordingCompiler{}
+			srv.compiler = compiler
+
+			rec := httptest.NewRecorder()
+			srv.handleGenerate(rec, newMultipartRequest(t, tt.parts))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.wantContentType, got)
+			}
+			if got := compiler.files[sourceFileName]; got != "= Hello" {
+				t.Errorf("expected template %q, got %q", "= Hello", got)
+			}
+			if got := strings.TrimSpace(compiler.files[dataFileName]); got != tt.wantData {
+				t.Errorf("expected data %q, got %q", tt.wantData, got)
+			}
+		})
+	}
+}
+
+// TestMultipartError tests mapping multipart read errors to status codes.
+func TestMultipartError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "body too large", err: &http.MaxBytesError{Limit: 1}, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "malformed body", err: errors.New("multipart: NextPart: EOF"), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var statusErr *statusError
+			if !errors.As(multipartError(tt.err), &statusErr) {
+				t.Fatal("expected a status error")
+			}
+			if statusErr.status != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, statusErr.status)
+			}
+		})
+	}
+}
+
+// TestDecodeJSONBody_TooLarge tests that JSON request bodies over the size limit are rejected with 413.
+func TestDecodeJSONBody_TooLarge(t *testing.T) {
+	t.Parallel()
+
+	// Bodies may be up to 64 bytes of template and data plus the request overhead.
+	largeData := `{"a": "` + strings.Repeat("a", requestOverhead+128) + `"}`
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "generate within limit",
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ", "data": {"a": "b"}}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "generate",
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ", "data": ` + largeData + `}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "query",
+			path:       "/query",
+			body:       `{"templateKey": "template.typ", "selector": "heading", "data": ` + largeData + `}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "batch",
+			path:       "/generate/batch",
+			body:       `{"templateKey": "template.typ", "items": [{"outputKey": "a.pdf", "data": ` + largeData + `}]}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:       bucketURL,
+				maxTemplateSize: 32,
+				maxDataSize:     32,
+				maxBatchItems:   1,
+			})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), "too large") {
+				t.Errorf("expected body too large error, got %q", rec.Body.String())
+			}
+		})
+	}
+}
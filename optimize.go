@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// pdfOptimizer is an external tool that compresses and linearizes PDFs.
+type pdfOptimizer struct {
+	// name is the name of the tool's binary, looked up in PATH.
+	name string
+	// args returns the command line arguments that optimize the PDF at input into output.
+	args func(input, output string) []string
+	// lossy reports whether the tool degrades the PDF, so it's only used when allowed.
+	lossy bool
+}
+
+// pdfOptimizers returns the supported PDF optimizers, in order of preference.
+//
+// qpdf recompresses and linearizes losslessly. Ghostscript rewrites the PDF, downsampling its
+// images to a resolution suited for screens, so it's lossy.
+func pdfOptimizers() []pdfOptimizer {
+	return []pdfOptimizer{
+		{
+			name: "qpdf",
+			args: func(input, output string) []string {
+				return []string{
+					"--linearize", "--object-streams=generate", "--compress-streams=y", "--recompress-flate",
+					"--warning-exit-0", input, output,
+				}
+			},
+		},
+		{
+			name: "gs",
+			args: func(input, output string) []string {
+				return []string{
+					"-sDEVICE=pdfwrite", "-dPDFSETTINGS=/ebook", "-dFastWebView=true",
+					"-dNOPAUSE", "-dBATCH", "-dQUIET", "-dSAFER",
+					"-sOutputFile=" + output, input,
+				}
+			},
+			lossy: true,
+		},
+	}
+}
+
+// findPDFOptimizer returns the first available PDF optimizer and the path of its binary, or false if
+// none is installed. Lossy optimizers are skipped unless allowLossy is set.
+func (s *Server) findPDFOptimizer(allowLossy bool) (pdfOptimizer, string, bool) {
+	for _, optimizer := range pdfOptimizers() {
+		if optimizer.lossy && !allowLossy {
+			continue
+		}
+		if path, err := s.lookPath(optimizer.name); err == nil {
+			return optimizer, path, true
+		}
+	}
+	return pdfOptimizer{}, "", false
+}
+
+// optimizePDF compresses and linearizes a compiled PDF with the first available PDF optimizer.
+//
+// The optimizer holds a compile slot and is bound by the compile memory limit like typst, so it
+// can't run more processes or use more memory than compiles may. Optimizing is best effort:
+// without an optimizer or a free compile slot, or if it fails or makes the PDF larger, the PDF
+// is returned as compiled.
+func (s *Server) optimizePDF(ctx context.Context, pdf []byte) []byte {
+	logger := s.requestLogger(ctx)
+	config := s.requestConfig(ctx)
+	optimizer, path, ok := s.findPDFOptimizer(config.optimizeLossy)
+	if !ok {
+		logger.Debug("no PDF optimizer installed, skipping optimization")
+		return pdf
+	}
+
+	release, err := s.compileLimiter.acquire(ctx, config.compileQueueTimeout)
+	if err != nil {
+		logger.Warn("no compile slot for the PDF optimizer, skipping optimization", "error", err)
+		return pdf
+	}
+	defer release()
+
+	optimized, err := runPDFOptimizer(ctx, optimizer, path, config.workDir, config.compileMemoryLimit, pdf)
+	if err != nil {
+		logger.Warn("failed to optimize PDF", "optimizer", optimizer.name, "error", err)
+		return pdf
+	}
+	if len(optimized) >= len(pdf) {
+		logger.Debug("optimized PDF isn't smaller, keeping the original",
+			"optimizer", optimizer.name, "size", len(pdf), "optimizedSize", len(optimized))
+		return pdf
+	}
+	logger.Debug("optimized PDF", "optimizer", optimizer.name, "size", len(pdf), "optimizedSize", len(optimized))
+	return optimized
+}
+
+// runPDFOptimizer runs the optimizer binary at path over pdf in a work directory created in
+// workDir, with its memory limited to memoryLimit bytes if positive, and returns the optimized PDF.
+func runPDFOptimizer(
+	ctx context.Context,
+	optimizer pdfOptimizer,
+	path, workDir string,
+	memoryLimit int64,
+	pdf []byte,
+) ([]byte, error) {
+	dir, err := os.MkdirTemp(workDir, workDirPrefix+"optimize-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.pdf")
+	output := filepath.Join(dir, "output.pdf")
+	if err = os.WriteFile(input, pdf, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+
+	var combined bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, optimizer.args(input, output)...)
+	cmd.Dir = dir
+	cmd.Stdout = &combined
+	cmd.Stderr = &combined
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w", optimizer.name, err)
+	}
+	// As for typst, the limit is applied right after the process starts.
+	if memoryLimit > 0 {
+		if err = applyMemoryLimit(cmd.Process.Pid, memoryLimit); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return nil, fmt.Errorf("apply memory limit: %w", err)
+		}
+	}
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", optimizer.name, err, combined.String())
+	}
+
+	optimized, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read optimized PDF: %w", err)
+	}
+	return optimized, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFakeOptimizer writes a shell script that stands in for qpdf, running script with the
+// input and output paths as $in and $out, and returns its path.
+func writeFakeOptimizer(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "qpdf")
+	content := "#!/bin/sh\nfor arg; do in=$out; out=$arg; done\n" + script + "\n"
+	if err := os.WriteFile(path, []byte(content), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write fake optimizer: %v", err)
+	}
+	return path
+}
+
+func TestFindPDFOptimizer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		installed  []string
+		allowLossy bool
+		wantName   string
+	}{
+		{name: "none", installed: nil, wantName: ""},
+		{name: "qpdf", installed: []string{"qpdf"}, wantName: "qpdf"},
+		{name: "ghostscript", installed: []string{"gs"}, wantName: ""},
+		{name: "lossy ghostscript", installed: []string{"gs"}, allowLossy: true, wantName: "gs"},
+		{name: "prefers qpdf", installed: []string{"gs", "qpdf"}, allowLossy: true, wantName: "qpdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{})
+			srv.lookPath = func(file string) (string, error) {
+				for _, name := range tt.installed {
+					if name == file {
+						return "/usr/bin/" + file, nil
+					}
+				}
+				return "", exec.ErrNotFound
+			}
+
+			optimizer, path, ok := srv.findPDFOptimizer(tt.allowLossy)
+			if ok != (tt.wantName != "") {
+				t.Fatalf("findPDFOptimizer() ok = %v, want %v", ok, tt.wantName != "")
+			}
+			if optimizer.name != tt.wantName {
+				t.Errorf("optimizer = %q, want %q", optimizer.name, tt.wantName)
+			}
+			if ok && path != "/usr/bin/"+tt.wantName {
+				t.Errorf("path = %q, want %q", path, "/usr/bin/"+tt.wantName)
+			}
+		})
+	}
+}
+
+func TestOptimizePDF(t *testing.T) {
+	t.Parallel()
+
+	const original = "%PDF-original-document"
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "optimized", script: `printf '%%PDF-small' > "$out"`, want: "%PDF-small"},
+		{name: "not installed", want: original},
+		{name: "fails", script: "echo broken >&2; exit 2", want: original},
+		{name: "larger", script: `cat "$in" "$in" > "$out"`, want: original},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{workDir: t.TempDir()})
+			srv.lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+			if tt.script != "" {
+				path := writeFakeOptimizer(t, tt.script)
+				srv.lookPath = func(file string) (string, error) {
+					if file == "qpdf" {
+						return path, nil
+					}
+					return "", exec.ErrNotFound
+				}
+			}
+
+			if got := srv.optimizePDF(context.Background(), []byte(original)); string(got) != tt.want {
+				t.Errorf("optimizePDF() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOptimizePDF_CompilerBusy tests that the PDF is returned as compiled when no compile slot frees up.
+func TestOptimizePDF_CompilerBusy(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{
+		workDir:               t.TempDir(),
+		maxConcurrentCompiles: 1,
+		compileQueueTimeout:   10 * time.Millisecond,
+	})
+	path := writeFakeOptimizer(t, `printf '%%PDF-small' > "$out"`)
+	srv.lookPath = func(string) (string, error) { return path, nil }
+
+	release, err := srv.compileLimiter.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("failed to acquire the compile slot: %v", err)
+	}
+	defer release()
+
+	const original = "%PDF-original-document"
+	if got := srv.optimizePDF(context.Background(), []byte(original)); string(got) != original {
+		t.Errorf("optimizePDF() = %q, want %q", got, original)
+	}
+}
+
+func TestHandleGenerate_Optimize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "pdf",
+			body:       `{"templateKey": "template.typ", "optimize": true}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "%PDF-small",
+		},
+		{
+			name:       "not requested",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "%PDF-original-document",
+		},
+		{
+			name:       "svg",
+			body:       `{"templateKey": "template.typ", "format": "svg", "optimize": true}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "optimize is only supported for pdf output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, workDir: t.TempDir()})
+			srv.compiler = &countingCompiler{output: "%PDF-original-document"}
+			path := writeFakeOptimizer(t, `printf '%%PDF-small' > "$out"`)
+			srv.lookPath = func(file string) (string, error) {
+				if file == "qpdf" {
+					return path, nil
+				}
+				return "", exec.ErrNotFound
+			}
+
+			rec := postGenerate(t, srv, tt.body, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
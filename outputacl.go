@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// outputACLPrivate leaves outputs private, with the bucket's default permissions.
+	outputACLPrivate = "private"
+	// outputACLPublicRead makes outputs publicly readable, such as for serving them through a CDN.
+	outputACLPublicRead = "public-read"
+)
+
+// errOutputACLUnsupported is returned when the bucket's driver can't set the ACL of an output.
+var errOutputACLUnsupported = errors.New("public-read outputs are not supported by the bucket")
+
+// outputACL returns the ACL of a request's outputs: the requested ACL, or the configured one if none was requested.
+//
+// A request can always make its outputs private, but can only make them public when the
+// configured ACL is public-read, so callers can't publish outputs the operator keeps private.
+func (s *Server) outputACL(ctx context.Context, requested string) (string, error) {
+	configured := s.requestConfig(ctx).outputACL
+	switch requested {
+	case "":
+		return configured, nil
+	case outputACLPrivate:
+		return outputACLPrivate, nil
+	case outputACLPublicRead:
+		if configured != outputACLPublicRead {
+			return "", newStatusError(http.StatusBadRequest,
+				errors.New("public-read outputs are disabled, set OUTPUT_ACL=public-read to allow them"))
+		}
+		return outputACLPublicRead, nil
+	default:
+		return "", newStatusError(http.StatusBadRequest, fmt.Errorf("unsupported acl %q", requested))
+	}
+}
+
+// beforeWriteACL returns the blob.WriterOptions.BeforeWrite function applying acl to an output, if any.
+//
+// Private outputs don't set an ACL at all, so they also work with buckets that have ACLs disabled.
+// Public-read outputs set the canned ACL on S3 and fail on drivers without ACLs.
+func beforeWriteACL(acl string) func(asFunc func(any) bool) error {
+	if acl != outputACLPublicRead {
+		return nil
+	}
+	return func(asFunc func(any) bool) error {
+		var input *s3.PutObjectInput
+		if !asFunc(&input) {
+			return errOutputACLUnsupported
+		}
+		input.ACL = s3types.ObjectCannedACLPublicRead
+		return nil
+	}
+}
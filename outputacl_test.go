@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TestServer_OutputACL tests which output ACL a request gets for each configured ACL.
+func TestServer_OutputACL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		configured string
+		requested  string
+		want       string
+		wantErr    bool
+	}{
+		{name: "default", want: outputACLPrivate},
+		{name: "configured public", configured: outputACLPublicRead, want: outputACLPublicRead},
+		{name: "request private", configured: outputACLPublicRead, requested: outputACLPrivate, want: outputACLPrivate},
+		{
+			name:       "request public when allowed",
+			configured: outputACLPublicRead,
+			requested:  outputACLPublicRead,
+			want:       outputACLPublicRead,
+		},
+		{name: "request public when private", requested: outputACLPublicRead, wantErr: true},
+		{name: "unsupported", configured: outputACLPublicRead, requested: "authenticated-read", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", outputACL: tt.configured})
+
+			got, err := srv.outputACL(context.Background(), tt.requested)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("outputACL(%q) expected error, got %q", tt.requested, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("outputACL(%q) = %q, %v, want %q", tt.requested, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestBeforeWriteACL tests that only public-read outputs set an ACL on the S3 request.
+func TestBeforeWriteACL(t *testing.T) {
+	t.Parallel()
+
+	if beforeWriteACL(outputACLPrivate) != nil {
+		t.Error("expected private outputs not to set an ACL")
+	}
+
+	input := &s3.PutObjectInput{}
+	s3As := func(target any) bool {
+		p, ok := target.(**s3.PutObjectInput)
+		if ok {
+			*p = input
+		}
+		return ok
+	}
+	if err := beforeWriteACL(outputACLPublicRead)(s3As); err != nil {
+		t.Fatalf("BeforeWrite returned error: %v", err)
+	}
+	if input.ACL != s3types.ObjectCannedACLPublicRead {
+		t.Errorf("expected ACL %q, got %q", s3types.ObjectCannedACLPublicRead, input.ACL)
+	}
+
+	noAs := func(any) bool { return false }
+	if err := beforeWriteACL(outputACLPublicRead)(noAs); !errors.Is(err, errOutputACLUnsupported) {
+		t.Errorf("expected errOutputACLUnsupported without an S3 request, got %v", err)
+	}
+}
+
+// TestHandleGenerateBatch_ACL tests that the output ACL is applied when writing batch outputs.
+func TestHandleGenerateBatch_ACL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		configured  string
+		acl         string
+		wantStatus  int
+		wantSuccess bool
+	}{
+		{name: "private", wantStatus: http.StatusOK, wantSuccess: true},
+		// File buckets have no ACLs, so public outputs fail to write.
+		{name: "public", configured: outputACLPublicRead, wantStatus: http.StatusOK},
+		{
+			name:        "private override",
+			configured:  outputACLPublicRead,
+			acl:         outputACLPrivate,
+			wantStatus:  http.StatusOK,
+			wantSuccess: true,
+		},
+		{name: "public not allowed", acl: outputACLPublicRead, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"invoice.typ": []byte("= Invoice")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, outputACL: tt.configured})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := `{"templateKey": "invoice.typ", "acl": "` + tt.acl + `", "items": [{"outputKey": "out/1.pdf"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/generate/batch", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerateBatch(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp BatchResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(resp.Results) != 1 || resp.Results[0].Success != tt.wantSuccess {
+				t.Errorf("expected success %v, got %+v", tt.wantSuccess, resp.Results)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// packageSeedTimeout is the maximum time for seeding the package cache from the bucket at startup.
+const packageSeedTimeout = 5 * time.Minute
+
+// errPackageResolution is returned when typst fails because an imported package can't be resolved,
+// as opposed to an error in the template itself.
+var errPackageResolution = errors.New("package resolution failed")
+
+// packageErrorMarkers returns the typst diagnostics that indicate a package resolution failure.
+func packageErrorMarkers() []string {
+	return []string{
+		"package not found",
+		"failed to download package",
+		"failed to load package",
+		"package manifest",
+	}
+}
+
+// isPackageFailure reports whether the diagnostics of a failed typst command indicate a
+// package resolution failure.
+func isPackageFailure(diagnostics string) bool {
+	lower := strings.ToLower(diagnostics)
+	for _, marker := range packageErrorMarkers() {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPackageCachePath returns the package cache shared by all compiles when none is configured:
+// a directory in workDir, or in the user cache directory without one, so downloaded packages are
+// kept across restarts. It's empty if there's no user cache directory, leaving the cache to typst.
+//
+// The directory's name doesn't start with workDirPrefix, so sweeping the work directory keeps it.
+func defaultPackageCachePath(workDir string) string {
+	if workDir != "" {
+		return filepath.Join(workDir, "packages")
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "givetypst", "packages")
+}
+
+// packageEnv returns the environment of a typst process, or nil to inherit the server's.
+func (c *LocalTypstCompiler) packageEnv() []string {
+	if c.packageCachePath == "" && c.packagePath == "" {
+		return nil
+	}
+	env := os.Environ()
+	if c.packageCachePath != "" {
+		env = append(env, "TYPST_PACKAGE_CACHE_PATH="+c.packageCachePath)
+	}
+	if c.packagePath != "" {
+		env = append(env, "TYPST_PACKAGE_PATH="+c.packagePath)
+	}
+	return env
+}
+
+// seedPackages copies the packages under the PACKAGE_SEED_PREFIX bucket prefix into the
+// package cache, so templates can import them without network access.
+//
+// Seeding is best effort: failures are logged, and compiles importing a missing package
+// fail with a package resolution error.
+func (s *Server) seedPackages(ctx context.Context) {
+	config := s.config.Load()
+	if config.packageSeedPrefix == "" {
+		return
+	}
+	if config.packageCachePath == "" {
+		s.logger.Warn("no package cache directory, set TYPST_PACKAGE_CACHE_PATH to seed packages")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, packageSeedTimeout)
+	defer cancel()
+
+	bucket, err := s.openBucket(ctx)
+	if err != nil {
+		s.logger.Error("failed to seed package cache", "error", err)
+		return
+	}
+	seeded, err := seedPackageCache(ctx, bucket, config.packageSeedPrefix, config.packageCachePath)
+	if err != nil {
+		s.logger.Error("failed to seed package cache", "seeded", seeded, "error", err)
+		return
+	}
+	s.logger.Info("seeded package cache", "prefix", config.packageSeedPrefix, "files", seeded)
+}
+
+// seedPackageCache downloads the objects under prefix into cacheDir, keeping their paths
+// relative to the prefix, and returns the number of files written.
+//
+// Files already in the cache are kept, since published package versions don't change.
+func seedPackageCache(ctx context.Context, bucket *blob.Bucket, prefix, cacheDir string) (int, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	seeded := 0
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		object, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return seeded, nil
+		}
+		if err != nil {
+			return seeded, fmt.Errorf("list %s: %w", prefix, err)
+		}
+
+		rel := strings.TrimPrefix(object.Key, prefix)
+		if object.IsDir || rel == "" {
+			continue
+		}
+		if !fs.ValidPath(rel) {
+			return seeded, fmt.Errorf("invalid package file %q", object.Key)
+		}
+
+		filePath := filepath.Join(cacheDir, filepath.FromSlash(rel))
+		if _, statErr := os.Stat(filePath); statErr == nil {
+			continue
+		}
+		if downloadErr := downloadFile(ctx, bucket, object.Key, filePath); downloadErr != nil {
+			return seeded, downloadErr
+		}
+		seeded++
+	}
+}
+
+// downloadFile downloads a bucket object to filePath, creating its directory.
+//
+// The object is downloaded to a temporary file first, so typst never sees a partial file.
+func downloadFile(ctx context.Context, bucket *blob.Bucket, key, filePath string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), dirPermissions); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".seed-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file for %s: %w", key, err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	downloadErr := bucket.Download(ctx, key, file, nil)
+	if closeErr := file.Close(); downloadErr == nil {
+		downloadErr = closeErr
+	}
+	if downloadErr != nil {
+		return fmt.Errorf("download %s: %w", key, downloadErr)
+	}
+
+	if renameErr := os.Rename(file.Name(), filePath); renameErr != nil {
+		return fmt.Errorf("failed to write %s: %w", key, renameErr)
+	}
+	return nil
+}
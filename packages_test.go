@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+// TestTypstFailure tests that package resolution failures are told apart from template errors.
+func TestTypstFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		diagnostics string
+		wantPackage bool
+	}{
+		{name: "syntax error", diagnostics: "error: unclosed delimiter"},
+		{name: "unknown variable", diagnostics: "error: unknown variable: cetz"},
+		{
+			name:        "package not found",
+			diagnostics: "error: package not found (searched for @preview/cetz:0.2.2)",
+			wantPackage: true,
+		},
+		{
+			name:        "download failed",
+			diagnostics: "error: failed to download package (network failed: dns error)",
+			wantPackage: true,
+		},
+		{name: "invalid manifest", diagnostics: "error: failed to parse package manifest", wantPackage: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := typstFailure("compile", "", tt.diagnostics)
+			if got := errors.Is(err, errPackageResolution); got != tt.wantPackage {
+				t.Errorf("errors.Is(%v, errPackageResolution) = %v, want %v", err, got, tt.wantPackage)
+			}
+		})
+	}
+}
+
+// TestDefaultPackageCachePath tests that compiles share a persistent package cache by default.
+func TestDefaultPackageCachePath(t *testing.T) {
+	t.Parallel()
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Skipf("no user cache directory: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		workDir string
+		want    string
+	}{
+		{name: "work directory", workDir: "/var/lib/givetypst", want: "/var/lib/givetypst/packages"},
+		{name: "user cache directory", want: filepath.Join(cacheDir, "givetypst", "packages")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := defaultPackageCachePath(tt.workDir); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// A configured package cache is kept, and every compile worker shares it.
+	srv := NewServer(testLogger(), ServerConfig{packageCachePath: "/cache"})
+	defer srv.Close()
+	compiler, ok := srv.pool.newCompiler(t.TempDir()).(*LocalTypstCompiler)
+	if !ok || compiler.packageCachePath != "/cache" {
+		t.Errorf("expected the worker compiler to use the configured package cache, got %+v", compiler)
+	}
+}
+
+// TestSeedPackageCache tests that the package files under the prefix are copied into the cache.
+func TestSeedPackageCache(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"packages/preview/cetz/0.2.2/typst.toml":  []byte("[package]"),
+		"packages/preview/cetz/0.2.2/src/lib.typ": []byte("#let canvas = none"),
+		"packages/preview/tablex/0.0.8/lib.typ":   []byte("#let tablex = none"),
+		"templates/invoice.typ":                   []byte("= Invoice"),
+	})
+	bucket, err := blob.OpenBucket(context.Background(), bucketURL)
+	if err != nil {
+		t.Fatalf("failed to open bucket: %v", err)
+	}
+	defer bucket.Close()
+
+	cacheDir := t.TempDir()
+	// Files already in the cache are kept.
+	existing := filepath.Join(cacheDir, "preview", "tablex", "0.0.8", "lib.typ")
+	if mkdirErr := os.MkdirAll(filepath.Dir(existing), dirPermissions); mkdirErr != nil {
+		t.Fatalf("failed to create cache directory: %v", mkdirErr)
+	}
+	if writeErr := os.WriteFile(existing, []byte("cached"), filePermissions); writeErr != nil {
+		t.Fatalf("failed to write cached file: %v", writeErr)
+	}
+
+	seeded, err := seedPackageCache(context.Background(), bucket, "packages", cacheDir)
+	if err != nil {
+		t.Fatalf("seedPackageCache() returned error: %v", err)
+	}
+	if seeded != 2 {
+		t.Errorf("expected 2 seeded files, got %d", seeded)
+	}
+
+	want := map[string]string{
+		"preview/cetz/0.2.2/typst.toml":  "[package]",
+		"preview/cetz/0.2.2/src/lib.typ": "#let canvas = none",
+		"preview/tablex/0.0.8/lib.typ":   "cached",
+	}
+	for rel, content := range want {
+		got, readErr := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(rel)))
+		if readErr != nil || string(got) != content {
+			t.Errorf("expected %s to contain %q, got %q, %v", rel, content, got, readErr)
+		}
+	}
+	if _, statErr := os.Stat(filepath.Join(cacheDir, "invoice.typ")); !errors.Is(statErr, os.ErrNotExist) {
+		t.Errorf("expected files outside the prefix not to be seeded, got %v", statErr)
+	}
+}
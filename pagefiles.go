@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pageDirName is the directory in the work directory that formats with one file per page are compiled to.
+const pageDirName = ".pages"
+
+// pageFile is a page typst compiled to a file of its own.
+type pageFile struct {
+	// number is the page number in the document.
+	number int
+	// path is the path of the file.
+	path string
+}
+
+// zipPageFiles zips the page files typst compiled to the page directory into the output file of
+// args, as "page-001.svg", "page-002.svg" and so on.
+//
+// Pages are copied from disk into the zip file one at a time, so documents with many pages
+// aren't held in memory. Documents with more than maxPages pages are rejected with
+// errTooManyPages, unless maxPages is zero.
+func zipPageFiles(workDir string, args compileArgs, maxPages int) error {
+	pages, err := readPageFiles(filepath.Join(workDir, pageDirName), "."+args.outputFormat().pageFormat)
+	if err != nil {
+		return err
+	}
+	if maxPages > 0 && len(pages) > maxPages {
+		return fmt.Errorf("%w: document has %d pages, maximum %d", errTooManyPages, len(pages), maxPages)
+	}
+
+	file, err := os.OpenFile(filepath.Join(workDir, args.outputFileName()), os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+		filePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	archive := zip.NewWriter(file)
+	for _, page := range pages {
+		name := fmt.Sprintf("page-%03d%s", page.number, filepath.Ext(page.path))
+		if err = addPageFile(archive, name, page.path); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	return errors.Join(err, file.Close())
+}
+
+// readPageFiles returns the page files with the given extension in dir, named by their page
+// number, sorted by page number.
+func readPageFiles(dir, extension string) ([]pageFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page directory: %w", err)
+	}
+	pages := make([]pageFile, 0, len(entries))
+	for _, entry := range entries {
+		number, atoiErr := strconv.Atoi(strings.TrimSuffix(entry.Name(), extension))
+		if atoiErr != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+		pages = append(pages, pageFile{number: number, path: filepath.Join(dir, entry.Name())})
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("failed to read output file: typst wrote no pages")
+	}
+	slices.SortFunc(pages, func(a, b pageFile) int { return cmp.Compare(a.number, b.number) })
+	return pages, nil
+}
+
+// addPageFile copies the page file at path into a new entry of archive.
+func addPageFile(archive *zip.Writer, name, path string) error {
+	page, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read page file: %w", err)
+	}
+	defer page.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry %s: %w", name, err)
+	}
+	if _, err = io.Copy(entry, page); err != nil {
+		return fmt.Errorf("failed to write zip entry %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestHandleGenerate_SVGPages tests that the svg-pages format returns a zip of the SVG typst
+// compiled for each page.
+func TestHandleGenerate_SVGPages(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst checks it was asked for SVG, and writes pages 1, 2 and 10 next to the
+	// output path it was given, like typst does for its {p} page number template.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nprev=\nfor arg; do [ \"$prev\" = --format ] && format=$arg; prev=$arg; out=$arg; done\n" +
+		"[ \"$format\" = svg ] || exit 1\n" +
+		"for p in 1 2 10; do printf '<svg>%s</svg>' \"$p\" > \"${out%/*}/$p.svg\"; done\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write typst script: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		maxSplitPages int
+		wantStatus    int
+	}{
+		{name: "zipped", maxSplitPages: 3, wantStatus: http.StatusOK},
+		{name: "unlimited", wantStatus: http.StatusOK},
+		{name: "too many pages", maxSplitPages: 2, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxSplitPages: tt.maxSplitPages})
+			srv.compiler = &LocalTypstCompiler{binary: binary}
+
+			rec := postGenerate(t, srv, `{"templateKey": "template.typ", "format": "svg-pages"}`, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/zip" {
+				t.Errorf("expected Content-Type %q, got %q", "application/zip", contentType)
+			}
+			assertSVGPages(t, rec.Body.Bytes(), map[string]string{
+				"page-001.svg": "<svg>1</svg>",
+				"page-002.svg": "<svg>2</svg>",
+				"page-010.svg": "<svg>10</svg>",
+			})
+		})
+	}
+}
+
+// assertSVGPages asserts that body is a zip with the given entries, in page order.
+func assertSVGPages(t *testing.T, body []byte, want map[string]string) {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+	names := make([]string, 0, len(archive.File))
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		entry, openErr := file.Open()
+		if openErr != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, openErr)
+		}
+		got, readErr := io.ReadAll(entry)
+		_ = entry.Close()
+		if readErr != nil {
+			t.Fatalf("failed to read %s: %v", file.Name, readErr)
+		}
+		if string(got) != want[file.Name] {
+			t.Errorf("expected %s to be %q, got %q", file.Name, want[file.Name], got)
+		}
+	}
+	if !slices.IsSorted(names) || len(names) != len(want) {
+		t.Errorf("expected entries %v in page order, got %v", want, names)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxPageRanges is the maximum number of comma-separated ranges in a page selection.
+const maxPageRanges = 64
+
+// pageRange is a range of pages selected for export, in 1-based page numbers.
+//
+// A zero first or last page leaves that end of the range open, as in "3-" or "-3".
+type pageRange struct {
+	// first is the first selected page, or 0 for the first page of the document.
+	first int
+	// last is the last selected page, or 0 for the last page of the document.
+	last int
+}
+
+// String formats the range in typst's --pages syntax.
+func (r pageRange) String() string {
+	if r.first != 0 && r.first == r.last {
+		return strconv.Itoa(r.first)
+	}
+	var first, last string
+	if r.first != 0 {
+		first = strconv.Itoa(r.first)
+	}
+	if r.last != 0 {
+		last = strconv.Itoa(r.last)
+	}
+	return first + "-" + last
+}
+
+// parsePages parses a page selection such as "1", "1-3", "2,4" or "5-" in typst's --pages syntax.
+func parsePages(spec string) ([]pageRange, error) {
+	items := strings.Split(spec, ",")
+	if len(items) > maxPageRanges {
+		return nil, fmt.Errorf("too many page ranges (maximum %d)", maxPageRanges)
+	}
+
+	ranges := make([]pageRange, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		firstStr, lastStr, isRange := strings.Cut(item, "-")
+
+		first, err := parsePageNumber(firstStr, isRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page range %q: %w", item, err)
+		}
+		last := first
+		if isRange {
+			if last, err = parsePageNumber(lastStr, true); err != nil {
+				return nil, fmt.Errorf("invalid page range %q: %w", item, err)
+			}
+		}
+
+		switch {
+		case first == 0 && last == 0:
+			return nil, fmt.Errorf("invalid page range %q", item)
+		case first != 0 && last != 0 && first > last:
+			return nil, fmt.Errorf("invalid page range %q: first page is after last page", item)
+		}
+		ranges = append(ranges, pageRange{first: first, last: last})
+	}
+
+	return ranges, nil
+}
+
+// parsePageNumber parses a 1-based page number. An empty string is 0 if open is set.
+func parsePageNumber(s string, open bool) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" && open {
+		return 0, nil
+	}
+	page, err := strconv.Atoi(s)
+	if err != nil || page < 1 {
+		return 0, errors.New("page numbers must be positive integers")
+	}
+	return page, nil
+}
+
+// formatPages formats page ranges in typst's --pages syntax.
+func formatPages(ranges []pageRange) string {
+	items := make([]string, len(ranges))
+	for i, r := range ranges {
+		items[i] = r.String()
+	}
+	return strings.Join(items, ",")
+}
+
+// isSinglePage reports whether the page ranges select exactly one page.
+func isSinglePage(ranges []pageRange) bool {
+	return len(ranges) == 1 && ranges[0].first != 0 && ranges[0].first == ranges[0].last
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestParsePages tests parsing and normalizing page selections.
+func TestParsePages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		spec           string
+		want           string
+		wantSinglePage bool
+		wantErr        bool
+	}{
+		{spec: "1", want: "1", wantSinglePage: true},
+		{spec: "1-3", want: "1-3"},
+		{spec: "2, 4", want: "2,4"},
+		{spec: "3-3", want: "3", wantSinglePage: true},
+		{spec: "5-", want: "5-"},
+		{spec: "-2", want: "-2"},
+		{spec: "", wantErr: true},
+		{spec: "0", wantErr: true},
+		{spec: "-", wantErr: true},
+		{spec: "3-1", wantErr: true},
+		{spec: "1-2-3", wantErr: true},
+		{spec: "a", wantErr: true},
+		{spec: "1,,2", wantErr: true},
+		{spec: "-1-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			t.Parallel()
+
+			ranges, err := parsePages(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsePages(%q) should have returned an error, got %v", tt.spec, ranges)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePages(%q) returned error: %v", tt.spec, err)
+			}
+			if got := formatPages(ranges); got != tt.want {
+				t.Errorf("expected pages %q, got %q", tt.want, got)
+			}
+			if got := isSinglePage(ranges); got != tt.wantSinglePage {
+				t.Errorf("expected single page %v, got %v", tt.wantSinglePage, got)
+			}
+		})
+	}
+}
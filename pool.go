@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errPoolClosed is returned when a compile is submitted to a closed compiler pool.
+var errPoolClosed = errors.New("compiler pool closed")
+
+// compilerPool is a TypstCompiler that runs compiles on a fixed number of long-lived workers.
+//
+// Each worker serializes its compiles and owns a dedicated temp root, which its typst processes
+// use as their temporary directory, so files left behind by killed compiles are removed with
+// the root when the worker stops. Workers are started on the first compile, so a pool that
+// is never used costs nothing.
+type compilerPool struct {
+	// size is the number of workers.
+	size int
+	// newCompiler creates the compiler of a worker, given the worker's temp root.
+	newCompiler func(root string) TypstCompiler
+	// tempDir is the directory temp roots are created in. Empty uses the OS temp directory.
+	tempDir string
+	// jobs delivers compiles to the workers.
+	jobs chan poolJob
+	// done is closed when the pool is closed.
+	done chan struct{}
+	// startOnce starts the workers on the first compile.
+	startOnce sync.Once
+	// closeOnce closes done once.
+	closeOnce sync.Once
+	// workers tracks the running workers.
+	workers sync.WaitGroup
+}
+
+// poolJob is a compile submitted to a compilerPool.
+type poolJob struct {
+	// run compiles with the worker's compiler.
+	run func(compiler TypstCompiler) ([]byte, error)
+	// result receives the output and error of the compile.
+	result chan poolResult
+}
+
+// poolResult is the result of a poolJob.
+type poolResult struct {
+	// output is the compiled output, if the compiler returned it directly.
+	output []byte
+	// err is the compile error.
+	err error
+}
+
+// newCompilerPool creates a compiler pool with size workers, whose compilers are created by newCompiler.
+func newCompilerPool(size int, newCompiler func(root string) TypstCompiler) *compilerPool {
+	return &compilerPool{
+		size:        size,
+		newCompiler: newCompiler,
+		jobs:        make(chan poolJob),
+		done:        make(chan struct{}),
+	}
+}
+
+// Compile compiles the source file in workDir on the next free worker.
+func (p *compilerPool) Compile(ctx context.Context, workDir string, args compileArgs) error {
+	_, err := p.submit(ctx, func(compiler TypstCompiler) ([]byte, error) {
+		return nil, compiler.Compile(ctx, workDir, args)
+	})
+	return err
+}
+
+// CompileOutput compiles on the next free worker and returns the output directly.
+//
+// It returns errOutputUnsupported if the worker's compiler can't return the output directly.
+func (p *compilerPool) CompileOutput(ctx context.Context, workDir string, args compileArgs) ([]byte, error) {
+	return p.submit(ctx, func(compiler TypstCompiler) ([]byte, error) {
+		outputCompiler, ok := compiler.(OutputCompiler)
+		if !ok {
+			return nil, errOutputUnsupported
+		}
+		return outputCompiler.CompileOutput(ctx, workDir, args)
+	})
+}
+
+// submit hands a compile to the next free worker and waits for its result.
+func (p *compilerPool) submit(ctx context.Context, run func(compiler TypstCompiler) ([]byte, error)) ([]byte, error) {
+	p.startOnce.Do(p.start)
+
+	job := poolJob{run: run, result: make(chan poolResult, 1)}
+	select {
+	case p.jobs <- job:
+	case <-p.done:
+		return nil, errPoolClosed
+	case <-ctx.Done():
+		return nil, fmt.Errorf("compile: %w", ctx.Err())
+	}
+
+	// The worker always sends a result, and the compile itself stops when ctx is done.
+	result := <-job.result
+	return result.output, result.err
+}
+
+// start starts the workers.
+func (p *compilerPool) start() {
+	for range p.size {
+		p.workers.Add(1)
+		go p.work()
+	}
+}
+
+// work runs compiles until the pool is closed.
+//
+// The worker's temp root is created lazily and removed when the worker stops. If it
+// can't be created, compiles fail until a later attempt succeeds.
+func (p *compilerPool) work() {
+	defer p.workers.Done()
+
+	var (
+		root     string
+		compiler TypstCompiler
+	)
+	defer func() {
+		if root != "" {
+			_ = os.RemoveAll(root)
+		}
+	}()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case job := <-p.jobs:
+			if compiler == nil {
+				dir, err := os.MkdirTemp(p.tempDir, workerRootPrefix+"*")
+				if err != nil {
+					job.result <- poolResult{err: fmt.Errorf("failed to create worker root: %w", err)}
+					continue
+				}
+				root, compiler = dir, p.newCompiler(dir)
+			}
+			output, err := job.run(compiler)
+			job.result <- poolResult{output: output, err: err}
+		}
+	}
+}
+
+// Close stops the workers after their current compile and removes their temp roots.
+//
+// Compiles submitted after Close fail with errPoolClosed.
+func (p *compilerPool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.workers.Wait()
+}
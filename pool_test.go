@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rootCompiler is a TypstCompiler that records the worker root it was created with.
+type rootCompiler struct {
+	// root is the worker root the compiler was created with.
+	root string
+	// compiled counts calls to Compile.
+	compiled int
+}
+
+// Compile writes the worker root to the output file.
+func (c *rootCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	c.compiled++
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte(c.root), filePermissions)
+}
+
+// TestCompilerPool_Compile tests that a worker keeps its root and compiler across compiles.
+func TestCompilerPool_Compile(t *testing.T) {
+	t.Parallel()
+
+	var compilers []*rootCompiler
+	pool := newCompilerPool(1, func(root string) TypstCompiler {
+		compiler := &rootCompiler{root: root}
+		compilers = append(compilers, compiler)
+		return compiler
+	})
+
+	var roots []string
+	for range 3 {
+		output, err := compileTypstWith(context.Background(), pool, "= Hello", nil, compileOptions{})
+		if err != nil {
+			t.Fatalf("compileTypstWith() returned error: %v", err)
+		}
+		roots = append(roots, string(output))
+	}
+	pool.Close()
+
+	if len(compilers) != 1 || compilers[0].compiled != 3 {
+		t.Fatalf("expected one worker compiler with 3 compiles, got %d compilers", len(compilers))
+	}
+	for _, root := range roots {
+		if root != compilers[0].root {
+			t.Errorf("expected every compile to use root %q, got %q", compilers[0].root, root)
+		}
+	}
+	if _, err := os.Stat(compilers[0].root); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected worker root to be removed on Close, got %v", err)
+	}
+}
+
+// TestCompilerPool_TempDir tests that the typst processes of a worker use its root as their temporary directory.
+func TestCompilerPool_TempDir(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst writes its temporary directory to its output path, the last argument.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nfor arg; do out=$arg; done\nprintf '%s' \"$TMPDIR\" > \"$out\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	var root string
+	pool := newCompilerPool(1, func(dir string) TypstCompiler {
+		root = dir
+		return &LocalTypstCompiler{binary: binary, tempDir: dir}
+	})
+	pool.tempDir = t.TempDir()
+	defer pool.Close()
+
+	output, err := compileTypstWith(context.Background(), pool, "= Hello", nil, compileOptions{})
+	if err != nil {
+		t.Fatalf("compileTypstWith() returned error: %v", err)
+	}
+	if string(output) != root || !strings.HasPrefix(filepath.Base(root), workerRootPrefix) {
+		t.Errorf("expected TMPDIR to be the worker root %q, got %q", root, output)
+	}
+}
+
+// TestCompilerPool_CompileOutput tests that direct output is unsupported unless the worker's compiler supports it.
+func TestCompilerPool_CompileOutput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		compiler  TypstCompiler
+		want      string
+		wantError error
+	}{
+		{name: "output compiler", compiler: &stubOutputCompiler{supported: true}, want: "%PDF-stdout"},
+		{name: "file compiler", compiler: &stubCompiler{}, wantError: errOutputUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pool := newCompilerPool(1, func(string) TypstCompiler { return tt.compiler })
+			defer pool.Close()
+
+			output, err := pool.CompileOutput(context.Background(), t.TempDir(), compileArgs{})
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("expected error %v, got %v", tt.wantError, err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("expected output %q, got %q", tt.want, output)
+			}
+		})
+	}
+}
+
+// TestCompilerPool_Query tests that queries are unsupported unless the worker's compiler supports them.
+func TestCompilerPool_Query(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		compiler  TypstCompiler
+		want      string
+		wantError error
+	}{
+		{name: "querier", compiler: &stubQuerier{result: "[]"}, want: "[]"},
+		{name: "compiler only", compiler: &stubCompiler{}, wantError: errQueryUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pool := newCompilerPool(1, func(string) TypstCompiler { return tt.compiler })
+			defer pool.Close()
+
+			output, err := pool.Query(context.Background(), t.TempDir(), queryArgs{selector: "heading"})
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("expected error %v, got %v", tt.wantError, err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("expected output %q, got %q", tt.want, output)
+			}
+		})
+	}
+}
+
+// TestCompilerPool_Busy tests that a compile waiting for a worker stops when its context is done.
+func TestCompilerPool_Busy(t *testing.T) {
+	t.Parallel()
+
+	compiler := &gatedCompiler{started: make(chan struct{}), release: make(chan struct{})}
+	pool := newCompilerPool(1, func(string) TypstCompiler { return compiler })
+	defer pool.Close()
+
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, sourceFileName), []byte("= Hello"), filePermissions); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_ = pool.Compile(context.Background(), workDir, compileArgs{})
+	})
+	<-compiler.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Compile(ctx, t.TempDir(), compileArgs{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(compiler.release)
+	wg.Wait()
+}
+
+// TestCompilerPool_Closed tests that compiles fail once the pool is closed.
+func TestCompilerPool_Closed(t *testing.T) {
+	t.Parallel()
+
+	pool := newCompilerPool(1, func(string) TypstCompiler { return &stubCompiler{} })
+	pool.Close()
+
+	if err := pool.Compile(context.Background(), t.TempDir(), compileArgs{}); !errors.Is(err, errPoolClosed) {
+		t.Errorf("expected errPoolClosed, got %v", err)
+	}
+}
+
+// BenchmarkCompile compares a fresh typst process per compile with the warm compiler pool.
+//
+// Requires the typst binary. Run with:
+//
+//	go test -run '^$' -bench BenchmarkCompile -benchtime 50x
+func BenchmarkCompile(b *testing.B) {
+	if _, err := exec.LookPath("typst"); err != nil {
+		b.Skip("typst not found")
+	}
+
+	source := "= Benchmark\n#lorem(200)"
+
+	compilers := []struct {
+		name     string
+		compiler TypstCompiler
+	}{
+		{name: "local", compiler: &LocalTypstCompiler{}},
+		{name: "pool", compiler: newCompilerPool(1, func(root string) TypstCompiler {
+			return &LocalTypstCompiler{tempDir: root}
+		})},
+	}
+
+	for _, bc := range compilers {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := compileTypstWith(context.Background(), bc.compiler, source, nil, compileOptions{}); err != nil {
+					b.Fatalf("compileTypstWith() returned error: %v", err)
+				}
+			}
+		})
+		if pool, ok := bc.compiler.(*compilerPool); ok {
+			pool.Close()
+		}
+	}
+}
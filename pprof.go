@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof registers the net/http/pprof profiling endpoints under /debug/pprof/.
+//
+// Profiles expose sensitive runtime data, such as command lines and memory contents, so the
+// endpoints require the same bearer token as /generate when AUTH_TOKEN is set.
+func (s *Server) registerPprof(mux *http.ServeMux) {
+	// Index also serves the named profiles, such as /debug/pprof/heap and /debug/pprof/goroutine.
+	mux.HandleFunc("GET /debug/pprof/", s.requireAuth(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", s.requireAuth(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", s.requireAuth(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", s.requireAuth(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", s.requireAuth(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", s.requireAuth(pprof.Trace))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRegisterPprof tests that the profiling endpoints are only served when enabled, and require the auth token.
+func TestRegisterPprof(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		config        ServerConfig
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{
+			name:       "disabled",
+			config:     ServerConfig{bucketURL: "file:///tmp/test"},
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "index",
+			config:     ServerConfig{bucketURL: "file:///tmp/test", pprof: true},
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "named profile",
+			config:     ServerConfig{bucketURL: "file:///tmp/test", pprof: true},
+			path:       "/debug/pprof/goroutine?debug=1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "cmdline",
+			config:     ServerConfig{bucketURL: "file:///tmp/test", pprof: true},
+			path:       "/debug/pprof/cmdline",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			config:     ServerConfig{bucketURL: "file:///tmp/test", pprof: true, authToken: "secret"},
+			path:       "/debug/pprof/goroutine?debug=1",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "token",
+			config:        ServerConfig{bucketURL: "file:///tmp/test", pprof: true, authToken: "secret"},
+			path:          "/debug/pprof/goroutine?debug=1",
+			authorization: "Bearer secret",
+			wantStatus:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), tt.config)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
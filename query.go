@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// errQueryUnsupported is returned when the server's compiler can't run queries.
+var errQueryUnsupported = errors.New("query not supported by the compiler")
+
+// QueryRequest is the request body for the /query endpoint.
+//
+// The template and data fields are the same as for /generate; output fields such as
+// format, pages and filename are ignored.
+type QueryRequest struct {
+	GenerateRequest
+
+	// Selector selects the elements to query, such as "heading" or "<label>".
+	Selector string `json:"selector"`
+	// Field extracts a single field of each selected element, such as "body". Defaults to the whole element.
+	Field string `json:"field,omitempty"`
+}
+
+// queryArgs holds the per-query arguments passed to a TypstQuerier.
+type queryArgs struct {
+	// binary is the typst binary to run, such as a version pinned with typstVersion. Empty uses
+	// the querier's default binary.
+	binary string
+	// selector selects the elements to query.
+	selector string
+	// field, if set, extracts a single field of each selected element.
+	field string
+	// inputs are passed to typst as "--input key=value" and exposed to the template as sys.inputs.
+	inputs map[string]string
+	// fontDir is the directory of per-request fonts relative to the work directory. Empty means none.
+	fontDir string
+	// creationTimestamp is the UNIX timestamp typst uses as the current date, so datetime.today()
+	// agrees with /generate. Zero is the UNIX epoch.
+	creationTimestamp int64
+}
+
+// args returns the typst query command line arguments for the query args, without the selector.
+//
+// The field is joined to its flag, so a field starting with "-" isn't parsed as a flag.
+func (a queryArgs) args() []string {
+	args := []string{"--format", "json"}
+	if a.field != "" {
+		args = append(args, "--field="+a.field)
+	}
+	for _, key := range slices.Sorted(maps.Keys(a.inputs)) {
+		args = append(args, "--input", key+"="+a.inputs[key])
+	}
+	return args
+}
+
+// TypstQuerier queries Typst documents for their elements, like "typst query".
+type TypstQuerier interface {
+	// Query queries the source file at workDir/main.typ and returns the result as JSON.
+	Query(ctx context.Context, workDir string, args queryArgs) ([]byte, error)
+}
+
+// Query runs typst query on the source file and returns the JSON result captured from stdout.
+func (c *LocalTypstCompiler) Query(ctx context.Context, workDir string, args queryArgs) ([]byte, error) {
+	cmdArgs := append(args.args(), c.fontPathArgs(workDir, args.fontDir)...)
+	// "--" ends the flags, so a selector starting with "-" isn't parsed as a flag.
+	cmdArgs = append(cmdArgs, "--", filepath.Join(workDir, sourceFileName), args.selector)
+	env := []string{creationTimestampEnv(args.creationTimestamp)}
+	return c.runTypst(ctx, args.binary, workDir, "query", cmdArgs, env, true, nil)
+}
+
+// Query queries the source file in workDir on the next free worker.
+//
+// It returns errQueryUnsupported if the worker's compiler can't run queries.
+func (p *compilerPool) Query(ctx context.Context, workDir string, args queryArgs) ([]byte, error) {
+	return p.submit(ctx, func(compiler TypstCompiler) ([]byte, error) {
+		querier, ok := compiler.(TypstQuerier)
+		if !ok {
+			return nil, errQueryUnsupported
+		}
+		return querier.Query(ctx, workDir, args)
+	})
+}
+
+// queryTypstWith queries a Typst source file using the specified querier.
+//
+// The work directory is staged like for compileTypstWith, and a compile slot is held while
+// the query runs.
+func queryTypstWith(
+	ctx context.Context,
+	querier TypstQuerier,
+	source string,
+	data any,
+	opts compileOptions,
+	args queryArgs,
+) ([]byte, error) {
+	workDir, err := stageWorkDir(source, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// Wait for a compile slot.
+	if opts.limiter != nil {
+		release, acquireErr := opts.limiter.acquire(ctx, opts.queueTimeout)
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+		defer release()
+	}
+
+	return querier.Query(ctx, workDir, args)
+}
+
+// handleQuery queries a template for elements matching a selector and returns the JSON result.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	logger := s.requestLogger(r.Context())
+
+	// Check if the request is valid.
+	if err := decodeJSONBody(w, r, &req, s.requestConfig(r.Context()).maxRequestSize()); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Selector == "" {
+		http.Error(w, "selector is required", http.StatusBadRequest)
+		return
+	}
+	// Allowed flags are checked against typst compile, and many of them don't exist for typst query.
+	if len(req.TypstFlags) > 0 {
+		http.Error(w, "typstFlags are not supported by /query", http.StatusBadRequest)
+		return
+	}
+	if err := s.validateGenerateRequest(r.Context(), &req.GenerateRequest); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Check that the template isn't at its concurrency limit.
+	release, ok := s.limiter.Load().acquire(r.Context(), req.TemplateKey)
+	if !ok {
+		http.Error(w, errTemplateBusy.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	logger.Debug("querying document",
+		"templateKey", req.TemplateKey,
+		"inlineTemplate", req.Template != "",
+		"dataKey", req.DataKey,
+		"inlineData", req.Data != nil,
+		"selector", req.Selector,
+		"field", req.Field,
+	)
+
+	result, err := s.query(r.Context(), logger, w.Header(), &req)
+	if err != nil {
+		logger.Warn("failed to query document", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if _, writeErr := w.Write(result); writeErr != nil {
+		logger.Error("failed to write query response", "error", writeErr)
+	}
+}
+
+// query resolves the data and template of a validated query request and queries the document,
+// bounded by the compile timeout.
+func (s *Server) query(
+	ctx context.Context,
+	logger *slog.Logger,
+	header http.Header,
+	req *QueryRequest,
+) ([]byte, error) {
+	querier, ok := s.compiler.(TypstQuerier)
+	if !ok {
+		return nil, newStatusError(http.StatusNotImplemented, errQueryUnsupported)
+	}
+
+	tmpl, data, err := s.resolveRequest(ctx, logger, header, &req.GenerateRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	config := s.requestConfig(ctx)
+	binary, err := config.typstBinaryFor(req.TypstVersion)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+	timestamp, err := creationTimestamp(req.CreationTime)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.compileTimeout)
+	defer cancel()
+
+	opts, values, err := s.stagingOptions(config, tmpl, data, compileArgs{inputs: requestInputs(&req.GenerateRequest)})
+	if err != nil {
+		return nil, err
+	}
+
+	args := queryArgs{
+		binary:            binary,
+		selector:          req.Selector,
+		field:             req.Field,
+		inputs:            opts.args.inputs,
+		fontDir:           tmpl.fontDir,
+		creationTimestamp: timestamp,
+	}
+	result, err := queryTypstWith(ctx, querier, tmpl.source, values, opts, args)
+	if err != nil {
+		return nil, compileError(err)
+	}
+	return result, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubQuerier is a TypstCompiler and TypstQuerier that records its last query.
+type stubQuerier struct {
+	stubCompiler
+
+	// result is returned by Query.
+	result string
+	// queryErr is returned by Query instead of the result, if set.
+	queryErr error
+	// args are the query args of the last query.
+	args queryArgs
+	// data is the data file staged for the last query.
+	data string
+}
+
+// Query records the query args and staged data file and returns the configured result.
+func (q *stubQuerier) Query(_ context.Context, workDir string, args queryArgs) ([]byte, error) {
+	q.args = args
+	data, _ := os.ReadFile(filepath.Join(workDir, dataFileName))
+	q.data = string(data)
+	if q.queryErr != nil {
+		return nil, q.queryErr
+	}
+	return []byte(q.result), nil
+}
+
+// TestQueryArgs_Args tests the typst query command line arguments.
+func TestQueryArgs_Args(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args queryArgs
+		want []string
+	}{
+		{name: "selector only", args: queryArgs{selector: "heading"}, want: []string{"--format", "json"}},
+		{
+			name: "field and inputs",
+			args: queryArgs{selector: "heading", field: "body", inputs: map[string]string{"b": "2", "a": "1"}},
+			want: []string{"--format", "json", "--field=body", "--input", "a=1", "--input", "b=2"},
+		},
+		{
+			name: "field starting with a dash",
+			args: queryArgs{selector: "heading", field: "--help"},
+			want: []string{"--format", "json", "--field=--help"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.args.args(); !slices.Equal(got, tt.want) {
+				t.Errorf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLocalTypstCompiler_Query_DashSelector tests that a selector starting with "-" is passed as the selector
+// rather than as a flag.
+func TestLocalTypstCompiler_Query_DashSelector(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst prints its arguments, one per line.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	workDir := t.TempDir()
+	compiler := &LocalTypstCompiler{binary: binary}
+	output, err := compiler.Query(context.Background(), workDir, queryArgs{selector: "--help"})
+	if err != nil {
+		t.Fatalf("Query() returned error: %v", err)
+	}
+
+	args := strings.Split(strings.TrimSpace(string(output)), "\n")
+	want := []string{"--", filepath.Join(workDir, sourceFileName), "--help"}
+	if len(args) < len(want) || !slices.Equal(args[len(args)-len(want):], want) {
+		t.Errorf("expected arguments to end with %v, got %v", want, args)
+	}
+}
+
+// TestHandleQuery tests that a query runs on the staged template and data and returns the JSON result.
+func TestHandleQuery(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"report.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	querier := &stubQuerier{result: `["Hello"]`}
+	srv.compiler = querier
+
+	reqBody := `{"templateKey": "report.typ", "data": {"title": "Report"}, "selector": "heading", "field": "body"}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleQuery(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != `["Hello"]` {
+		t.Errorf("expected query result, got %q", rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != contentTypeJSON {
+		t.Errorf("expected Content-Type %q, got %q", contentTypeJSON, contentType)
+	}
+	if querier.args.selector != "heading" || querier.args.field != "body" {
+		t.Errorf("expected selector heading and field body, got %+v", querier.args)
+	}
+	if !strings.Contains(querier.data, `"title": "Report"`) {
+		t.Errorf("expected data file to be staged, got %q", querier.data)
+	}
+}
+
+// TestHandleQuery_Errors tests the error responses of the query endpoint.
+func TestHandleQuery_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		compiler   TypstCompiler
+		wantStatus int
+		wantErr    string
+	}{
+		{
+			name:       "invalid JSON",
+			body:       `{`,
+			compiler:   &stubQuerier{},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "invalid request",
+		},
+		{
+			name:       "no selector",
+			body:       `{"templateKey": "report.typ"}`,
+			compiler:   &stubQuerier{},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "selector is required",
+		},
+		{
+			name:       "typst flags",
+			body:       `{"templateKey": "report.typ", "selector": "heading", "typstFlags": ["--jobs=2"]}`,
+			compiler:   &stubQuerier{},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "typstFlags are not supported by /query",
+		},
+		{
+			name:       "no template",
+			body:       `{"selector": "heading"}`,
+			compiler:   &stubQuerier{},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "templateKey or template is required",
+		},
+		{
+			name:       "query failed",
+			body:       `{"templateKey": "report.typ", "selector": "heading"}`,
+			compiler:   &stubQuerier{queryErr: errors.New("query failed: error: unknown variable")},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "error: unknown variable",
+		},
+		{
+			name:       "unsupported compiler",
+			body:       `{"templateKey": "report.typ", "selector": "heading"}`,
+			compiler:   &stubCompiler{},
+			wantStatus: http.StatusNotImplemented,
+			wantErr:    "query not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"report.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = tt.compiler
+
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.handleQuery(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleQuery_TypstVersion tests that a query pinned with typstVersion runs that version's binary.
+func TestHandleQuery_TypstVersion(t *testing.T) {
+	t.Parallel()
+
+	binary := filepath.Join(t.TempDir(), "typst-0.12")
+	script := "#!/bin/sh\nprintf '[\"0.12\"]'\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"report.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:     bucketURL,
+		typstVersions: map[string]string{"0.12": binary},
+	})
+	srv.compiler = &LocalTypstCompiler{binary: filepath.Join(t.TempDir(), "typst-default")}
+
+	reqBody := `{"templateKey": "report.typ", "selector": "heading", "typstVersion": "0.12"}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleQuery(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != `["0.12"]` {
+		t.Errorf("expected the pinned binary's result, got %q", rec.Body.String())
+	}
+}
+
+// TestHandleQuery_CreationTime tests that queries see the same creation timestamp as a compile would.
+func TestHandleQuery_CreationTime(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst returns the creation timestamp it was given.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nprintf '[%s]' \"$SOURCE_DATE_EPOCH\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		creationTime string
+		wantStatus   int
+		wantBody     string
+	}{
+		{name: "epoch by default", wantStatus: http.StatusOK, wantBody: "[0]"},
+		{name: "creation time", creationTime: "2024-01-02T00:00:00Z", wantStatus: http.StatusOK, wantBody: "[1704153600]"},
+		{name: "invalid", creationTime: "yesterday", wantStatus: http.StatusBadRequest, wantBody: "invalid creationTime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"report.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &LocalTypstCompiler{binary: binary}
+
+			reqBody := `{"templateKey": "report.typ", "selector": "heading", "creationTime": "` + tt.creationTime + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleQuery(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("expected body containing %q, got %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
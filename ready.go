@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// readyCheckTTL is how long the result of a readiness check is reused before checking again.
+	readyCheckTTL = 10 * time.Second
+	// readyCheckSource is the document compiled by the readiness check.
+	readyCheckSource = "Ready"
+	// readyStatusOK is the status of a ready server.
+	readyStatusOK = "ok"
+	// readyStatusUnavailable is the status of a server that isn't ready.
+	readyStatusUnavailable = "unavailable"
+	// pdfMagic is the prefix of every PDF document.
+	pdfMagic = "%PDF-"
+)
+
+// ReadyResponse is the response body for the /ready endpoint.
+type ReadyResponse struct {
+	// Status is "ok" if the server can compile documents, or "unavailable" otherwise.
+	Status string `json:"status"`
+	// TypstVersion is the version reported by the typst binary.
+	TypstVersion string `json:"typstVersion,omitempty"`
+	// Error is the reason the server isn't ready.
+	Error string `json:"error,omitempty"`
+}
+
+// readyResult is the cached result of a readiness check.
+type readyResult struct {
+	// version is the detected typst version.
+	version string
+	// err is the reason the check failed, if it did.
+	err error
+}
+
+// readyCache reuses the result of a readiness check for readyCheckTTL.
+//
+// The mutex is held while checking, so concurrent probes share a single compile.
+type readyCache struct {
+	mu        sync.Mutex
+	result    readyResult
+	checkedAt time.Time
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+}
+
+// get returns the cached result, running check if there is none or it has expired.
+func (c *readyCache) get(check func() readyResult) readyResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.checkedAt.IsZero() || now.Sub(c.checkedAt) >= readyCheckTTL {
+		c.result = check()
+		c.checkedAt = now
+	}
+	return c.result
+}
+
+// handleReady checks that the server can compile documents.
+//
+// Unlike /health, it compiles a trivial document through the real compiler path and
+// checks that the output is a PDF. Results are cached briefly so frequent probes don't
+// each trigger a compile.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	// A probe that disconnects must not cache a failure for the others.
+	ctx := context.WithoutCancel(r.Context())
+	result := s.ready.get(func() readyResult { return s.checkReady(ctx) })
+	if s.isDraining() {
+		// A draining server can compile, but won't accept new work.
+		result.err = errShuttingDown
+	}
+
+	resp := ReadyResponse{Status: readyStatusOK, TypstVersion: result.version}
+	status := http.StatusOK
+	if result.err != nil {
+		s.logger.Warn("readiness check failed", "error", result.err)
+		resp.Status = readyStatusUnavailable
+		resp.Error = result.err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if encodeErr := json.NewEncoder(w).Encode(resp); encodeErr != nil {
+		s.logger.Error("failed to write ready response", "error", encodeErr)
+	}
+}
+
+// checkReady detects the typst version, checks that the storage bucket can be reached and compiles
+// a trivial document.
+func (s *Server) checkReady(ctx context.Context) readyResult {
+	var result readyResult
+	config := s.config.Load()
+
+	versionCtx, cancel := context.WithTimeout(ctx, config.compileTimeout)
+	defer cancel()
+	version, err := s.typstVersion(versionCtx)
+	if err != nil {
+		result.err = fmt.Errorf("detect typst version: %w", err)
+		return result
+	}
+	result.version = version
+
+	if config.templateFS == nil {
+		if bucketErr := s.checkBucket(ctx); bucketErr != nil {
+			result.err = bucketErr
+			return result
+		}
+	}
+
+	doc, _, err := s.compile(ctx, resolvedTemplate{source: readyCheckSource}, resolvedData{},
+		compileArgs{format: formatPDF})
+	if err != nil {
+		result.err = fmt.Errorf("compile: %w", err)
+		return result
+	}
+	if !bytes.HasPrefix(doc, []byte(pdfMagic)) {
+		result.err = errors.New("compile: output is not a PDF")
+	}
+	return result
+}
+
+// checkBucket checks that the storage bucket can be reached, by listing at most one object.
+func (s *Server) checkBucket(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	bucket, err := s.openBucket(ctx)
+	if err != nil {
+		return fmt.Errorf("open bucket: %w", err)
+	}
+	accessible, err := bucket.IsAccessible(ctx)
+	if err != nil {
+		return fmt.Errorf("check bucket: %w", err)
+	}
+	if !accessible {
+		return errors.New("check bucket: bucket doesn't exist")
+	}
+	return nil
+}
+
+// localTypstVersion returns the version reported by a local typst binary, such as "typst 0.13.1".
+func localTypstVersion(ctx context.Context, binary string) (string, error) {
+	output, err := exec.CommandContext(ctx, binary, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCompiler is a TypstCompiler that counts compiles and writes the given output.
+type countingCompiler struct {
+	output   string
+	compiles atomic.Int32
+}
+
+// Compile writes the configured output to the work directory.
+func (c *countingCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	c.compiles.Add(1)
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte(c.output), 0600)
+}
+
+// serveReady calls handleReady and decodes its response.
+func serveReady(t *testing.T, srv *Server) (int, ReadyResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	srv.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	var resp ReadyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+// TestHandleReady tests the readiness check's result for working and broken compilers.
+func TestHandleReady(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		compiler   TypstCompiler
+		versionErr error
+		draining   bool
+		noBucket   bool
+		wantStatus int
+		wantErr    string
+	}{
+		{name: "ready", compiler: &stubCompiler{}, wantStatus: http.StatusOK},
+		{
+			name:       "compile fails",
+			compiler:   &stubCompiler{err: errors.New("typst crashed")},
+			wantStatus: http.StatusServiceUnavailable,
+			wantErr:    "typst crashed",
+		},
+		{
+			name:       "not a PDF",
+			compiler:   &countingCompiler{output: "garbage"},
+			wantStatus: http.StatusServiceUnavailable,
+			wantErr:    "not a PDF",
+		},
+		{
+			name:       "no typst",
+			compiler:   &stubCompiler{},
+			versionErr: errors.New("executable file not found"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantErr:    "detect typst version",
+		},
+		{
+			name:       "bucket unreachable",
+			compiler:   &stubCompiler{},
+			noBucket:   true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantErr:    "bucket",
+		},
+		{
+			name:       "draining",
+			compiler:   &stubCompiler{},
+			draining:   true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantErr:    "shutting down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, nil)
+			if tt.noBucket {
+				bucketURL = "file://" + filepath.Join(t.TempDir(), "missing")
+			}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = tt.compiler
+			srv.typstVersion = func(context.Context) (string, error) {
+				return "typst 0.13.1", tt.versionErr
+			}
+			if tt.draining {
+				srv.Drain()
+			}
+
+			code, resp := serveReady(t, srv)
+
+			if code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %+v", tt.wantStatus, code, resp)
+			}
+			if tt.wantErr == "" {
+				if resp.Status != readyStatusOK || resp.TypstVersion != "typst 0.13.1" {
+					t.Errorf("expected ok status with the typst version, got %+v", resp)
+				}
+				return
+			}
+			if resp.Status != readyStatusUnavailable || !strings.Contains(resp.Error, tt.wantErr) {
+				t.Errorf("expected unavailable status with error containing %q, got %+v", tt.wantErr, resp)
+			}
+		})
+	}
+}
+
+// TestHandleReady_Cache tests that the readiness check compiles at most once per TTL.
+func TestHandleReady_Cache(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: setupTestBucket(t, nil)})
+	compiler := &countingCompiler{output: "%PDF-ready"}
+	srv.compiler = compiler
+	srv.typstVersion = func(context.Context) (string, error) { return "typst 0.13.1", nil }
+
+	now := time.Now()
+	srv.ready.now = func() time.Time { return now }
+
+	for range 3 {
+		if code, resp := serveReady(t, srv); code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %+v", http.StatusOK, code, resp)
+		}
+	}
+	if got := compiler.compiles.Load(); got != 1 {
+		t.Errorf("expected 1 compile within the TTL, got %d", got)
+	}
+
+	now = now.Add(readyCheckTTL)
+	serveReady(t, srv)
+	if got := compiler.compiles.Load(); got != 2 {
+		t.Errorf("expected 2 compiles after the TTL, got %d", got)
+	}
+}
+
+// TestHandleHealth_BucketUnreachable tests that liveness doesn't depend on the storage bucket.
+func TestHandleHealth_BucketUnreachable(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "file://" + filepath.Join(t.TempDir(), "missing")})
+	srv.lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Errorf("expected status %d with OK, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+)
+
+// configContextKey is the context key of the configuration a request was started with.
+type configContextKey struct{}
+
+// pinConfig wraps next to load the live configuration once and store it in the request's context.
+//
+// Everything a request does reads the configuration through requestConfig, so a reload that lands
+// in the middle of a request can't mix the settings of two configurations.
+func (s *Server) pinConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), configContextKey{}, s.config.Load())))
+	})
+}
+
+// requestConfig returns the configuration stored by pinConfig, or the live configuration outside
+// of a request.
+func (s *Server) requestConfig(ctx context.Context) *ServerConfig {
+	if config, ok := ctx.Value(configContextKey{}).(*ServerConfig); ok {
+		return config
+	}
+	return s.config.Load()
+}
+
+// Reload atomically replaces the live configuration with config, after applying defaults.
+//
+// Settings that shape resources created once by NewServer, or routes wrapped once by
+// Handler, keep their current value; see keepRestartOnly. It returns the names of the
+// fields that changed, and of the fields that differ but require a restart.
+func (s *Server) Reload(config ServerConfig) ([]string, []string) {
+	config = withDefaults(config)
+	current := s.config.Load()
+
+	requested := configDiff(current, &config)
+	keepRestartOnly(&config, current)
+	changed := configDiff(current, &config)
+
+	var ignored []string
+	for _, name := range requested {
+		if !slices.Contains(changed, name) {
+			ignored = append(ignored, name)
+		}
+	}
+
+	if slices.Contains(changed, "templateConcurrency") {
+		s.limiter.Store(newTemplateLimiter(config.templateConcurrency))
+	}
+	s.config.Store(&config)
+
+	return changed, ignored
+}
+
+// keepRestartOnly copies the fields that can't change without a restart from current to config.
+func keepRestartOnly(config, current *ServerConfig) {
+	// The bucket and embedded filesystem are opened once.
+	config.bucketURL = current.bucketURL
+	config.templateFS = current.templateFS
+	// Metrics, profiling, auth, tenant tagging and key prefixes wrap the routes once in Handler.
+	config.metrics = current.metrics
+	config.pprof = current.pprof
+	config.authToken = current.authToken
+	config.tenantHeader = current.tenantHeader
+	config.keyPrefix = current.keyPrefix
+	// The compile limiter, compiler pool and template, font and document caches are created once in NewServer.
+	config.maxConcurrentCompiles = current.maxConcurrentCompiles
+	config.compileWorkers = current.compileWorkers
+	config.compileMemoryLimit = current.compileMemoryLimit
+	config.compileToStdout = current.compileToStdout
+	config.templateCacheSize = current.templateCacheSize
+	config.templateCacheTTL = current.templateCacheTTL
+	config.templateCacheURL = current.templateCacheURL
+	config.fontCacheSize = current.fontCacheSize
+	config.fontCacheMaxBytes = current.fontCacheMaxBytes
+	config.pdfCacheMaxBytes = current.pdfCacheMaxBytes
+	config.fontPath = current.fontPath
+	config.packageCachePath = current.packageCachePath
+	config.packagePath = current.packagePath
+	config.packageSeedPrefix = current.packageSeedPrefix
+	config.typstBinary = current.typstBinary
+	// The work directory is swept once at startup, and holds the default package cache and the
+	// temp roots of compile workers.
+	config.workDir = current.workDir
+	// The job queue is created once in NewServer.
+	config.jobQueueSize = current.jobQueueSize
+	config.jobWorkers = current.jobWorkers
+	config.jobTTL = current.jobTTL
+	config.jobResultsMaxBytes = current.jobResultsMaxBytes
+}
+
+// configDiff returns the names of the fields that differ between two configurations.
+func configDiff(a, b *ServerConfig) []string {
+	var names []string
+	aValue := reflect.ValueOf(a).Elem()
+	bValue := reflect.ValueOf(b).Elem()
+	for i := range aValue.NumField() {
+		// Fields are unexported, so they are compared by their formatted values.
+		if fmt.Sprint(aValue.Field(i)) != fmt.Sprint(bValue.Field(i)) {
+			names = append(names, aValue.Type().Field(i).Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestServer_Reload tests that reloadable settings take effect and restart-only settings are kept.
+func TestServer_Reload(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", maxTemplateSize: 16, maxConcurrentCompiles: 2})
+	srv.compiler = &stubCompiler{}
+
+	generate := func() int {
+		reqBody := `{"template": "= A template longer than sixteen bytes"}`
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+		rec := httptest.NewRecorder()
+		srv.handleGenerate(rec, req)
+		return rec.Code
+	}
+
+	if code := generate(); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d before reload, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+
+	changed, ignored := srv.Reload(ServerConfig{
+		bucketURL:             "file:///elsewhere",
+		maxTemplateSize:       1024,
+		maxConcurrentCompiles: 2,
+		templateConcurrency:   map[string]int{"invoice.typ": 1},
+	})
+
+	if want := []string{"maxTemplateSize", "templateConcurrency"}; !slices.Equal(changed, want) {
+		t.Errorf("expected changed fields %v, got %v", want, changed)
+	}
+	if want := []string{"bucketURL"}; !slices.Equal(ignored, want) {
+		t.Errorf("expected ignored fields %v, got %v", want, ignored)
+	}
+	if bucketURL := srv.config.Load().bucketURL; bucketURL != "mem://" {
+		t.Errorf("expected bucketURL to stay %q, got %q", "mem://", bucketURL)
+	}
+	if code := generate(); code != http.StatusOK {
+		t.Errorf("expected status %d after reload, got %d", http.StatusOK, code)
+	}
+
+	// The new per-template limit is enforced.
+	release, ok := srv.limiter.Load().acquire(context.Background(), "invoice.typ")
+	if !ok {
+		t.Fatal("expected the first invoice.typ request to be admitted")
+	}
+	defer release()
+	if _, ok = srv.limiter.Load().acquire(context.Background(), "invoice.typ"); ok {
+		t.Error("expected the second invoice.typ request to be rejected")
+	}
+}
+
+// TestServer_ReloadUnchanged tests that reloading the same configuration changes nothing.
+func TestServer_ReloadUnchanged(t *testing.T) {
+	t.Parallel()
+
+	config := ServerConfig{bucketURL: "mem://", maxDataSize: 2048, allowedContentTypes: []string{contentTypePDF}}
+	srv := NewServer(testLogger(), config)
+
+	changed, ignored := srv.Reload(config)
+	if len(changed) != 0 || len(ignored) != 0 {
+		t.Errorf("expected no changes, got changed %v and ignored %v", changed, ignored)
+	}
+}
+
+// TestServer_PinConfig tests that a request keeps the configuration it started with across a reload.
+func TestServer_PinConfig(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", maxTemplateSize: 16})
+
+	var before, after int64
+	handler := srv.pinConfig(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		before = srv.requestConfig(r.Context()).maxTemplateSize
+		srv.Reload(ServerConfig{bucketURL: "mem://", maxTemplateSize: 1024})
+		after = srv.requestConfig(r.Context()).maxTemplateSize
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if before != 16 || after != 16 {
+		t.Errorf("expected maxTemplateSize 16 throughout the request, got %d and %d", before, after)
+	}
+	if live := srv.requestConfig(context.Background()).maxTemplateSize; live != 1024 {
+		t.Errorf("expected the live maxTemplateSize 1024 outside a request, got %d", live)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// requestIDHeader is the request and response header carrying the request ID.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the maximum length of a request ID taken from the request header.
+	maxRequestIDLength = 128
+)
+
+// requestIDContextKey is the context key of the request ID.
+type requestIDContextKey struct{}
+
+// tagRequestID wraps next to store the request's ID in its context and echo it in the
+// X-Request-ID response header.
+//
+// The ID is taken from the X-Request-ID request header, so clients and proxies can
+// correlate their logs with the server's. Requests without a valid ID get a random UUID.
+func tagRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is non-empty, at most
+// maxRequestIDLength bytes and printable ASCII, so it's safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := range len(id) {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the request ID stored by tagRequestID, or "" if there is none.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestTagRequestID tests that the request ID is taken from the header or generated, and echoed in the response.
+func TestTagRequestID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		header        string
+		wantGenerated bool
+	}{
+		{name: "from header", header: "req-123"},
+		{name: "missing", wantGenerated: true},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1), wantGenerated: true},
+		{name: "control character", header: "req\x00123", wantGenerated: true},
+		{name: "non-ASCII", header: "req-ü", wantGenerated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotID string
+			handler := tagRequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotID = requestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantGenerated {
+				if uuid.Validate(gotID) != nil {
+					t.Errorf("expected a generated UUID, got %q", gotID)
+				}
+			} else if gotID != tt.header {
+				t.Errorf("expected request ID %q, got %q", tt.header, gotID)
+			}
+			if echoed := rec.Header().Get(requestIDHeader); echoed != gotID {
+				t.Errorf("expected %s header %q, got %q", requestIDHeader, gotID, echoed)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_RequestIDLogField tests that every log entry of a request carries its ID.
+func TestHandleGenerate_RequestIDLogField(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(logger, ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{err: errors.New("compile failed")}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	req.Header.Set(requestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusInternalServerError, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(requestIDHeader); got != "req-123" {
+		t.Errorf("expected %s header %q, got %q", requestIDHeader, "req-123", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected the request and its failure to be logged, got %q", logs.String())
+	}
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse log entry %q: %v", line, err)
+		}
+		if entry["requestID"] != "req-123" {
+			t.Errorf("expected requestID %q in log entry, got %v", "req-123", entry)
+		}
+	}
+}
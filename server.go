@@ -1,26 +1,91 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+
+	"github.com/boringbin/givetypst/client"
 )
 
 const (
-	// fetchTimeout is the timeout for fetching files from storage.
+	// fetchTimeout is the timeout for fetching files from storage, and for writing batch outputs.
 	fetchTimeout = 30 * time.Second
+	// maxConcurrentFetches is the maximum number of files fetched at once for a single request.
+	maxConcurrentFetches = 8
 	// defaultMaxTemplateSize is the default maximum size of a template file (1MB).
 	defaultMaxTemplateSize = 1024 * 1024
 	// defaultMaxDataSize is the default maximum size of a data file (10MB).
 	defaultMaxDataSize = 10 * 1024 * 1024
+	// defaultMaxAssetSize is the default maximum size of an asset file (10MB).
+	defaultMaxAssetSize = 10 * 1024 * 1024
+	// contentTypePDF is the content type of a generated PDF.
+	contentTypePDF = "application/pdf"
+	// contentTypeJSON is the content type of a JSON envelope wrapping the generated document.
+	contentTypeJSON = "application/json"
+	// dataFormatJSON is the data format of JSON data files.
+	dataFormatJSON = "json"
+	// dataFormatYAML is the data format of YAML data files.
+	dataFormatYAML = "yaml"
+	// dataFormatCSV is the data format of CSV data files, which are passed through verbatim.
+	dataFormatCSV = "csv"
+	// utf8BOM is the UTF-8 byte order mark some exporters prepend to JSON files.
+	utf8BOM = "\xef\xbb\xbf"
+	// defaultTypstBinary is the default typst binary, looked up on PATH.
+	defaultTypstBinary = "typst"
+	// defaultCompileTimeout is the default maximum duration of a single compilation.
+	defaultCompileTimeout = 30 * time.Second
+	// defaultTemplateCacheTTL is the default time a cached template stays valid.
+	defaultTemplateCacheTTL = 5 * time.Minute
+	// defaultBatchRequestDataSizes is the default maximum size of a batch request body in multiples of
+	// MAX_DATA_SIZE, on top of the allowance for its other fields.
+	defaultBatchRequestDataSizes = 4
+	// defaultMaxInputs is the default maximum number of "--input" flags passed to typst.
+	defaultMaxInputs = 128
+	// defaultMaxSplitPages is the default maximum number of pages split into single-page PDFs.
+	defaultMaxSplitPages = 100
+	// defaultMaxIncludeFiles is the default maximum number of include files of a single request.
+	defaultMaxIncludeFiles = 32
+	// defaultMaxAssetFiles is the default maximum number of asset files of a single request.
+	defaultMaxAssetFiles = 64
+	// maxFilenameLength is the maximum length in bytes of a requested download file name.
+	maxFilenameLength = 255
+	// maxWatermarkLength is the maximum length in characters of a watermark.
+	maxWatermarkLength = 100
+	// watermarkInput is the name of the typst input holding the watermark, read as sys.inputs.watermark.
+	watermarkInput = "watermark"
+	// generateQueryCacheControl lets browsers keep the documents of GET /generate, but revalidate
+	// them by their ETag on every use. Private, since a document may be specific to the requester.
+	generateQueryCacheControl = "private, no-cache"
 )
 
 // ServerConfig is the configuration for the server.
@@ -31,149 +96,1600 @@ type ServerConfig struct {
 	maxTemplateSize int64
 	// maxDataSize is the maximum size of a data file in bytes.
 	maxDataSize int64
+	// maxAssetSize is the maximum size of an asset file in bytes.
+	maxAssetSize int64
+	// maxIncludeFiles is the maximum number of include files of a single request.
+	maxIncludeFiles int
+	// maxAssetFiles is the maximum number of asset files of a single request.
+	maxAssetFiles int
+	// maxOutputSize is the maximum size of a compiled document in bytes (0 = unlimited).
+	maxOutputSize int64
+	// maxOutputSizes are the maximum sizes of compiled documents by output format, overriding maxOutputSize.
+	maxOutputSizes map[string]int64
+	// templatesPageSize is the maximum number of bucket objects listed per /templates page.
+	templatesPageSize int
+	// templateConcurrency maps template keys to their maximum number of concurrent requests.
+	templateConcurrency map[string]int
+	// metrics enables Prometheus metrics and the /metrics endpoint.
+	metrics bool
+	// pprof enables the net/http/pprof profiling endpoints under /debug/pprof/.
+	pprof bool
+	// tenantHeader, if set, is the request header whose value labels /generate metrics and logs.
+	tenantHeader string
+	// tenantAllowlist lists the tenant header values used as labels. Other values are labeled "unknown".
+	tenantAllowlist []string
+	// keyPrefix, if set, is prepended to the bucket keys of requests. "{tenant}" in it is replaced by the
+	// request's tenant header.
+	keyPrefix string
+	// authToken, if set, is the bearer token required by /generate and /templates.
+	authToken string
+	// templateFS, if set, serves templates, data files and assets instead of the bucket.
+	templateFS fs.FS
+	// maxConcurrentCompiles is the maximum number of concurrent compilations.
+	maxConcurrentCompiles int
+	// skipEmptyData skips writing the data file for empty data, such as "data": {} or "data": [], as if no data was given.
+	skipEmptyData bool
+	// compileMemoryLimit is the maximum memory of a compile process in bytes (0 = unlimited).
+	compileMemoryLimit int64
+	// optimizeLossy allows optimizing PDFs with Ghostscript, which downsamples images, when qpdf isn't installed.
+	optimizeLossy bool
+	// allowedContentTypes is the allowlist of output content types clients may request.
+	allowedContentTypes []string
+	// templateCacheSize is the maximum number of cached templates (0 = caching disabled).
+	templateCacheSize int
+	// templateCacheURL, if set, is the URL of a bucket templates are cached in instead of in memory.
+	templateCacheURL string
+	// fontCacheSize is the maximum number of cached font files.
+	fontCacheSize int
+	// fontCacheMaxBytes is the maximum total size of the cached font files.
+	fontCacheMaxBytes int64
+	// fontPath is a directory, or list of directories, with additional fonts for typst.
+	fontPath string
+	// packageCachePath is the package cache shared by all compiles. Defaults to packages in workDir, or
+	// in the user cache directory without one.
+	packageCachePath string
+	// packagePath is the directory of local packages for typst. Empty uses typst's default.
+	packagePath string
+	// typstBinary is the typst binary compiles run with, by name on PATH or by path, such as a wrapper script.
+	typstBinary string
+	// allowedTypstFlags are the typst compile flags requests may pass with typstFlags, such as "--jobs".
+	allowedTypstFlags []string
+	// typstVersions maps the versions clients may pin with typstVersion to their typst binaries.
+	typstVersions map[string]string
+	// packageSeedPrefix is the bucket prefix the package cache is seeded from at startup. Empty means no seeding.
+	packageSeedPrefix string
+	// pdfCacheMaxBytes is the maximum total size of the cached compiled documents (0 = caching disabled).
+	pdfCacheMaxBytes int64
+	// templateCacheTTL is how long a cached template stays valid.
+	templateCacheTTL time.Duration
+	// cacheFailClosed rejects requests with 503 when the template cache fails, instead of fetching without it.
+	cacheFailClosed bool
+	// allowURLSources allows templateKey and dataKey to be http or https URLs on urlSourceHosts.
+	allowURLSources bool
+	// urlSourceHosts is the allowlist of hosts, optionally with a port, URL sources may be fetched from.
+	urlSourceHosts []string
+	// dataFilePath is where the data file is written, relative to the project root.
+	dataFilePath string
+	// workDir is the directory work directories are created in. Empty uses the OS temp directory.
+	workDir string
+	// debugSampleRate is the fraction of requests (0 to 1) logged at debug level.
+	debugSampleRate float64
+	// compileTimeout is the maximum duration of a single compilation.
+	compileTimeout time.Duration
+	// compileQueueTimeout is the maximum time a compilation waits for a slot. Zero waits until compileTimeout.
+	compileQueueTimeout time.Duration
+	// compileToStdout makes the compiler write the PDF to stdout instead of a file.
+	compileToStdout bool
+	// compileWorkers is the number of long-lived compiler pool workers (0 = maxConcurrentCompiles).
+	compileWorkers int
+	// outputACL is the ACL of outputs written to the bucket ("private" or "public-read").
+	outputACL string
+	// dataKeysMode checks data keys against the template's expected keys file ("off", "warn" or "strict").
+	dataKeysMode string
+	// dataAsInputs passes scalar data values to typst as "--input" flags, exposing them as sys.inputs.
+	dataAsInputs bool
+	// maxInputs is the maximum number of "--input" flags passed to typst.
+	maxInputs int
+	// maxSplitPages is the maximum number of pages of a document split into single-page PDFs.
+	maxSplitPages int
+	// allowBucketOverride honors the bucketURL field of generate requests.
+	allowBucketOverride bool
+	// bucketOverrideSchemes are the bucket URL schemes a request may override the bucket with.
+	bucketOverrideSchemes []string
+	// checkDataContentType rejects JSON data files whose content type or content is obviously not JSON.
+	checkDataContentType bool
+	// filenameFromTemplate derives the default download file name from the template key instead of "output".
+	filenameFromTemplate bool
+	// keepDataBOM keeps a leading UTF-8 byte order mark of JSON data files instead of stripping it.
+	keepDataBOM bool
+	// maxBatchItems is the maximum number of items in a batch request.
+	maxBatchItems int
+	// maxBatchRequestSize is the maximum size of a batch request body in bytes.
+	maxBatchRequestSize int64
+	// jobQueueSize is the maximum number of pending asynchronous jobs.
+	jobQueueSize int
+	// jobWorkers is the number of asynchronous jobs run at once (0 = maxConcurrentCompiles).
+	jobWorkers int
+	// jobTTL is how long a finished asynchronous job and its document are kept.
+	jobTTL time.Duration
+	// jobResultsMaxBytes is the maximum total size of the documents of finished asynchronous jobs.
+	jobResultsMaxBytes int64
 }
 
 // Server is the server for the `givetypst` CLI.
 type Server struct {
 	// logger is the logger for the server.
 	logger *slog.Logger
-	// config is the configuration for the server.
-	config ServerConfig
+	// config is the live configuration for the server, swapped by Reload.
+	config atomic.Pointer[ServerConfig]
+	// compiler is the compiler used to turn templates into PDFs.
+	compiler TypstCompiler
+	// pool is the compiler pool created by NewServer, closed by Close.
+	pool *compilerPool
+	// templates caches fetched template sources. Nil when caching is disabled.
+	templates sourceCache
+	// fonts caches fetched font files.
+	fonts *templateCache
+	// documents caches compiled documents by their document key. Nil when caching is disabled.
+	documents *documentCache
+
+	// limiter limits concurrent requests per template key. Replaced by Reload when the limits change.
+	limiter atomic.Pointer[templateLimiter]
+	// compileLimiter bounds concurrent compilations.
+	compileLimiter *compileLimiter
+	// metrics records Prometheus metrics. Nil when metrics are disabled.
+	metrics *serverMetrics
+	// jobs runs the asynchronous generate requests of /jobs.
+	jobs *jobQueue
+	// ready caches the result of the /ready check.
+	ready readyCache
+	// draining is canceled by Drain, once the server stops accepting new work.
+	draining context.Context
+	// drain cancels draining.
+	drain context.CancelFunc
+	// urlSourceClient fetches templates and data from URLs.
+	urlSourceClient *http.Client
+	// lookPath looks up the typst binary and the binaries of PDF optimizers. Overridden in tests.
+	lookPath func(file string) (string, error)
+	// typstVersion returns the version of the typst binary compiles run with.
+	typstVersion func(ctx context.Context) (string, error)
+	// typstVersionOnce guards the first detection of the typst version for /version.
+	typstVersionOnce sync.Once
+	// detectedTypstVersion is the typst version detected by detectTypstVersion.
+	detectedTypstVersion string
+	// typstVersionErr is the error of detectTypstVersion, if typst is unavailable.
+	typstVersionErr error
+	// bucketMu guards bucket.
+	bucketMu sync.Mutex
+	// bucket is the shared storage bucket handle, opened on first use.
+	bucket *blob.Bucket
 }
 
 // NewServer creates a new server.
 func NewServer(logger *slog.Logger, config ServerConfig) *Server {
-	// Apply defaults if not set.
+	config = withDefaults(config)
+	compileLimiter := newCompileLimiter(config.maxConcurrentCompiles)
+	pool := newCompilerPool(config.compileWorkers, func(root string) TypstCompiler {
+		return &LocalTypstCompiler{
+			memoryLimit:      config.compileMemoryLimit,
+			stdout:           config.compileToStdout,
+			packageCachePath: config.packageCachePath,
+			packagePath:      config.packagePath,
+			fontPath:         config.fontPath,
+			binary:           config.typstBinary,
+			tempDir:          root,
+		}
+	})
+	pool.tempDir = config.workDir
+
+	var templates sourceCache
+	switch {
+	case config.templateCacheURL != "":
+		templates = newBucketCache(config.templateCacheURL, config.templateCacheTTL)
+	case config.templateCacheSize > 0:
+		templates = newTemplateCache(config.templateCacheSize, 0, config.templateCacheTTL)
+	}
+
+	var metrics *serverMetrics
+	if config.metrics {
+		metrics = newServerMetrics(compileLimiter.queueDepth, config.tenantHeader != "")
+	}
+
+	s := &Server{
+		logger:         logger,
+		compiler:       pool,
+		pool:           pool,
+		templates:      templates,
+		fonts:          newTemplateCache(config.fontCacheSize, config.fontCacheMaxBytes, config.templateCacheTTL),
+		documents:      newDocumentCache(config.pdfCacheMaxBytes),
+		compileLimiter: compileLimiter,
+		metrics:        metrics,
+		ready:          readyCache{now: time.Now},
+		lookPath:       exec.LookPath,
+		typstVersion: func(ctx context.Context) (string, error) {
+			return localTypstVersion(ctx, config.typstBinary)
+		},
+	}
+	s.draining, s.drain = context.WithCancel(context.Background())
+	s.urlSourceClient = s.newURLSourceClient()
+	s.jobs = newJobQueue(config.jobQueueSize, config.jobWorkers, config.jobTTL, s.runJob)
+	s.jobs.maxBytes = config.jobResultsMaxBytes
+	s.config.Store(&config)
+	s.limiter.Store(newTemplateLimiter(config.templateConcurrency))
+	return s
+}
+
+// withDefaults returns config with defaults applied to unset or invalid fields.
+func withDefaults(config ServerConfig) ServerConfig {
+	config.typstBinary = cmp.Or(config.typstBinary, defaultTypstBinary)
 	if config.maxTemplateSize <= 0 {
 		config.maxTemplateSize = defaultMaxTemplateSize
 	}
 	if config.maxDataSize <= 0 {
 		config.maxDataSize = defaultMaxDataSize
 	}
+	if config.maxAssetSize <= 0 {
+		config.maxAssetSize = defaultMaxAssetSize
+	}
+	if config.maxIncludeFiles <= 0 {
+		config.maxIncludeFiles = defaultMaxIncludeFiles
+	}
+	if config.maxAssetFiles <= 0 {
+		config.maxAssetFiles = defaultMaxAssetFiles
+	}
+	if config.templatesPageSize <= 0 {
+		config.templatesPageSize = defaultTemplatesPageSize
+	}
+	config.allowedContentTypes = slices.DeleteFunc(slices.Clone(config.allowedContentTypes), func(ct string) bool {
+		return !isSupportedContentType(ct)
+	})
+	if len(config.allowedContentTypes) == 0 {
+		config.allowedContentTypes = supportedContentTypes()
+	}
+	if config.templateCacheTTL <= 0 {
+		config.templateCacheTTL = defaultTemplateCacheTTL
+	}
+	config.dataFilePath = cleanDataFilePath(config.dataFilePath)
+	config.packageCachePath = cmp.Or(config.packageCachePath, defaultPackageCachePath(config.workDir))
+	if config.fontCacheSize <= 0 {
+		config.fontCacheSize = defaultFontCacheSize
+	}
+	if config.fontCacheMaxBytes <= 0 {
+		config.fontCacheMaxBytes = defaultFontCacheMaxBytes
+	}
+	if config.outputACL != outputACLPublicRead {
+		config.outputACL = outputACLPrivate
+	}
+	if config.dataKeysMode != dataKeysWarn && config.dataKeysMode != dataKeysStrict {
+		config.dataKeysMode = dataKeysOff
+	}
+	if config.compileTimeout <= 0 {
+		config.compileTimeout = defaultCompileTimeout
+	}
+	if config.maxInputs <= 0 {
+		config.maxInputs = defaultMaxInputs
+	}
+	if config.maxSplitPages <= 0 {
+		config.maxSplitPages = defaultMaxSplitPages
+	}
+	if len(config.bucketOverrideSchemes) == 0 {
+		config.bucketOverrideSchemes = defaultBucketOverrideSchemes()
+	}
+	if config.maxBatchItems <= 0 {
+		config.maxBatchItems = defaultMaxBatchItems
+	}
+	if config.maxBatchRequestSize <= 0 {
+		config.maxBatchRequestSize = defaultBatchRequestDataSizes*config.maxDataSize + requestOverhead
+	}
+	if config.jobQueueSize <= 0 {
+		config.jobQueueSize = defaultJobQueueSize
+	}
+	if config.jobTTL <= 0 {
+		config.jobTTL = defaultJobTTL
+	}
+	if config.jobResultsMaxBytes <= 0 {
+		config.jobResultsMaxBytes = defaultJobResultsMaxBytes
+	}
+	if config.maxConcurrentCompiles <= 0 {
+		config.maxConcurrentCompiles = runtime.NumCPU()
+	}
+	if config.compileWorkers <= 0 {
+		config.compileWorkers = config.maxConcurrentCompiles
+	}
+	if config.jobWorkers <= 0 {
+		config.jobWorkers = config.maxConcurrentCompiles
+	}
+	return config
+}
+
+// Close releases the resources held by the server, including the compiler pool and the shared
+// bucket and template cache handles.
+func (s *Server) Close() error {
+	s.jobs.close()
+	s.pool.Close()
+
+	var cacheErr error
+	if cache, ok := s.templates.(*bucketCache); ok {
+		cacheErr = cache.Close()
+	}
+
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+
+	if s.bucket == nil {
+		return cacheErr
+	}
+	err := s.bucket.Close()
+	s.bucket = nil
+	if err != nil {
+		return errors.Join(fmt.Errorf("close bucket: %w", err), cacheErr)
+	}
+	return cacheErr
+}
 
-	return &Server{
-		logger: logger,
-		config: config,
+// requestLogger returns the logger for a single request.
+//
+// A random sample of requests, sized by debugSampleRate, gets a logger with debug
+// output enabled regardless of the server-wide level. Requests log their ID in the
+// "requestID" field, and requests tagged with a tenant log it in the "tenant" field.
+func (s *Server) requestLogger(ctx context.Context) *slog.Logger {
+	config := s.requestConfig(ctx)
+	logger := s.logger
+	if config.debugSampleRate > 0 &&
+		rand.Float64() < config.debugSampleRate { //nolint:gosec // Sampling is not security sensitive.
+		logger = slog.New(debugHandler{Handler: s.logger.Handler()})
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		logger = logger.With("requestID", id)
 	}
+	if tenant := tenantFromContext(ctx); tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	return logger
 }
 
 // Handler returns the HTTP handler for the server.
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /generate", s.handleGenerate)
+	generate := s.tagTenant(s.metrics.instrumentGenerate(s.requireAuth(s.scopeKeys(gzipResponses(s.handleGenerate)))))
+	mux.Handle("POST /generate", generate)
+	mux.Handle("GET /generate", generate)
+	mux.Handle("POST /generate/batch", s.tagTenant(s.requireAuth(s.scopeKeys(s.handleGenerateBatch))))
+	mux.Handle("POST /query", s.tagTenant(s.requireAuth(s.scopeKeys(s.handleQuery))))
+	mux.Handle("POST /validate", s.tagTenant(s.requireAuth(s.scopeKeys(s.handleValidate))))
+	mux.Handle("POST /jobs", s.tagTenant(s.requireAuth(s.scopeKeys(s.handleSubmitJob))))
+	mux.Handle("GET /jobs/{id}", s.tagTenant(s.requireAuth(s.scopeKeys(s.handleGetJob))))
+	mux.Handle("GET /jobs/{id}/result", s.tagTenant(s.requireAuth(s.scopeKeys(gzipResponses(s.handleGetJobResult)))))
 	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /ready", s.handleReady)
+	mux.HandleFunc("GET /version", s.handleVersion)
+	mux.HandleFunc("GET /templates", s.requireAuth(s.scopeKeys(gzipResponses(s.handleTemplates))))
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics.handler())
+	}
+	if s.config.Load().pprof {
+		s.registerPprof(mux)
+	}
 
-	return mux
+	return s.pinConfig(tagRequestID(s.logAccess(s.rejectWhileDraining(mux))))
 }
 
-// handleHealth checks if the typst command is available.
+// requireAuth wraps next to require the configured bearer token.
 //
-// Will return an "OK" response if everything looks good.
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	// First, check if the typst command is available.
-	if _, err := exec.LookPath("typst"); err != nil {
-		http.Error(w, "typst not found", http.StatusServiceUnavailable)
-		return
+// Requests without a matching "Authorization: Bearer <token>" header get 401 Unauthorized.
+// If no token is configured, next is returned unchanged.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	config := s.config.Load()
+	if config.authToken == "" {
+		return next
 	}
-	// Next, check if we have access to the storage bucket.
-	bucket, bucketErr := blob.OpenBucket(r.Context(), s.config.bucketURL)
-	if bucketErr != nil {
-		http.Error(w, "failed to open bucket", http.StatusServiceUnavailable)
+
+	// Tokens are compared by hash so the comparison doesn't leak the token length.
+	want := sha256.Sum256([]byte(config.authToken))
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(token))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleHealth is the liveness check: it checks that the process is up and the typst command is available.
+//
+// Will return an "OK" response if everything looks good. The storage bucket is checked by /ready
+// instead, so a storage outage takes the server out of rotation rather than restarting it.
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	if _, err := s.lookPath(s.config.Load().typstBinary); err != nil {
+		http.Error(w, "typst not found", http.StatusServiceUnavailable)
 		return
 	}
-	_ = bucket.Close()
 
 	if _, writeErr := w.Write([]byte("OK")); writeErr != nil {
 		s.logger.Error("failed to write health response", "error", writeErr)
 	}
 }
 
-// GenerateRequest is the request body for the /generate endpoint.
-type GenerateRequest struct {
-	// TemplateKey is the key of the template in the storage bucket.
-	TemplateKey string `json:"templateKey"`
-	// Data is the inline data to inject into the template.
-	Data map[string]any `json:"data,omitempty"`
-	// DataKey is the key of a JSON data file in the storage bucket.
-	DataKey string `json:"dataKey,omitempty"`
+// GenerateRequest is the request body for the /generate endpoint, shared with the Go client.
+type GenerateRequest = client.GenerateRequest
+
+// requestInputs returns the typst inputs set by a request itself, or nil if there are none.
+func requestInputs(req *GenerateRequest) map[string]string {
+	if req.Watermark == "" {
+		return nil
+	}
+	return map[string]string{watermarkInput: req.Watermark}
+}
+
+// statusError is an error that carries the HTTP status code to respond with.
+type statusError struct {
+	// status is the HTTP status code.
+	status int
+	// err is the underlying error, whose message is returned to the client.
+	err error
+}
+
+// Error returns the message of the underlying error.
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// newStatusError wraps err with an HTTP status code.
+func newStatusError(status int, err error) error {
+	return &statusError{status: status, err: err}
+}
+
+// writeError writes err as a plain-text error response, or as a CompileErrorResponse for typst failures.
+//
+// Errors without a status code are reported as 500 Internal Server Error.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.status
+	}
+	var typstErr *typstError
+	if errors.As(err, &typstErr) {
+		writeTypstError(w, status, typstErr)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// resolvedData is the data of a generate request, ready to be staged for compilation.
+type resolvedData struct {
+	// values is structured data, written to the data file as JSON. May be nil.
+	values any
+	// raw is a data file passed through verbatim, such as CSV. Nil for structured data.
+	raw []byte
+	// rawPath is where raw is written, relative to the project root.
+	rawPath string
+}
+
+// resolvedTemplate is the template of a generate request, ready to be staged for compilation.
+type resolvedTemplate struct {
+	// source is the main template source.
+	source string
+	// files are additional files staged with the template, keyed by their path relative to the project root.
+	files map[string][]byte
+	// fontDir is the directory of the staged fonts relative to the project root. Empty if there are none.
+	fontDir string
 }
 
 // handleGenerate generates a PDF from a template.
+//
+// GET requests take the scalar fields of the request as query parameters, for embedding documents
+// in pages. Their responses may be cached by browsers, which revalidate them with If-None-Match.
 func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
 	var req GenerateRequest
+	logger := s.requestLogger(r.Context())
 
 	// Check if the request is valid.
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := s.decodeGenerateRequest(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.validateGenerateRequest(r.Context(), &req); err != nil {
+		writeError(w, err)
 		return
 	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Cache-Control", generateQueryCacheControl)
+	}
 
-	// Validate templateKey is provided.
-	if req.TemplateKey == "" {
-		http.Error(w, "templateKey is required", http.StatusBadRequest)
+	// Check that the requested output content type is allowed for the output format.
+	format := outputFormats()[req.Format]
+	allowed := s.allowedContentTypesFor(r.Context(), format)
+	contentType, ok := negotiateContentType(r.Header.Get("Accept"), allowed)
+	if !ok {
+		msg := "unsupported content type, allowed: " + strings.Join(allowed, ", ")
+		http.Error(w, msg, http.StatusNotAcceptable)
 		return
 	}
 
-	// Validate that both data and dataKey are not provided.
-	if req.Data != nil && req.DataKey != "" {
-		http.Error(w, "cannot specify both 'data' and 'dataKey'", http.StatusBadRequest)
+	// Check that the template isn't at its concurrency limit.
+	release, ok := s.limiter.Load().acquire(r.Context(), req.TemplateKey)
+	if !ok {
+		http.Error(w, errTemplateBusy.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	defer release()
 
-	// Resolve data: either from inline data or from bucket.
-	var data map[string]any
-	if req.DataKey != "" {
-		fetchedData, fetchErr := s.fetchData(r.Context(), req.DataKey)
-		if fetchErr != nil {
-			http.Error(w, fmt.Sprintf("failed to fetch data: %v", fetchErr), http.StatusInternalServerError)
-			return
+	logger.Debug("generating document",
+		"templateKey", req.TemplateKey,
+		"inlineTemplate", req.Template != "",
+		"includeKeys", len(req.IncludeKeys),
+		"assetKeys", len(req.AssetKeys),
+		"fontKeys", len(req.FontKeys),
+		"dataKey", req.DataKey,
+		"inlineData", req.Data != nil,
+		"mergeData", req.MergeData,
+		"noCache", req.NoCache,
+		"format", req.Format,
+		"pages", req.Pages,
+		"filename", req.Filename,
+		"metaOnly", req.MetaOnly,
+		"optimize", req.Optimize,
+		"typstVersion", req.TypstVersion,
+		"watermark", req.Watermark != "",
+		"bucketOverride", req.BucketURL != "",
+		"contentType", contentType,
+	)
+
+	// Resolve the data and template, and compile them into the output format.
+	filename := format.downloadName(s.requestConfig(r.Context()).requestFilename(&req))
+	cond := conditionalRender{contentType: contentType, filename: filename, ifNoneMatch: r.Header.Get("If-None-Match")}
+	if req.MetaOnly {
+		// A metadata-only response has no document to tag.
+		cond = conditionalRender{}
+	}
+	doc, err := s.generate(r.Context(), logger, w.Header(), &req, cond)
+	if errors.Is(err, errNotModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err != nil {
+		logger.Warn("failed to generate document", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	defer doc.Close()
+
+	// Only report the success for a metadata-only request.
+	if req.MetaOnly {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Return the document wrapped in a JSON envelope if requested.
+	w.Header().Set("Content-Type", contentType)
+	if contentType == contentTypeJSON {
+		if writeErr := writeEnvelope(w, filename, format.contentType, doc.warnings, doc); writeErr != nil {
+			logger.Error("failed to write JSON response", "error", writeErr)
 		}
-		data = fetchedData
-	} else {
-		data = req.Data // May be nil, which is valid.
+		return
+	}
+
+	// Stream the document, which may still be in its work directory, rather than reading it into memory.
+	w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"\"")
+	w.Header().Set("Content-Length", strconv.FormatInt(doc.size, 10))
+	if _, writeErr := doc.WriteTo(w); writeErr != nil {
+		logger.Error("failed to write document response", "error", writeErr)
+	}
+}
+
+// generate resolves the data and template of a validated request and compiles the document.
+// The caller closes the compiled output.
+//
+// Headers describing the result, such as a data keys mismatch, the entity tag and the compile's
+// resource usage, are set on header. If the client already has the document described by cond,
+// errNotModified is returned without compiling.
+func (s *Server) generate(
+	ctx context.Context,
+	logger *slog.Logger,
+	header http.Header,
+	req *GenerateRequest,
+	cond conditionalRender,
+) (*compiledOutput, error) {
+	tmpl, data, err := s.resolveRequest(ctx, logger, header, req)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fetch the template from the storage bucket.
-	source, err := s.fetchTemplate(r.Context(), req.TemplateKey)
+	binary, err := s.requestConfig(ctx).typstBinaryFor(req.TypstVersion)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to fetch template: %v", err), http.StatusInternalServerError)
-		return
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+	timestamp, err := creationTimestamp(req.CreationTime)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Skip the compile if the client already has the document, or it's cached.
+	args := compileArgs{
+		binary:            binary,
+		format:            req.Format,
+		pages:             req.Pages,
+		inputs:            requestInputs(req),
+		fontDir:           tmpl.fontDir,
+		optimize:          req.Optimize,
+		flags:             req.TypstFlags,
+		creationTimestamp: timestamp,
+	}
+	var key string
+	if cond.contentType != "" || s.documents != nil {
+		if key, err = s.documentKey(ctx, tmpl, data, args); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkNotModified(header, key, cond); err != nil {
+		return nil, err
 	}
+	if doc, ok := s.cachedDocument(ctx, key, args.format); ok {
+		return memoryOutput(doc), nil
+	}
+
+	// Compile the template into the output format.
+	output, usage, err := s.compileOutput(ctx, tmpl, data, args)
+	if err != nil {
+		return nil, err
+	}
+	writeUsageHeaders(header, usage)
+
+	// Documents that fit in the document cache are read into memory to be cached.
+	if key != "" && s.documents.fits(output.size) {
+		doc, readErr := output.bytes()
+		_ = output.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		s.documents.put(key, doc)
+		output = memoryOutput(doc)
+	}
+	output.warnings = usage.warnings
 
-	// Compile the template into a PDF.
-	pdf, err := compileTypst(source, data)
+	return output, nil
+}
+
+// cachedDocument returns the cached document with key, if there is one within the output size
+// limit of format, and records the cache lookup. An empty key is never cached.
+func (s *Server) cachedDocument(ctx context.Context, key, format string) ([]byte, bool) {
+	if key == "" || s.documents == nil {
+		return nil, false
+	}
+	doc, ok := s.documents.get(key)
+	if ok {
+		// The output size limit may have been lowered by a reload since the document was cached.
+		limit := s.requestConfig(ctx).outputSizeLimit(format)
+		ok = limit <= 0 || int64(len(doc)) <= limit
+	}
+	s.metrics.documentCacheLookup(ok)
+	return doc, ok
+}
+
+// resolveRequest resolves the data and template of a validated request.
+//
+// A data keys mismatch is reported on header.
+func (s *Server) resolveRequest(
+	ctx context.Context,
+	logger *slog.Logger,
+	header http.Header,
+	req *GenerateRequest,
+) (resolvedTemplate, resolvedData, error) {
+	// Fetch from the requested bucket instead of the configured one, if overridden.
+	ctx, closeBucket, err := s.withBucketOverride(ctx, req.BucketURL)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return resolvedTemplate{}, resolvedData{}, err
+	}
+	defer closeBucket()
+
+	// Resolve the data and the template concurrently: either inline or from the storage bucket.
+	var (
+		data resolvedData
+		tmpl resolvedTemplate
+	)
+	group, fetchCtx := errgroup.WithContext(ctx)
+	group.Go(func() (resolveErr error) {
+		data, resolveErr = s.resolveData(fetchCtx, req)
+		return resolveErr
+	})
+	group.Go(func() (resolveErr error) {
+		tmpl, resolveErr = s.resolveTemplate(fetchCtx, req)
+		return resolveErr
+	})
+	if err = group.Wait(); err != nil {
+		return resolvedTemplate{}, resolvedData{}, err
+	}
+
+	if err = s.checkDataKeys(ctx, header, logger, req.TemplateKey, data); err != nil {
+		return resolvedTemplate{}, resolvedData{}, err
+	}
+	return tmpl, data, nil
+}
+
+// writeUsageHeaders sets the resource usage headers of the compile process, and the number of
+// warnings typst reported, if it was recorded.
+func writeUsageHeaders(header http.Header, usage compileUsage) {
+	if !usage.recorded {
 		return
 	}
+	header.Set("X-Typst-Warnings", strconv.Itoa(len(usage.warnings)))
+	header.Set("X-Compile-CPU-Ms", strconv.FormatInt(usage.cpuTime.Milliseconds(), 10))
+	if usage.maxRSSKB > 0 {
+		header.Set("X-Compile-MaxRSS-KB", strconv.FormatInt(usage.maxRSSKB, 10))
+	}
+}
 
-	// Return the PDF.
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", "inline; filename=\"output.pdf\"")
-	if _, writeErr := w.Write(pdf); writeErr != nil {
-		s.logger.Error("failed to write PDF response", "error", writeErr)
+// allowedContentTypesFor returns the allowed content types that can be produced for the output format:
+// the format's own content type and the JSON envelope. The format's own content type comes first so
+// requests without a preference get the document rather than the envelope.
+func (s *Server) allowedContentTypesFor(ctx context.Context, format outputFormat) []string {
+	var allowed []string
+	for _, contentType := range []string{format.contentType, contentTypeJSON} {
+		if slices.Contains(s.requestConfig(ctx).allowedContentTypes, contentType) {
+			allowed = append(allowed, contentType)
+		}
 	}
+	return allowed
+}
+
+// checkFormatAllowed returns a 406 Not Acceptable error if the content type of the output format
+// isn't in ALLOWED_CONTENT_TYPES, for documents that are served without negotiating an Accept header.
+func (s *Server) checkFormatAllowed(ctx context.Context, format outputFormat) error {
+	allowed := s.requestConfig(ctx).allowedContentTypes
+	if slices.Contains(allowed, format.contentType) {
+		return nil
+	}
+	return newStatusError(http.StatusNotAcceptable,
+		fmt.Errorf("unsupported content type %s, allowed: %s", format.contentType, strings.Join(allowed, ", ")))
+}
+
+// validateGenerateRequest checks a generate request for conflicting or oversized fields.
+//
+// It also normalizes req.DataFormat to the resolved format of the data file, and
+// req.Format to the name of the output format.
+func (s *Server) validateGenerateRequest(ctx context.Context, req *GenerateRequest) error {
+	config := s.requestConfig(ctx)
+
+	// Validate that exactly one of templateKey and template is provided.
+	if req.TemplateKey == "" && req.Template == "" {
+		return newStatusError(http.StatusBadRequest, errors.New("templateKey or template is required"))
+	}
+	if req.TemplateKey != "" && req.Template != "" {
+		return newStatusError(http.StatusBadRequest, errors.New("cannot specify both 'template' and 'templateKey'"))
+	}
+
+	// Validate that an inline template is within the template size limit.
+	if int64(len(req.Template)) > config.maxTemplateSize {
+		return newStatusError(http.StatusRequestEntityTooLarge, errors.New("template exceeds maximum size"))
+	}
+
+	// Validate the keys fetched from the bucket or over HTTP before anything is fetched.
+	if err := s.validateURLSources(ctx, req); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	if err := validateRequestKeys(req, config.dataFilePath); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Validate the include files of the template.
+	if err := validateIncludeKeys(req.TemplateKey, req.IncludeKeys, config.maxIncludeFiles); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Validate the asset files of the template.
+	if err := validateAssetKeys(req.AssetKeys, config.maxAssetFiles); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	if err := validateFontKeys(req.FontKeys); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Validate the inline data and the data file.
+	if err := validateData(req, config.maxDataSize); err != nil {
+		return err
+	}
+
+	// Validate the output format and file name.
+	if err := validateOutput(req); err != nil {
+		return err
+	}
+
+	// Validate the typst version and flags.
+	if _, err := config.typstBinaryFor(req.TypstVersion); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	if err := validateTypstFlags(req.TypstFlags, config.allowedTypstFlags); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	if _, err := creationTimestamp(req.CreationTime); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Validate the bucket override.
+	if req.BucketURL != "" {
+		if err := s.validateBucketOverride(ctx, req.BucketURL); err != nil {
+			return newStatusError(http.StatusBadRequest, err)
+		}
+	}
+
+	// Validate the watermark.
+	if utf8.RuneCountInString(req.Watermark) > maxWatermarkLength {
+		return newStatusError(http.StatusBadRequest,
+			fmt.Errorf("watermark exceeds maximum length of %d characters", maxWatermarkLength))
+	}
+
+	// Validate the document metadata.
+	if err := validateMetadata(req.Metadata); err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+
+	return nil
+}
+
+// validateData checks the inline data and data file of a generate request for conflicts, and
+// that inline data is at most maxDataSize bytes.
+//
+// It also normalizes req.DataFormat to the resolved format of the data file.
+func validateData(req *GenerateRequest, maxDataSize int64) error {
+	// Validate that both data and dataKey are not provided, unless they're merged.
+	if req.Data != nil && req.DataKey != "" && !req.MergeData {
+		return newStatusError(http.StatusBadRequest, errors.New("cannot specify both 'data' and 'dataKey'"))
+	}
+	if req.MergeData && (req.Data == nil || req.DataKey == "") {
+		return newStatusError(http.StatusBadRequest, errors.New("mergeData requires both 'data' and 'dataKey'"))
+	}
+	if req.DataYAML != "" && (req.Data != nil || req.DataKey != "") {
+		return newStatusError(http.StatusBadRequest, errors.New("cannot specify 'dataYaml' with 'data' or 'dataKey'"))
+	}
+
+	// Validate that inline data is within the data size limit, like data files from the bucket.
+	if err := validateInlineDataSize(req.Data, req.DataYAML, maxDataSize); err != nil {
+		return err
+	}
+
+	// Validate the data format of the data file.
+	dataFormat, err := resolveDataFormat(req.DataKey, req.DataFormat)
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, err)
+	}
+	req.DataFormat = dataFormat
+	if req.MergeData && dataFormat == dataFormatCSV {
+		return newStatusError(http.StatusBadRequest, fmt.Errorf("mergeData is not supported for %s data", dataFormatCSV))
+	}
+
+	return nil
+}
+
+// validateOutput checks the output options of a generate request: the format, the page selection,
+// PDF optimization and the download file name.
+//
+// It also normalizes req.Format to the name of the output format, and req.Pages to its canonical form.
+func validateOutput(req *GenerateRequest) error {
+	// Validate the output format.
+	req.Format = cmp.Or(strings.ToLower(req.Format), formatPDF)
+	if _, ok := outputFormats()[req.Format]; !ok {
+		return newStatusError(http.StatusBadRequest, fmt.Errorf("unsupported format %q", req.Format))
+	}
+
+	// Validate the page selection.
+	if req.Pages != "" {
+		ranges, err := parsePages(req.Pages)
+		if err != nil {
+			return newStatusError(http.StatusBadRequest, err)
+		}
+		// typst writes one image per page, but a png or svg response holds only one, so a selection of
+		// several pages would silently lose all but one of them.
+		if outputFormats()[req.Format].firstPageOnly && !isSinglePage(ranges) {
+			return newStatusError(http.StatusBadRequest,
+				fmt.Errorf("%s output is a single image, so pages must select a single page", req.Format))
+		}
+		req.Pages = formatPages(ranges)
+	}
+
+	// Validate that only PDFs are optimized.
+	if req.Optimize && req.Format != formatPDF {
+		return newStatusError(http.StatusBadRequest, fmt.Errorf("optimize is only supported for %s output", formatPDF))
+	}
+
+	// Validate the download file name.
+	if len(req.Filename) > maxFilenameLength {
+		return newStatusError(http.StatusBadRequest,
+			fmt.Errorf("filename exceeds maximum length of %d bytes", maxFilenameLength))
+	}
+
+	return nil
+}
+
+// validateInlineDataSize checks that inline data is at most maxSize bytes, serialized as JSON
+// for structured data or as-is for YAML.
+func validateInlineDataSize(data any, dataYAML string, maxSize int64) error {
+	size := int64(len(dataYAML))
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return newStatusError(http.StatusBadRequest, fmt.Errorf("invalid data: %w", err))
+		}
+		size = int64(len(encoded))
+	}
+	if size > maxSize {
+		return newStatusError(http.StatusRequestEntityTooLarge,
+			fmt.Errorf("data exceeds maximum size: %d bytes, maximum %d", size, maxSize))
+	}
+	return nil
+}
+
+// validateRequestKeys checks every bucket key of a generate request with validateKey, and that no
+// include or asset file would be staged over one of the server's own files.
+func validateRequestKeys(req *GenerateRequest, dataPath string) error {
+	if req.TemplateKey != "" && !isURLSource(req.TemplateKey) {
+		if err := validateKey("templateKey", req.TemplateKey); err != nil {
+			return err
+		}
+	}
+	if req.DataKey != "" && !isURLSource(req.DataKey) {
+		if err := validateKey("dataKey", req.DataKey); err != nil {
+			return err
+		}
+	}
+	keyLists := []struct {
+		kind string
+		keys []string
+	}{
+		{kind: "include key", keys: req.IncludeKeys},
+		{kind: "asset key", keys: req.AssetKeys},
+		{kind: "font key", keys: req.FontKeys},
+	}
+	for _, list := range keyLists {
+		for _, key := range list.keys {
+			if err := validateKey(list.kind, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keys that can't be staged at all are reported by validateIncludeKeys and validateAssetKeys.
+	for _, key := range req.IncludeKeys {
+		if rel, err := includePath(req.TemplateKey, key); err == nil && reservedPath(rel, dataPath) {
+			return fmt.Errorf("invalid include key %q: %s is reserved", key, rel)
+		}
+	}
+	for _, key := range req.AssetKeys {
+		if reservedPath(path.Clean(key), dataPath) {
+			return fmt.Errorf("invalid asset key %q: %s is reserved", key, path.Clean(key))
+		}
+	}
+	return nil
+}
+
+// reservedPath reports whether rel, a path relative to the project root, is where the server
+// stages its own files: the template source, the JSON or CSV data file, the compiled output, or
+// the directories of the request's fonts and of formats with one file per page.
+func reservedPath(rel, dataPath string) bool {
+	for _, dir := range []string{fontDirName, pageDirName} {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	dataPath = path.Clean(filepath.ToSlash(cmp.Or(dataPath, dataFileName)))
+	csvPath := strings.TrimSuffix(dataPath, path.Ext(dataPath)) + ".csv"
+	if rel == sourceFileName || rel == dataPath || rel == csvPath {
+		return true
+	}
+	for _, format := range outputFormats() {
+		if rel == format.fileName() {
+			return true
+		}
+	}
+	return false
+}
+
+// validateKey checks that a bucket key from a request can't reach outside the bucket.
+//
+// Some backends, such as fileblob, map keys to file paths, so keys with ".." segments,
+// leading slashes, null bytes or a URL scheme are rejected rather than passed on.
+func validateKey(kind, key string) error {
+	switch {
+	case strings.ContainsRune(key, 0):
+		return fmt.Errorf("invalid %s %q: contains a null byte", kind, key)
+	case strings.HasPrefix(key, "/") || strings.HasPrefix(key, `\`):
+		return fmt.Errorf("invalid %s %q: must not start with a slash", kind, key)
+	case strings.Contains(key, "://"):
+		return fmt.Errorf("invalid %s %q: must not contain a URL scheme", kind, key)
+	}
+	for segment := range strings.FieldsFuncSeq(key, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return fmt.Errorf("invalid %s %q: must not contain \"..\" segments", kind, key)
+		}
+	}
+	return nil
+}
+
+// validateIncludeKeys checks that there are at most maxFiles include keys, and that every one
+// can be staged next to the template.
+func validateIncludeKeys(templateKey string, includeKeys []string, maxFiles int) error {
+	if len(includeKeys) > maxFiles {
+		return fmt.Errorf("too many include files: %d, maximum %d", len(includeKeys), maxFiles)
+	}
+	for _, key := range includeKeys {
+		if _, err := includePath(templateKey, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includePath returns where an include file is staged, relative to the project root.
+//
+// Include files keep their path relative to the template's directory, so a template can
+// import its siblings by their original names. Keys outside that directory are rejected.
+func includePath(templateKey, key string) (string, error) {
+	rel := path.Clean(key)
+	if dir := path.Dir(templateKey); dir != "." {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, dir+"/"); !ok {
+			return "", fmt.Errorf("include key %q is not in the template's directory", key)
+		}
+	}
+	if !fs.ValidPath(rel) || rel == "." || rel == sourceFileName {
+		return "", fmt.Errorf("invalid include key %q", key)
+	}
+	return rel, nil
+}
+
+// validateAssetKeys checks that there are at most maxFiles asset keys, and that every one can be
+// staged in the work directory.
+//
+// Keys are staged as-is, so keys that are absolute or contain "." or ".." elements are
+// rejected rather than cleaned, keeping assets from escaping the work directory.
+func validateAssetKeys(assetKeys []string, maxFiles int) error {
+	if len(assetKeys) > maxFiles {
+		return fmt.Errorf("too many asset files: %d, maximum %d", len(assetKeys), maxFiles)
+	}
+	for _, key := range assetKeys {
+		if !fs.ValidPath(key) || key == "." || key == sourceFileName {
+			return fmt.Errorf("invalid asset key %q", key)
+		}
+	}
+	return nil
+}
+
+// resolveData resolves the data of a validated generate request.
+//
+// CSV data files are passed through verbatim next to where the JSON data file would
+// be written, with the extension changed to .csv.
+func (s *Server) resolveData(ctx context.Context, req *GenerateRequest) (resolvedData, error) {
+	config := s.requestConfig(ctx)
+	switch {
+	case req.DataKey != "" && req.DataFormat == dataFormatCSV:
+		rawData, err := s.fetchFromBucket(ctx, req.DataKey, config.maxDataSize)
+		if err != nil {
+			return resolvedData{}, fetchError("data", req.DataKey, err)
+		}
+		rawPath := strings.TrimSuffix(config.dataFilePath, filepath.Ext(config.dataFilePath)) + ".csv"
+		return resolvedData{raw: rawData, rawPath: rawPath}, nil
+	case req.DataKey != "":
+		data, err := s.fetchData(ctx, req.DataKey, req.DataFormat)
+		if err != nil {
+			return resolvedData{}, fetchError("data", req.DataKey, err)
+		}
+		if req.MergeData {
+			data = mergeData(data, req.Data)
+		}
+		return resolvedData{values: data}, nil
+	case req.DataYAML != "":
+		data, err := parseData([]byte(req.DataYAML), dataFormatYAML)
+		if err != nil {
+			return resolvedData{}, newStatusError(http.StatusBadRequest, err)
+		}
+		return resolvedData{values: data}, nil
+	default:
+		return resolvedData{values: req.Data}, nil // May be nil, which is valid.
+	}
+}
+
+// resolveTemplate returns the inline template source or fetches it from the storage bucket,
+// along with the include and asset files of a validated generate request.
+//
+// The template and its files are fetched concurrently, at most maxConcurrentFetches at a time.
+// The first failed fetch cancels the others, and a missing file responds with 404 Not Found.
+func (s *Server) resolveTemplate(ctx context.Context, req *GenerateRequest) (resolvedTemplate, error) {
+	tmpl := resolvedTemplate{source: req.Template}
+	files, err := s.templateFiles(ctx, req)
+	if err != nil {
+		return resolvedTemplate{}, err
+	}
+
+	group, fetchCtx := errgroup.WithContext(ctx)
+	group.SetLimit(maxConcurrentFetches)
+	if req.TemplateKey != "" {
+		group.Go(func() error {
+			source, fetchErr := s.fetchTemplate(fetchCtx, req.TemplateKey, req.NoCache)
+			if fetchErr != nil {
+				return fetchError("template", req.TemplateKey, fetchErr)
+			}
+			tmpl.source = source
+			return nil
+		})
+	}
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		group.Go(func() error {
+			content, fetchErr := file.fetch(fetchCtx, file.key)
+			if fetchErr != nil {
+				return fetchError(file.kind, file.key, fetchErr)
+			}
+			contents[i] = content
+			return nil
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return resolvedTemplate{}, err
+	}
+
+	for i, file := range files {
+		if tmpl.files == nil {
+			tmpl.files = make(map[string][]byte, len(files))
+		}
+		tmpl.files[file.path] = contents[i]
+	}
+	if len(req.FontKeys) > 0 {
+		tmpl.fontDir = fontDirName
+	}
+
+	// Set the document metadata before any of the template's own rules.
+	tmpl.source = metadataPreamble(req.Metadata) + tmpl.source
+
+	return tmpl, nil
+}
+
+// templateFile is a file staged with a template, such as an include, asset or font.
+type templateFile struct {
+	// kind names the file in errors, such as "include".
+	kind string
+	// key is the key of the file in the storage bucket.
+	key string
+	// path is where the file is staged, relative to the project root.
+	path string
+	// fetch fetches the file.
+	fetch func(ctx context.Context, key string) ([]byte, error)
+}
+
+// templateFiles returns the include, asset and font files of a validated generate request, in
+// the order they're staged.
+func (s *Server) templateFiles(ctx context.Context, req *GenerateRequest) ([]templateFile, error) {
+	config := s.requestConfig(ctx)
+	fetchInclude := func(ctx context.Context, key string) ([]byte, error) {
+		return s.fetchFromBucket(ctx, key, config.maxTemplateSize)
+	}
+	fetchAsset := func(ctx context.Context, key string) ([]byte, error) {
+		return s.fetchFromBucket(ctx, key, config.maxAssetSize)
+	}
+
+	files := make([]templateFile, 0, len(req.IncludeKeys)+len(req.AssetKeys)+len(req.FontKeys))
+	for _, key := range req.IncludeKeys {
+		rel, err := includePath(req.TemplateKey, key)
+		if err != nil {
+			return nil, newStatusError(http.StatusBadRequest, err)
+		}
+		files = append(files, templateFile{kind: "include", key: key, path: rel, fetch: fetchInclude})
+	}
+	for _, key := range req.AssetKeys {
+		files = append(files, templateFile{kind: "asset", key: key, path: key, fetch: fetchAsset})
+	}
+	for _, key := range req.FontKeys {
+		files = append(files, templateFile{kind: "font", key: key, path: fontFilePath(key), fetch: s.fetchFont})
+	}
+	return files, nil
+}
+
+// stagingOptions returns the compile options and the data values to stage for a compile or
+// query of tmpl with data.
+func (s *Server) stagingOptions(
+	config *ServerConfig,
+	tmpl resolvedTemplate,
+	data resolvedData,
+	args compileArgs,
+) (compileOptions, any, error) {
+	opts := compileOptions{
+		dataPath:       config.dataFilePath,
+		files:          maps.Clone(tmpl.files),
+		args:           args,
+		observeCompile: s.metrics.observeCompile,
+		limiter:        s.compileLimiter,
+		queueTimeout:   config.compileQueueTimeout,
+		workDir:        config.workDir,
+		maxPages:       config.maxSplitPages,
+	}
+	if data.raw != nil {
+		if opts.files == nil {
+			opts.files = make(map[string][]byte, 1)
+		}
+		opts.files[data.rawPath] = data.raw
+	}
+	if config.dataAsInputs {
+		// Inputs set by the request take precedence over data values of the same name.
+		opts.args.inputs = scalarInputs(data.values)
+		maps.Copy(opts.args.inputs, args.inputs)
+	}
+	if len(opts.args.inputs) > config.maxInputs {
+		return compileOptions{}, nil, newStatusError(http.StatusBadRequest,
+			fmt.Errorf("too many inputs: %d, maximum %d", len(opts.args.inputs), config.maxInputs))
+	}
+	if err := validateInputKeys(opts.args.inputs); err != nil {
+		return compileOptions{}, nil, newStatusError(http.StatusBadRequest, err)
+	}
+
+	// Data that is null or omitted never has a data file. Empty data only has one unless skipped.
+	values := data.values
+	if config.skipEmptyData && isEmptyData(values) {
+		values = nil
+	}
+
+	return opts, values, nil
+}
+
+// compile compiles the template and data with the compile args, bounded by the compile timeout.
+func (s *Server) compile(
+	ctx context.Context,
+	tmpl resolvedTemplate,
+	data resolvedData,
+	args compileArgs,
+) ([]byte, compileUsage, error) {
+	output, usage, err := s.compileOutput(ctx, tmpl, data, args)
+	if err != nil {
+		return nil, usage, err
+	}
+	defer output.Close()
+
+	doc, err := output.bytes()
+	return doc, usage, err
+}
+
+// compileOutput compiles like compile, but returns the compiled output so it can be streamed.
+//
+// Output that is post-processed, such as split pages or an optimized PDF, is read into memory.
+// The caller closes the output.
+func (s *Server) compileOutput(
+	ctx context.Context,
+	tmpl resolvedTemplate,
+	data resolvedData,
+	args compileArgs,
+) (*compiledOutput, compileUsage, error) {
+	config := s.requestConfig(ctx)
+	ctx, cancel := context.WithTimeout(ctx, config.compileTimeout)
+	defer cancel()
+
+	var usage compileUsage
+	args.usage = &usage
+
+	opts, values, err := s.stagingOptions(config, tmpl, data, args)
+	if err != nil {
+		return nil, usage, err
+	}
+
+	// Formats split into pages are compiled as a single PDF first.
+	format := args.outputFormat()
+	if format.splitPages {
+		opts.args.format = formatPDF
+	}
+
+	output, err := compileTypstOutput(ctx, s.compiler, tmpl.source, values, opts)
+	if err != nil {
+		return nil, usage, compileError(err)
+	}
+
+	if format.splitPages || args.optimize {
+		if output, err = s.postProcess(ctx, config, output, args); err != nil {
+			return nil, usage, err
+		}
+	}
+
+	if limit := config.outputSizeLimit(args.format); limit > 0 && output.size > limit {
+		_ = output.Close()
+		return nil, usage, newStatusError(http.StatusUnprocessableEntity,
+			fmt.Errorf("output too large: %d bytes, maximum %d", output.size, limit))
+	}
+
+	return output, usage, nil
+}
+
+// postProcess splits the pages of a compiled PDF or optimizes it, as requested by args, and
+// returns the result in memory. The compiled output is closed.
+func (s *Server) postProcess(
+	ctx context.Context,
+	config *ServerConfig,
+	output *compiledOutput,
+	args compileArgs,
+) (*compiledOutput, error) {
+	doc, err := output.bytes()
+	_ = output.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if args.outputFormat().splitPages {
+		doc, err = splitPDFPages(doc, config.maxSplitPages)
+		switch {
+		case errors.Is(err, errTooManyPages):
+			return nil, newStatusError(http.StatusUnprocessableEntity, err)
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	if args.optimize {
+		doc = s.optimizePDF(ctx, doc)
+	}
+
+	return memoryOutput(doc), nil
+}
+
+// compileError returns the error of a failed compile or query with the HTTP status it maps to, if any.
+func compileError(err error) error {
+	switch {
+	case errors.Is(err, errCompilerBusy):
+		return newStatusError(http.StatusServiceUnavailable, errCompilerBusy)
+	case errors.Is(err, errTypstUnavailable), errors.Is(err, exec.ErrNotFound):
+		return newStatusError(http.StatusServiceUnavailable, errTypstUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		return newStatusError(http.StatusGatewayTimeout, errors.New("compilation timed out"))
+	case errors.Is(err, errDocumentTooComplex), errors.Is(err, errTooManyPages), errors.As(err, new(*typstError)):
+		return newStatusError(http.StatusUnprocessableEntity, err)
+	default:
+		return err
+	}
+}
+
+// outputSizeLimit returns the maximum size of a document compiled to format, or 0 if unlimited.
+//
+// A limit set for the format takes precedence over the general limit.
+func (c *ServerConfig) outputSizeLimit(format string) int64 {
+	if limit, ok := c.maxOutputSizes[cmp.Or(format, formatPDF)]; ok {
+		return limit
+	}
+	return c.maxOutputSize
+}
+
+// requestFilename returns the file name requested for a document, before it's sanitized.
+//
+// Without an explicit filename, it's the template key's base name without its extension
+// if filenameFromTemplate is set, so "invoices/invoice.typ" is downloaded as "invoice.pdf".
+func (c *ServerConfig) requestFilename(req *GenerateRequest) string {
+	if req.Filename != "" || !c.filenameFromTemplate || req.TemplateKey == "" {
+		return req.Filename
+	}
+	base := path.Base(req.TemplateKey)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// GenerateResponse is the JSON envelope returned by /generate for "Accept: application/json".
+type GenerateResponse struct {
+	// Filename is the suggested filename of the document.
+	Filename string `json:"filename"`
+	// ContentType is the content type of the document.
+	ContentType string `json:"contentType"`
+	// Warnings are the warnings typst reported when compiling the document, if any.
+	Warnings []Diagnostic `json:"warnings,omitempty"`
+	// Data is the base64-encoded document.
+	Data string `json:"data"`
+}
+
+// writeEnvelope writes the document as a GenerateResponse JSON envelope.
+//
+// The base64 payload is streamed to the writer rather than built as one string in memory.
+func writeEnvelope(w io.Writer, filename, contentType string, warnings []Diagnostic, document io.WriterTo) error {
+	filenameValue, err := json.Marshal(filename)
+	if err != nil {
+		return fmt.Errorf("marshal filename: %w", err)
+	}
+	contentTypeValue, err := json.Marshal(contentType)
+	if err != nil {
+		return fmt.Errorf("marshal content type: %w", err)
+	}
+
+	header := `{"filename":` + string(filenameValue) + `,"contentType":` + string(contentTypeValue)
+	if len(warnings) > 0 {
+		warningsValue, marshalErr := json.Marshal(warnings)
+		if marshalErr != nil {
+			return fmt.Errorf("marshal warnings: %w", marshalErr)
+		}
+		header += `,"warnings":` + string(warningsValue)
+	}
+	header += `,"data":"`
+	if _, writeErr := io.WriteString(w, header); writeErr != nil {
+		return fmt.Errorf("write header: %w", writeErr)
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, writeErr := document.WriteTo(encoder); writeErr != nil {
+		return fmt.Errorf("write data: %w", writeErr)
+	}
+	if closeErr := encoder.Close(); closeErr != nil {
+		return fmt.Errorf("write data: %w", closeErr)
+	}
+
+	if _, writeErr := io.WriteString(w, `"}`); writeErr != nil {
+		return fmt.Errorf("write trailer: %w", writeErr)
+	}
+	return nil
+}
+
+// supportedContentTypes returns the content types the server can produce, default first.
+func supportedContentTypes() []string {
+	formats := outputFormats()
+	contentTypes := []string{contentTypePDF}
+	for _, name := range slices.Sorted(maps.Keys(formats)) {
+		if contentType := formats[name].contentType; contentType != contentTypePDF {
+			contentTypes = append(contentTypes, contentType)
+		}
+	}
+	return append(contentTypes, contentTypeJSON)
+}
+
+// cleanDataFilePath normalizes a data file path to a path relative to the project root.
+//
+// Root-absolute paths ("/data/input.json") are accepted, and ".." segments can't escape
+// the root. An empty path selects the default data file name.
+func cleanDataFilePath(path string) string {
+	cleaned := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	if cleaned == "" {
+		return dataFileName
+	}
+	return cleaned
+}
+
+// isSupportedContentType reports whether the server can produce the given content type.
+func isSupportedContentType(contentType string) bool {
+	return slices.Contains(supportedContentTypes(), contentType)
+}
+
+// negotiateContentType picks the output content type for the given Accept header.
+//
+// The allowed content types are in order of preference. An empty Accept header selects
+// the first allowed content type. Otherwise the first media range (in header order)
+// matching an allowed content type wins, with support for the "*/*" and "type/*"
+// wildcards. Returns false if nothing matches or nothing is allowed.
+func negotiateContentType(accept string, allowed []string) (string, bool) {
+	if len(allowed) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return allowed[0], true
+	}
+
+	for mediaRange := range strings.SplitSeq(accept, ",") {
+		// Drop parameters such as ";q=0.9".
+		mediaRange, _, _ = strings.Cut(mediaRange, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+
+		if mediaRange == "*/*" {
+			return allowed[0], true
+		}
+		if prefix, found := strings.CutSuffix(mediaRange, "/*"); found {
+			for _, contentType := range allowed {
+				if strings.HasPrefix(contentType, prefix+"/") {
+					return contentType, true
+				}
+			}
+			continue
+		}
+		if slices.Contains(allowed, mediaRange) {
+			return mediaRange, true
+		}
+	}
+
+	return "", false
+}
+
+// openBucket returns the shared bucket handle, opening it on first use. Requests with a
+// bucket override get the bucket opened for them instead.
+//
+// A failed open is not cached, so the next call tries again.
+func (s *Server) openBucket(ctx context.Context) (*blob.Bucket, error) {
+	if override, ok := bucketOverrideFromContext(ctx); ok {
+		return override.bucket, nil
+	}
+
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+
+	if s.bucket != nil {
+		return s.bucket, nil
+	}
+
+	bucket, err := blob.OpenBucket(ctx, s.config.Load().bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket: %w", err)
+	}
+	s.bucket = bucket
+
+	return bucket, nil
 }
 
 // fetchFromBucket fetches a file from the storage bucket with size limiting.
+//
+// If a template filesystem is configured, the file is read from it instead.
 func (s *Server) fetchFromBucket(ctx context.Context, key string, maxSize int64) ([]byte, error) {
+	data, _, err := s.fetchObject(ctx, key, maxSize)
+	return data, err
+}
+
+// fetchObject fetches an object like fetchFromBucket, along with its content type.
+//
+// The content type is the one stored with the bucket object, and empty for objects read
+// from the template filesystem. Keys that are URLs are fetched over HTTP instead, and other
+// keys get the request's key prefix.
+func (s *Server) fetchObject(ctx context.Context, key string, maxSize int64) ([]byte, string, error) {
+	if isURLSource(key) {
+		return s.fetchURL(ctx, key, maxSize)
+	}
+	key = bucketKey(ctx, key)
+
+	config := s.requestConfig(ctx)
+	if config.templateFS != nil {
+		data, err := readFromFS(config.templateFS, key, maxSize)
+		if err != nil {
+			s.metrics.fetchFailed()
+		}
+		return data, "", err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
 	defer cancel()
 
-	bucket, err := blob.OpenBucket(ctx, s.config.bucketURL)
+	bucket, err := s.openBucket(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("open bucket: %w", err)
+		s.metrics.fetchFailed()
+		return nil, "", err
 	}
-	defer bucket.Close()
 
 	reader, err := bucket.NewReader(ctx, key, nil)
 	if err != nil {
-		return nil, fmt.Errorf("open key %s: %w", key, err)
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("open key %s: %w", key, err)
 	}
 	defer reader.Close()
 
 	data, err := io.ReadAll(io.LimitReader(reader, maxSize))
+	if err != nil {
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("read: %w", err)
+	}
+
+	return data, reader.ContentType(), nil
+}
+
+// fetchError returns the error of fetching a request's file of the given kind, such as "template".
+//
+// Missing keys respond with 404 Not Found, so they aren't mistaken for a fault of the server.
+// Other failures, such as an unreachable bucket, keep their status.
+func fetchError(kind, key string, err error) error {
+	if gcerrors.Code(err) == gcerrors.NotFound || errors.Is(err, fs.ErrNotExist) {
+		return newStatusError(http.StatusNotFound, fmt.Errorf("%s not found: %s", kind, key))
+	}
+	return fmt.Errorf("failed to fetch %s: %w", kind, err)
+}
+
+// readFromFS reads a file from a filesystem with size limiting.
+func readFromFS(fsys fs.FS, key string, maxSize int64) ([]byte, error) {
+	file, err := fsys.Open(key)
+	if err != nil {
+		return nil, fmt.Errorf("open key %s: %w", key, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize))
 	if err != nil {
 		return nil, fmt.Errorf("read: %w", err)
 	}
@@ -182,25 +1698,151 @@ func (s *Server) fetchFromBucket(ctx context.Context, key string, maxSize int64)
 }
 
 // fetchTemplate fetches a template from the storage bucket.
-func (s *Server) fetchTemplate(ctx context.Context, key string) (string, error) {
-	data, err := s.fetchFromBucket(ctx, key, s.config.maxTemplateSize)
+//
+// The template cache is consulted first unless noCache is set. Fetched templates
+// are always stored in the cache so a bypassing request also refreshes it.
+// Failed lookups are handled by cacheFailed, while failed stores are only logged.
+func (s *Server) fetchTemplate(ctx context.Context, key string, noCache bool) (string, error) {
+	cacheKey := templateCacheKey(ctx, key)
+	if s.templates != nil && !noCache {
+		source, ok, err := s.templates.get(ctx, cacheKey)
+		if err != nil {
+			if failErr := s.cacheFailed(ctx, key, err); failErr != nil {
+				return "", failErr
+			}
+		} else if ok {
+			return source, nil
+		}
+	}
+
+	data, err := s.fetchFromBucket(ctx, key, s.requestConfig(ctx).maxTemplateSize)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+
+	source := string(data)
+	if s.templates != nil {
+		if putErr := s.templates.put(ctx, cacheKey, source); putErr != nil {
+			// The template was fetched, so a failed store doesn't need to fail the request.
+			s.requestLogger(ctx).Warn("failed to cache template", "key", key, "error", putErr)
+		}
+	}
+	return source, nil
+}
+
+// cacheFailed handles a failed template cache lookup.
+//
+// By default the failure is logged and the request continues without the cache (fail open).
+// With cacheFailClosed, it returns a 503 error so an unavailable cache doesn't turn every
+// request into a bucket fetch.
+func (s *Server) cacheFailed(ctx context.Context, key string, err error) error {
+	if s.requestConfig(ctx).cacheFailClosed {
+		s.requestLogger(ctx).Error("template cache unavailable", "key", key, "error", err)
+		return newStatusError(http.StatusServiceUnavailable, errCacheUnavailable)
+	}
+	s.requestLogger(ctx).Warn("template cache unavailable, continuing without it", "key", key, "error", err)
+	return nil
 }
 
-// fetchData fetches a JSON data file from the storage bucket.
-func (s *Server) fetchData(ctx context.Context, key string) (map[string]any, error) {
-	rawData, err := s.fetchFromBucket(ctx, key, s.config.maxDataSize)
+// fetchData fetches a JSON or YAML data file from the storage bucket.
+func (s *Server) fetchData(ctx context.Context, key, format string) (any, error) {
+	config := s.requestConfig(ctx)
+	rawData, contentType, err := s.fetchObject(ctx, key, config.maxDataSize)
 	if err != nil {
 		return nil, err
 	}
+	if format == dataFormatJSON && !config.keepDataBOM {
+		// A byte order mark isn't valid JSON, so parsing would fail with "invalid character".
+		rawData = bytes.TrimPrefix(rawData, []byte(utf8BOM))
+	}
+	if config.checkDataContentType && format == dataFormatJSON {
+		if reason := notJSONReason(contentType, rawData); reason != "" {
+			return nil, newStatusError(http.StatusUnprocessableEntity,
+				fmt.Errorf("data file %s is not JSON: %s", key, reason))
+		}
+	}
+
+	return parseData(rawData, format)
+}
+
+// resolveDataFormat returns the format of a data file from the request hint or the key's extension.
+//
+// Keys ending in .yaml or .yml are YAML, keys ending in .csv are CSV, everything else is JSON.
+func resolveDataFormat(key, hint string) (string, error) {
+	switch strings.ToLower(hint) {
+	case dataFormatJSON:
+		return dataFormatJSON, nil
+	case dataFormatYAML, "yml":
+		return dataFormatYAML, nil
+	case dataFormatCSV:
+		return dataFormatCSV, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(key)) {
+		case ".yaml", ".yml":
+			return dataFormatYAML, nil
+		case ".csv":
+			return dataFormatCSV, nil
+		default:
+			return dataFormatJSON, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported dataFormat %q", hint)
+	}
+}
+
+// isEmptyData reports whether structured data is an empty object or array.
+func isEmptyData(data any) bool {
+	switch v := data.(type) {
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// hasStringKeys reports whether every mapping in parsed YAML data has only string keys.
+//
+// yaml.v3 decodes mappings with other keys, such as "1: one", into map[any]any.
+func hasStringKeys(data any) bool {
+	switch v := data.(type) {
+	case map[any]any:
+		return false
+	case map[string]any:
+		for _, value := range v {
+			if !hasStringKeys(value) {
+				return false
+			}
+		}
+	case []any:
+		for _, value := range v {
+			if !hasStringKeys(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseData parses raw JSON or YAML data. The top-level value may be of any type, such as an array.
+func parseData(rawData []byte, format string) (any, error) {
+	var data any
+
+	if format == dataFormatYAML {
+		if unmarshalErr := yaml.Unmarshal(rawData, &data); unmarshalErr != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", unmarshalErr)
+		}
+		if !hasStringKeys(data) {
+			// Data is written to the data file as JSON, whose object keys are always strings.
+			return nil, newStatusError(http.StatusBadRequest,
+				errors.New("invalid YAML: mapping keys must be strings, quote keys such as numbers and booleans"))
+		}
+		return data, nil
+	}
 
-	var data map[string]any
 	if unmarshalErr := json.Unmarshal(rawData, &data); unmarshalErr != nil {
 		return nil, fmt.Errorf("invalid JSON: %w", unmarshalErr)
 	}
-
 	return data, nil
 }
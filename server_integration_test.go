@@ -91,7 +91,7 @@ func TestFetchTemplate_S3(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: seaweedBucketURL})
 
-	content, err := srv.fetchTemplate(context.Background(), "test.typ")
+	content, err := srv.fetchTemplate(context.Background(), "test.typ", false)
 	if err != nil {
 		t.Fatalf("fetchTemplate() returned error: %v", err)
 	}
@@ -109,7 +109,7 @@ func TestFetchTemplate_S3_NotFound(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: seaweedBucketURL})
 
-	_, err := srv.fetchTemplate(context.Background(), "nonexistent.typ")
+	_, err := srv.fetchTemplate(context.Background(), "nonexistent.typ", false)
 	if err == nil {
 		t.Fatal("fetchTemplate() should return error for missing key")
 	}
@@ -126,11 +126,15 @@ func TestFetchData_S3(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: seaweedBucketURL})
 
-	data, err := srv.fetchData(context.Background(), "data.json")
+	fetched, err := srv.fetchData(context.Background(), "data.json", dataFormatJSON)
 	if err != nil {
 		t.Fatalf("fetchData() returned error: %v", err)
 	}
 
+	data, ok := fetched.(map[string]any)
+	if !ok {
+		t.Fatalf("expected JSON object to be a map, got %T", fetched)
+	}
 	if data["name"] != "John" {
 		t.Errorf("expected name 'John', got %v", data["name"])
 	}
@@ -147,7 +151,7 @@ func TestFetchData_S3_NotFound(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: seaweedBucketURL})
 
-	_, err := srv.fetchData(context.Background(), "nonexistent.json")
+	_, err := srv.fetchData(context.Background(), "nonexistent.json", dataFormatJSON)
 	if err == nil {
 		t.Fatal("fetchData() should return error for missing key")
 	}
@@ -163,7 +167,7 @@ func TestFetchData_S3_InvalidJSON(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: seaweedBucketURL})
 
-	_, err := srv.fetchData(context.Background(), "bad.json")
+	_, err := srv.fetchData(context.Background(), "bad.json", dataFormatJSON)
 	if err == nil {
 		t.Fatal("fetchData() should return error for invalid JSON")
 	}
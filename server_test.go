@@ -3,15 +3,26 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	_ "gocloud.dev/blob/fileblob"
 )
@@ -40,6 +51,98 @@ func setupTestBucket(t *testing.T, files map[string][]byte) string {
 	return "file://" + dir
 }
 
+// stubCompiler is a TypstCompiler that writes a fake PDF or returns an error.
+type stubCompiler struct {
+	err error
+}
+
+// Compile writes a fake PDF to the work directory or returns the configured error.
+func (c *stubCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	if c.err != nil {
+		return c.err
+	}
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte("%PDF-stub"), 0600)
+}
+
+// recordingCompiler is a TypstCompiler that records the files staged in the work directory.
+type recordingCompiler struct {
+	// files maps paths relative to the work directory to their contents.
+	files map[string]string
+	// args are the compile args of the last compilation.
+	args compileArgs
+	// workDir is the work directory of the last compilation.
+	workDir string
+}
+
+// Compile records the staged files and writes a fake PDF.
+func (c *recordingCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	c.args = args
+	c.workDir = workDir
+	c.files = map[string]string{}
+	walkErr := filepath.WalkDir(workDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		rel, _ := filepath.Rel(workDir, path)
+		c.files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte("%PDF-stub"), 0600)
+}
+
+// gatedCompiler is a TypstCompiler that blocks compilations of sources containing a marker
+// until released, and compiles everything else immediately.
+type gatedCompiler struct {
+	// marker selects the sources to block.
+	marker string
+	// started receives a value when a blocked compilation starts.
+	started chan struct{}
+	// release unblocks blocked compilations when closed.
+	release chan struct{}
+}
+
+// Compile blocks if the source contains the marker, then writes a fake PDF.
+func (c *gatedCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	source, err := os.ReadFile(filepath.Join(workDir, sourceFileName))
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(source), c.marker) {
+		c.started <- struct{}{}
+		<-c.release
+	}
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte("%PDF-stub"), 0600)
+}
+
+// blockingCompiler is a TypstCompiler that blocks until its context is done.
+type blockingCompiler struct{}
+
+// Compile waits for the context to be canceled and returns its error.
+func (c *blockingCompiler) Compile(ctx context.Context, _ string, _ compileArgs) error {
+	<-ctx.Done()
+	return fmt.Errorf("compile: %w", ctx.Err())
+}
+
+// failingCache is a sourceCache whose lookups and stores fail, like an unavailable cache backend.
+type failingCache struct{}
+
+// get returns an error.
+func (failingCache) get(context.Context, string) (string, bool, error) {
+	return "", false, errors.New("cache backend unavailable")
+}
+
+// put returns an error.
+func (failingCache) put(context.Context, string, string) error {
+	return errors.New("cache backend unavailable")
+}
+
 // TestNewServer_DefaultLimits tests the default limits.
 func TestNewServer_DefaultLimits(t *testing.T) {
 	t.Parallel()
@@ -48,11 +151,14 @@ func TestNewServer_DefaultLimits(t *testing.T) {
 		bucketURL: "file:///tmp/test",
 	})
 
-	if srv.config.maxTemplateSize != defaultMaxTemplateSize {
-		t.Errorf("expected maxTemplateSize %d, got %d", defaultMaxTemplateSize, srv.config.maxTemplateSize)
+	if srv.config.Load().maxTemplateSize != defaultMaxTemplateSize {
+		t.Errorf("expected maxTemplateSize %d, got %d", defaultMaxTemplateSize, srv.config.Load().maxTemplateSize)
 	}
-	if srv.config.maxDataSize != defaultMaxDataSize {
-		t.Errorf("expected maxDataSize %d, got %d", defaultMaxDataSize, srv.config.maxDataSize)
+	if srv.config.Load().maxDataSize != defaultMaxDataSize {
+		t.Errorf("expected maxDataSize %d, got %d", defaultMaxDataSize, srv.config.Load().maxDataSize)
+	}
+	if srv.config.Load().compileTimeout != defaultCompileTimeout {
+		t.Errorf("expected compileTimeout %v, got %v", defaultCompileTimeout, srv.config.Load().compileTimeout)
 	}
 }
 
@@ -66,11 +172,11 @@ func TestNewServer_CustomLimits(t *testing.T) {
 		maxDataSize:     1000,
 	})
 
-	if srv.config.maxTemplateSize != 500 {
-		t.Errorf("expected maxTemplateSize 500, got %d", srv.config.maxTemplateSize)
+	if srv.config.Load().maxTemplateSize != 500 {
+		t.Errorf("expected maxTemplateSize 500, got %d", srv.config.Load().maxTemplateSize)
 	}
-	if srv.config.maxDataSize != 1000 {
-		t.Errorf("expected maxDataSize 1000, got %d", srv.config.maxDataSize)
+	if srv.config.Load().maxDataSize != 1000 {
+		t.Errorf("expected maxDataSize 1000, got %d", srv.config.Load().maxDataSize)
 	}
 }
 
@@ -90,14 +196,21 @@ func TestHandleGenerate_Errors(t *testing.T) {
 			files:            map[string][]byte{},
 			reqBody:          `{}`,
 			wantStatus:       http.StatusBadRequest,
-			wantBodyContains: "templateKey is required",
+			wantBodyContains: "templateKey or template is required",
 		},
 		{
 			name:             "empty templateKey",
 			files:            map[string][]byte{},
 			reqBody:          `{"templateKey": ""}`,
 			wantStatus:       http.StatusBadRequest,
-			wantBodyContains: "templateKey is required",
+			wantBodyContains: "templateKey or template is required",
+		},
+		{
+			name:             "both template and templateKey",
+			files:            map[string][]byte{"template.typ": []byte("= Hello")},
+			reqBody:          `{"templateKey": "template.typ", "template": "= Inline"}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "cannot specify both 'template' and 'templateKey'",
 		},
 		{
 			name:             "invalid JSON body",
@@ -117,15 +230,15 @@ func TestHandleGenerate_Errors(t *testing.T) {
 			name:             "template not found",
 			files:            map[string][]byte{},
 			reqBody:          `{"templateKey": "nonexistent.typ"}`,
-			wantStatus:       http.StatusInternalServerError,
-			wantBodyContains: "failed to fetch template",
+			wantStatus:       http.StatusNotFound,
+			wantBodyContains: "template not found: nonexistent.typ",
 		},
 		{
 			name:             "dataKey not found",
 			files:            map[string][]byte{"template.typ": []byte("= Hello")},
 			reqBody:          `{"templateKey": "template.typ", "dataKey": "nonexistent.json"}`,
-			wantStatus:       http.StatusInternalServerError,
-			wantBodyContains: "failed to fetch data",
+			wantStatus:       http.StatusNotFound,
+			wantBodyContains: "data not found: nonexistent.json",
 		},
 		{
 			name: "invalid JSON in dataKey",
@@ -175,7 +288,7 @@ func TestFetchTemplate_Success(t *testing.T) {
 
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
 
-	content, err := srv.fetchTemplate(context.Background(), "test.typ")
+	content, err := srv.fetchTemplate(context.Background(), "test.typ", false)
 	if err != nil {
 		t.Fatalf("fetchTemplate() returned error: %v", err)
 	}
@@ -192,33 +305,84 @@ func TestFetchTemplate_NotFound(t *testing.T) {
 	bucketURL := setupTestBucket(t, map[string][]byte{})
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
 
-	_, err := srv.fetchTemplate(context.Background(), "nonexistent.typ")
+	_, err := srv.fetchTemplate(context.Background(), "nonexistent.typ", false)
 	if err == nil {
 		t.Fatal("fetchTemplate() should return error for missing key")
 	}
 }
 
-// TestFetchData_Success tests the fetchData success.
+// TestFetchData_Success tests that data files round-trip whatever their top-level JSON value is.
 func TestFetchData_Success(t *testing.T) {
 	t.Parallel()
 
-	dataJSON := `{"name": "John", "age": 30}`
-	bucketURL := setupTestBucket(t, map[string][]byte{
-		"data.json": []byte(dataJSON),
-	})
+	tests := []struct {
+		name     string
+		dataJSON string
+		want     any
+	}{
+		{name: "object", dataJSON: `{"name": "John", "age": 30}`, want: map[string]any{"name": "John", "age": float64(30)}},
+		{name: "array", dataJSON: `[{"name": "John"}, 2]`, want: []any{map[string]any{"name": "John"}, float64(2)}},
+		{name: "string", dataJSON: `"John"`, want: "John"},
+		{name: "number", dataJSON: `30`, want: float64(30)},
+		{name: "boolean", dataJSON: `false`, want: false},
+	}
 
-	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	data, err := srv.fetchData(context.Background(), "data.json")
-	if err != nil {
-		t.Fatalf("fetchData() returned error: %v", err)
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"data.json": []byte(tt.dataJSON),
+			})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+
+			data, err := srv.fetchData(context.Background(), "data.json", dataFormatJSON)
+			if err != nil {
+				t.Fatalf("fetchData() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("fetchData() = %#v, want %#v", data, tt.want)
+			}
+		})
 	}
+}
 
-	if data["name"] != "John" {
-		t.Errorf("expected name 'John', got %v", data["name"])
+// TestFetchData_BOM tests that a leading UTF-8 byte order mark is stripped from JSON data unless kept.
+func TestFetchData_BOM(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keepBOM bool
+		wantErr bool
+	}{
+		{name: "stripped by default"},
+		{name: "kept", keepBOM: true, wantErr: true},
 	}
-	if data["age"] != float64(30) {
-		t.Errorf("expected age 30, got %v", data["age"])
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"data.json": []byte(utf8BOM + `{"name": "John"}`),
+			})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, keepDataBOM: tt.keepBOM})
+
+			data, err := srv.fetchData(context.Background(), "data.json", dataFormatJSON)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("fetchData() should return error for a kept BOM")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchData() returned error: %v", err)
+			}
+			if want := map[string]any{"name": "John"}; !reflect.DeepEqual(data, want) {
+				t.Errorf("fetchData() = %#v, want %#v", data, want)
+			}
+		})
 	}
 }
 
@@ -229,7 +393,7 @@ func TestFetchData_NotFound(t *testing.T) {
 	bucketURL := setupTestBucket(t, map[string][]byte{})
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
 
-	_, err := srv.fetchData(context.Background(), "nonexistent.json")
+	_, err := srv.fetchData(context.Background(), "nonexistent.json", dataFormatJSON)
 	if err == nil {
 		t.Fatal("fetchData() should return error for missing key")
 	}
@@ -244,7 +408,7 @@ func TestFetchData_InvalidJSON(t *testing.T) {
 	})
 	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
 
-	_, err := srv.fetchData(context.Background(), "bad.json")
+	_, err := srv.fetchData(context.Background(), "bad.json", dataFormatJSON)
 	if err == nil {
 		t.Fatal("fetchData() should return error for invalid JSON")
 	}
@@ -334,3 +498,2279 @@ func TestHandler_RegistersRoutes(t *testing.T) {
 		t.Error("GET /health returned 404, route not registered")
 	}
 }
+
+// TestHandleGenerate_CompileErrors tests the status codes for compile failures.
+func TestHandleGenerate_CompileErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		compileErr       error
+		wantStatus       int
+		wantBodyContains string
+	}{
+		{
+			name:             "success",
+			compileErr:       nil,
+			wantStatus:       http.StatusOK,
+			wantBodyContains: "%PDF",
+		},
+		{
+			name:             "memory limit exceeded",
+			compileErr:       errDocumentTooComplex,
+			wantStatus:       http.StatusUnprocessableEntity,
+			wantBodyContains: "document too complex",
+		},
+		{
+			name:             "compile failed",
+			compileErr:       typstFailure("compile", "", "error: unexpected end of file"),
+			wantStatus:       http.StatusUnprocessableEntity,
+			wantBodyContains: `"error":"compile_failed"`,
+		},
+		{
+			name:             "package not found",
+			compileErr:       typstFailure("compile", "", "error: package not found (searched for @preview/cetz:0.2.2)"),
+			wantStatus:       http.StatusUnprocessableEntity,
+			wantBodyContains: `"error":"package_resolution_failed"`,
+		},
+		{
+			name:             "compiler failure",
+			compileErr:       errors.New("compile failed: typst exited with code 137 without any output"),
+			wantStatus:       http.StatusInternalServerError,
+			wantBodyContains: "compile failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{err: tt.compileErr}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBodyContains) {
+				t.Errorf("expected body to contain %q, got: %s", tt.wantBodyContains, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestNegotiateContentType tests the negotiateContentType function.
+func TestNegotiateContentType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		accept  string
+		allowed []string
+		want    string
+		wantOK  bool
+	}{
+		{name: "empty accept", accept: "", allowed: []string{contentTypePDF}, want: contentTypePDF, wantOK: true},
+		{
+			name:    "exact match",
+			accept:  "application/pdf",
+			allowed: []string{contentTypePDF},
+			want:    contentTypePDF,
+			wantOK:  true,
+		},
+		{name: "any type", accept: "*/*", allowed: []string{contentTypePDF}, want: contentTypePDF, wantOK: true},
+		{
+			name:    "type wildcard",
+			accept:  "application/*",
+			allowed: []string{contentTypePDF},
+			want:    contentTypePDF,
+			wantOK:  true,
+		},
+		{
+			name:    "with parameters",
+			accept:  "text/html;q=0.9, application/pdf;q=0.8",
+			allowed: []string{contentTypePDF},
+			want:    contentTypePDF,
+			wantOK:  true,
+		},
+		{
+			name:    "case insensitive",
+			accept:  "Application/PDF",
+			allowed: []string{contentTypePDF},
+			want:    contentTypePDF,
+			wantOK:  true,
+		},
+		{name: "disallowed type", accept: "image/png", allowed: []string{contentTypePDF}, want: "", wantOK: false},
+		{name: "disallowed wildcard", accept: "image/*", allowed: []string{contentTypePDF}, want: "", wantOK: false},
+		{name: "nothing allowed, empty accept", accept: "", allowed: nil, want: "", wantOK: false},
+		{name: "nothing allowed, any type", accept: "*/*", allowed: nil, want: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := negotiateContentType(tt.accept, tt.allowed)
+			if ok != tt.wantOK {
+				t.Errorf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("expected content type %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestNewServer_AllowedContentTypes tests that unsupported content types are dropped from the allowlist.
+func TestNewServer_AllowedContentTypes(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:           "file:///tmp/test",
+		allowedContentTypes: []string{"text/html", contentTypePDF},
+	})
+
+	if len(srv.config.Load().allowedContentTypes) != 1 || srv.config.Load().allowedContentTypes[0] != contentTypePDF {
+		t.Errorf("expected allowedContentTypes [%s], got %v", contentTypePDF, srv.config.Load().allowedContentTypes)
+	}
+}
+
+// TestHandleGenerate_Accept tests the Accept header validation.
+func TestHandleGenerate_Accept(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                string
+		accept              string
+		allowedContentTypes []string
+		wantStatus          int
+		wantContentType     string
+	}{
+		{name: "no accept", accept: "", wantStatus: http.StatusOK, wantContentType: contentTypePDF},
+		{name: "allowed", accept: contentTypePDF, wantStatus: http.StatusOK, wantContentType: contentTypePDF},
+		{name: "disallowed", accept: "image/svg+xml", wantStatus: http.StatusNotAcceptable, wantContentType: ""},
+		{
+			name:                "format not allowed, no accept",
+			allowedContentTypes: []string{"image/png"},
+			wantStatus:          http.StatusNotAcceptable,
+		},
+		{
+			name:                "format not allowed, any type",
+			accept:              "*/*",
+			allowedContentTypes: []string{"image/png"},
+			wantStatus:          http.StatusNotAcceptable,
+		},
+		{
+			name:                "no accept prefers format over envelope",
+			allowedContentTypes: []string{contentTypeJSON, contentTypePDF},
+			wantStatus:          http.StatusOK,
+			wantContentType:     contentTypePDF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:           bucketURL,
+				allowedContentTypes: tt.allowedContentTypes,
+			})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.wantContentType, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_JSONEnvelope tests the JSON envelope response.
+func TestHandleGenerate_JSONEnvelope(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	req.Header.Set("Accept", contentTypeJSON)
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != contentTypeJSON {
+		t.Errorf("expected Content-Type %q, got %q", contentTypeJSON, rec.Header().Get("Content-Type"))
+	}
+
+	var resp GenerateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Filename != "output.pdf" {
+		t.Errorf("expected filename %q, got %q", "output.pdf", resp.Filename)
+	}
+	if resp.ContentType != contentTypePDF {
+		t.Errorf("expected contentType %q, got %q", contentTypePDF, resp.ContentType)
+	}
+
+	pdf, err := base64.StdEncoding.DecodeString(resp.Data)
+	if err != nil {
+		t.Fatalf("failed to decode data: %v", err)
+	}
+	if string(pdf) != "%PDF-stub" {
+		t.Errorf("expected decoded data %q, got %q", "%PDF-stub", pdf)
+	}
+}
+
+// TestServer_SharedBucket tests that the bucket is opened once and reused.
+func TestServer_SharedBucket(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"test.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+
+	first, err := srv.openBucket(context.Background())
+	if err != nil {
+		t.Fatalf("openBucket() returned error: %v", err)
+	}
+	if _, fetchErr := srv.fetchTemplate(context.Background(), "test.typ", false); fetchErr != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", fetchErr)
+	}
+	second, err := srv.openBucket(context.Background())
+	if err != nil {
+		t.Fatalf("openBucket() returned error: %v", err)
+	}
+	if first != second {
+		t.Error("openBucket() should return the same bucket handle")
+	}
+
+	if closeErr := srv.Close(); closeErr != nil {
+		t.Fatalf("Close() returned error: %v", closeErr)
+	}
+	if srv.bucket != nil {
+		t.Error("Close() should release the bucket handle")
+	}
+	if closeErr := srv.Close(); closeErr != nil {
+		t.Errorf("second Close() returned error: %v", closeErr)
+	}
+}
+
+// TestFetchTemplate_Cache tests that fetchTemplate uses the template cache.
+func TestFetchTemplate_Cache(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"test.typ": []byte("= Old")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, templateCacheSize: 10})
+
+	if _, err := srv.fetchTemplate(context.Background(), "test.typ", false); err != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", err)
+	}
+
+	// Change the template in the bucket.
+	dir := strings.TrimPrefix(bucketURL, "file://")
+	if err := os.WriteFile(filepath.Join(dir, "test.typ"), []byte("= New"), 0644); err != nil {
+		t.Fatalf("failed to update template: %v", err)
+	}
+
+	content, err := srv.fetchTemplate(context.Background(), "test.typ", false)
+	if err != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", err)
+	}
+	if content != "= Old" {
+		t.Errorf("expected cached content %q, got %q", "= Old", content)
+	}
+
+	content, err = srv.fetchTemplate(context.Background(), "test.typ", true)
+	if err != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", err)
+	}
+	if content != "= New" {
+		t.Errorf("expected fresh content %q with noCache, got %q", "= New", content)
+	}
+
+	// The bypassing request refreshed the cache.
+	content, err = srv.fetchTemplate(context.Background(), "test.typ", false)
+	if err != nil {
+		t.Fatalf("fetchTemplate() returned error: %v", err)
+	}
+	if content != "= New" {
+		t.Errorf("expected refreshed content %q, got %q", "= New", content)
+	}
+}
+
+// TestHandleGenerate_CacheUnavailable tests failing open and closed when the template cache errors.
+func TestHandleGenerate_CacheUnavailable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		failClosed bool
+		body       string
+		wantStatus int
+	}{
+		{name: "fail open", body: `{"templateKey": "template.typ"}`, wantStatus: http.StatusOK},
+		{
+			name:       "fail open with noCache",
+			body:       `{"templateKey": "template.typ", "noCache": true}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "fail closed",
+			failClosed: true,
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			// The lookup is skipped, and a failed store never fails the request.
+			name:       "fail closed with noCache",
+			failClosed: true,
+			body:       `{"templateKey": "template.typ", "noCache": true}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, cacheFailClosed: tt.failClosed})
+			srv.templates = failingCache{}
+			compiler := &recordingCompiler{}
+			srv.compiler = compiler
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK && compiler.files != nil {
+				t.Error("expected no compilation when failing closed")
+			}
+			if tt.wantStatus == http.StatusOK && compiler.files[sourceFileName] != "= Hello" {
+				t.Errorf("expected template %q to be compiled, got %q", "= Hello", compiler.files[sourceFileName])
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_InlineTemplate tests generating from an inline template.
+func TestHandleGenerate_InlineTemplate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		template   string
+		wantStatus int
+	}{
+		{name: "within limit", template: "= Inline", wantStatus: http.StatusOK},
+		{name: "at limit", template: strings.Repeat("a", 16), wantStatus: http.StatusOK},
+		{name: "over limit", template: strings.Repeat("a", 17), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The bucket is empty, so any attempt to fetch a template would fail.
+			bucketURL := setupTestBucket(t, map[string][]byte{})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxTemplateSize: 16})
+			srv.compiler = &stubCompiler{}
+
+			body, _ := json.Marshal(GenerateRequest{Template: tt.template})
+			req := httptest.NewRequest(http.MethodPost, "/generate", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_InlineDataSize tests that inline data is limited to the maximum data size.
+func TestHandleGenerate_InlineDataSize(t *testing.T) {
+	t.Parallel()
+
+	// {"a":"..."} serializes to 8 bytes plus the value.
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantErr    string
+	}{
+		{
+			name:       "at limit",
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ", "data": {"a": "` + strings.Repeat("a", 24) + `"}}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "over limit",
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ", "data": {"a": "` + strings.Repeat("a", 25) + `"}}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantErr:    "data exceeds maximum size: 33 bytes, maximum 32",
+		},
+		{
+			name:       "yaml over limit",
+			path:       "/generate",
+			body:       `{"templateKey": "template.typ", "dataYaml": "a: ` + strings.Repeat("a", 32) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantErr:    "data exceeds maximum size: 35 bytes, maximum 32",
+		},
+		{
+			name: "batch item over limit",
+			path: "/generate/batch",
+			body: `{"templateKey": "template.typ", "items": [{"outputKey": "a.pdf", "data": {"a": "` +
+				strings.Repeat("a", 25) + `"}}]}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantErr:    "item 0: data exceeds maximum size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxDataSize: 32})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestCleanDataFilePath tests the cleanDataFilePath function.
+func TestCleanDataFilePath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "", want: dataFileName},
+		{path: "/", want: dataFileName},
+		{path: "data.json", want: "data.json"},
+		{path: "/data/input.json", want: "data/input.json"},
+		{path: "data/./input.json", want: "data/input.json"},
+		{path: "../../etc/passwd", want: "etc/passwd"},
+		{path: "/data/../../input.json", want: "input.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			if got := cleanDataFilePath(tt.path); got != tt.want {
+				t.Errorf("cleanDataFilePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequestLogger_DebugSampling tests that roughly the configured fraction of requests log at debug level.
+func TestRequestLogger_DebugSampling(t *testing.T) {
+	t.Parallel()
+
+	const (
+		requests   = 2000
+		sampleRate = 0.25
+		tolerance  = 0.05
+	)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	srv := NewServer(logger, ServerConfig{bucketURL: "file:///tmp/test", debugSampleRate: sampleRate})
+
+	for range requests {
+		srv.requestLogger(context.Background()).Debug("sampled")
+		srv.requestLogger(context.Background()).Info("always")
+	}
+
+	debugCount := strings.Count(buf.String(), `"level":"DEBUG"`)
+	infoCount := strings.Count(buf.String(), `"level":"INFO"`)
+
+	if infoCount != requests {
+		t.Errorf("expected %d info records, got %d", requests, infoCount)
+	}
+
+	fraction := float64(debugCount) / requests
+	if fraction < sampleRate-tolerance || fraction > sampleRate+tolerance {
+		t.Errorf("expected about %.2f of requests to log at debug, got %.3f", sampleRate, fraction)
+	}
+}
+
+// TestRequestLogger_NoSampling tests that debug records are dropped when sampling is disabled.
+func TestRequestLogger_NoSampling(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	srv := NewServer(logger, ServerConfig{bucketURL: "file:///tmp/test"})
+
+	for range 100 {
+		srv.requestLogger(context.Background()).Debug("sampled")
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no debug records, got: %s", buf.String())
+	}
+}
+
+// TestHandleGenerate_DebugSampling tests that a sampled request logs its resolved parameters.
+func TestHandleGenerate_DebugSampling(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(logger, ServerConfig{bucketURL: bucketURL, debugSampleRate: 1})
+	srv.compiler = &stubCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if !strings.Contains(buf.String(), `"templateKey":"template.typ"`) {
+		t.Errorf("expected debug record with templateKey, got: %s", buf.String())
+	}
+}
+
+// TestHandleGenerate_CompileTimeout tests that a compilation exceeding the timeout returns 504.
+func TestHandleGenerate_CompileTimeout(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, compileTimeout: 50 * time.Millisecond})
+	srv.compiler = &blockingCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status %d, got %d", http.StatusGatewayTimeout, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "compilation timed out") {
+		t.Errorf("expected body to contain %q, got: %s", "compilation timed out", rec.Body.String())
+	}
+}
+
+// TestFetchData_YAML tests fetching YAML data files.
+func TestFetchData_YAML(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"data.yaml": []byte("name: John\nage: 30\naddress:\n  city: Berlin\n"),
+		"bad.yaml":  []byte("name: [unclosed"),
+	})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+
+	fetched, err := srv.fetchData(context.Background(), "data.yaml", dataFormatYAML)
+	if err != nil {
+		t.Fatalf("fetchData() returned error: %v", err)
+	}
+	data, ok := fetched.(map[string]any)
+	if !ok {
+		t.Fatalf("expected YAML mapping to be a map, got %T", fetched)
+	}
+	if data["name"] != "John" {
+		t.Errorf("expected name 'John', got %v", data["name"])
+	}
+	if data["age"] != 30 {
+		t.Errorf("expected age 30, got %v", data["age"])
+	}
+
+	// Nested maps must still be serializable as JSON for the template.
+	if _, marshalErr := json.Marshal(data); marshalErr != nil {
+		t.Errorf("YAML data should marshal to JSON: %v", marshalErr)
+	}
+
+	_, err = srv.fetchData(context.Background(), "bad.yaml", dataFormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "invalid YAML") {
+		t.Errorf("expected 'invalid YAML' error, got: %v", err)
+	}
+}
+
+// TestResolveDataFormat tests the resolveDataFormat function.
+func TestResolveDataFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		hint    string
+		want    string
+		wantErr bool
+	}{
+		{name: "json extension", key: "data.json", hint: "", want: dataFormatJSON},
+		{name: "yaml extension", key: "data.yaml", hint: "", want: dataFormatYAML},
+		{name: "yml extension", key: "data.YML", hint: "", want: dataFormatYAML},
+		{name: "no extension", key: "data", hint: "", want: dataFormatJSON},
+		{name: "csv extension", key: "rows.csv", hint: "", want: dataFormatCSV},
+		{name: "csv hint", key: "rows.txt", hint: "csv", want: dataFormatCSV},
+		{name: "hint overrides extension", key: "data.txt", hint: "yaml", want: dataFormatYAML},
+		{name: "json hint", key: "data.yaml", hint: "json", want: dataFormatJSON},
+		{name: "unknown hint", key: "data.json", hint: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := resolveDataFormat(tt.key, tt.hint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveDataFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expected format %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_YAMLData tests the YAML data request validation.
+func TestHandleGenerate_YAMLData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		reqBody          string
+		wantStatus       int
+		wantBodyContains string
+	}{
+		{
+			name:       "yaml data key",
+			reqBody:    `{"templateKey": "template.typ", "dataKey": "data.yml"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "inline yaml",
+			reqBody:    `{"templateKey": "template.typ", "dataYaml": "name: John"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:             "invalid inline yaml",
+			reqBody:          `{"templateKey": "template.typ", "dataYaml": "name: [unclosed"}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "invalid YAML",
+		},
+		{
+			name:             "inline yaml with non-string key",
+			reqBody:          `{"templateKey": "template.typ", "dataYaml": "items:\n  1: one"}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "mapping keys must be strings",
+		},
+		{
+			name:             "yaml data key with non-string key",
+			reqBody:          `{"templateKey": "template.typ", "dataKey": "numbers.yml"}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "mapping keys must be strings",
+		},
+		{
+			name:             "inline yaml with data",
+			reqBody:          `{"templateKey": "template.typ", "dataYaml": "name: John", "data": {"name": "Jane"}}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "cannot specify 'dataYaml'",
+		},
+		{
+			name:             "unsupported data format",
+			reqBody:          `{"templateKey": "template.typ", "dataKey": "data.yml", "dataFormat": "toml"}`,
+			wantStatus:       http.StatusBadRequest,
+			wantBodyContains: "unsupported dataFormat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"template.typ": []byte("= Hello"),
+				"data.yml":     []byte("name: John"),
+				"numbers.yml":  []byte("- true: yes"),
+			})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBodyContains) {
+				t.Errorf("expected body to contain %q, got: %s", tt.wantBodyContains, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_CSVData tests that CSV data files are staged verbatim.
+func TestHandleGenerate_CSVData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		dataFilePath string
+		wantPath     string
+	}{
+		{name: "default data path", dataFilePath: "", wantPath: "data.csv"},
+		{name: "custom data path", dataFilePath: "/data/input.json", wantPath: "data/input.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			csvData := "name,amount\nAcme,1000\n"
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"template.typ": []byte("= Hello"),
+				"rows.csv":     []byte(csvData),
+			})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, dataFilePath: tt.dataFilePath})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ", "dataKey": "rows.csv"}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if compiler.files[tt.wantPath] != csvData {
+				t.Errorf("expected %s to contain %q, got files: %v", tt.wantPath, csvData, compiler.files)
+			}
+			if _, ok := compiler.files[dataFileName]; ok {
+				t.Errorf("expected no %s for CSV data, got files: %v", dataFileName, compiler.files)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_DataAsInputs tests that scalar data values are passed as typst inputs.
+func TestHandleGenerate_DataAsInputs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		dataAsInputs bool
+		wantInputs   map[string]string
+	}{
+		{name: "disabled", dataAsInputs: false, wantInputs: nil},
+		{name: "enabled", dataAsInputs: true, wantInputs: map[string]string{"title": "Invoice", "total": "42.5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, dataAsInputs: tt.dataAsInputs})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ", "data": {"title": "Invoice", "total": 42.5, "items": [1, 2]}}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if !maps.Equal(compiler.args.inputs, tt.wantInputs) {
+				t.Errorf("expected inputs %v, got %v", tt.wantInputs, compiler.args.inputs)
+			}
+			if !strings.Contains(compiler.files[dataFileName], `"items"`) {
+				t.Errorf("expected %s to contain nested values, got %q", dataFileName, compiler.files[dataFileName])
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_MaxInputs tests that requests exceeding the input cap are rejected before compiling.
+func TestHandleGenerate_MaxInputs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		maxInputs  int
+		wantStatus int
+	}{
+		{name: "at the cap", maxInputs: 2, wantStatus: http.StatusOK},
+		{name: "over the cap", maxInputs: 1, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", dataAsInputs: true, maxInputs: tt.maxInputs})
+			srv.compiler = compiler
+
+			reqBody := `{"template": "= Hello", "data": {"title": "Invoice", "total": 42.5, "items": [1, 2]}}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK && compiler.files != nil {
+				t.Error("expected the compiler not to run")
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_InvalidInputKeys tests that data values whose names can't be typst inputs are rejected.
+func TestHandleGenerate_InvalidInputKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		data       string
+		wantStatus int
+	}{
+		{name: "valid", data: `{"title": "a=b"}`, wantStatus: http.StatusOK},
+		{name: "empty name", data: `{"": "x"}`, wantStatus: http.StatusBadRequest},
+		{name: "name with equals sign", data: `{"a=b": "x"}`, wantStatus: http.StatusBadRequest},
+		{name: "nested value", data: `{"a=b": {"c": 1}}`, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", dataAsInputs: true})
+			srv.compiler = compiler
+
+			reqBody := `{"template": "= Hello", "data": ` + tt.data + `}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK && compiler.files != nil {
+				t.Error("expected the compiler not to run")
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_IncludeKeys tests that include files are staged next to the template.
+func TestHandleGenerate_IncludeKeys(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"invoices/invoice.typ":      []byte(`#import "common.typ": *`),
+		"invoices/common.typ":       []byte("#let total = 1"),
+		"invoices/parts/header.typ": []byte("= Header"),
+	})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+
+	reqBody := `{
+		"templateKey": "invoices/invoice.typ",
+		"includeKeys": ["invoices/common.typ", "invoices/parts/header.typ"]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	want := map[string]string{
+		sourceFileName:     `#import "common.typ": *`,
+		"common.typ":       "#let total = 1",
+		"parts/header.typ": "= Header",
+	}
+	for name, content := range want {
+		if compiler.files[name] != content {
+			t.Errorf("expected %s to contain %q, got files: %v", name, content, compiler.files)
+		}
+	}
+}
+
+// TestHandleGenerate_IncludeKeysValidation tests that invalid include keys are rejected.
+func TestHandleGenerate_IncludeKeysValidation(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, defaultMaxIncludeFiles+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("part%d.typ", i)
+	}
+	tooManyJSON, err := json.Marshal(tooMany)
+	if err != nil {
+		t.Fatalf("failed to marshal include keys: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		templateKey string
+		includeKeys string
+		wantErr     string
+	}{
+		{
+			name:        "too many",
+			templateKey: "template.typ",
+			includeKeys: string(tooManyJSON),
+			wantErr:     "too many include files",
+		},
+		{
+			name:        "outside template directory",
+			templateKey: "invoices/invoice.typ",
+			includeKeys: `["receipts/common.typ"]`,
+			wantErr:     "not in the template's directory",
+		},
+		{
+			name:        "traversal out of template directory",
+			templateKey: "invoices/invoice.typ",
+			includeKeys: `["invoices/../secret.typ"]`,
+			wantErr:     `must not contain ".." segments`,
+		},
+		{
+			name:        "traversal out of root",
+			templateKey: "template.typ",
+			includeKeys: `["../secret.typ"]`,
+			wantErr:     "invalid include key",
+		},
+		{
+			name:        "overwrites main template",
+			templateKey: "template.typ",
+			includeKeys: `["main.typ"]`,
+			wantErr:     "invalid include key",
+		},
+		{
+			name:        "overwrites data file",
+			templateKey: "template.typ",
+			includeKeys: `["data.json"]`,
+			wantErr:     "data.json is reserved",
+		},
+		{
+			name:        "overwrites output file",
+			templateKey: "invoices/invoice.typ",
+			includeKeys: `["invoices/output.pdf"]`,
+			wantErr:     "output.pdf is reserved",
+		},
+		{
+			name:        "in page directory",
+			templateKey: "template.typ",
+			includeKeys: `[".pages/1.svg"]`,
+			wantErr:     ".pages/1.svg is reserved",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test"})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": %q, "includeKeys": %s}`, tt.templateKey, tt.includeKeys)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_FileLimits tests the MAX_INCLUDE_FILES and MAX_ASSET_FILES caps at their boundary.
+func TestHandleGenerate_FileLimits(t *testing.T) {
+	t.Parallel()
+
+	files := map[string][]byte{"template.typ": []byte("= Hello")}
+	for i := range 3 {
+		files[fmt.Sprintf("part%d.typ", i)] = []byte("= Part")
+		files[fmt.Sprintf("image%d.png", i)] = []byte("PNG")
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantErr    string
+	}{
+		{
+			name:       "includes at the cap",
+			body:       `{"templateKey": "template.typ", "includeKeys": ["part0.typ", "part1.typ"]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "includes over the cap",
+			body:       `{"templateKey": "template.typ", "includeKeys": ["part0.typ", "part1.typ", "part2.typ"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "too many include files: 3, maximum 2",
+		},
+		{
+			name:       "assets at the cap",
+			body:       `{"templateKey": "template.typ", "assetKeys": ["image0.png", "image1.png"]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "assets over the cap",
+			body:       `{"templateKey": "template.typ", "assetKeys": ["image0.png", "image1.png", "image2.png"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "too many asset files: 3, maximum 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, files)
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxIncludeFiles: 2, maxAssetFiles: 2})
+			srv.compiler = &stubCompiler{}
+
+			rec := postGenerate(t, srv, tt.body, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected body to contain %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_AssetKeys tests that asset files are staged under their keys.
+func TestHandleGenerate_AssetKeys(t *testing.T) {
+	t.Parallel()
+
+	logo := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"template.typ":      []byte(`#image("images/logo.png")`),
+		"images/logo.png":   logo,
+		"images/banner.png": []byte("banner"),
+	})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+
+	reqBody := `{"templateKey": "template.typ", "assetKeys": ["images/logo.png"]}`
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if compiler.files["images/logo.png"] != string(logo) {
+		t.Errorf("expected images/logo.png to be staged, got files: %v", compiler.files)
+	}
+	if _, ok := compiler.files["images/banner.png"]; ok {
+		t.Errorf("expected unrequested asset not to be staged, got files: %v", compiler.files)
+	}
+}
+
+// TestValidateKey tests that keys able to reach outside the bucket are rejected.
+func TestValidateKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr string
+	}{
+		{name: "plain", key: "template.typ"},
+		{name: "nested", key: "invoices/2024/invoice.typ"},
+		{name: "dots in name", key: "invoice..v2.typ"},
+		{name: "parent", key: "../../etc/passwd", wantErr: `".." segments`},
+		{name: "inner parent", key: "invoices/../../secret.typ", wantErr: `".." segments`},
+		{name: "trailing parent", key: "invoices/..", wantErr: `".." segments`},
+		{name: "backslash parent", key: `invoices\..\secret.typ`, wantErr: `".." segments`},
+		{name: "leading slash", key: "/etc/passwd", wantErr: "must not start with a slash"},
+		{name: "leading backslash", key: `\etc\passwd`, wantErr: "must not start with a slash"},
+		{name: "null byte", key: "template.typ\x00.json", wantErr: "null byte"},
+		{name: "scheme", key: "file:///etc/passwd", wantErr: "URL scheme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateKey("templateKey", tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateKey() returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_KeyValidation tests that requests with unsafe keys get 400 before anything is fetched.
+func TestHandleGenerate_KeyValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		reqBody string
+		wantErr string
+	}{
+		{name: "templateKey", reqBody: `{"templateKey": "../secret.typ"}`, wantErr: "invalid templateKey"},
+		{
+			name:    "dataKey",
+			reqBody: `{"templateKey": "template.typ", "dataKey": "/etc/passwd.json"}`,
+			wantErr: "invalid dataKey",
+		},
+		{
+			name:    "assetKeys",
+			reqBody: `{"templateKey": "template.typ", "assetKeys": ["logo\u0000.png"]}`,
+			wantErr: "invalid asset key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///nonexistent"})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestReservedPath tests the paths include and asset files can't be staged at.
+func TestReservedPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rel      string
+		dataPath string
+		want     bool
+	}{
+		{rel: "main.typ", want: true},
+		{rel: "data.json", want: true},
+		{rel: "data.csv", want: true},
+		{rel: "output.zip", want: true},
+		{rel: "parts/data.json", want: false},
+		{rel: "data.json", dataPath: "input/values.json", want: false},
+		{rel: "input/values.json", dataPath: "input/values.json", want: true},
+		{rel: "input/values.csv", dataPath: "input/values.json", want: true},
+		{rel: "logo.png", want: false},
+		{rel: ".fonts/Inter.otf", want: true},
+		{rel: ".fonts", want: true},
+		{rel: ".fontsx/Inter.otf", want: false},
+		{rel: ".pages/1.svg", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rel+" "+tt.dataPath, func(t *testing.T) {
+			t.Parallel()
+
+			if got := reservedPath(tt.rel, tt.dataPath); got != tt.want {
+				t.Errorf("reservedPath(%q, %q) = %v, want %v", tt.rel, tt.dataPath, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_AssetKeysValidation tests that asset keys escaping the work directory are rejected.
+func TestHandleGenerate_AssetKeysValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		assetKeys string
+	}{
+		{name: "parent directory", assetKeys: `["../secret.png"]`},
+		{name: "nested parent directory", assetKeys: `["images/../../secret.png"]`},
+		{name: "absolute path", assetKeys: `["/etc/passwd"]`},
+		{name: "empty key", assetKeys: `[""]`},
+		{name: "overwrites main template", assetKeys: `["main.typ"]`},
+		{name: "overwrites data file", assetKeys: `["data.json"]`},
+		{name: "overwrites CSV data file", assetKeys: `["data.csv"]`},
+		{name: "overwrites output file", assetKeys: `["output.png"]`},
+		{name: "in font directory", assetKeys: `[".fonts/Inter.otf"]`},
+		{name: "in page directory", assetKeys: `[".pages/1.svg"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test"})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "assetKeys": %s}`, tt.assetKeys)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "invalid asset key") {
+				t.Errorf("expected invalid asset key error, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Pages tests validating the page selection and passing it to the compiler.
+func TestHandleGenerate_Pages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		format     string
+		pages      string
+		wantStatus int
+		wantArgs   []string
+	}{
+		{name: "pdf range", pages: "1-3", wantStatus: http.StatusOK, wantArgs: []string{"--pages", "1-3"}},
+		{name: "pdf list", pages: "2, 4", wantStatus: http.StatusOK, wantArgs: []string{"--pages", "2,4"}},
+		{name: "png single page", format: "png", pages: "2", wantStatus: http.StatusOK, wantArgs: []string{"--pages", "2"}},
+		{name: "png default first page", format: "png", wantStatus: http.StatusOK, wantArgs: []string{"--pages", "1"}},
+		{name: "png multiple pages", format: "png", pages: "1-2", wantStatus: http.StatusBadRequest},
+		{name: "svg open range", format: "svg", pages: "2-", wantStatus: http.StatusBadRequest},
+		{name: "malformed", pages: "1-x", wantStatus: http.StatusBadRequest},
+		{name: "reversed", pages: "3-1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://"})
+			compiler := &recordingCompiler{}
+			srv.compiler = compiler
+
+			reqBody := fmt.Sprintf(`{"template": "= Hello", "format": %q, "pages": %q}`, tt.format, tt.pages)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if compiler.files != nil {
+					t.Error("expected the compiler not to run for an invalid page selection")
+				}
+				return
+			}
+			args := compiler.args.args()
+			if i := slices.Index(args, "--pages"); i < 0 || !slices.Equal(args[i:i+2], tt.wantArgs) {
+				t.Errorf("expected args to contain %q, got %q", tt.wantArgs, args)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Format tests that the output format selects the file name and content type.
+func TestHandleGenerate_Format(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		format          string
+		accept          string
+		wantStatus      int
+		wantContentType string
+		wantFilename    string
+	}{
+		{
+			name:            "default",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/pdf",
+			wantFilename:    "output.pdf",
+		},
+		{
+			name:            "png",
+			format:          "png",
+			wantStatus:      http.StatusOK,
+			wantContentType: "image/png",
+			wantFilename:    "output.png",
+		},
+		{
+			name:            "svg accepted explicitly",
+			format:          "SVG",
+			accept:          "image/svg+xml",
+			wantStatus:      http.StatusOK,
+			wantContentType: "image/svg+xml",
+			wantFilename:    "output.svg",
+		},
+		{name: "accept mismatch", format: "png", accept: "application/pdf", wantStatus: http.StatusNotAcceptable},
+		{name: "unsupported format", format: "docx", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "format": %q}`, tt.format)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != tt.wantContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.wantContentType, contentType)
+			}
+			wantDisposition := "inline; filename=\"" + tt.wantFilename + "\""
+			if disposition := rec.Header().Get("Content-Disposition"); disposition != wantDisposition {
+				t.Errorf("expected Content-Disposition %q, got %q", wantDisposition, disposition)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Filename tests that the requested file name is used in the response.
+func TestHandleGenerate_Filename(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		filename     string
+		fromTemplate bool
+		accept       string
+		wantStatus   int
+		wantName     string
+		wantInBody   bool
+	}{
+		{name: "raw", filename: "invoice-42", wantStatus: http.StatusOK, wantName: "invoice-42.pdf"},
+		{name: "default", wantStatus: http.StatusOK, wantName: "output.pdf"},
+		{name: "from template", fromTemplate: true, wantStatus: http.StatusOK, wantName: "template.pdf"},
+		{
+			name:         "explicit over template",
+			filename:     "invoice-42",
+			fromTemplate: true,
+			wantStatus:   http.StatusOK,
+			wantName:     "invoice-42.pdf",
+		},
+		{
+			name:       "envelope",
+			filename:   "../invoice-42.pdf",
+			accept:     contentTypeJSON,
+			wantStatus: http.StatusOK,
+			wantName:   "invoice-42.pdf",
+			wantInBody: true,
+		},
+		{name: "too long", filename: strings.Repeat("a", maxFilenameLength+1), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, filenameFromTemplate: tt.fromTemplate})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "filename": %q}`, tt.filename)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if tt.wantInBody {
+				var resp GenerateResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp.Filename != tt.wantName {
+					t.Errorf("expected filename %q, got %q", tt.wantName, resp.Filename)
+				}
+				return
+			}
+			wantDisposition := "inline; filename=\"" + tt.wantName + "\""
+			if disposition := rec.Header().Get("Content-Disposition"); disposition != wantDisposition {
+				t.Errorf("expected Content-Disposition %q, got %q", wantDisposition, disposition)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_MetaOnly tests that metadata-only requests signal success with an empty body.
+func TestHandleGenerate_MetaOnly(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		compileErr error
+		accept     string
+		wantStatus int
+		wantEmpty  bool
+	}{
+		{name: "success", wantStatus: http.StatusOK, wantEmpty: true},
+		{name: "success with envelope", accept: contentTypeJSON, wantStatus: http.StatusOK, wantEmpty: true},
+		{
+			name:       "failure",
+			compileErr: typstFailure("compile", "", "error: unclosed delimiter"),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &stubCompiler{err: tt.compileErr}
+
+			reqBody := `{"templateKey": "template.typ", "metaOnly": true}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if empty := rec.Body.Len() == 0; empty != tt.wantEmpty {
+				t.Errorf("expected empty body %v, got %q", tt.wantEmpty, rec.Body.String())
+			}
+			if tt.wantEmpty && rec.Header().Get("Content-Disposition") != "" {
+				t.Errorf("expected no Content-Disposition, got %q", rec.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+// TestServerConfig_RequestFilename tests deriving the default file name from the template key.
+func TestServerConfig_RequestFilename(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		fromTemplate bool
+		req          GenerateRequest
+		want         string
+	}{
+		{name: "disabled", req: GenerateRequest{TemplateKey: "invoice.typ"}, want: ""},
+		{name: "base name", fromTemplate: true, req: GenerateRequest{TemplateKey: "invoices/invoice.typ"}, want: "invoice"},
+		{name: "no extension", fromTemplate: true, req: GenerateRequest{TemplateKey: "reports/summary"}, want: "summary"},
+		{name: "dotted name", fromTemplate: true, req: GenerateRequest{TemplateKey: "q1.2024.typ"}, want: "q1.2024"},
+		{name: "inline template", fromTemplate: true, req: GenerateRequest{Template: "= Hello"}, want: ""},
+		{
+			name:         "explicit filename",
+			fromTemplate: true,
+			req:          GenerateRequest{TemplateKey: "invoice.typ", Filename: "invoice-42"},
+			want:         "invoice-42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := ServerConfig{filenameFromTemplate: tt.fromTemplate}
+			if got := config.requestFilename(&tt.req); got != tt.want {
+				t.Errorf("requestFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_Watermark tests that the watermark is passed to typst as an input.
+func TestHandleGenerate_Watermark(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		watermark    string
+		dataAsInputs bool
+		wantStatus   int
+		wantInputs   map[string]string
+	}{
+		{name: "none", wantStatus: http.StatusOK},
+		{name: "draft", watermark: "DRAFT", wantStatus: http.StatusOK, wantInputs: map[string]string{"watermark": "DRAFT"}},
+		{
+			name:         "overrides data",
+			watermark:    "DRAFT",
+			dataAsInputs: true,
+			wantStatus:   http.StatusOK,
+			wantInputs:   map[string]string{"watermark": "DRAFT", "title": "Report"},
+		},
+		{
+			name:       "at maximum length",
+			watermark:  strings.Repeat("é", maxWatermarkLength),
+			wantStatus: http.StatusOK,
+			wantInputs: map[string]string{"watermark": strings.Repeat("é", maxWatermarkLength)},
+		},
+		{name: "too long", watermark: strings.Repeat("x", maxWatermarkLength+1), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &pdfCompiler{pdf: []byte("%PDF-stub")}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, dataAsInputs: tt.dataAsInputs})
+			srv.compiler = compiler
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "watermark": %q,
+				"data": {"title": "Report", "watermark": "from data"}}`, tt.watermark)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if !maps.Equal(compiler.args.inputs, tt.wantInputs) {
+				t.Errorf("expected inputs %v, got %v", tt.wantInputs, compiler.args.inputs)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_TemplateConcurrency tests that a template at its limit doesn't starve other templates.
+func TestHandleGenerate_TemplateConcurrency(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"hot.typ":  []byte("= Hot"),
+		"cold.typ": []byte("= Cold"),
+	})
+	compiler := &gatedCompiler{marker: "Hot", started: make(chan struct{}), release: make(chan struct{})}
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:             bucketURL,
+		templateConcurrency:   map[string]int{"hot.typ": 1},
+		maxConcurrentCompiles: 2,
+	})
+	srv.compiler = compiler
+
+	generate := func(templateKey string) int {
+		reqBody := fmt.Sprintf(`{"templateKey": %q}`, templateKey)
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+		rec := httptest.NewRecorder()
+		srv.handleGenerate(rec, req)
+		return rec.Code
+	}
+
+	// Saturate the hot template's limit with a blocked request.
+	firstStatus := make(chan int, 1)
+	go func() { firstStatus <- generate("hot.typ") }()
+	<-compiler.started
+
+	if status := generate("hot.typ"); status != http.StatusServiceUnavailable {
+		t.Errorf("expected over-limit request status %d, got %d", http.StatusServiceUnavailable, status)
+	}
+	if status := generate("cold.typ"); status != http.StatusOK {
+		t.Errorf("expected other template status %d, got %d", http.StatusOK, status)
+	}
+
+	close(compiler.release)
+	if status := <-firstStatus; status != http.StatusOK {
+		t.Errorf("expected blocked request status %d, got %d", http.StatusOK, status)
+	}
+}
+
+// TestHandler_Auth tests that /generate and /templates require the bearer token when configured.
+func TestHandler_Auth(t *testing.T) {
+	t.Parallel()
+
+	const token = "s3cret"
+
+	tests := []struct {
+		name          string
+		authToken     string
+		method        string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "generate without auth configured", method: http.MethodPost, path: "/generate", wantStatus: http.StatusOK},
+		{
+			name:       "generate missing token",
+			authToken:  token,
+			method:     http.MethodPost,
+			path:       "/generate",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "generate wrong token",
+			authToken:     token,
+			method:        http.MethodPost,
+			path:          "/generate",
+			authorization: "Bearer wrong",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "generate wrong scheme",
+			authToken:     token,
+			method:        http.MethodPost,
+			path:          "/generate",
+			authorization: "Basic " + token,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "generate valid token",
+			authToken:     token,
+			method:        http.MethodPost,
+			path:          "/generate",
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:       "templates missing token",
+			authToken:  token,
+			method:     http.MethodGet,
+			path:       "/templates",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "templates valid token",
+			authToken:     token,
+			method:        http.MethodGet,
+			path:          "/templates",
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, authToken: tt.authToken})
+			srv.compiler = &stubCompiler{}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"templateKey": "template.typ"}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandler_AuthHealthUnauthenticated tests that /health doesn't require the bearer token.
+func TestHandler_AuthHealthUnauthenticated(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test", authToken: "s3cret"})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if rec.Code == http.StatusUnauthorized {
+		t.Error("expected /health not to require authentication")
+	}
+}
+
+// TestHandleGenerate_NoUsageHeaders tests that usage headers are omitted when the compiler doesn't record usage.
+func TestHandleGenerate_NoUsageHeaders(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	for _, header := range []string{"X-Compile-CPU-Ms", "X-Compile-MaxRSS-KB"} {
+		if value := rec.Header().Get(header); value != "" {
+			t.Errorf("expected no %s header, got %q", header, value)
+		}
+	}
+}
+
+// TestHandleGenerate_EmptyData tests when a data file is written for null, omitted and empty data.
+func TestHandleGenerate_EmptyData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		data          string
+		skipEmptyData bool
+		wantDataFile  bool
+	}{
+		{name: "omitted", data: "", wantDataFile: false},
+		{name: "null", data: `, "data": null`, wantDataFile: false},
+		{name: "empty object", data: `, "data": {}`, wantDataFile: true},
+		{name: "non-empty object", data: `, "data": {"a": 1}`, wantDataFile: true},
+		{name: "omitted skipping empty", data: "", skipEmptyData: true, wantDataFile: false},
+		{name: "null skipping empty", data: `, "data": null`, skipEmptyData: true, wantDataFile: false},
+		{name: "empty object skipping empty", data: `, "data": {}`, skipEmptyData: true, wantDataFile: false},
+		{name: "non-empty object skipping empty", data: `, "data": {"a": 1}`, skipEmptyData: true, wantDataFile: true},
+		{name: "empty array skipping empty", data: `, "data": []`, skipEmptyData: true, wantDataFile: false},
+		{name: "scalar skipping empty", data: `, "data": 0`, skipEmptyData: true, wantDataFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, skipEmptyData: tt.skipEmptyData})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ"` + tt.data + `}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if _, ok := compiler.files[dataFileName]; ok != tt.wantDataFile {
+				t.Errorf("expected data file written %v, got files: %v", tt.wantDataFile, compiler.files)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_NonObjectData tests that data whose top-level value isn't an object is staged as-is.
+func TestHandleGenerate_NonObjectData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		data     string
+		wantFile string
+	}{
+		{name: "array", data: `[{"name": "John"}, 2]`, wantFile: "[\n  {\n    \"name\": \"John\"\n  },\n  2\n]"},
+		{name: "string", data: `"John"`, wantFile: `"John"`},
+		{name: "number", data: `1.5`, wantFile: "1.5"},
+		{name: "boolean", data: `true`, wantFile: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ", "data": ` + tt.data + `}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if got := compiler.files[dataFileName]; got != tt.wantFile {
+				t.Errorf("expected data file %q, got %q", tt.wantFile, got)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_CompilerBusy tests that a request that can't get a compile slot in time gets 503.
+func TestHandleGenerate_CompilerBusy(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	compiler := &gatedCompiler{marker: "Hello", started: make(chan struct{}), release: make(chan struct{})}
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:             bucketURL,
+		maxConcurrentCompiles: 1,
+		compileTimeout:        50 * time.Millisecond,
+	})
+	srv.compiler = compiler
+
+	generate := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/generate",
+			strings.NewReader(`{"templateKey": "template.typ"}`))
+		rec := httptest.NewRecorder()
+		srv.handleGenerate(rec, req)
+		return rec
+	}
+
+	// Occupy the only compile slot.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		generate(context.Background())
+	}()
+	<-compiler.started
+	t.Cleanup(func() {
+		close(compiler.release)
+		<-done
+	})
+
+	if depth := srv.compileLimiter.queueDepth(); depth != 0 {
+		t.Errorf("expected queue depth 0, got %d", depth)
+	}
+
+	rec := generate(context.Background())
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "too many concurrent compilations") {
+		t.Errorf("expected busy message, got %q", rec.Body.String())
+	}
+}
+
+// TestHandleGenerate_CompileQueueTimeout tests that the compile queue timeout bounds the wait for a compile slot.
+func TestHandleGenerate_CompileQueueTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		queueTimeout time.Duration
+		wantStatus   int
+	}{
+		{name: "short wait fails fast", queueTimeout: 10 * time.Millisecond, wantStatus: http.StatusServiceUnavailable},
+		{name: "long wait gets the freed slot", queueTimeout: 10 * time.Second, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{
+				"slow.typ": []byte("= Slow"),
+				"fast.typ": []byte("= Fast"),
+			})
+			compiler := &gatedCompiler{marker: "Slow", started: make(chan struct{}), release: make(chan struct{})}
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:             bucketURL,
+				maxConcurrentCompiles: 1,
+				compileTimeout:        time.Minute,
+				compileQueueTimeout:   tt.queueTimeout,
+			})
+			srv.compiler = compiler
+
+			generate := func(templateKey string) int {
+				req := httptest.NewRequest(http.MethodPost, "/generate",
+					strings.NewReader(`{"templateKey": "`+templateKey+`"}`))
+				rec := httptest.NewRecorder()
+				srv.handleGenerate(rec, req)
+				return rec.Code
+			}
+
+			// Occupy the only compile slot.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				generate("slow.typ")
+			}()
+			<-compiler.started
+			var releaseOnce sync.Once
+			release := func() { releaseOnce.Do(func() { close(compiler.release) }) }
+			t.Cleanup(func() {
+				release()
+				<-done
+			})
+
+			// Free the slot once the request is waiting for it, if it's expected to get it.
+			if tt.wantStatus == http.StatusOK {
+				go func() {
+					for srv.compileLimiter.queueDepth() == 0 {
+						time.Sleep(time.Millisecond)
+					}
+					release()
+				}()
+			}
+
+			if status := generate("fast.typ"); status != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_OutputSizeLimit tests that the output size limit of the requested format is applied.
+func TestHandleGenerate_OutputSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	// The stub compiler's output is 9 bytes.
+	tests := []struct {
+		name           string
+		format         string
+		maxOutputSize  int64
+		maxOutputSizes map[string]int64
+		wantStatus     int
+	}{
+		{name: "unlimited", format: formatPDF, wantStatus: http.StatusOK},
+		{name: "within general limit", format: formatPDF, maxOutputSize: 9, wantStatus: http.StatusOK},
+		{name: "over general limit", format: formatPDF, maxOutputSize: 8, wantStatus: http.StatusUnprocessableEntity},
+		{
+			name:           "format limit overrides general",
+			format:         formatPNG,
+			maxOutputSize:  8,
+			maxOutputSizes: map[string]int64{formatPNG: 100},
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "over format limit",
+			format:         formatPNG,
+			maxOutputSize:  100,
+			maxOutputSizes: map[string]int64{formatPNG: 8},
+			wantStatus:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "other format falls back to general",
+			format:         formatSVG,
+			maxOutputSize:  8,
+			maxOutputSizes: map[string]int64{formatPNG: 100},
+			wantStatus:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "default format uses pdf limit",
+			maxOutputSizes: map[string]int64{formatPDF: 8},
+			wantStatus:     http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:      bucketURL,
+				maxOutputSize:  tt.maxOutputSize,
+				maxOutputSizes: tt.maxOutputSizes,
+			})
+			srv.compiler = &stubCompiler{}
+
+			reqBody := fmt.Sprintf(`{"templateKey": "template.typ", "format": %q}`, tt.format)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity && !strings.Contains(rec.Body.String(), "output too large") {
+				t.Errorf("expected output too large error, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_StreamsDocument tests that a document is streamed from its work directory,
+// with its Content-Length, and that the work directory is removed afterwards.
+func TestHandleGenerate_StreamsDocument(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+		t.Errorf("expected Content-Length %s, got %s", want, got)
+	}
+	if _, err := os.Stat(compiler.workDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected work directory %s to be removed, got %v", compiler.workDir, err)
+	}
+}
+
+// TestHandleGenerate_GET tests generating a document from the query parameters of a GET request.
+func TestHandleGenerate_GET(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"reports/report.typ": []byte("= Report"),
+		"data/q3.yaml":       []byte("title: Q3\n"),
+	})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = compiler
+	srv.typstVersion = func(context.Context) (string, error) { return "typst 0.13.1", nil }
+	handler := srv.Handler()
+
+	target := "/generate?templateKey=reports/report.typ&dataKey=data/q3.yaml&format=svg&filename=q3"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/svg+xml" {
+		t.Errorf("expected Content-Type %q, got %q", "image/svg+xml", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `inline; filename="q3.svg"` {
+		t.Errorf("expected Content-Disposition %q, got %q", `inline; filename="q3.svg"`, got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != generateQueryCacheControl {
+		t.Errorf("expected Cache-Control %q, got %q", generateQueryCacheControl, got)
+	}
+	if got := compiler.files["data.json"]; !strings.Contains(got, `"title": "Q3"`) {
+		t.Errorf("expected data.json to contain the YAML data, got %q", got)
+	}
+
+	// The browser revalidates its copy with the ETag.
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	revalidated := httptest.NewRecorder()
+	handler.ServeHTTP(revalidated, req)
+	if revalidated.Code != http.StatusNotModified {
+		t.Errorf("expected status %d on revalidation, got %d", http.StatusNotModified, revalidated.Code)
+	}
+
+	missing := httptest.NewRecorder()
+	handler.ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/generate?dataKey=data/q3.yaml", nil))
+	if missing.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without templateKey, got %d", http.StatusBadRequest, missing.Code)
+	}
+}
+
+// barrierFS is an fs.FS whose files are only opened once n of them are being opened at the
+// same time, so reading them one after another fails.
+type barrierFS struct {
+	fs.FS
+	// n is the number of opens the barrier waits for.
+	n int
+	// mu guards opening.
+	mu sync.Mutex
+	// opening is the number of opens that reached the barrier.
+	opening int
+	// release is closed once n opens reached the barrier.
+	release chan struct{}
+}
+
+// Open waits for n opens to reach the barrier, then opens the file.
+func (f *barrierFS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	f.opening++
+	if f.opening == f.n {
+		close(f.release)
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-f.release:
+		return f.FS.Open(name)
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("open %s: files were not fetched concurrently", name)
+	}
+}
+
+// TestHandleGenerate_ConcurrentFetches tests that the template and its files are fetched concurrently.
+func TestHandleGenerate_ConcurrentFetches(t *testing.T) {
+	t.Parallel()
+
+	files := fstest.MapFS{
+		"reports/report.typ":      {Data: []byte("= Report")},
+		"reports/lib/table.typ":   {Data: []byte("#let table = none")},
+		"reports/lib/header.typ":  {Data: []byte("#let header = none")},
+		"assets/logo.svg":         {Data: []byte("<svg/>")},
+		"assets/chart.png":        {Data: []byte("png")},
+		"fonts/Inter-Regular.ttf": {Data: []byte("font")},
+		"data/q3.json":            {Data: []byte(`{"title": "Q3"}`)},
+	}
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:  embedBucketURL,
+		templateFS: &barrierFS{FS: files, n: len(files), release: make(chan struct{})},
+	})
+	srv.compiler = compiler
+
+	body := `{"templateKey": "reports/report.typ", "dataKey": "data/q3.json",
+		"includeKeys": ["reports/lib/table.typ", "reports/lib/header.typ"],
+		"assetKeys": ["assets/logo.svg", "assets/chart.png"], "fontKeys": ["fonts/Inter-Regular.ttf"]}`
+	rec := postGenerate(t, srv, body, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	want := map[string]string{
+		"main.typ":                       "= Report",
+		"lib/table.typ":                  "#let table = none",
+		"lib/header.typ":                 "#let header = none",
+		"assets/logo.svg":                "<svg/>",
+		"assets/chart.png":               "png",
+		".fonts/fonts/Inter-Regular.ttf": "font",
+	}
+	for name, content := range want {
+		if got := compiler.files[name]; got != content {
+			t.Errorf("expected %s to be %q, got %q", name, content, got)
+		}
+	}
+}
+
+// TestHandleGenerate_FetchFailure tests that a file that fails to fetch fails the whole request.
+func TestHandleGenerate_FetchFailure(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"template.typ": []byte("= Hello"),
+		"lib/a.typ":    []byte("#let a = 1"),
+		"logo.svg":     []byte("<svg/>"),
+	})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &stubCompiler{}
+
+	body := `{"templateKey": "template.typ", "includeKeys": ["lib/a.typ"], "assetKeys": ["logo.svg", "missing.png"]}`
+	rec := postGenerate(t, srv, body, "", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNotFound, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "asset not found: missing.png") {
+		t.Errorf("expected body to name the missing asset, got %q", rec.Body.String())
+	}
+}
+
+// TestHandleGenerate_TypstUnavailable tests that a typst binary missing at compile time responds with 503.
+func TestHandleGenerate_TypstUnavailable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		binary string
+	}{
+		{name: "not on PATH", binary: "typst-missing-binary"},
+		{name: "missing path", binary: filepath.Join(t.TempDir(), "typst")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &LocalTypstCompiler{binary: tt.binary}
+
+			rec := postGenerate(t, srv, `{"templateKey": "template.typ"}`, "", "")
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != "typst binary unavailable" {
+				t.Errorf("expected body %q, got %q", "typst binary unavailable", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// errTooManyPages is returned when a document has more pages than can be split.
+var errTooManyPages = errors.New("too many pages to split")
+
+// pdfConfiguration returns pdfcpu's built-in configuration, copied by each caller.
+//
+// pdfcpu keeps the config directory setting in a global read by every split, so it's disabled only
+// once, rather than reading or creating a user config directory.
+var pdfConfiguration = sync.OnceValue(func() model.Configuration {
+	api.DisableConfigDir()
+	return *model.NewDefaultConfiguration()
+})
+
+// splitPDFPages splits a PDF into single-page PDFs, returned as a zip of "page-001.pdf",
+// "page-002.pdf" and so on.
+//
+// Documents with more than maxPages pages are rejected with errTooManyPages before any
+// page is extracted.
+func splitPDFPages(pdf []byte, maxPages int) ([]byte, error) {
+	conf := pdfConfiguration()
+	conf.Cmd = model.EXTRACTPAGES
+
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), &conf)
+	if err != nil {
+		return nil, fmt.Errorf("read PDF: %w", err)
+	}
+	if ctx.PageCount > maxPages {
+		return nil, fmt.Errorf("%w: document has %d pages, maximum %d", errTooManyPages, ctx.PageCount, maxPages)
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		page, extractErr := api.ExtractPage(ctx, pageNr)
+		if extractErr != nil {
+			return nil, fmt.Errorf("extract page %d: %w", pageNr, extractErr)
+		}
+
+		entry, createErr := archive.Create(fmt.Sprintf("page-%03d.pdf", pageNr))
+		if createErr != nil {
+			return nil, fmt.Errorf("create zip entry for page %d: %w", pageNr, createErr)
+		}
+		if _, copyErr := io.Copy(entry, page); copyErr != nil {
+			return nil, fmt.Errorf("write page %d: %w", pageNr, copyErr)
+		}
+	}
+	if err = archive.Close(); err != nil {
+		return nil, fmt.Errorf("close zip: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// multiPagePDF returns a minimal PDF document with the given number of blank pages.
+func multiPagePDF(t *testing.T, pages int) []byte {
+	t.Helper()
+
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", ""}
+	kids := make([]string, pages)
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", i+3)
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] >>")
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages)
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// assertSplitPages checks that a zip contains the expected single-page PDFs, in order.
+func assertSplitPages(t *testing.T, archive []byte, wantPages int) {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	if len(reader.File) != wantPages {
+		t.Fatalf("expected %d files in zip, got %d", wantPages, len(reader.File))
+	}
+	for i, file := range reader.File {
+		wantName := fmt.Sprintf("page-%03d.pdf", i+1)
+		if file.Name != wantName {
+			t.Errorf("expected file %d to be named %q, got %q", i, wantName, file.Name)
+		}
+
+		rc, openErr := file.Open()
+		if openErr != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, openErr)
+		}
+		page, readErr := io.ReadAll(rc)
+		_ = rc.Close()
+		if readErr != nil {
+			t.Fatalf("failed to read %s: %v", file.Name, readErr)
+		}
+
+		split, splitErr := splitPDFPages(page, 1)
+		if splitErr != nil {
+			t.Errorf("expected %s to be a single-page PDF: %v", file.Name, splitErr)
+			continue
+		}
+		if count := len(mustZipFiles(t, split)); count != 1 {
+			t.Errorf("expected %s to have 1 page, got %d", file.Name, count)
+		}
+	}
+}
+
+// mustZipFiles returns the files of a zip archive.
+func mustZipFiles(t *testing.T, archive []byte) []*zip.File {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	return reader.File
+}
+
+// TestSplitPDFPages tests splitting a PDF into a zip of single-page PDFs.
+func TestSplitPDFPages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		pages    int
+		maxPages int
+		wantErr  error
+	}{
+		{name: "single page", pages: 1, maxPages: 10},
+		{name: "multiple pages", pages: 3, maxPages: 10},
+		{name: "at limit", pages: 5, maxPages: 5},
+		{name: "over limit", pages: 6, maxPages: 5, wantErr: errTooManyPages},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			archive, err := splitPDFPages(multiPagePDF(t, tt.pages), tt.maxPages)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitPDFPages() returned error: %v", err)
+			}
+			assertSplitPages(t, archive, tt.pages)
+		})
+	}
+}
+
+// TestSplitPDFPages_InvalidPDF tests that a document that isn't a PDF is rejected.
+func TestSplitPDFPages_InvalidPDF(t *testing.T) {
+	t.Parallel()
+
+	if _, err := splitPDFPages([]byte("not a pdf"), 10); err == nil {
+		t.Fatal("expected error for invalid PDF")
+	}
+}
+
+// pdfCompiler is a TypstCompiler that writes a fixed PDF as the output.
+type pdfCompiler struct {
+	// pdf is the document written as the output.
+	pdf []byte
+	// args are the args of the last compilation.
+	args compileArgs
+}
+
+// Compile writes the fixed PDF to the output file.
+func (c *pdfCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	c.args = args
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), c.pdf, filePermissions)
+}
+
+// TestHandleGenerate_PDFPages tests that the pdf-pages format returns a zip of single-page PDFs.
+func TestHandleGenerate_PDFPages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		pages      int
+		wantStatus int
+	}{
+		{name: "split", pages: 3, wantStatus: http.StatusOK},
+		{name: "too many pages", pages: 4, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &pdfCompiler{pdf: multiPagePDF(t, tt.pages)}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, maxSplitPages: 3})
+			srv.compiler = compiler
+
+			reqBody := `{"templateKey": "template.typ", "format": "pdf-pages"}`
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+
+			srv.handleGenerate(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if compiler.args.format != formatPDF {
+				t.Errorf("expected compile format %q, got %q", formatPDF, compiler.args.format)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/zip" {
+				t.Errorf("expected Content-Type %q, got %q", "application/zip", contentType)
+			}
+			wantDisposition := `inline; filename="output.zip"`
+			if disposition := rec.Header().Get("Content-Disposition"); disposition != wantDisposition {
+				t.Errorf("expected Content-Disposition %q, got %q", wantDisposition, disposition)
+			}
+			assertSplitPages(t, rec.Body.Bytes(), tt.pages)
+		})
+	}
+}
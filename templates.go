@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+const (
+	// defaultTemplatesPageSize is the default number of bucket objects listed per /templates page.
+	defaultTemplatesPageSize = 100
+	// templateExtension is the file extension of Typst templates.
+	templateExtension = ".typ"
+	// nextPageTokenHeader is the response header carrying the token of the next /templates page.
+	nextPageTokenHeader = "X-Next-Page-Token"
+)
+
+// TemplateInfo describes a template in the storage bucket.
+type TemplateInfo struct {
+	// Key is the key of the template in the storage bucket.
+	Key string `json:"key"`
+	// Size is the size of the template in bytes.
+	Size int64 `json:"size"`
+	// ModTime is when the template was last modified.
+	ModTime time.Time `json:"modTime"`
+}
+
+// handleTemplates lists the templates in the storage bucket.
+//
+// The optional "prefix" query parameter limits the listing to keys with that prefix, and
+// "all=true" includes objects that aren't templates. Results are paginated: when more
+// objects remain, the X-Next-Page-Token header holds the "pageToken" of the next page.
+// A page may hold fewer templates than the page size, since filtering happens after listing.
+// With a key prefix, only the keys under it are listed, relative to it.
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	pageToken := blob.FirstPageToken
+	if tokenParam := query.Get("pageToken"); tokenParam != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(tokenParam)
+		if err != nil {
+			http.Error(w, "invalid pageToken", http.StatusBadRequest)
+			return
+		}
+		pageToken = decoded
+	}
+
+	all := query.Get("all") == "true"
+
+	bucket, err := s.openBucket(r.Context())
+	if err != nil {
+		http.Error(w, "failed to open bucket", http.StatusServiceUnavailable)
+		return
+	}
+
+	keyPrefix := bucketKey(r.Context(), "")
+	objects, nextPageToken, err := bucket.ListPage(r.Context(), pageToken, s.requestConfig(r.Context()).templatesPageSize,
+		&blob.ListOptions{Prefix: keyPrefix + query.Get("prefix")})
+	if err != nil {
+		s.requestLogger(r.Context()).Error("failed to list templates", "error", err)
+		writeError(w, fmt.Errorf("failed to list templates: %w", err))
+		return
+	}
+
+	templates := make([]TemplateInfo, 0, len(objects))
+	for _, object := range objects {
+		if object.IsDir || (!all && !strings.HasSuffix(object.Key, templateExtension)) {
+			continue
+		}
+		key := strings.TrimPrefix(object.Key, keyPrefix)
+		templates = append(templates, TemplateInfo{Key: key, Size: object.Size, ModTime: object.ModTime})
+	}
+
+	if nextPageToken != nil {
+		w.Header().Set(nextPageTokenHeader, base64.RawURLEncoding.EncodeToString(nextPageToken))
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if encodeErr := json.NewEncoder(w).Encode(templates); encodeErr != nil {
+		s.requestLogger(r.Context()).Error("failed to write templates response", "error", encodeErr)
+	}
+}
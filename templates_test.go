@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// listTemplates requests a /templates page and returns the listed keys and the next page token.
+func listTemplates(t *testing.T, srv *Server, query string) ([]string, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/templates"+query, nil)
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != contentTypeJSON {
+		t.Errorf("expected Content-Type %q, got %q", contentTypeJSON, contentType)
+	}
+
+	var templates []TemplateInfo
+	if err := json.NewDecoder(rec.Body).Decode(&templates); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	keys := make([]string, 0, len(templates))
+	for _, template := range templates {
+		keys = append(keys, template.Key)
+	}
+	return keys, rec.Header().Get(nextPageTokenHeader)
+}
+
+// TestHandleTemplates tests listing templates with filters.
+func TestHandleTemplates(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"invoice.typ":          []byte("= Invoice"),
+		"data.json":            []byte("{}"),
+		"reports/monthly.typ":  []byte("= Monthly"),
+		"reports/monthly.yaml": []byte("a: 1"),
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		wantKeys []string
+	}{
+		{name: "templates only", query: "", wantKeys: []string{"invoice.typ", "reports/monthly.typ"}},
+		{name: "prefix", query: "?prefix=reports/", wantKeys: []string{"reports/monthly.typ"}},
+		{
+			name:     "all objects",
+			query:    "?all=true",
+			wantKeys: []string{"data.json", "invoice.typ", "reports/monthly.typ", "reports/monthly.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+
+			keys, nextPageToken := listTemplates(t, srv, tt.query)
+
+			if !slices.Equal(keys, tt.wantKeys) {
+				t.Errorf("expected keys %v, got %v", tt.wantKeys, keys)
+			}
+			if nextPageToken != "" {
+				t.Errorf("expected no next page token, got %q", nextPageToken)
+			}
+		})
+	}
+}
+
+// TestHandleTemplates_Pagination tests that listings are split into pages.
+func TestHandleTemplates_Pagination(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"a.typ": []byte("= A"),
+		"b.typ": []byte("= B"),
+		"c.typ": []byte("= C"),
+	})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, templatesPageSize: 2})
+
+	firstPage, nextPageToken := listTemplates(t, srv, "")
+	if want := []string{"a.typ", "b.typ"}; !slices.Equal(firstPage, want) {
+		t.Errorf("expected first page %v, got %v", want, firstPage)
+	}
+	if nextPageToken == "" {
+		t.Fatal("expected a next page token")
+	}
+
+	secondPage, lastPageToken := listTemplates(t, srv, "?pageToken="+nextPageToken)
+	if want := []string{"c.typ"}; !slices.Equal(secondPage, want) {
+		t.Errorf("expected second page %v, got %v", want, secondPage)
+	}
+	if lastPageToken != "" {
+		t.Errorf("expected no next page token on the last page, got %q", lastPageToken)
+	}
+}
+
+// TestHandleTemplates_InvalidPageToken tests that a malformed page token is rejected.
+func TestHandleTemplates_InvalidPageToken(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "file:///tmp/test"})
+
+	req := httptest.NewRequest(http.MethodGet, "/templates?pageToken=not*base64", nil)
+	rec := httptest.NewRecorder()
+
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// unknownTenant is the tenant label of requests whose tenant isn't in the allowlist.
+	unknownTenant = "unknown"
+	// tenantPlaceholder is replaced by the request's tenant header in KEY_PREFIX.
+	tenantPlaceholder = "{tenant}"
+)
+
+// tenantContextKey is the context key of the request's tenant label.
+type tenantContextKey struct{}
+
+// keyPrefixContextKey is the context key of the request's bucket key prefix.
+type keyPrefixContextKey struct{}
+
+// tagTenant wraps next to store the request's tenant label in its context.
+//
+// The tenant is read from the configured tenant header. Tenants missing from the
+// allowlist are bucketed as "unknown" to bound the cardinality of metric labels.
+// If no tenant header is configured, next is returned unchanged.
+func (s *Server) tagTenant(next http.Handler) http.Handler {
+	if s.config.Load().tenantHeader == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := s.requestConfig(r.Context())
+		tenant := r.Header.Get(config.tenantHeader)
+		if !slices.Contains(config.tenantAllowlist, tenant) {
+			tenant = unknownTenant
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+	})
+}
+
+// tenantFromContext returns the tenant label stored by tagTenant, or "" if there is none.
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// checkKeyPrefix checks that the configured key prefix can be expanded for requests.
+func (c *ServerConfig) checkKeyPrefix() error {
+	if strings.Contains(c.keyPrefix, tenantPlaceholder) && c.tenantHeader == "" {
+		return fmt.Errorf("KEY_PREFIX uses %s, but TENANT_HEADER is not set", tenantPlaceholder)
+	}
+	return validateKey("KEY_PREFIX", strings.ReplaceAll(c.keyPrefix, tenantPlaceholder, "tenant"))
+}
+
+// requestKeyPrefix returns the key prefix of a request, with the tenant placeholder replaced by
+// its tenant header.
+//
+// The tenant must be a single path segment, so a client can't reach another tenant's keys
+// through its header either.
+func (c *ServerConfig) requestKeyPrefix(r *http.Request) (string, error) {
+	if !strings.Contains(c.keyPrefix, tenantPlaceholder) {
+		return c.keyPrefix, nil
+	}
+	tenant := r.Header.Get(c.tenantHeader)
+	switch {
+	case tenant == "":
+		return "", fmt.Errorf("missing %s header", c.tenantHeader)
+	case tenant == "." || tenant == ".." || strings.ContainsAny(tenant, "/\\\x00"):
+		return "", errors.New("invalid tenant: must be a single path segment")
+	}
+	return strings.ReplaceAll(c.keyPrefix, tenantPlaceholder, tenant), nil
+}
+
+// scopeKeys wraps next to prefix the bucket keys of the request with the configured key prefix.
+//
+// Keys are validated before they're prefixed, and can't contain ".." segments, so a request
+// can only reach the keys under its prefix. If no key prefix is configured, next is returned
+// unchanged.
+func (s *Server) scopeKeys(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.Load().keyPrefix == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefix, err := s.requestConfig(r.Context()).requestKeyPrefix(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), keyPrefixContextKey{}, prefix)))
+	}
+}
+
+// keyPrefixFromContext returns the key prefix stored by scopeKeys, or "" if there is none.
+func keyPrefixFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(keyPrefixContextKey{}).(string)
+	return prefix
+}
+
+// bucketKey returns the bucket key of a request's key, with the request's key prefix prepended.
+func bucketKey(ctx context.Context, key string) string {
+	return keyPrefixFromContext(ctx) + key
+}
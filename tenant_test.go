@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestTagTenant tests that the tenant header is bucketed by the allowlist.
+func TestTagTenant(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		tenantHeader string
+		header       string
+		want         string
+	}{
+		{name: "allowlisted tenant", tenantHeader: "X-Tenant-ID", header: "acme", want: "acme"},
+		{name: "unknown tenant", tenantHeader: "X-Tenant-ID", header: "globex", want: unknownTenant},
+		{name: "missing header", tenantHeader: "X-Tenant-ID", header: "", want: unknownTenant},
+		{name: "disabled", tenantHeader: "", header: "acme", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:       "mem://",
+				tenantHeader:    tt.tenantHeader,
+				tenantAllowlist: []string{"acme"},
+			})
+
+			var got string
+			handler := srv.tagTenant(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = tenantFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("expected tenant %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_TenantLogField tests that request logs carry the tenant field.
+func TestHandleGenerate_TenantLogField(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(logger, ServerConfig{
+		bucketURL:       bucketURL,
+		tenantHeader:    "X-Tenant-ID",
+		tenantAllowlist: []string{"acme"},
+	})
+	srv.compiler = &stubCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// The first entry is the handler's, followed by the access log.
+	var entry map[string]any
+	if err := json.NewDecoder(bytes.NewReader(logs.Bytes())).Decode(&entry); err != nil {
+		t.Fatalf("failed to parse log entry %q: %v", logs.String(), err)
+	}
+	if entry["msg"] != "generating document" || entry["tenant"] != "acme" {
+		t.Errorf("expected the generating document entry with tenant %q, got %v", "acme", entry)
+	}
+}
+
+// TestCheckKeyPrefix tests that key prefixes that can't be expanded or escape the bucket are rejected.
+func TestCheckKeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  ServerConfig
+		wantErr string
+	}{
+		{name: "none", config: ServerConfig{}},
+		{name: "fixed", config: ServerConfig{keyPrefix: "tenants/acme/"}},
+		{name: "tenant", config: ServerConfig{keyPrefix: "tenants/{tenant}/", tenantHeader: "X-Tenant-ID"}},
+		{
+			name:    "tenant without header",
+			config:  ServerConfig{keyPrefix: "tenants/{tenant}/"},
+			wantErr: "TENANT_HEADER is not set",
+		},
+		{name: "traversal", config: ServerConfig{keyPrefix: "tenants/../"}, wantErr: `".." segments`},
+		{name: "absolute", config: ServerConfig{keyPrefix: "/tenants/"}, wantErr: "must not start with a slash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.config.checkKeyPrefix()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkKeyPrefix() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkKeyPrefix() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_KeyPrefix tests that request keys are fetched from under the tenant's prefix.
+func TestHandleGenerate_KeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"template.typ":                 []byte("= Shared"),
+		"tenants/acme/template.typ":    []byte("= Acme"),
+		"tenants/acme/data.json":       []byte(`{"name": "Acme"}`),
+		"tenants/globex/template.typ":  []byte("= Globex"),
+		"tenants/globex/secret.typ":    []byte("= Secret"),
+		"tenants/fixed/template.typ":   []byte("= Fixed"),
+		"tenants/acme/parts/intro.typ": []byte("= Intro"),
+	})
+
+	tests := []struct {
+		name       string
+		keyPrefix  string
+		tenant     string
+		body       string
+		wantStatus int
+		wantFiles  map[string]string
+		wantErr    string
+	}{
+		{
+			name:       "tenant template and data",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "acme",
+			body:       `{"templateKey": "template.typ", "dataKey": "data.json", "includeKeys": ["parts/intro.typ"]}`,
+			wantStatus: http.StatusOK,
+			wantFiles: map[string]string{
+				"main.typ":        "= Acme",
+				"data.json":       "{\n  \"name\": \"Acme\"\n}",
+				"parts/intro.typ": "= Intro",
+			},
+		},
+		{
+			name:       "other tenant",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "globex",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusOK,
+			wantFiles:  map[string]string{"main.typ": "= Globex"},
+		},
+		{
+			name:       "fixed prefix",
+			keyPrefix:  "tenants/fixed/",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusOK,
+			wantFiles:  map[string]string{"main.typ": "= Fixed"},
+		},
+		{
+			name:       "key of other tenant",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "acme",
+			body:       `{"templateKey": "secret.typ"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "traversal out of prefix",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "acme",
+			body:       `{"templateKey": "../globex/secret.typ"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    `must not contain ".." segments`,
+		},
+		{
+			name:       "traversal in tenant",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "..",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "invalid tenant",
+		},
+		{
+			name:       "nested tenant",
+			keyPrefix:  "tenants/{tenant}/",
+			tenant:     "globex/secret",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "invalid tenant",
+		},
+		{
+			name:       "missing tenant",
+			keyPrefix:  "tenants/{tenant}/",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "missing X-Tenant-ID header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{
+				bucketURL:    bucketURL,
+				tenantHeader: "X-Tenant-ID",
+				keyPrefix:    tt.keyPrefix,
+			})
+			srv.compiler = compiler
+
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(tt.body))
+			if tt.tenant != "" {
+				req.Header.Set("X-Tenant-ID", tt.tenant)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("expected body to contain %q, got %q", tt.wantErr, rec.Body.String())
+			}
+			for name, want := range tt.wantFiles {
+				if got := compiler.files[name]; got != want {
+					t.Errorf("expected %s to be %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestKeyPrefix_TemplateCacheAndListing tests that tenants don't share cached templates, and list their own keys.
+func TestKeyPrefix_TemplateCacheAndListing(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{
+		"tenants/acme/template.typ":   []byte("= Acme"),
+		"tenants/globex/template.typ": []byte("= Globex"),
+		"tenants/globex/other.typ":    []byte("= Other"),
+	})
+	compiler := &recordingCompiler{}
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:         bucketURL,
+		tenantHeader:      "X-Tenant-ID",
+		keyPrefix:         "tenants/{tenant}/",
+		templateCacheSize: 10,
+	})
+	srv.compiler = compiler
+	handler := srv.Handler()
+
+	for _, tenant := range []string{"acme", "globex"} {
+		req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d: %s", http.StatusOK, tenant, rec.Code, rec.Body.String())
+		}
+		if want := "= " + strings.ToUpper(tenant[:1]) + tenant[1:]; compiler.files["main.typ"] != want {
+			t.Errorf("expected %s's template %q, got %q", tenant, want, compiler.files["main.typ"])
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/templates", nil)
+	req.Header.Set("X-Tenant-ID", "globex")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var templates []TemplateInfo
+	if err := json.NewDecoder(rec.Body).Decode(&templates); err != nil {
+		t.Fatalf("failed to decode templates: %v", err)
+	}
+	var keys []string
+	for _, template := range templates {
+		keys = append(keys, template.Key)
+	}
+	if want := []string{"other.typ", "template.typ"}; !slices.Equal(keys, want) {
+		t.Errorf("expected templates %v, got %v", want, keys)
+	}
+}
@@ -1,100 +1,698 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	// filePermissions is the permission mode for temporary files.
 	// Using 0600 for security (owner read/write only).
 	filePermissions = 0600
+	// dirPermissions is the permission mode for directories created in the work directory.
+	dirPermissions = 0700
 	// sourceFileName is the name of the Typst source file in the work directory.
 	sourceFileName = "main.typ"
-	// outputFileName is the name of the compiled PDF file in the work directory.
-	outputFileName = "output.pdf"
-	// dataFileName is the name of the JSON data file in the work directory.
+	// outputBaseName is the name of the compiled output file in the work directory, without extension.
+	outputBaseName = "output"
+	// formatPDF is the output format of PDF documents.
+	formatPDF = "pdf"
+	// formatPNG is the output format of PNG images.
+	formatPNG = "png"
+	// formatSVG is the output format of SVG images.
+	formatSVG = "svg"
+	// formatPDFPages is the output format of a zip of single-page PDF documents.
+	formatPDFPages = "pdf-pages"
+	// formatSVGPages is the output format of a zip of one SVG image per page.
+	formatSVGPages = "svg-pages"
+	// dataFileName is the default path of the JSON data file in the work directory.
 	dataFileName = "data.json"
+	// stdoutPath is the output path that makes typst write the output to stdout.
+	stdoutPath = "-"
+	// allocationFailureMarker is printed by the Rust allocator when an allocation fails.
+	allocationFailureMarker = "memory allocation of"
 )
 
+var (
+	// errDocumentTooComplex is returned when a compile exceeds its memory limit.
+	errDocumentTooComplex = errors.New("document too complex")
+	// errOutputUnsupported is returned by OutputCompiler when it can't return the output directly.
+	errOutputUnsupported = errors.New("direct output not supported")
+	// errCompilerBusy is returned when no compile slot became available before the context was done.
+	errCompilerBusy = errors.New("too many concurrent compilations, try again later")
+	// errTypstUnavailable is returned when the typst binary can't be found, such as after it was removed.
+	errTypstUnavailable = errors.New("typst binary unavailable")
+)
+
+// outputFormat describes a format typst can compile a document to.
+type outputFormat struct {
+	// extension is the file extension of the output, including the dot.
+	extension string
+	// contentType is the HTTP content type of the output.
+	contentType string
+	// firstPageOnly limits compilation to a single page, the first unless another is selected,
+	// for formats with one file per page.
+	firstPageOnly bool
+	// splitPages compiles a PDF and splits it into a zip of single-page PDFs.
+	splitPages bool
+	// pageFormat, if set, is the typst format of formats compiled to one file per page, such as
+	// "svg", which are zipped.
+	pageFormat string
+}
+
+// outputFormats returns the supported output formats by name.
+func outputFormats() map[string]outputFormat {
+	return map[string]outputFormat{
+		formatPDF:      {extension: ".pdf", contentType: contentTypePDF},
+		formatPNG:      {extension: ".png", contentType: "image/png", firstPageOnly: true},
+		formatSVG:      {extension: ".svg", contentType: "image/svg+xml", firstPageOnly: true},
+		formatPDFPages: {extension: ".zip", contentType: "application/zip", splitPages: true},
+		formatSVGPages: {extension: ".zip", contentType: "application/zip", pageFormat: formatSVG},
+	}
+}
+
+// fileName returns the name of the output file for the format.
+func (f outputFormat) fileName() string {
+	return outputBaseName + f.extension
+}
+
+// downloadName returns the file name to suggest to clients for the output.
+//
+// The requested name is sanitized by sanitizeFileName, and the format's extension is
+// appended unless the name already ends with it. An empty name falls back to fileName.
+func (f outputFormat) downloadName(requested string) string {
+	name := sanitizeFileName(requested)
+	if name == "" {
+		return f.fileName()
+	}
+	if !strings.EqualFold(filepath.Ext(name), f.extension) {
+		name += f.extension
+	}
+	return name
+}
+
+// sanitizeFileName strips any directory components, control characters and quotes from
+// a requested file name. It returns "" if nothing usable is left.
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if strings.Trim(name, ".") == "" {
+		return ""
+	}
+	return name
+}
+
+// compileArgs holds the per-compilation arguments passed to a TypstCompiler.
+type compileArgs struct {
+	// inputs are passed to typst as "--input key=value" and exposed to the
+	// template as sys.inputs.
+	inputs map[string]string
+	// format is the name of the output format. Empty means PDF.
+	format string
+	// pages is the page selection passed to typst as "--pages". Empty means all pages,
+	// or the first page for formats with one file per page.
+	pages string
+	// usage, if set, receives the resource usage of the compile process.
+	usage *compileUsage
+	// fontDir is the directory of per-request fonts relative to the work directory, passed to
+	// typst as "--font-path". Empty means none.
+	fontDir string
+	// binary is the typst binary to run, such as a version pinned with typstVersion. Empty uses
+	// the compiler's default.
+	binary string
+	// optimize compresses and linearizes a compiled PDF with an external PDF optimizer, if one is installed.
+	optimize bool
+	// flags are the request's additional typst compile flags, checked against TYPST_ALLOWED_FLAGS.
+	flags []string
+	// creationTimestamp is the document's creation time as a UNIX timestamp, so that identical
+	// inputs compile to identical documents. Zero is the UNIX epoch.
+	creationTimestamp int64
+}
+
+// compileUsage is the resource usage of a compile process, and the warnings it reported.
+type compileUsage struct {
+	// recorded is set once the usage of a process was recorded.
+	recorded bool
+	// cpuTime is the user and system CPU time of the process.
+	cpuTime time.Duration
+	// maxRSSKB is the peak resident set size of the process in kilobytes. Zero if unavailable.
+	maxRSSKB int64
+	// warnings are the warnings typst reported for a successful compile.
+	warnings []Diagnostic
+}
+
+// record records the resource usage of an exited process. A nil usage records nothing.
+func (u *compileUsage) record(state *os.ProcessState) {
+	if u == nil || state == nil {
+		return
+	}
+	u.recorded = true
+	u.cpuTime = state.UserTime() + state.SystemTime()
+	u.maxRSSKB = processMaxRSSKB(state)
+}
+
+// outputFormat returns the output format of the compilation.
+func (a compileArgs) outputFormat() outputFormat {
+	return outputFormats()[cmp.Or(a.format, formatPDF)]
+}
+
+// outputFileName returns the name of the compiled output file in the work directory.
+func (a compileArgs) outputFileName() string {
+	return a.outputFormat().fileName()
+}
+
+// typstOutputName returns the output path typst compiles to, relative to the work directory.
+//
+// Formats with one file per page are compiled to a path template, where typst replaces "{p}"
+// with the page number.
+func (a compileArgs) typstOutputName() string {
+	if pageFormat := a.outputFormat().pageFormat; pageFormat != "" {
+		return filepath.Join(pageDirName, "{p}."+pageFormat)
+	}
+	return a.outputFileName()
+}
+
+// args returns the typst compile command line arguments for the compile args.
+func (a compileArgs) args() []string {
+	args := make([]string, 0, 2*len(a.inputs))
+	if format := cmp.Or(a.outputFormat().pageFormat, a.format); format != "" {
+		args = append(args, "--format", format)
+	}
+	switch {
+	case a.pages != "":
+		args = append(args, "--pages", a.pages)
+	case a.outputFormat().firstPageOnly:
+		args = append(args, "--pages", "1")
+	}
+	for _, key := range slices.Sorted(maps.Keys(a.inputs)) {
+		args = append(args, "--input", key+"="+a.inputs[key])
+	}
+	return append(args, a.flags...)
+}
+
+// scalarInputs returns the scalar values of data as strings, suitable for "--input" flags.
+//
+// Nested objects, arrays and nulls are skipped; they are only available through the data file.
+// Data that isn't an object has no named values, so it yields no inputs.
+func scalarInputs(data any) map[string]string {
+	inputs := make(map[string]string)
+	object, _ := data.(map[string]any)
+	for key, value := range object {
+		switch v := value.(type) {
+		case string:
+			inputs[key] = v
+		case bool:
+			inputs[key] = strconv.FormatBool(v)
+		case float64:
+			inputs[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int:
+			inputs[key] = strconv.Itoa(v)
+		case int64:
+			inputs[key] = strconv.FormatInt(v, 10)
+		}
+	}
+	return inputs
+}
+
+// validateInputKeys checks that the input names can be passed as "--input key=value" flags.
+//
+// typst splits each flag at its first "=", so a name can't be empty or contain one.
+func validateInputKeys(inputs map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(inputs)) {
+		if key == "" {
+			return errors.New("invalid input name: must not be empty")
+		}
+		if strings.Contains(key, "=") {
+			return fmt.Errorf("invalid input name %q: must not contain \"=\"", key)
+		}
+	}
+	return nil
+}
+
+// compileOptions holds per-compilation settings for compileTypstWith.
+type compileOptions struct {
+	// dataPath is the path of the data file relative to the work directory, which is
+	// also the Typst project root. Defaults to dataFileName.
+	dataPath string
+	// files are additional files written verbatim to the work directory, keyed by
+	// their path relative to the project root.
+	files map[string][]byte
+	// args are passed through to the compiler.
+	args compileArgs
+	// observeCompile, if set, is called with the duration of the compiler alone.
+	observeCompile func(time.Duration)
+	// limiter, if set, bounds concurrent compilations. A compile slot is held while the compiler runs.
+	limiter *compileLimiter
+	// queueTimeout, if positive, is the maximum time to wait for a compile slot.
+	queueTimeout time.Duration
+	// workDir is the directory the work directory is created in. Empty uses the OS temp directory.
+	workDir string
+	// maxPages, if positive, is the maximum number of pages of formats compiled to one file per page.
+	maxPages int
+}
+
 // TypstCompiler defines the interface for compiling Typst files.
 // This allows for dependency injection of different compilation strategies.
 type TypstCompiler interface {
 	// Compile compiles a Typst source file in the given working directory.
 	// The source file is expected to be at workDir/main.typ and the output
-	// will be written to workDir/output.pdf.
-	Compile(ctx context.Context, workDir string) error
+	// will be written to workDir/output.<ext>, named by args.typstOutputName.
+	// The working directory is the
+	// project root, so root-absolute paths like "/data/input.json" resolve
+	// inside it.
+	Compile(ctx context.Context, workDir string, args compileArgs) error
+}
+
+// OutputCompiler is a TypstCompiler that can return the compiled output directly
+// instead of writing it to the work directory, saving a disk round-trip.
+type OutputCompiler interface {
+	TypstCompiler
+	// CompileOutput compiles like Compile but returns the output bytes.
+	// It returns errOutputUnsupported when direct output isn't available, in
+	// which case callers fall back to Compile.
+	CompileOutput(ctx context.Context, workDir string, args compileArgs) ([]byte, error)
 }
 
 // LocalTypstCompiler compiles Typst files using the local typst binary.
-type LocalTypstCompiler struct{}
+type LocalTypstCompiler struct {
+	// memoryLimit is the maximum address space of the typst process in bytes.
+	// Zero means no limit. Only enforced on Linux.
+	memoryLimit int64
+	// stdout makes CompileOutput capture the output from stdout ("-" output path).
+	stdout bool
+	// packageCachePath, if set, is where typst caches downloaded packages instead of the user cache directory.
+	packageCachePath string
+	// packagePath, if set, is where typst looks for local packages, imported with the "@local" namespace.
+	packagePath string
+	// fontPath, if set, is passed to typst as "--font-path" after any per-request font directory.
+	fontPath string
+	// binary is the typst binary to run, by name on PATH or by path. Empty runs "typst".
+	binary string
+	// tempDir, if set, is the temporary directory of the typst process instead of the OS default.
+	tempDir string
+	// stdoutUnsupported is set once typst turned out not to support stdout output.
+	stdoutUnsupported atomic.Bool
+}
 
 // Compile runs the local typst binary to compile the source file.
-func (c *LocalTypstCompiler) Compile(ctx context.Context, workDir string) error {
-	sourcePath := filepath.Join(workDir, sourceFileName)
-	outputPath := filepath.Join(workDir, outputFileName)
+func (c *LocalTypstCompiler) Compile(ctx context.Context, workDir string, args compileArgs) error {
+	_, err := c.run(ctx, workDir, filepath.Join(workDir, args.typstOutputName()), args)
+	return err
+}
+
+// CompileOutput runs the local typst binary with "-" as the output path and
+// returns the PDF captured from stdout.
+//
+// Returns errOutputUnsupported if stdout output is disabled, for formats with one file per
+// page, or if typst succeeded without writing to stdout (older versions treat "-" as a file name).
+func (c *LocalTypstCompiler) CompileOutput(ctx context.Context, workDir string, args compileArgs) ([]byte, error) {
+	if !c.stdout || c.stdoutUnsupported.Load() || args.outputFormat().pageFormat != "" {
+		return nil, errOutputUnsupported
+	}
 
-	cmd := exec.CommandContext(ctx, "typst", "compile", sourcePath, outputPath)
+	output, err := c.run(ctx, workDir, stdoutPath, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		c.stdoutUnsupported.Store(true)
+		return nil, errOutputUnsupported
+	}
+
+	return output, nil
+}
+
+// run runs typst compile with the given output path and returns what it wrote to stdout.
+func (c *LocalTypstCompiler) run(ctx context.Context, workDir, outputPath string, args compileArgs) ([]byte, error) {
+	cmdArgs := append(args.args(), c.fontPathArgs(workDir, args.fontDir)...)
+	cmdArgs = append(cmdArgs, filepath.Join(workDir, sourceFileName), outputPath)
+	env := []string{creationTimestampEnv(args.creationTimestamp)}
+	return c.runTypst(ctx, args.binary, workDir, "compile", cmdArgs, env, outputPath == stdoutPath, args.usage)
+}
+
+// creationTimestamp returns the UNIX timestamp of a request's creationTime, an RFC 3339 timestamp,
+// or zero, the UNIX epoch, if it's empty.
+func creationTimestamp(creationTime string) (int64, error) {
+	if creationTime == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, creationTime)
+	if err != nil {
+		return 0, fmt.Errorf("invalid creationTime %q: must be an RFC 3339 timestamp", creationTime)
+	}
+	if t.Unix() < 0 {
+		return 0, fmt.Errorf("invalid creationTime %q: must not be before 1970", creationTime)
+	}
+	return t.Unix(), nil
+}
+
+// creationTimestampEnv returns the environment variable that sets typst's creation timestamp.
+//
+// SOURCE_DATE_EPOCH is the environment variable of the "--creation-timestamp" flag. Unlike the
+// flag, typst versions without it ignore the variable, so versions pinned with typstVersion
+// still run.
+func creationTimestampEnv(timestamp int64) string {
+	return "SOURCE_DATE_EPOCH=" + strconv.FormatInt(timestamp, 10)
+}
+
+// runTypst runs a typst subcommand with workDir as the project root and returns what it wrote to stdout.
+// It runs binary, or the compiler's default binary if binary is empty, with env added to its environment.
+//
+// When stdout is captured, diagnostics are read from stderr only. Otherwise both streams
+// are combined into the error message.
+func (c *LocalTypstCompiler) runTypst(
+	ctx context.Context,
+	binary, workDir, command string,
+	cmdArgs, env []string,
+	captureStdout bool,
+	usage *compileUsage,
+) ([]byte, error) {
+	binary = cmp.Or(binary, c.binary, defaultTypstBinary)
+	cmd := exec.CommandContext(ctx, binary, append([]string{command, "--root", workDir}, cmdArgs...)...)
 	cmd.Dir = workDir
+	cmd.Env = c.packageEnv()
+	if c.tempDir != "" {
+		env = append(slices.Clip(env), "TMPDIR="+c.tempDir)
+	}
+	if len(env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, env...)
+	}
 
-	if output, cmdErr := cmd.CombinedOutput(); cmdErr != nil {
-		return fmt.Errorf("compile failed: %s", string(output))
+	var stdout, diagnostics bytes.Buffer
+	cmd.Stdout = &diagnostics
+	if captureStdout {
+		cmd.Stdout = &stdout
 	}
+	cmd.Stderr = &diagnostics
 
-	return nil
+	if startErr := cmd.Start(); startErr != nil {
+		// A binary looked up on PATH is exec.ErrNotFound when missing, and a path fs.ErrNotExist.
+		// A missing work directory is fs.ErrNotExist too, so the binary itself is checked.
+		if errors.Is(startErr, exec.ErrNotFound) || (errors.Is(startErr, fs.ErrNotExist) && binaryMissing(cmd.Path)) {
+			return nil, fmt.Errorf("%s failed: %w: %w", command, errTypstUnavailable, startErr)
+		}
+		return nil, fmt.Errorf("%s failed: %w", command, startErr)
+	}
+
+	// The limit is applied right after the process starts, so a few early
+	// allocations may happen before it takes effect.
+	if c.memoryLimit > 0 {
+		if limitErr := applyMemoryLimit(cmd.Process.Pid, c.memoryLimit); limitErr != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return nil, fmt.Errorf("apply memory limit: %w", limitErr)
+		}
+	}
+
+	waitErr := cmd.Wait()
+	usage.record(cmd.ProcessState)
+	if waitErr != nil {
+		// The process was killed because the context was canceled or timed out.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%s: %w", command, ctxErr)
+		}
+		if c.memoryLimit > 0 && memoryLimitExceeded(cmd.ProcessState, diagnostics.String()) {
+			return nil, errDocumentTooComplex
+		}
+		if strings.TrimSpace(diagnostics.String()) == "" {
+			return nil, silentFailure(command, cmd.ProcessState)
+		}
+		return nil, typstFailure(command, workDir, diagnostics.String())
+	}
+	if usage != nil {
+		usage.warnings = parseWarnings(relativeToRoot(workDir, diagnostics.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// silentFailure returns the error of a typst command that failed without any diagnostics,
+// which usually means the process was killed from outside, such as by the OOM killer.
+func silentFailure(command string, state *os.ProcessState) error {
+	status := "failed"
+	if state != nil {
+		if code := state.ExitCode(); code >= 0 {
+			status = fmt.Sprintf("exited with code %d", code)
+		} else {
+			status = "was terminated (" + state.String() + ")"
+		}
+	}
+	return fmt.Errorf("%s failed: typst %s without any output, it may have run out of memory or time", command, status)
 }
 
-// compileTypst compiles a Typst source file into a PDF using the default compiler.
-func compileTypst(source string, data map[string]any) ([]byte, error) {
-	return compileTypstWith(context.Background(), &LocalTypstCompiler{}, source, data)
+// binaryMissing reports whether there is no file at the binary path, as opposed to another file
+// a process needs to start, such as its working directory, being missing.
+func binaryMissing(path string) bool {
+	_, err := os.Stat(path)
+	return errors.Is(err, fs.ErrNotExist)
 }
 
-// compileTypstWith compiles a Typst source file into a PDF using the specified compiler.
+// memoryLimitExceeded reports whether a failed compile looks like it ran out of memory.
 //
-// Will create a temporary directory to work in, write the source file and data to it,
-// and then compile the source file into a PDF using the provided compiler.
-func compileTypstWith(ctx context.Context, compiler TypstCompiler, source string, data map[string]any) ([]byte, error) {
+// Hitting the address space limit either makes the allocator abort with a message
+// or gets the process killed by a signal, in which case there is no exit code.
+func memoryLimitExceeded(state *os.ProcessState, output string) bool {
+	if strings.Contains(output, allocationFailureMarker) {
+		return true
+	}
+	return state != nil && state.ExitCode() == -1
+}
+
+// runCompiler compiles the source file in workDir.
+//
+// It returns the output if the compiler provided it directly, or nil if the output was
+// written to the work directory.
+func runCompiler(ctx context.Context, compiler TypstCompiler, workDir string, args compileArgs) ([]byte, error) {
+	if outputCompiler, ok := compiler.(OutputCompiler); ok {
+		output, err := outputCompiler.CompileOutput(ctx, workDir, args)
+		if !errors.Is(err, errOutputUnsupported) {
+			return output, err
+		}
+	}
+	return nil, compiler.Compile(ctx, workDir, args)
+}
+
+// stageWorkDir creates a temporary work directory and writes the source file, the data
+// and the additional files of opts to it.
+//
+// The caller removes the directory once done with it.
+func stageWorkDir(source string, data any, opts compileOptions) (string, error) {
 	// Create a temporary directory to work in.
 	// This will be used to store the source file and any data.
-	workDir, err := os.MkdirTemp("", "typst-*")
+	workDir, err := os.MkdirTemp(opts.workDir, workDirPrefix+"*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	if stageErr := stageFiles(workDir, source, data, opts); stageErr != nil {
+		_ = os.RemoveAll(workDir)
+		return "", stageErr
 	}
-	defer os.RemoveAll(workDir)
+	return workDir, nil
+}
 
+// stageFiles writes the source file, the data and the additional files of opts to workDir.
+func stageFiles(workDir, source string, data any, opts compileOptions) error {
 	// If data is provided, marshal it to JSON and write it to a file.
 	if data != nil {
 		dataBytes, marshalErr := json.MarshalIndent(data, "", "  ")
 		if marshalErr != nil {
-			return nil, fmt.Errorf("failed to marshal data: %w", marshalErr)
+			return fmt.Errorf("failed to marshal data: %w", marshalErr)
+		}
+		dataPath := filepath.Join(workDir, cmp.Or(opts.dataPath, dataFileName))
+		if mkdirErr := os.MkdirAll(filepath.Dir(dataPath), dirPermissions); mkdirErr != nil {
+			return fmt.Errorf("failed to create data directory: %w", mkdirErr)
 		}
-		dataPath := filepath.Join(workDir, dataFileName)
 		if writeErr := os.WriteFile(dataPath, dataBytes, filePermissions); writeErr != nil {
-			return nil, fmt.Errorf("failed to write data file: %w", writeErr)
+			return fmt.Errorf("failed to write data file: %w", writeErr)
+		}
+	}
+
+	// Write any additional files to the temporary directory.
+	for name, content := range opts.files {
+		filePath := filepath.Join(workDir, name)
+		if mkdirErr := os.MkdirAll(filepath.Dir(filePath), dirPermissions); mkdirErr != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, mkdirErr)
+		}
+		if writeErr := os.WriteFile(filePath, content, filePermissions); writeErr != nil {
+			return fmt.Errorf("failed to write %s: %w", name, writeErr)
 		}
 	}
 
 	// Write the source file to the temporary directory.
 	sourcePath := filepath.Join(workDir, sourceFileName)
 	if writeErr := os.WriteFile(sourcePath, []byte(source), filePermissions); writeErr != nil {
-		return nil, fmt.Errorf("failed to write source file: %w", writeErr)
+		return fmt.Errorf("failed to write source file: %w", writeErr)
 	}
+	return nil
+}
 
-	// Compile the source file.
-	if compileErr := compiler.Compile(ctx, workDir); compileErr != nil {
+// compileTypstWith compiles a Typst source file into a PDF using the specified compiler.
+//
+// Will create a temporary directory to work in, write the source file and data to it,
+// and then compile the source file into a PDF using the provided compiler.
+func compileTypstWith(
+	ctx context.Context,
+	compiler TypstCompiler,
+	source string,
+	data any,
+	opts compileOptions,
+) ([]byte, error) {
+	output, err := compileTypstOutput(ctx, compiler, source, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	return output.bytes()
+}
+
+// compileTypstOutput compiles a Typst source file like compileTypstWith, but leaves the output
+// file in the work directory so it can be streamed rather than read into memory.
+//
+// The caller closes the output, which removes the work directory.
+func compileTypstOutput(
+	ctx context.Context,
+	compiler TypstCompiler,
+	source string,
+	data any,
+	opts compileOptions,
+) (*compiledOutput, error) {
+	workDir, err := stageWorkDir(source, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	keepWorkDir := false
+	defer func() {
+		if !keepWorkDir {
+			_ = os.RemoveAll(workDir)
+		}
+	}()
+
+	// Formats with one file per page are compiled into a directory of their own.
+	if opts.args.outputFormat().pageFormat != "" {
+		if mkdirErr := os.Mkdir(filepath.Join(workDir, pageDirName), dirPermissions); mkdirErr != nil {
+			return nil, fmt.Errorf("failed to create page directory: %w", mkdirErr)
+		}
+	}
+
+	// Wait for a compile slot.
+	if opts.limiter != nil {
+		release, acquireErr := opts.limiter.acquire(ctx, opts.queueTimeout)
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+		defer release()
+	}
+
+	// Compile the source file, taking the output directly from the compiler if it can.
+	start := time.Now()
+	output, compileErr := runCompiler(ctx, compiler, workDir, opts.args)
+	if opts.observeCompile != nil {
+		opts.observeCompile(time.Since(start))
+	}
+	if compileErr != nil {
 		return nil, compileErr
 	}
+	if output != nil {
+		return memoryOutput(output), nil
+	}
 
-	// Read the output file from the temporary directory.
-	outputPath := filepath.Join(workDir, outputFileName)
-	pdfData, readErr := os.ReadFile(outputPath)
-	if readErr != nil {
-		return nil, fmt.Errorf("failed to read output PDF: %w", readErr)
+	// Zip the pages of formats with one file per page into the output file.
+	if opts.args.outputFormat().pageFormat != "" {
+		if zipErr := zipPageFiles(workDir, opts.args, opts.maxPages); zipErr != nil {
+			return nil, zipErr
+		}
+	}
+
+	// Open the output file in the temporary directory, which is kept until the output is closed.
+	file, err := os.Open(filepath.Join(workDir, opts.args.outputFileName()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read output file: %w", err)
+	}
+	keepWorkDir = true
+
+	return &compiledOutput{file: file, size: info.Size(), workDir: workDir}, nil
+}
+
+// compiledOutput is a compiled document, either in memory or still in the work directory it was
+// compiled in. It must be closed, which removes the work directory.
+type compiledOutput struct {
+	// data is the document, if it's in memory.
+	data []byte
+	// file is the document's output file, if it's not in memory.
+	file *os.File
+	// size is the size of the document in bytes.
+	size int64
+	// workDir is the work directory of file, removed by Close.
+	workDir string
+	// warnings are the warnings typst reported when compiling the document. Cached documents have none.
+	warnings []Diagnostic
+}
+
+// memoryOutput returns the compiled output of a document in memory.
+func memoryOutput(document []byte) *compiledOutput {
+	return &compiledOutput{data: document, size: int64(len(document))}
+}
+
+// WriteTo writes the document to w, copying the output file without reading it into memory.
+func (o *compiledOutput) WriteTo(w io.Writer) (int64, error) {
+	if o.file == nil {
+		n, err := w.Write(o.data)
+		return int64(n), err
+	}
+	return io.Copy(w, o.file)
+}
+
+// bytes returns the document, reading the output file into memory.
+func (o *compiledOutput) bytes() ([]byte, error) {
+	if o.file == nil {
+		return o.data, nil
+	}
+	document := make([]byte, o.size)
+	if _, err := io.ReadFull(o.file, document); err != nil {
+		return nil, fmt.Errorf("failed to read output file: %w", err)
 	}
+	return document, nil
+}
 
-	return pdfData, nil
+// Close closes the output file and removes its work directory.
+func (o *compiledOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	closeErr := o.file.Close()
+	return errors.Join(closeErr, os.RemoveAll(o.workDir))
 }
@@ -5,18 +5,33 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-// typstImage is the official Typst Docker image from GitHub Container Registry.
-const typstImage = "ghcr.io/typst/typst:0.14.2"
+const (
+	// typstImage is the official Typst Docker image from GitHub Container Registry.
+	typstImage = "ghcr.io/typst/typst:0.14.2"
+	// defaultCopyRetries is the default number of retries for a failed container file copy.
+	defaultCopyRetries = 3
+	// defaultCopyBackoff is the default delay before the first copy retry; it doubles on each retry.
+	defaultCopyBackoff = 100 * time.Millisecond
+)
 
 // pdfMagicBytes is the magic byte sequence at the start of PDF files.
 var pdfMagicBytes = []byte("%PDF")
@@ -26,6 +41,10 @@ var pdfMagicBytes = []byte("%PDF")
 type ContainerTypstCompiler struct {
 	ctx       context.Context
 	container testcontainers.Container
+	// copyRetries is the maximum number of retries for a transiently failing file copy.
+	copyRetries int
+	// copyBackoff is the delay before the first copy retry; it doubles on each retry.
+	copyBackoff time.Duration
 }
 
 // NewContainerTypstCompiler creates a new container-based Typst compiler.
@@ -46,54 +65,197 @@ func NewContainerTypstCompiler(ctx context.Context) (*ContainerTypstCompiler, er
 	}
 
 	return &ContainerTypstCompiler{
-		ctx:       ctx,
-		container: container,
+		ctx:         ctx,
+		container:   container,
+		copyRetries: defaultCopyRetries,
+		copyBackoff: defaultCopyBackoff,
 	}, nil
 }
 
-// Compile compiles a Typst source file using the container.
-func (c *ContainerTypstCompiler) Compile(ctx context.Context, workDir string) error {
-	sourcePath := filepath.Join(workDir, sourceFileName)
-	if err := c.container.CopyFileToContainer(ctx, sourcePath, "/work/"+sourceFileName, 0644); err != nil {
-		return fmt.Errorf("failed to copy source file to container: %w", err)
+// isTransientCopyError reports whether a failed container file copy is worth retrying.
+func isTransientCopyError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
 	}
 
-	dataPath := filepath.Join(workDir, dataFileName)
-	if _, err := os.Stat(dataPath); err == nil {
-		if copyErr := c.container.CopyFileToContainer(ctx, dataPath, "/work/"+dataFileName, 0644); copyErr != nil {
-			return fmt.Errorf("failed to copy data file to container: %w", copyErr)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// withCopyRetry runs copyFn, retrying transient failures with exponential backoff.
+func (c *ContainerTypstCompiler) withCopyRetry(ctx context.Context, copyFn func() error) error {
+	backoff := c.copyBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := copyFn()
+		if err == nil || attempt >= c.copyRetries || !isTransientCopyError(err) {
+			return err
 		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (retry aborted: %w)", err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
 	}
+}
 
-	exitCode, output, err := c.container.Exec(ctx, []string{
-		"typst", "compile", "/work/" + sourceFileName, "/work/" + outputFileName,
-	})
+// Compile compiles a Typst source file using the container.
+//
+// Every file in workDir is copied into a fresh directory in the container, so
+// compilations don't see files left behind by earlier ones.
+func (c *ContainerTypstCompiler) Compile(ctx context.Context, workDir string, args compileArgs) error {
+	containerRoot := "/work/" + filepath.Base(workDir)
+
+	if err := c.copyWorkDir(ctx, workDir, containerRoot); err != nil {
+		return err
+	}
+
+	cmd := append([]string{"typst", "compile", "--root", containerRoot}, args.args()...)
+	if args.fontDir != "" {
+		cmd = append(cmd, "--font-path", containerRoot+"/"+args.fontDir)
+	}
+	cmd = append(cmd, containerRoot+"/"+sourceFileName, containerRoot+"/"+args.outputFileName())
+
+	exitCode, output, err := c.container.Exec(ctx, cmd)
 	if err != nil {
 		return fmt.Errorf("failed to exec typst compile: %w", err)
 	}
 	if exitCode != 0 {
 		buf := new(bytes.Buffer)
 		_, _ = buf.ReadFrom(output)
-		return fmt.Errorf("compile failed: %s", buf.String())
+		return typstFailure("compile", containerRoot, buf.String())
 	}
 
-	reader, err := c.container.CopyFileFromContainer(ctx, "/work/"+outputFileName)
+	pdfBuf := new(bytes.Buffer)
+	copyErr := c.withCopyRetry(ctx, func() error {
+		pdfBuf.Reset()
+		return c.copyFileFromContainer(ctx, containerRoot+"/"+args.outputFileName(), pdfBuf)
+	})
+	if copyErr != nil {
+		return copyErr
+	}
+
+	outputPath := filepath.Join(workDir, args.outputFileName())
+	if writeErr := os.WriteFile(outputPath, pdfBuf.Bytes(), 0644); writeErr != nil {
+		return fmt.Errorf("failed to write output PDF: %w", writeErr)
+	}
+
+	return nil
+}
+
+// copyFileFromContainer reads the file at containerPath into buf.
+func (c *ContainerTypstCompiler) copyFileFromContainer(
+	ctx context.Context,
+	containerPath string,
+	buf *bytes.Buffer,
+) error {
+	reader, err := c.container.CopyFileFromContainer(ctx, containerPath)
 	if err != nil {
 		return fmt.Errorf("failed to copy output PDF from container: %w", err)
 	}
 	defer reader.Close()
 
-	pdfBuf := new(bytes.Buffer)
-	if _, bufErr := pdfBuf.ReadFrom(reader); bufErr != nil {
-		return fmt.Errorf("failed to read output PDF: %w", bufErr)
+	if _, readErr := buf.ReadFrom(reader); readErr != nil {
+		return fmt.Errorf("failed to read output PDF: %w", readErr)
 	}
+	return nil
+}
 
-	outputPath := filepath.Join(workDir, outputFileName)
-	if writeErr := os.WriteFile(outputPath, pdfBuf.Bytes(), 0644); writeErr != nil {
-		return fmt.Errorf("failed to write output PDF: %w", writeErr)
+// copyWorkDir copies every file in workDir to containerRoot, preserving relative paths.
+func (c *ContainerTypstCompiler) copyWorkDir(ctx context.Context, workDir, containerRoot string) error {
+	return filepath.WalkDir(workDir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil || entry.IsDir() {
+			return walkErr
+		}
+
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+
+		containerPath := containerRoot + "/" + filepath.ToSlash(rel)
+		copyErr := c.withCopyRetry(ctx, func() error {
+			return c.container.CopyFileToContainer(ctx, path, containerPath, 0644)
+		})
+		if copyErr != nil {
+			return fmt.Errorf("failed to copy %s to container: %w", rel, copyErr)
+		}
+		return nil
+	})
+}
+
+// stdoutContainerTypstCompiler is a ContainerTypstCompiler that captures the PDF from stdout.
+type stdoutContainerTypstCompiler struct {
+	*ContainerTypstCompiler
+}
+
+// CompileOutput compiles with "-" as the output path and returns the PDF written to stdout.
+func (c *stdoutContainerTypstCompiler) CompileOutput(
+	ctx context.Context,
+	workDir string,
+	args compileArgs,
+) ([]byte, error) {
+	containerRoot := "/work/" + filepath.Base(workDir)
+
+	if err := c.copyWorkDir(ctx, workDir, containerRoot); err != nil {
+		return nil, err
 	}
 
-	return nil
+	cmd := append([]string{"typst", "compile", "--root", containerRoot}, args.args()...)
+	cmd = append(cmd, containerRoot+"/"+sourceFileName, stdoutPath)
+
+	exitCode, output, err := c.container.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec typst compile: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, readErr := buf.ReadFrom(output); readErr != nil {
+		return nil, fmt.Errorf("failed to read output: %w", readErr)
+	}
+	if exitCode != 0 {
+		return nil, typstFailure("compile", containerRoot, buf.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Query queries a Typst source file using the container and returns the JSON written to stdout.
+func (c *ContainerTypstCompiler) Query(ctx context.Context, workDir string, args queryArgs) ([]byte, error) {
+	containerRoot := "/work/" + filepath.Base(workDir)
+
+	if err := c.copyWorkDir(ctx, workDir, containerRoot); err != nil {
+		return nil, err
+	}
+
+	cmd := append([]string{"typst", "query", "--root", containerRoot}, args.args()...)
+	cmd = append(cmd, "--", containerRoot+"/"+sourceFileName, args.selector)
+
+	exitCode, output, err := c.container.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec typst query: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, readErr := buf.ReadFrom(output); readErr != nil {
+		return nil, fmt.Errorf("failed to read output: %w", readErr)
+	}
+	if exitCode != 0 {
+		return nil, typstFailure("query", containerRoot, buf.String())
+	}
+
+	return buf.Bytes(), nil
 }
 
 // Close terminates the container.
@@ -124,7 +286,7 @@ func TestCompileTypst_SimpleDocument(t *testing.T) {
 
 This is a simple test document.`
 
-	pdf, err := compileTypstWith(context.Background(), testCompiler, source, nil)
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, nil, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() returned error: %v", err)
 	}
@@ -145,7 +307,7 @@ func TestCompileTypst_WithData(t *testing.T) {
 		"content": "Test content paragraph.",
 	}
 
-	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data)
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() with data returned error: %v", err)
 	}
@@ -174,7 +336,7 @@ Items:
 		"items": []string{"Item 1", "Item 2", "Item 3"},
 	}
 
-	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data)
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() with nested data returned error: %v", err)
 	}
@@ -186,7 +348,7 @@ Items:
 func TestCompileTypst_InvalidSyntax(t *testing.T) {
 	source := `#let x = (`
 
-	_, err := compileTypstWith(context.Background(), testCompiler, source, nil)
+	_, err := compileTypstWith(context.Background(), testCompiler, source, nil, compileOptions{})
 	if err == nil {
 		t.Fatal("compileTypstWith() with invalid syntax should return error")
 	}
@@ -198,7 +360,7 @@ func TestCompileTypst_MissingDataFile(t *testing.T) {
 
 = #data.title`
 
-	_, err := compileTypstWith(context.Background(), testCompiler, source, nil)
+	_, err := compileTypstWith(context.Background(), testCompiler, source, nil, compileOptions{})
 	if err == nil {
 		t.Fatal("compileTypstWith() referencing missing data.json should return error")
 	}
@@ -206,7 +368,7 @@ func TestCompileTypst_MissingDataFile(t *testing.T) {
 
 // TestCompileTypst_EmptySource verifies compilation of empty source produces valid PDF.
 func TestCompileTypst_EmptySource(t *testing.T) {
-	pdf, err := compileTypstWith(context.Background(), testCompiler, "", nil)
+	pdf, err := compileTypstWith(context.Background(), testCompiler, "", nil, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() with empty source returned error: %v", err)
 	}
@@ -220,7 +382,7 @@ func TestCompileTypst_EmptyData(t *testing.T) {
 
 = Empty Data Test`
 
-	pdf, err := compileTypstWith(context.Background(), testCompiler, source, map[string]any{})
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, map[string]any{}, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() with empty data returned error: %v", err)
 	}
@@ -244,10 +406,239 @@ func TestCompileTypst_UsingTestdata(t *testing.T) {
 		"date":    "2026-01-02",
 	}
 
-	pdf, err := compileTypstWith(context.Background(), testCompiler, string(modifiedSource), data)
+	pdf, err := compileTypstWith(context.Background(), testCompiler, string(modifiedSource), data, compileOptions{})
 	if err != nil {
 		t.Fatalf("compileTypstWith() with testdata returned error: %v", err)
 	}
 
 	assertValidPDF(t, pdf)
 }
+
+// TestCompileTypst_RootAbsoluteDataPath verifies data can be read via a root-absolute path.
+func TestCompileTypst_RootAbsoluteDataPath(t *testing.T) {
+	source := `#let data = json("/data/input.json")
+
+= #data.title`
+
+	data := map[string]any{
+		"title": "Root Data",
+	}
+
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data, compileOptions{
+		dataPath: cleanDataFilePath("/data/input.json"),
+	})
+	if err != nil {
+		t.Fatalf("compileTypstWith() with root-absolute data path returned error: %v", err)
+	}
+
+	assertValidPDF(t, pdf)
+}
+
+// TestCompileTypst_Stdout verifies that capturing the PDF from stdout produces a valid PDF.
+func TestCompileTypst_Stdout(t *testing.T) {
+	source := `= Hello Stdout
+
+This document was captured from stdout.`
+
+	compiler := &stdoutContainerTypstCompiler{ContainerTypstCompiler: testCompiler}
+	pdf, err := compileTypstWith(context.Background(), compiler, source, nil, compileOptions{})
+	if err != nil {
+		t.Fatalf("compileTypstWith() with stdout output returned error: %v", err)
+	}
+
+	assertValidPDF(t, pdf)
+}
+
+// TestCompileTypst_Inputs verifies that scalar inputs are exposed through sys.inputs.
+func TestCompileTypst_Inputs(t *testing.T) {
+	source := `= #sys.inputs.title
+
+Total: #sys.inputs.total`
+
+	data := map[string]any{
+		"title": "Input Title",
+		"total": 42.5,
+	}
+
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, data, compileOptions{
+		args: compileArgs{inputs: scalarInputs(data)},
+	})
+	if err != nil {
+		t.Fatalf("compileTypstWith() with inputs returned error: %v", err)
+	}
+
+	assertValidPDF(t, pdf)
+}
+
+// TestCompileTypst_Watermark tests that the documented watermark snippet compiles with and without the input.
+func TestCompileTypst_Watermark(t *testing.T) {
+	source := `#let watermark = sys.inputs.at("watermark", default: none)
+#set page(background: if watermark != none {
+  rotate(-45deg, text(64pt, fill: luma(85%), watermark))
+})
+
+= Report`
+
+	for _, inputs := range []map[string]string{nil, {watermarkInput: "DRAFT"}} {
+		pdf, err := compileTypstWith(context.Background(), testCompiler, source, nil, compileOptions{
+			args: compileArgs{inputs: inputs},
+		})
+		if err != nil {
+			t.Fatalf("compileTypstWith() with inputs %v returned error: %v", inputs, err)
+		}
+
+		assertValidPDF(t, pdf)
+	}
+}
+
+// TestCompileTypst_Metadata tests that the metadata preamble compiles with values that need escaping.
+func TestCompileTypst_Metadata(t *testing.T) {
+	metadata := &DocumentMetadata{
+		Title:    `Quote " and backslash \ and #strong[markup]`,
+		Author:   "Line\nbreak",
+		Keywords: []string{"single"},
+		Date:     "2024-02-29",
+	}
+
+	pdf, err := compileTypstWith(context.Background(), testCompiler, metadataPreamble(metadata)+"= Hello", nil,
+		compileOptions{})
+	if err != nil {
+		t.Fatalf("compileTypstWith() with metadata returned error: %v", err)
+	}
+
+	assertValidPDF(t, pdf)
+}
+
+// TestCompileTypst_SplitPages tests splitting a multi-page document compiled by typst.
+func TestCompileTypst_SplitPages(t *testing.T) {
+	source := "= One\n#pagebreak()\n= Two\n#pagebreak()\n= Three"
+
+	pdf, err := compileTypstWith(context.Background(), testCompiler, source, nil, compileOptions{})
+	if err != nil {
+		t.Fatalf("compileTypstWith() returned error: %v", err)
+	}
+
+	archive, err := splitPDFPages(pdf, defaultMaxSplitPages)
+	if err != nil {
+		t.Fatalf("splitPDFPages() returned error: %v", err)
+	}
+	assertSplitPages(t, archive, 3)
+}
+
+// flakyContainer is a container whose file copies fail transiently a fixed number of times.
+type flakyContainer struct {
+	testcontainers.Container
+	// failures is the number of copies to fail before delegating to the container.
+	failures atomic.Int32
+	// err is the error returned by failing copies.
+	err error
+	// copies counts all copy attempts.
+	copies atomic.Int32
+}
+
+// CopyFileToContainer fails while failures remain, then delegates to the container.
+func (c *flakyContainer) CopyFileToContainer(
+	ctx context.Context,
+	hostFilePath, containerFilePath string,
+	fileMode int64,
+) error {
+	c.copies.Add(1)
+	if c.failures.Add(-1) >= 0 {
+		return c.err
+	}
+	return c.Container.CopyFileToContainer(ctx, hostFilePath, containerFilePath, fileMode)
+}
+
+// CopyFileFromContainer fails while failures remain, then delegates to the container.
+func (c *flakyContainer) CopyFileFromContainer(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	c.copies.Add(1)
+	if c.failures.Add(-1) >= 0 {
+		return nil, c.err
+	}
+	return c.Container.CopyFileFromContainer(ctx, filePath)
+}
+
+// TestCompileTypst_CopyRetry verifies that transient copy failures are retried and permanent ones are not.
+func TestCompileTypst_CopyRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		failures   int32
+		wantErr    bool
+		wantCopies int32
+	}{
+		{
+			name:       "transient failure recovers",
+			err:        fmt.Errorf("copy to container: %w", syscall.ECONNRESET),
+			failures:   2,
+			wantErr:    false,
+			wantCopies: 4, // Two failed attempts, then main.typ and output.pdf.
+		},
+		{
+			name:       "permanent failure is not retried",
+			err:        errors.New("no such container"),
+			failures:   1,
+			wantErr:    true,
+			wantCopies: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := &flakyContainer{Container: testCompiler.container, err: tt.err}
+			container.failures.Store(tt.failures)
+			compiler := &ContainerTypstCompiler{
+				ctx:         testCompiler.ctx,
+				container:   container,
+				copyRetries: defaultCopyRetries,
+				copyBackoff: time.Millisecond,
+			}
+
+			pdf, err := compileTypstWith(context.Background(), compiler, "= Retry", nil, compileOptions{})
+			if tt.wantErr {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("compileTypstWith() returned error: %v", err)
+				}
+				assertValidPDF(t, pdf)
+			}
+
+			if got := container.copies.Load(); got != tt.wantCopies {
+				t.Errorf("expected %d copy attempts, got %d", tt.wantCopies, got)
+			}
+		})
+	}
+}
+
+// TestQueryTypst_Headings tests querying the headings of a document staged with data.
+func TestQueryTypst_Headings(t *testing.T) {
+	source := `#let data = json("data.json")
+= Introduction
+= #data.title`
+
+	output, err := queryTypstWith(context.Background(), testCompiler, source, map[string]any{"title": "Results"},
+		compileOptions{}, queryArgs{selector: "heading", field: "body"})
+	if err != nil {
+		t.Fatalf("queryTypstWith() returned error: %v", err)
+	}
+
+	var bodies []any
+	if unmarshalErr := json.Unmarshal(output, &bodies); unmarshalErr != nil {
+		t.Fatalf("failed to decode query output %q: %v", output, unmarshalErr)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("expected 2 headings, got %d: %s", len(bodies), output)
+	}
+}
+
+// TestQueryTypst_InvalidSelector tests that query errors include the typst diagnostics.
+func TestQueryTypst_InvalidSelector(t *testing.T) {
+	_, err := queryTypstWith(context.Background(), testCompiler, "= Hello", nil,
+		compileOptions{}, queryArgs{selector: "not a selector ("})
+	if err == nil || !strings.Contains(err.Error(), "query failed") {
+		t.Errorf("expected query failed error, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyMemoryLimit caps the address space of the process with the given pid.
+func applyMemoryLimit(pid int, limit int64) error {
+	rlimit := &unix.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+	return unix.Prlimit(pid, unix.RLIMIT_AS, rlimit, nil)
+}
+
+// processMaxRSSKB returns the peak resident set size of an exited process in kilobytes.
+func processMaxRSSKB(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return rusage.Maxrss // Reported in kilobytes on Linux.
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestApplyMemoryLimit_Applied verifies the limit is set on the target process.
+func TestApplyMemoryLimit_Applied(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	const limit = 256 * 1024 * 1024
+	if err := applyMemoryLimit(cmd.Process.Pid, limit); err != nil {
+		t.Fatalf("applyMemoryLimit() returned error: %v", err)
+	}
+
+	var got unix.Rlimit
+	if err := unix.Prlimit(cmd.Process.Pid, unix.RLIMIT_AS, nil, &got); err != nil {
+		t.Fatalf("failed to read limit: %v", err)
+	}
+	if got.Cur != limit || got.Max != limit {
+		t.Errorf("expected limit %d, got cur=%d max=%d", limit, got.Cur, got.Max)
+	}
+}
+
+// TestApplyMemoryLimit_Enforced verifies a process exceeding the limit fails.
+func TestApplyMemoryLimit_Enforced(t *testing.T) {
+	t.Parallel()
+
+	// dd allocates a buffer of the block size, far above the limit below.
+	// The sleep gives us time to apply the limit before dd is started.
+	cmd := exec.Command("sh", "-c", "sleep 0.2; dd if=/dev/zero of=/dev/null bs=512M count=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+
+	if err := applyMemoryLimit(cmd.Process.Pid, 64*1024*1024); err != nil {
+		_ = cmd.Process.Kill()
+		t.Fatalf("applyMemoryLimit() returned error: %v", err)
+	}
+
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("process exceeding the memory limit should fail")
+	}
+}
+
+// TestMemoryLimitExceeded tests the memoryLimitExceeded function.
+func TestMemoryLimitExceeded(t *testing.T) {
+	t.Parallel()
+
+	if !memoryLimitExceeded(nil, "memory allocation of 1048576 bytes failed") {
+		t.Error("allocator failure output should be detected")
+	}
+	if memoryLimitExceeded(nil, "error: unexpected end of file") {
+		t.Error("regular compile error should not be detected")
+	}
+
+	// A process killed by a signal has no exit code.
+	cmd := exec.Command("sh", "-c", "kill -9 $$")
+	_ = cmd.Run()
+	if !memoryLimitExceeded(cmd.ProcessState, "") {
+		t.Error("process killed by a signal should be detected")
+	}
+}
+
+// processCompiler is a TypstCompiler that runs a real process and records its resource usage.
+type processCompiler struct{}
+
+// Compile runs a short-lived process, records its usage and writes a fake PDF.
+func (c *processCompiler) Compile(ctx context.Context, workDir string, args compileArgs) error {
+	cmd := exec.CommandContext(ctx, "dd", "if=/dev/zero", "of=/dev/null", "bs=1M", "count=64")
+	runErr := cmd.Run()
+	args.usage.record(cmd.ProcessState)
+	if runErr != nil {
+		return runErr
+	}
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte("%PDF-stub"), 0600)
+}
+
+// TestHandleGenerate_UsageHeaders verifies the resource usage of the compile process is returned in headers.
+func TestHandleGenerate_UsageHeaders(t *testing.T) {
+	t.Parallel()
+
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+	srv.compiler = &processCompiler{}
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	cpuMs, err := strconv.ParseInt(rec.Header().Get("X-Compile-CPU-Ms"), 10, 64)
+	if err != nil || cpuMs < 0 {
+		t.Errorf("expected a non-negative X-Compile-CPU-Ms, got %q", rec.Header().Get("X-Compile-CPU-Ms"))
+	}
+
+	// dd with a 1MB block size needs at least that much memory, and far less than 1GB.
+	maxRSSKB, err := strconv.ParseInt(rec.Header().Get("X-Compile-MaxRSS-KB"), 10, 64)
+	if err != nil || maxRSSKB < 1024 || maxRSSKB > 1024*1024 {
+		t.Errorf("expected a plausible X-Compile-MaxRSS-KB, got %q", rec.Header().Get("X-Compile-MaxRSS-KB"))
+	}
+}
+
+// TestOptimizePDF_MemoryLimit verifies the PDF optimizer is bound by the compile memory limit.
+func TestOptimizePDF_MemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	// As above, dd allocates far above the limit once it has been applied.
+	srv := NewServer(testLogger(), ServerConfig{workDir: t.TempDir(), compileMemoryLimit: 64 * 1024 * 1024})
+	path := writeFakeOptimizer(t, `sleep 0.2; dd if=/dev/zero of=/dev/null bs=512M count=1 && printf small > "$out"`)
+	srv.lookPath = func(string) (string, error) { return path, nil }
+
+	const original = "%PDF-original-document"
+	if got := srv.optimizePDF(context.Background(), []byte(original)); string(got) != original {
+		t.Errorf("optimizePDF() = %q, want %q", got, original)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// applyMemoryLimit is a no-op on platforms without prlimit support.
+func applyMemoryLimit(_ int, _ int64) error {
+	return nil
+}
+
+// processMaxRSSKB returns 0, as the peak resident set size isn't reported consistently on other platforms.
+func processMaxRSSKB(_ *os.ProcessState) int64 {
+	return 0
+}
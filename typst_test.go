@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubOutputCompiler is an OutputCompiler that returns its output directly when supported.
+type stubOutputCompiler struct {
+	// supported makes CompileOutput return the output instead of errOutputUnsupported.
+	supported bool
+	// compiled counts calls to Compile.
+	compiled int
+}
+
+// Compile writes a fake PDF to the work directory.
+func (c *stubOutputCompiler) Compile(_ context.Context, workDir string, args compileArgs) error {
+	c.compiled++
+	return os.WriteFile(filepath.Join(workDir, args.outputFileName()), []byte("%PDF-file"), 0600)
+}
+
+// CompileOutput returns a fake PDF directly, or errOutputUnsupported.
+func (c *stubOutputCompiler) CompileOutput(_ context.Context, _ string, _ compileArgs) ([]byte, error) {
+	if !c.supported {
+		return nil, errOutputUnsupported
+	}
+	return []byte("%PDF-stdout"), nil
+}
+
+// TestCompileTypstWith_OutputCompiler tests direct output and the fallback to file output.
+func TestCompileTypstWith_OutputCompiler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		supported    bool
+		want         string
+		wantCompiled int
+	}{
+		{name: "direct output", supported: true, want: "%PDF-stdout", wantCompiled: 0},
+		{name: "fallback to file", supported: false, want: "%PDF-file", wantCompiled: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiler := &stubOutputCompiler{supported: tt.supported}
+			pdf, err := compileTypstWith(context.Background(), compiler, "= Hello", nil, compileOptions{})
+			if err != nil {
+				t.Fatalf("compileTypstWith() returned error: %v", err)
+			}
+			if string(pdf) != tt.want {
+				t.Errorf("expected output %q, got %q", tt.want, pdf)
+			}
+			if compiler.compiled != tt.wantCompiled {
+				t.Errorf("expected Compile to be called %d times, got %d", tt.wantCompiled, compiler.compiled)
+			}
+		})
+	}
+}
+
+// TestCompileTypstOutput tests that file output keeps its work directory until it's closed.
+func TestCompileTypstOutput(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	output, err := compileTypstOutput(context.Background(), &stubOutputCompiler{}, "= Hello", nil,
+		compileOptions{workDir: workDir})
+	if err != nil {
+		t.Fatalf("compileTypstOutput() returned error: %v", err)
+	}
+	if output.size != int64(len("%PDF-file")) {
+		t.Errorf("expected size %d, got %d", len("%PDF-file"), output.size)
+	}
+	if _, statErr := os.Stat(output.workDir); statErr != nil {
+		t.Fatalf("expected the work directory to exist until closed: %v", statErr)
+	}
+
+	var buf bytes.Buffer
+	if _, writeErr := output.WriteTo(&buf); writeErr != nil {
+		t.Fatalf("WriteTo() returned error: %v", writeErr)
+	}
+	if buf.String() != "%PDF-file" {
+		t.Errorf("expected output %q, got %q", "%PDF-file", buf.String())
+	}
+
+	if closeErr := output.Close(); closeErr != nil {
+		t.Fatalf("Close() returned error: %v", closeErr)
+	}
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatalf("failed to read work directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the work directory to be removed, found %d entries", len(entries))
+	}
+}
+
+// TestLocalTypstCompiler_StdoutDisabled tests that stdout output is unsupported unless enabled.
+func TestLocalTypstCompiler_StdoutDisabled(t *testing.T) {
+	t.Parallel()
+
+	compiler := &LocalTypstCompiler{}
+	_, err := compiler.CompileOutput(context.Background(), t.TempDir(), compileArgs{})
+	if !errors.Is(err, errOutputUnsupported) {
+		t.Errorf("expected errOutputUnsupported, got %v", err)
+	}
+}
+
+// TestScalarInputs tests that only scalar data values become typst inputs.
+func TestScalarInputs(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]any{
+		"title":  "Invoice",
+		"total":  1234.5,
+		"count":  float64(3),
+		"paid":   true,
+		"client": map[string]any{"name": "Acme"},
+		"items":  []any{"a", "b"},
+		"note":   nil,
+	}
+
+	want := map[string]string{
+		"title": "Invoice",
+		"total": "1234.5",
+		"count": "3",
+		"paid":  "true",
+	}
+
+	if got := scalarInputs(data); !maps.Equal(got, want) {
+		t.Errorf("expected inputs %v, got %v", want, got)
+	}
+}
+
+// TestValidateInputKeys tests that input names typst can't parse are rejected.
+func TestValidateInputKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		inputs  map[string]string
+		wantErr string
+	}{
+		{name: "none", inputs: nil},
+		{name: "valid", inputs: map[string]string{"title": "a=b", "total": "42"}},
+		{name: "empty", inputs: map[string]string{"": "x"}, wantErr: "must not be empty"},
+		{name: "equals sign", inputs: map[string]string{"a=b": "x"}, wantErr: `invalid input name "a=b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateInputKeys(tt.inputs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestCompileArgs_Args tests the typst command line arguments of compile args.
+func TestCompileArgs_Args(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args compileArgs
+		want []string
+	}{
+		{name: "default", args: compileArgs{}, want: []string{}},
+		{
+			name: "sorted inputs",
+			args: compileArgs{inputs: map[string]string{"title": "Hello World", "a": "x=y"}},
+			want: []string{"--input", "a=x=y", "--input", "title=Hello World"},
+		},
+		{name: "pdf format", args: compileArgs{format: formatPDF}, want: []string{"--format", "pdf"}},
+		{
+			name: "image format renders first page",
+			args: compileArgs{format: formatPNG, inputs: map[string]string{"a": "b"}},
+			want: []string{"--format", "png", "--pages", "1", "--input", "a=b"},
+		},
+		{
+			name: "selected pages",
+			args: compileArgs{format: formatPNG, pages: "3"},
+			want: []string{"--format", "png", "--pages", "3"},
+		},
+		{name: "page format", args: compileArgs{format: formatSVGPages}, want: []string{"--format", "svg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.args.args(); !slices.Equal(got, tt.want) {
+				t.Errorf("expected args %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestOutputFormats tests the output file name and content type of each output format.
+func TestOutputFormats(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format          string
+		wantFileName    string
+		wantContentType string
+	}{
+		{format: "", wantFileName: "output.pdf", wantContentType: "application/pdf"},
+		{format: formatPDF, wantFileName: "output.pdf", wantContentType: "application/pdf"},
+		{format: formatPNG, wantFileName: "output.png", wantContentType: "image/png"},
+		{format: formatSVG, wantFileName: "output.svg", wantContentType: "image/svg+xml"},
+		{format: formatSVGPages, wantFileName: "output.zip", wantContentType: "application/zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+
+			args := compileArgs{format: tt.format}
+			if got := args.outputFileName(); got != tt.wantFileName {
+				t.Errorf("expected file name %q, got %q", tt.wantFileName, got)
+			}
+			if got := args.outputFormat().contentType; got != tt.wantContentType {
+				t.Errorf("expected content type %q, got %q", tt.wantContentType, got)
+			}
+		})
+	}
+}
+
+// TestOutputFormat_DownloadName tests sanitizing requested download file names.
+func TestOutputFormat_DownloadName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		format    string
+		requested string
+		want      string
+	}{
+		{name: "default", format: formatPDF, want: "output.pdf"},
+		{name: "extension appended", format: formatPDF, requested: "invoice-42", want: "invoice-42.pdf"},
+		{name: "extension kept", format: formatPDF, requested: "invoice.PDF", want: "invoice.PDF"},
+		{name: "other extension", format: formatPNG, requested: "thumb.pdf", want: "thumb.pdf.png"},
+		{name: "directories", format: formatPDF, requested: "../../etc/passwd", want: "passwd.pdf"},
+		{name: "windows directories", format: formatSVG, requested: `C:\docs\chart`, want: "chart.svg"},
+		{name: "control characters", format: formatPDF, requested: "a\r\nb\x00c", want: "abc.pdf"},
+		{name: "quotes", format: formatPDF, requested: `x"; filename="evil`, want: "x; filename=evil.pdf"},
+		{name: "only dots", format: formatPDF, requested: "..", want: "output.pdf"},
+		{name: "trailing separator", format: formatPDF, requested: "reports/", want: "output.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := outputFormats()[tt.format].downloadName(tt.requested); got != tt.want {
+				t.Errorf("downloadName(%q) = %q, want %q", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLocalTypstCompiler_SilentFailure tests the error of a typst process that fails without any output.
+func TestLocalTypstCompiler_SilentFailure(t *testing.T) {
+	// Replaces the typst binary through PATH, so it can't run in parallel.
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nexit 137\n"
+	if err := os.WriteFile(filepath.Join(binDir, "typst"), []byte(script), 0700); err != nil {
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	compiler := &LocalTypstCompiler{}
+	err := compiler.Compile(context.Background(), t.TempDir(), compileArgs{})
+	if err == nil {
+		t.Fatal("expected Compile to fail")
+	}
+	want := "compile failed: typst exited with code 137 without any output, it may have run out of memory or time"
+	if err.Error() != want {
+		t.Errorf("expected error %q, got %q", want, err.Error())
+	}
+}
+
+// TestLocalTypstCompiler_MissingWorkDir tests that a missing work directory isn't reported as a
+// missing typst binary.
+func TestLocalTypstCompiler_MissingWorkDir(t *testing.T) {
+	t.Parallel()
+
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+	compiler := &LocalTypstCompiler{binary: binary}
+
+	workDir := filepath.Join(t.TempDir(), "missing")
+	_, err := compiler.runTypst(context.Background(), "", workDir, "compile", nil, nil, false, nil)
+	if err == nil {
+		t.Fatal("expected an error for a missing work directory")
+	}
+	if errors.Is(err, errTypstUnavailable) {
+		t.Errorf("expected a missing work directory not to be errTypstUnavailable, got %v", err)
+	}
+}
+
+// TestLocalTypstCompiler_Warnings tests that the warnings of a successful compile are recorded.
+func TestLocalTypstCompiler_Warnings(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst reports a warning in the project root, given as its third argument.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nprintf 'warning: unknown font family: inter\\n  ┌─ %s/main.typ:1:18\\n' \"$3\" >&2\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	var usage compileUsage
+	compiler := &LocalTypstCompiler{binary: binary}
+	if err := compiler.Compile(context.Background(), t.TempDir(), compileArgs{usage: &usage}); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	want := []Diagnostic{{File: "main.typ", Line: 1, Column: 18, Message: "unknown font family: inter"}}
+	if !reflect.DeepEqual(usage.warnings, want) {
+		t.Errorf("expected warnings %+v, got %+v", want, usage.warnings)
+	}
+}
+
+func TestCreationTimestamp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		creationTime string
+		want         int64
+		wantErr      string
+	}{
+		{name: "default", creationTime: "", want: 0},
+		{name: "UTC", creationTime: "2024-01-02T15:04:05Z", want: 1704207845},
+		{name: "offset", creationTime: "2024-01-02T16:04:05+01:00", want: 1704207845},
+		{name: "date only", creationTime: "2024-01-02", wantErr: "must be an RFC 3339 timestamp"},
+		{name: "before 1970", creationTime: "1969-12-31T23:59:59Z", wantErr: "must not be before 1970"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := creationTimestamp(tt.creationTime)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("creationTimestamp(%q) error = %v, want it to contain %q", tt.creationTime, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("creationTimestamp(%q) = %d, %v, want %d", tt.creationTime, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_CreationTime tests that typst compiles with the requested creation time, or the UNIX epoch.
+func TestHandleGenerate_CreationTime(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst writes its creation timestamp to its output path, the last argument.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nfor arg; do out=$arg; done\nprintf '%s' \"$SOURCE_DATE_EPOCH\" > \"$out\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "default",
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "0",
+		},
+		{
+			name:       "requested",
+			body:       `{"templateKey": "template.typ", "creationTime": "2024-01-02T15:04:05Z"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "1704207845",
+		},
+		{
+			name:       "invalid",
+			body:       `{"templateKey": "template.typ", "creationTime": "yesterday"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `invalid creationTime "yesterday"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = &LocalTypstCompiler{binary: binary}
+
+			rec := postGenerate(t, srv, tt.body, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			got := rec.Body.String()
+			if tt.wantStatus == http.StatusOK && got != tt.wantBody || !strings.Contains(got, tt.wantBody) {
+				t.Errorf("expected body %q, got %q", tt.wantBody, got)
+			}
+		})
+	}
+}
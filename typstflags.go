@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// reservedTypstFlags are the typst compile flags the server sets itself, which requests can't
+// pass even if they're allowlisted.
+func reservedTypstFlags() []string {
+	return []string{
+		"--root", "--font-path", "--package-path", "--package-cache-path", "--input", "--format", "--pages",
+		"--creation-timestamp",
+	}
+}
+
+// normalizeTypstFlag returns an allowlisted flag name in its "--name" form.
+func normalizeTypstFlag(flag string) string {
+	return "--" + strings.TrimLeft(flag, "-")
+}
+
+// validateTypstFlags checks that every flag of a request is an allowlisted long flag.
+//
+// Flags are passed to typst as separate arguments, never through a shell, so values may
+// contain spaces. A value must be joined to its flag with "=", as in "--jobs=4": an argument
+// that isn't a flag would be read as the input or output file.
+func validateTypstFlags(flags, allowed []string) error {
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "--") || len(flag) == 2 {
+			return fmt.Errorf("invalid typstFlags entry %q: must be a flag like --name or --name=value", flag)
+		}
+		name, _, _ := strings.Cut(flag, "=")
+		if slices.Contains(reservedTypstFlags(), name) {
+			return fmt.Errorf("typst flag %s is set by the server", name)
+		}
+		if !slices.Contains(allowed, name) {
+			return fmt.Errorf("typst flag %s is not allowed", name)
+		}
+	}
+	return nil
+}
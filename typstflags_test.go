@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateTypstFlags(t *testing.T) {
+	t.Parallel()
+
+	allowed := []string{"--jobs", "--pdf-standard", "--root", "--creation-timestamp"}
+	tests := []struct {
+		name    string
+		flags   []string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "allowed", flags: []string{"--jobs=2", "--pdf-standard=a-2b"}},
+		{name: "boolean flag", flags: []string{"--jobs"}},
+		{name: "value with spaces", flags: []string{"--pdf-standard=a 2b"}},
+		{name: "not allowed", flags: []string{"--jobs=2", "--open=firefox"}, wantErr: "typst flag --open is not allowed"},
+		{name: "reserved", flags: []string{"--root=/"}, wantErr: "typst flag --root is set by the server"},
+		{
+			name:    "creation timestamp",
+			flags:   []string{"--creation-timestamp=0"},
+			wantErr: "typst flag --creation-timestamp is set by the server",
+		},
+		{name: "separate value", flags: []string{"--jobs", "2"}, wantErr: `invalid typstFlags entry "2"`},
+		{name: "short flag", flags: []string{"-j=2"}, wantErr: `invalid typstFlags entry "-j=2"`},
+		{name: "empty flag", flags: []string{"--"}, wantErr: `invalid typstFlags entry "--"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateTypstFlags(tt.flags, allowed)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateTypstFlags(%q) error = %v, want nil", tt.flags, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTypstFlags(%q) error = %v, want it to contain %q", tt.flags, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestHandleGenerate_TypstFlags tests that allowed flags are appended to the compile command as single arguments.
+func TestHandleGenerate_TypstFlags(t *testing.T) {
+	t.Parallel()
+
+	// The stub typst writes its arguments, one per line, to its output path, the last argument.
+	binary := filepath.Join(t.TempDir(), "typst")
+	script := "#!/bin/sh\nfor arg; do out=$arg; done\nprintf '%s\\n' \"$@\" > \"$out\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write stub typst: %v", err)
+	}
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{
+		bucketURL:         bucketURL,
+		allowedTypstFlags: []string{"--jobs", "--pdf-standard"},
+	})
+	srv.compiler = &LocalTypstCompiler{binary: binary}
+
+	rec := postGenerate(t, srv,
+		`{"templateKey": "template.typ", "typstFlags": ["--jobs=2", "--pdf-standard=a 2b; rm -rf /"]}`, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	args := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if !slices.Contains(args, "--jobs=2") || !slices.Contains(args, "--pdf-standard=a 2b; rm -rf /") {
+		t.Errorf("expected the flags as single arguments, got %q", args)
+	}
+
+	rec = postGenerate(t, srv, `{"templateKey": "template.typ", "typstFlags": ["--open=firefox"]}`, "", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "--open") {
+		t.Errorf("expected the error to name the flag, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// typstBinaryFor returns the typst binary that compiles a request pinned to version, from
+// TYPST_VERSIONS. An empty version returns an empty binary, so the compiler's default is used.
+func (c *ServerConfig) typstBinaryFor(version string) (string, error) {
+	if version == "" {
+		return "", nil
+	}
+	if binary, ok := c.typstVersions[version]; ok {
+		return binary, nil
+	}
+	if len(c.typstVersions) == 0 {
+		return "", fmt.Errorf("unknown typstVersion %q, no typst versions are configured", version)
+	}
+	available := slices.Sorted(maps.Keys(c.typstVersions))
+	return "", fmt.Errorf("unknown typstVersion %q, available: %s", version, strings.Join(available, ", "))
+}
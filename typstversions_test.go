@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestHandleGenerate_TypstVersion tests compiling with the typst binary of a pinned version.
+func TestHandleGenerate_TypstVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		versions    map[string]string
+		body        string
+		wantStatus  int
+		wantBinary  string
+		wantMessage string
+	}{
+		{
+			name:       "default",
+			versions:   map[string]string{"0.11": "/opt/typst-0.11/typst"},
+			body:       `{"templateKey": "template.typ"}`,
+			wantStatus: http.StatusOK,
+			wantBinary: "",
+		},
+		{
+			name:       "pinned",
+			versions:   map[string]string{"0.11": "/opt/typst-0.11/typst", "0.14": "typst-0.14"},
+			body:       `{"templateKey": "template.typ", "typstVersion": "0.11"}`,
+			wantStatus: http.StatusOK,
+			wantBinary: "/opt/typst-0.11/typst",
+		},
+		{
+			name:        "unknown",
+			versions:    map[string]string{"0.14": "typst-0.14", "0.11": "/opt/typst-0.11/typst"},
+			body:        `{"templateKey": "template.typ", "typstVersion": "0.12"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: `unknown typstVersion "0.12", available: 0.11, 0.14`,
+		},
+		{
+			name:        "none configured",
+			body:        `{"templateKey": "template.typ", "typstVersion": "0.11"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "no typst versions are configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			compiler := &recordingCompiler{}
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, typstVersions: tt.versions})
+			srv.compiler = compiler
+
+			rec := postGenerate(t, srv, tt.body, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMessage) {
+				t.Errorf("expected body to contain %q, got %q", tt.wantMessage, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && compiler.args.binary != tt.wantBinary {
+				t.Errorf("expected binary %q, got %q", tt.wantBinary, compiler.args.binary)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// maxURLSourceRedirects is the maximum number of redirects followed when fetching a URL source.
+const maxURLSourceRedirects = 5
+
+// errURLSourcesDisabled is returned for URL sources when ALLOW_URL_SOURCES is off.
+var errURLSourcesDisabled = errors.New("URL sources are not enabled")
+
+// isURLSource reports whether a template or data key is an http or https URL rather than a bucket key.
+func isURLSource(key string) bool {
+	lower := strings.ToLower(key)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// checkURLSource checks that a URL source may be fetched: URL sources must be enabled, and the
+// URL's host must be in the allowlist, so requests can't reach arbitrary internal services.
+func (c *ServerConfig) checkURLSource(rawURL string) error {
+	if !c.allowURLSources {
+		return errURLSourcesDisabled
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("URL must not contain credentials")
+	}
+	host := strings.ToLower(u.Host)
+	if !slices.Contains(c.urlSourceHosts, host) && !slices.Contains(c.urlSourceHosts, strings.ToLower(u.Hostname())) {
+		return fmt.Errorf("host %q is not allowed", u.Host)
+	}
+	return nil
+}
+
+// validateURLSources checks the template and data keys of a request that are URLs.
+//
+// A template fetched from a URL has no directory in the bucket, so it can't have include files.
+func (s *Server) validateURLSources(ctx context.Context, req *GenerateRequest) error {
+	config := s.requestConfig(ctx)
+	sources := []struct {
+		kind string
+		key  string
+	}{
+		{kind: "templateKey", key: req.TemplateKey},
+		{kind: "dataKey", key: req.DataKey},
+	}
+	for _, source := range sources {
+		if !isURLSource(source.key) {
+			continue
+		}
+		if err := config.checkURLSource(source.key); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", source.kind, source.key, err)
+		}
+	}
+	if isURLSource(req.TemplateKey) && len(req.IncludeKeys) > 0 {
+		return errors.New("includeKeys are not supported with a templateKey URL")
+	}
+	return nil
+}
+
+// newURLSourceClient returns the HTTP client that fetches URL sources.
+//
+// Redirects are only followed to allowed hosts, so an allowed host can't redirect a fetch elsewhere.
+func (s *Server) newURLSourceClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxURLSourceRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxURLSourceRedirects)
+			}
+			if err := s.requestConfig(req.Context()).checkURLSource(req.URL.String()); err != nil {
+				return fmt.Errorf("redirect to %s: %w", req.URL.Redacted(), err)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchURL fetches a URL source with size limiting, along with its content type.
+//
+// A 404 Not Found response is reported as fs.ErrNotExist, so it responds like a missing bucket key.
+func (s *Server) fetchURL(ctx context.Context, rawURL string, maxSize int64) ([]byte, string, error) {
+	if err := s.requestConfig(ctx).checkURLSource(rawURL); err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("create request: %w", err)
+	}
+	resp, err := s.urlSourceClient.Do(req)
+	if err != nil {
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("get %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("get %s: %w", req.URL.Redacted(), fs.ErrNotExist)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("get %s: unexpected status %s", req.URL.Redacted(), resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		s.metrics.fetchFailed()
+		return nil, "", fmt.Errorf("read: %w", err)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ValidateResponse is the response of /validate for a request that compiles.
+type ValidateResponse struct {
+	// Valid is true when the document compiled. Requests that fail respond with the usual error instead.
+	Valid bool `json:"valid"`
+}
+
+// handleValidate compiles a generate request like /generate, without returning the document.
+//
+// It's meant for checking templates against sample data, such as in CI: a request that compiles
+// responds with {"valid": true}, and one with compile errors with 422 and the typst diagnostics.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var req GenerateRequest
+	logger := s.requestLogger(r.Context())
+
+	// Check if the request is valid.
+	if err := s.decodeGenerateRequest(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.validateGenerateRequest(r.Context(), &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Check that the template isn't at its concurrency limit.
+	release, ok := s.limiter.Load().acquire(r.Context(), req.TemplateKey)
+	if !ok {
+		http.Error(w, errTemplateBusy.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	logger.Debug("validating document",
+		"templateKey", req.TemplateKey,
+		"inlineTemplate", req.Template != "",
+		"dataKey", req.DataKey,
+		"inlineData", req.Data != nil,
+		"format", req.Format,
+	)
+
+	// Compile the document like a metadata-only request, which keeps only its headers.
+	req.MetaOnly = true
+	output, err := s.generate(r.Context(), logger, w.Header(), &req, conditionalRender{})
+	if err != nil {
+		logger.Debug("document is invalid", "error", err)
+		writeError(w, err)
+		return
+	}
+	_ = output.Close()
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if encodeErr := json.NewEncoder(w).Encode(ValidateResponse{Valid: true}); encodeErr != nil {
+		logger.Error("failed to write validate response", "error", encodeErr)
+	}
+}
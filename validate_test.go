@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleValidate tests that /validate compiles a request without returning the document.
+func TestHandleValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		compiler   TypstCompiler
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "valid",
+			body:       `{"templateKey": "template.typ", "data": {"title": "Report"}}`,
+			compiler:   &stubCompiler{},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"valid":true}`,
+		},
+		{
+			name:       "compile error",
+			body:       `{"templateKey": "template.typ"}`,
+			compiler:   &stubCompiler{err: typstFailure("compile", "", "error: unclosed delimiter\n  ┌─ main.typ:2:1\n")},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `"message":"unclosed delimiter"`,
+		},
+		{
+			name:       "missing template",
+			body:       `{"templateKey": "missing.typ"}`,
+			compiler:   &stubCompiler{},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "missing.typ",
+		},
+		{
+			name:       "invalid request",
+			body:       `{}`,
+			compiler:   &stubCompiler{},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "templateKey or template is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL})
+			srv.compiler = tt.compiler
+
+			req := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("expected body to contain %q, got %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
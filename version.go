@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// typstVersionTimeout is the timeout for running "typst --version".
+const typstVersionTimeout = 10 * time.Second
+
+// VersionResponse is the response body for the /version endpoint.
+type VersionResponse struct {
+	// Version is the givetypst build version.
+	Version string `json:"version"`
+	// TypstAvailable reports whether the typst binary was found and reported its version.
+	TypstAvailable bool `json:"typstAvailable"`
+	// TypstVersion is the version reported by the typst binary, such as "typst 0.13.1".
+	TypstVersion string `json:"typstVersion,omitempty"`
+}
+
+// detectTypstVersion runs "typst --version" on first use and returns the result of that first run.
+//
+// The server starts even if typst is missing, in which case the error is returned on every call.
+func (s *Server) detectTypstVersion() (string, error) {
+	s.typstVersionOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), typstVersionTimeout)
+		defer cancel()
+		s.detectedTypstVersion, s.typstVersionErr = s.typstVersion(ctx)
+	})
+	return s.detectedTypstVersion, s.typstVersionErr
+}
+
+// resolveTypstBinary returns the absolute path of the configured typst binary, looked up on PATH
+// unless it's a path.
+func (s *Server) resolveTypstBinary() (string, error) {
+	path, err := s.lookPath(s.config.Load().typstBinary)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// logTypstVersion logs the detected typst version, or a warning if typst is unavailable.
+func (s *Server) logTypstVersion() {
+	typstVersion, err := s.detectTypstVersion()
+	if err != nil {
+		s.logger.Warn("typst is unavailable", "error", err)
+		return
+	}
+	s.logger.Info("detected typst", "version", typstVersion)
+}
+
+// handleVersion returns the givetypst build version and the detected typst version.
+func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	resp := VersionResponse{Version: version}
+	if typstVersion, err := s.detectTypstVersion(); err == nil {
+		resp.TypstAvailable = true
+		resp.TypstVersion = typstVersion
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if encodeErr := json.NewEncoder(w).Encode(resp); encodeErr != nil {
+		s.logger.Error("failed to write version response", "error", encodeErr)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestHandleVersion tests that /version reports the build version and whether typst is available.
+func TestHandleVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		versionErr error
+		want       VersionResponse
+	}{
+		{
+			name: "typst available",
+			want: VersionResponse{Version: version, TypstAvailable: true, TypstVersion: "typst 0.13.1"},
+		},
+		{
+			name:       "typst unavailable",
+			versionErr: errors.New("executable file not found"),
+			want:       VersionResponse{Version: version},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://"})
+			detections := 0
+			srv.typstVersion = func(context.Context) (string, error) {
+				detections++
+				return "typst 0.13.1", tt.versionErr
+			}
+
+			for range 2 {
+				rec := httptest.NewRecorder()
+				srv.handleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+				}
+				var resp VersionResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp != tt.want {
+					t.Errorf("expected %+v, got %+v", tt.want, resp)
+				}
+			}
+			if detections != 1 {
+				t.Errorf("expected typst version to be detected once, got %d", detections)
+			}
+		})
+	}
+}
+
+// TestResolveTypstBinary tests resolving the configured typst binary to an absolute path.
+func TestResolveTypstBinary(t *testing.T) {
+	t.Parallel()
+
+	binary := filepath.Join(t.TempDir(), "typst-wrapper")
+	script := []byte("#!/bin/sh\nexec typst \"$@\"\n")
+	if err := os.WriteFile(binary, script, 0700); err != nil { //nolint:gosec // The script must be executable.
+		t.Fatalf("failed to write wrapper: %v", err)
+	}
+
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", typstBinary: binary})
+	path, err := srv.resolveTypstBinary()
+	if err != nil {
+		t.Fatalf("resolveTypstBinary() error = %v", err)
+	}
+	if path != binary {
+		t.Errorf("resolveTypstBinary() = %q, want %q", path, binary)
+	}
+
+	missing := NewServer(testLogger(), ServerConfig{bucketURL: "mem://", typstBinary: filepath.Join(t.TempDir(), "typst")})
+	if _, err = missing.resolveTypstBinary(); err == nil {
+		t.Error("expected an error for a missing binary")
+	}
+	rec := httptest.NewRecorder()
+	missing.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /health status %d for a missing binary, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
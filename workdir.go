@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// workDirPrefix starts the names of the work directories of compiles and compile workers.
+	workDirPrefix = "typst-"
+	// workerRootPrefix starts the names of the temp roots of compile workers.
+	workerRootPrefix = workDirPrefix + "worker-"
+	// staleWorkDirAge is the age after which a leftover compile work directory is removed at startup.
+	//
+	// It's far longer than any compile, so the compile directories of other servers sharing WORK_DIR
+	// are kept. Worker roots live as long as their server, so their age says nothing and they are
+	// never swept, and neither is the longer-lived package cache.
+	staleWorkDirAge = time.Hour
+)
+
+// sweepWorkDir removes the stale work directories that crashed processes left behind in
+// the configured WORK_DIR, creating it if needed.
+//
+// Sweeping is best effort: failures are logged, and compiles fail later if the directory
+// is unusable.
+func (s *Server) sweepWorkDir() {
+	workDir := s.config.Load().workDir
+	if workDir == "" {
+		return
+	}
+	if err := os.MkdirAll(workDir, dirPermissions); err != nil {
+		s.logger.Error("failed to create work directory", "workDir", workDir, "error", err)
+		return
+	}
+
+	removed, err := sweepStaleWorkDirs(workDir, time.Now().Add(-staleWorkDirAge))
+	if err != nil {
+		s.logger.Error("failed to sweep work directory", "workDir", workDir, "removed", removed, "error", err)
+		return
+	}
+	if removed > 0 {
+		s.logger.Info("removed stale work directories", "workDir", workDir, "removed", removed)
+	}
+}
+
+// sweepStaleWorkDirs removes the work directories in dir last modified before cutoff, and
+// returns how many were removed.
+//
+// Other entries, such as the package cache, are kept, so dir can be shared with other programs
+// and servers, and so are worker roots, which may belong to a live server sharing dir. Removal
+// continues past failures, which are joined into the returned error.
+func sweepStaleWorkDirs(dir string, cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	removed := 0
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) ||
+			strings.HasPrefix(entry.Name(), workerRootPrefix) {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if errors.Is(infoErr, fs.ErrNotExist) {
+			continue // Removed by its owner in the meantime.
+		}
+		if infoErr != nil {
+			errs = append(errs, infoErr)
+			continue
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if removeErr := os.RemoveAll(filepath.Join(dir, entry.Name())); removeErr != nil {
+			errs = append(errs, removeErr)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
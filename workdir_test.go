@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSweepStaleWorkDirs tests that only stale compile work directories are removed, and worker
+// roots and the package cache, which may belong to other live servers, are kept.
+func TestSweepStaleWorkDirs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	stale := time.Now().Add(-2 * staleWorkDirAge)
+	entries := []struct {
+		name    string
+		isDir   bool
+		modTime time.Time
+	}{
+		{name: "typst-stale", isDir: true, modTime: stale},
+		{name: "typst-sibling-fresh", isDir: true, modTime: time.Now()},
+		{name: "typst-worker-stale", isDir: true, modTime: stale},
+		{name: "packages", isDir: true, modTime: stale},
+		{name: "typst-fresh", isDir: true, modTime: time.Now()},
+		{name: "other-stale", isDir: true, modTime: stale},
+		{name: "typst-file", modTime: stale},
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.name)
+		if entry.isDir {
+			if err := os.MkdirAll(filepath.Join(entryPath, "nested"), 0o750); err != nil {
+				t.Fatalf("failed to create %s: %v", entry.name, err)
+			}
+		} else if err := os.WriteFile(entryPath, []byte("keep"), 0o600); err != nil {
+			t.Fatalf("failed to create %s: %v", entry.name, err)
+		}
+		if err := os.Chtimes(entryPath, entry.modTime, entry.modTime); err != nil {
+			t.Fatalf("failed to set the time of %s: %v", entry.name, err)
+		}
+	}
+
+	removed, err := sweepStaleWorkDirs(dir, time.Now().Add(-staleWorkDirAge))
+	if err != nil {
+		t.Fatalf("sweepStaleWorkDirs() returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 directory removed, got %d", removed)
+	}
+
+	remaining, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	var names []string
+	for _, entry := range remaining {
+		names = append(names, entry.Name())
+	}
+	want := []string{"other-stale", "packages", "typst-file", "typst-fresh", "typst-sibling-fresh", "typst-worker-stale"}
+	if !slices.Equal(names, want) {
+		t.Errorf("expected %v to remain, got %v", want, names)
+	}
+}
+
+// TestHandleGenerate_WorkDir tests that work directories are created in the configured work directory.
+func TestHandleGenerate_WorkDir(t *testing.T) {
+	t.Parallel()
+
+	workDir := filepath.Join(t.TempDir(), "work")
+	bucketURL := setupTestBucket(t, map[string][]byte{"template.typ": []byte("= Hello")})
+	srv := NewServer(testLogger(), ServerConfig{bucketURL: bucketURL, workDir: workDir})
+	compiler := &recordingCompiler{}
+	srv.compiler = compiler
+	srv.sweepWorkDir()
+
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(`{"templateKey": "template.typ"}`))
+	rec := httptest.NewRecorder()
+
+	srv.handleGenerate(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if filepath.Dir(compiler.workDir) != workDir {
+		t.Errorf("expected work directory in %s, got %s", workDir, compiler.workDir)
+	}
+}